@@ -0,0 +1,528 @@
+package gokvdb
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"bufio"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	BACKUP_MAGIC string = "GOKVDBBK"
+	BACKUP_VERSION uint8 = 1
+	// the backup header is followed by the storage header, then by records
+	// of a u32 page id and the page, then by a page id of 0, the u32 count
+	// of pages and the CRC32C of everything before it
+	BACKUP_HEADER_SIZE int = 64
+)
+
+// BackupInfo describes a backup. Epoch is the commit count of the storage
+// the backup was read at, an incremental backup holds the pages written
+// by the commits after BaseEpoch. Lineage is the one of the storage, an
+// incremental backup only applies over a file of the same lineage.
+type BackupInfo struct {
+	Epoch uint64
+	BaseEpoch uint64
+	Incremental bool
+	PageSize uint32
+	LastPageId uint32
+	PageCount int
+	Lineage Lineage
+}
+
+func (info BackupInfo) ToString() string {
+	return fmt.Sprintf("<BackupInfo epoch=%v baseEpoch=%v incremental=%v pageSize=%v lastPageId=%v pageCount=%v lineage=%x>", info.Epoch, info.BaseEpoch, info.Incremental, info.PageSize, info.LastPageId, info.PageCount, info.Lineage)
+}
+
+// Backup writes every page of the last commit to w. It reads a snapshot,
+// so writers go on meanwhile, the pages they replace are kept in memory
+// until it is done.
+func (s *Storage) Backup(w io.Writer) (BackupInfo, error) {
+	return s._Backup(w, nil)
+}
+
+// BackupIncremental writes to w the pages written since the backup base
+// was taken. When the storage was opened after base it cannot tell which
+// pages changed before and writes them all, the backup still applies over
+// base.
+func (s *Storage) BackupIncremental(w io.Writer, base BackupInfo) (BackupInfo, error) {
+	return s._Backup(w, &base)
+}
+
+// BackupTo writes a full backup to a file at path, which it replaces
+// atomically.
+func (s *Storage) BackupTo(path string) (BackupInfo, error) {
+	return s._BackupTo(path, nil)
+}
+
+func (s *Storage) BackupIncrementalTo(path string, base BackupInfo) (BackupInfo, error) {
+	return s._BackupTo(path, &base)
+}
+
+func (s *Storage) _BackupTo(path string, base *BackupInfo) (BackupInfo, error) {
+
+	var info BackupInfo
+
+	err := _ReplaceFile(path, func(tmpPath string) error {
+
+		f, err := os.OpenFile(tmpPath, os.O_RDWR | os.O_CREATE | os.O_TRUNC, 0666)
+		if err != nil {
+			return err
+		}
+
+		info, err = s._Backup(f, base)
+		if err == nil {
+			err = f.Sync()
+		}
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+		return err
+	})
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	return info, nil
+}
+
+func (s *Storage) _Backup(w io.Writer, base *BackupInfo) (BackupInfo, error) {
+
+	var info BackupInfo
+
+	err := s.View(func(snap *Snapshot) error {
+		var err error
+		info, err = snap._WriteBackup(w, base)
+		return err
+	})
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	return info, nil
+}
+
+func (snap *Snapshot) _WriteBackup(w io.Writer, base *BackupInfo) (BackupInfo, error) {
+
+	pager := snap.pager
+
+	info := BackupInfo{PageSize: pager.pageSize}
+
+	// the snapshot of a storage never saved has no header and no page
+	header := make([]byte, HEADER_SIZE)
+	if snap.header != nil {
+		copy(header, snap.header)
+		rd := NewDataStreamFromBuffer(header)
+		rd.Seek(STORAGE_PAGER_META_OFFSET)
+		rd.ReadUInt32()
+		info.LastPageId = rd.ReadUInt32()
+		info.Epoch = _ReadStorageEpoch(header)
+		info.Lineage = _ReadStorageLineage(header)
+	}
+
+	var pageIds []uint32
+	all := true
+
+	if base != nil {
+		if base.PageSize != info.PageSize {
+			return info, fmt.Errorf("%w: base pageSize=%v, storage pageSize=%v", ErrInvalidBackup, base.PageSize, info.PageSize)
+		}
+		// a file rebuilt by Compact restarts its epochs under a new lineage
+		if base.Lineage != info.Lineage {
+			return info, fmt.Errorf("%w: base lineage=%x, storage lineage=%x", ErrInvalidBackup, base.Lineage, info.Lineage)
+		}
+		if base.Epoch > info.Epoch {
+			return info, fmt.Errorf("%w: base epoch=%v is newer than the storage epoch=%v", ErrInvalidBackup, base.Epoch, info.Epoch)
+		}
+
+		info.Incremental = true
+		info.BaseEpoch = base.Epoch
+
+		pageIds, all = pager.parent.versions._ChangedPageIds(base.Epoch, info.LastPageId)
+		all = !all
+	}
+
+	if all {
+		pageIds = nil
+		for pid:=uint32(1); pid<=info.LastPageId; pid++ {
+			pageIds = append(pageIds, pid)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	sum := crc32.New(crc32cTable)
+	out := io.MultiWriter(bw, sum)
+
+	hdrW := NewDataStreamFromBuffer(make([]byte, BACKUP_HEADER_SIZE))
+	hdrW.Write([]byte(BACKUP_MAGIC))
+	hdrW.WriteUInt8(BACKUP_VERSION)
+	hdrW.WriteBool(info.Incremental)
+	hdrW.WriteUInt32(info.PageSize)
+	hdrW.WriteUInt64(info.BaseEpoch)
+	hdrW.WriteUInt64(info.Epoch)
+	hdrW.WriteUInt32(info.LastPageId)
+	hdrW.Write(info.Lineage[:])
+
+	_, err := out.Write(hdrW.ToBytes())
+	if err != nil {
+		return info, err
+	}
+	_, err = out.Write(header)
+	if err != nil {
+		return info, err
+	}
+
+	pidW := NewDataStreamFromBuffer(make([]byte, 4))
+
+	for _, pid := range pageIds {
+
+		data, err := pager.ReadPage(pid, 0)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return info, err
+		}
+		// a hole reads back as zeros from the restored file too
+		if _IsZeroPage(data) {
+			continue
+		}
+
+		pidW.Seek(0)
+		pidW.WriteUInt32(pid)
+		_, err = out.Write(pidW.ToBytes())
+		if err == nil {
+			_, err = out.Write(data)
+		}
+		if err != nil {
+			return info, err
+		}
+
+		info.PageCount += 1
+	}
+
+	endW := NewDataStreamFromBuffer(make([]byte, 8))
+	endW.WriteUInt32(0)
+	endW.WriteUInt32(uint32(info.PageCount))
+	_, err = out.Write(endW.ToBytes())
+	if err != nil {
+		return info, err
+	}
+
+	sumW := NewDataStreamFromBuffer(make([]byte, 4))
+	sumW.WriteUInt32(sum.Sum32())
+	_, err = bw.Write(sumW.ToBytes())
+	if err != nil {
+		return info, err
+	}
+
+	return info, bw.Flush()
+}
+
+// ReadBackupInfo returns the description of the backup file at path
+// without checking its pages.
+func ReadBackupInfo(path string) (BackupInfo, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer f.Close()
+
+	info, _, err := _ReadBackupHeader(f)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	stat, err := f.Stat()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	end := make([]byte, 12)
+	_, err = f.ReadAt(end, stat.Size() - int64(len(end)))
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+
+	rd := NewDataStreamFromBuffer(end)
+	if rd.ReadUInt32() != 0 {
+		return BackupInfo{}, fmt.Errorf("%w: no end record", ErrInvalidBackup)
+	}
+	info.PageCount = int(rd.ReadUInt32())
+
+	return info, nil
+}
+
+// _ReadBackupHeader reads the backup header and the storage header it
+// holds.
+func _ReadBackupHeader(r io.Reader) (BackupInfo, []byte, error) {
+
+	var info BackupInfo
+
+	buf := make([]byte, BACKUP_HEADER_SIZE + HEADER_SIZE)
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return info, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+
+	rd := NewDataStreamFromBuffer(buf)
+	if string(rd.Read(len(BACKUP_MAGIC))) != BACKUP_MAGIC {
+		return info, nil, fmt.Errorf("%w: bad magic", ErrInvalidBackup)
+	}
+	version := rd.ReadUInt8()
+	if version != BACKUP_VERSION {
+		return info, nil, fmt.Errorf("%w: version=%v", ErrInvalidBackup, version)
+	}
+
+	info.Incremental = rd.ReadBool()
+	info.PageSize = rd.ReadUInt32()
+	info.BaseEpoch = rd.ReadUInt64()
+	info.Epoch = rd.ReadUInt64()
+	info.LastPageId = rd.ReadUInt32()
+	// zero in a backup taken before lineages were kept
+	copy(info.Lineage[:], rd.Read(STORAGE_LINEAGE_SIZE))
+
+	if info.PageSize < uint32(HEADER_SIZE) {
+		return info, nil, fmt.Errorf("%w: pageSize=%v", ErrInvalidBackup, info.PageSize)
+	}
+
+	header := buf[BACKUP_HEADER_SIZE:]
+	if !_IsZeroPage(header) {
+		_, err = _VerifyStorageHeader("Backup storage header", header)
+		if err != nil {
+			return info, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+	}
+
+	return info, header, nil
+}
+
+// Restore writes the backup read from r to the database file at path. A
+// full backup replaces the file, an incremental one applies over the file
+// restored from its base or from a later incremental backup of the same
+// chain. The file is replaced atomically once the whole backup has been
+// read and checked, it fails with ErrLocked while path is open.
+func Restore(path string, r io.Reader) (BackupInfo, error) {
+
+	var info BackupInfo
+
+	err := _ReplaceFile(path, func(tmpPath string) error {
+		var err error
+		info, err = _RestoreTo(tmpPath, path, r)
+		return err
+	})
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	return info, nil
+}
+
+// RestoreFrom restores the backup file at backupPath to path.
+func RestoreFrom(path string, backupPath string) (BackupInfo, error) {
+
+	f, err := os.Open(backupPath)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer f.Close()
+
+	return Restore(path, f)
+}
+
+func _RestoreTo(tmpPath string, path string, r io.Reader) (BackupInfo, error) {
+
+	sum := crc32.New(crc32cTable)
+	in := io.TeeReader(bufio.NewReader(r), sum)
+
+	info, header, err := _ReadBackupHeader(in)
+	if err != nil {
+		return info, err
+	}
+
+	err = os.Remove(tmpPath)
+	if err != nil && !os.IsNotExist(err) {
+		return info, err
+	}
+
+	if info.Incremental {
+		err = _CopyStorageFile(path, tmpPath, info)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	stream, err := _OpenFileStream(tmpPath, false)
+	if err != nil {
+		return info, err
+	}
+	defer stream.Close()
+
+	pageSize := int64(info.PageSize)
+	record := make([]byte, 4)
+	count := 0
+
+	// the pages of a file written before checksums carry none
+	checksums := _HasChecksums(header)
+
+	for {
+		_, err = io.ReadFull(in, record)
+		if err != nil {
+			return info, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+
+		pid := NewDataStreamFromBuffer(record).ReadUInt32()
+		if pid == 0 {
+			break
+		}
+		if pid > info.LastPageId {
+			return info, fmt.Errorf("%w: pid=%v beyond lastPageId=%v", ErrInvalidBackup, pid, info.LastPageId)
+		}
+
+		data := make([]byte, pageSize)
+		_, err = io.ReadFull(in, data)
+		if err != nil {
+			return info, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		if checksums {
+			err = _VerifyChecksum("Backup page", pid, data, PAGE_CHECKSUM_OFFSET)
+			if err != nil {
+				return info, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+			}
+		}
+
+		err = stream.SeekTo(int64(pid) * pageSize)
+		if err == nil {
+			err = stream.Write(data)
+		}
+		if err != nil {
+			return info, err
+		}
+		count += 1
+	}
+
+	_, err = io.ReadFull(in, record)
+	if err != nil {
+		return info, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	info.PageCount = int(NewDataStreamFromBuffer(record).ReadUInt32())
+	if info.PageCount != count {
+		return info, fmt.Errorf("%w: %v pages, expected %v", ErrInvalidBackup, count, info.PageCount)
+	}
+
+	expected := sum.Sum32()
+	_, err = io.ReadFull(in, record)
+	if err != nil {
+		return info, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	stored := NewDataStreamFromBuffer(record).ReadUInt32()
+	if stored != expected {
+		return info, fmt.Errorf("%w: checksum %08x, expected %08x", ErrInvalidBackup, stored, expected)
+	}
+
+	if !_IsZeroPage(header) {
+		err = stream.SeekTo(0)
+		if err == nil {
+			err = stream.Write(header)
+		}
+		if err != nil {
+			return info, err
+		}
+	}
+
+	// the pages cut off by a vacuum after the base go too
+	size, err := stream.Size()
+	if err != nil {
+		return info, err
+	}
+	lastSize := int64(info.LastPageId + 1) * pageSize
+	if size > lastSize {
+		err = stream.Truncate(lastSize)
+		if err != nil {
+			return info, err
+		}
+	}
+
+	err = stream.Sync()
+	if err != nil {
+		return info, err
+	}
+
+	return info, nil
+}
+
+// _CopyStorageFile copies the database at path to tmpPath with the commits
+// still in its log folded in, so an incremental backup can apply over it.
+// It checks the copy is of the lineage of the backup and at an epoch the
+// backup covers.
+func _CopyStorageFile(path string, tmpPath string, info BackupInfo) error {
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(tmpPath, os.O_RDWR | os.O_CREATE | os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	stream, err := _OpenFileStream(tmpPath, false)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	wal, err := OpenWriteAheadLogReadOnly(path + "-wal", info.PageSize)
+	if err != nil {
+		return err
+	}
+	if wal != nil {
+		err = wal.WritePages(stream)
+		wal.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	header, err := stream.ReadAt(0, HEADER_SIZE)
+	if err != nil {
+		return fmt.Errorf("%w: incremental backup over a file without a header: %v", ErrInvalidBackup, err)
+	}
+	_, err = _VerifyStorageHeader("Storage header", header)
+	if err != nil {
+		return err
+	}
+
+	rd := NewDataStreamFromBuffer(header)
+	rd.Seek(STORAGE_PAGER_META_OFFSET)
+	pageSize := rd.ReadUInt32()
+	if pageSize != info.PageSize {
+		return fmt.Errorf("%w: file pageSize=%v, backup pageSize=%v", ErrInvalidBackup, pageSize, info.PageSize)
+	}
+
+	lineage := _ReadStorageLineage(header)
+	if lineage != info.Lineage {
+		return fmt.Errorf("%w: file lineage=%x, backup lineage=%x", ErrInvalidBackup, lineage, info.Lineage)
+	}
+
+	epoch := _ReadStorageEpoch(header)
+	if epoch < info.BaseEpoch || epoch > info.Epoch {
+		return fmt.Errorf("%w: file epoch=%v outside of the backup epochs %v-%v", ErrInvalidBackup, epoch, info.BaseEpoch, info.Epoch)
+	}
+
+	return nil
+}
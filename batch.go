@@ -0,0 +1,175 @@
+package gokvdb
+
+import (
+	"fmt"
+	"sort"
+	"math/bits"
+)
+
+const (
+	// the longest string key a context row holds, see WriteHStr
+	MAX_STR_KEY_SIZE = 65535
+	// the longest string value a context row holds, see WriteChunk
+	MAX_STR_VALUE_SIZE = 1<<24 - 1
+)
+
+// I64StrPair is one item of LazyI64StrDict.SetPairs.
+type I64StrPair struct {
+	Key int64
+	Value string
+}
+
+// StrI64Pair is one item of LazyStrI64Dict.SetPairs.
+type StrI64Pair struct {
+	Key string
+	Value int64
+}
+
+// Found is the bitmap GetMany returns with its values, bit i is set when
+// the i-th key was found.
+type Found []uint64
+
+func _NewFound(n int) Found {
+	return make(Found, (n + 63) / 64)
+}
+
+func (f Found) Has(i int) bool {
+	return f[i / 64] & (1 << uint(i % 64)) != 0
+}
+
+func (f Found) _Set(i int) {
+	f[i / 64] |= 1 << uint(i % 64)
+}
+
+// Count returns how many keys were found.
+func (f Found) Count() int {
+	count := 0
+	for _, word := range f {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// _CheckStrKey runs before a string key gets into a dict, a longer key
+// would not fit the length WriteHStr writes.
+func _CheckStrKey(key string) error {
+	if len(key) > MAX_STR_KEY_SIZE {
+		return fmt.Errorf("%w: key of %v bytes, at most %v", ErrKeyTooLarge, len(key), MAX_STR_KEY_SIZE)
+	}
+	return nil
+}
+
+func _CheckStrValue(key int64, value string) error {
+	if len(value) > MAX_STR_VALUE_SIZE {
+		return fmt.Errorf("%w: key=%v value of %v bytes, at most %v", ErrValueTooLarge, key, len(value), MAX_STR_VALUE_SIZE)
+	}
+	return nil
+}
+
+// _BatchError fails a whole batch with the error of one of its items.
+func _BatchError(err error) error {
+	return fmt.Errorf("%w: %w", ErrInvalidBatch, err)
+}
+
+// _SortI64Pairs sorts a copy of pairs by key. Pairs of the same key keep
+// their order so the last one is applied last.
+func _SortI64Pairs(pairs []I64StrPair) []I64StrPair {
+	sorted := make([]I64StrPair, len(pairs))
+	copy(sorted, pairs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+// _SortStrPairs sorts a copy of pairs in the order of an index of
+// indexKind: by key, or by the hash of the key which lays out the keys of a
+// hash context next to each other. Pairs of the same key keep their order.
+func _SortStrPairs(pairs []StrI64Pair, indexKind byte) []StrI64Pair {
+	sortKeys := make([]string, len(pairs))
+	order := make([]int, len(pairs))
+	for i, pair := range pairs {
+		sortKeys[i] = string(_BulkStrKey(pair.Key, indexKind))
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sortKeys[order[i]] < sortKeys[order[j]]
+	})
+
+	sorted := make([]StrI64Pair, len(pairs))
+	for i, k := range order {
+		sorted[i] = pairs[k]
+	}
+	return sorted
+}
+
+// _SortI64Values returns the values sorted without duplicates.
+func _SortI64Values(values []int64) []int64 {
+	sorted := make(I64Array, len(values))
+	copy(sorted, values)
+	sort.Sort(sorted)
+
+	n := 0
+	for i, v := range sorted {
+		if i == 0 || v != sorted[n-1] {
+			sorted[n] = v
+			n++
+		}
+	}
+	return sorted[:n]
+}
+
+// _UniqueKeys returns the keys sorted by less without duplicates, and the
+// index in them of every key.
+func _UniqueKeys[K comparable](keys []K, less func(a K, b K) bool) ([]K, []int) {
+	order := make([]int, len(keys))
+	for i := range keys {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return less(keys[order[i]], keys[order[j]])
+	})
+
+	var unique []K
+	at := make([]int, len(keys))
+	for _, i := range order {
+		if len(unique) == 0 || unique[len(unique)-1] != keys[i] {
+			unique = append(unique, keys[i])
+		}
+		at[i] = len(unique) - 1
+	}
+
+	return unique, at
+}
+
+func _LessI64(a int64, b int64) bool {
+	return a < b
+}
+
+func _LessStr(a string, b string) bool {
+	return a < b
+}
+
+// _SpreadMany returns the values of the unique keys of _UniqueKeys in the
+// order of the keys they came from.
+func _SpreadMany[V any](values []V, found []bool, at []int) ([]V, Found) {
+	spread := make([]V, len(at))
+	spreadFound := _NewFound(len(at))
+	for i, k := range at {
+		if found[k] {
+			spread[i] = values[k]
+			spreadFound._Set(i)
+		}
+	}
+	return spread, spreadFound
+}
+
+// _SortPageIds returns the page ids of indexesByPageId in ascending order.
+func _SortPageIds(indexesByPageId map[uint32][]int) []uint32 {
+	pageIds := make([]uint32, 0, len(indexesByPageId))
+	for pid := range indexesByPageId {
+		pageIds = append(pageIds, pid)
+	}
+	sort.Sort(U32Array(pageIds))
+	return pageIds
+}
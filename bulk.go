@@ -0,0 +1,1226 @@
+package gokvdb
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"sort"
+	"bytes"
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+)
+
+const (
+	// BULK_SPILL_SIZE is the default byte budget of the records a
+	// BulkLoader holds before it spills them to a temp file
+	BULK_SPILL_SIZE = 64 << 20
+
+	// memory cost of a held record besides its bytes
+	BULK_RECORD_OVERHEAD = 48
+)
+
+// BulkLoader is the part the loaders of every dict kind share. The records
+// added are held up to SpillSize bytes, then sorted and spilled to a temp
+// file as a run. Finish merges the runs in key order, the last value added
+// for a key wins, and builds the dict pages bottom-up from the merged
+// records: every page is written once and fully packed, and the dict meta
+// is installed with one Save.
+//
+// The dict must not exist yet. Close removes the temp files, a loader is
+// closed by Finish too. Finish fails while a transaction is open and the
+// loader stays open, it can finish once the transaction is done.
+type BulkLoader struct {
+	// SpillSize is the byte budget of the records held in memory, zero
+	// means BULK_SPILL_SIZE.
+	SpillSize int
+	// TempDir is where the runs are spilled, empty means os.TempDir.
+	TempDir string
+
+	storage *Storage
+	db *DBContext
+	dbName string
+	dictName string
+	// a set keeps every value of a key, ordered after the key
+	isSet bool
+	records []_BulkRecord
+	size int
+	runPaths []string
+	count int
+	isDone bool
+}
+
+type _BulkRecord struct {
+	key []byte
+	value []byte
+}
+
+func _NewBulkLoader(s *Storage, dbName string, dictName string, isSet bool) (*BulkLoader, error) {
+
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	db, err := s.DB(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	l := new(BulkLoader)
+	l.storage = s
+	l.db = db
+	l.dbName = dbName
+	l.dictName = dictName
+	l.isSet = isSet
+
+	err = l._CheckNew(dictName)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *BulkLoader) ToString() string {
+	return fmt.Sprintf("<BulkLoader dict=%v count=%v runs=%v isDone=%v>", _DictOwner(l.dbName, l.dictName), l.count, len(l.runPaths), l.isDone)
+}
+
+// Count returns the number of records added, before the duplicates are
+// dropped.
+func (l *BulkLoader) Count() int {
+	return l.count
+}
+
+// Close removes the spilled runs and drops the records not loaded yet.
+func (l *BulkLoader) Close() error {
+
+	var firstErr error
+	for _, path := range l.runPaths {
+		err := os.Remove(path)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	l.runPaths = nil
+	l.records = nil
+	l.size = 0
+	l.isDone = true
+
+	return firstErr
+}
+
+func (l *BulkLoader) _CheckNew(name string) error {
+	if l.db._HasDict(name) {
+		return fmt.Errorf("%w: dict %v", ErrExists, _DictOwner(l.dbName, name))
+	}
+	return nil
+}
+
+func (l *BulkLoader) _SpillSize() int {
+	if l.SpillSize <= 0 {
+		return BULK_SPILL_SIZE
+	}
+	return l.SpillSize
+}
+
+func (l *BulkLoader) _Add(key []byte, value []byte) error {
+	if l.isDone {
+		return DBError{message: "bulk loader is finished"}
+	}
+
+	l.records = append(l.records, _BulkRecord{key: key, value: value})
+	l.size += len(key) + len(value) + BULK_RECORD_OVERHEAD
+	l.count += 1
+
+	if l.size >= l._SpillSize() {
+		return l._Spill()
+	}
+
+	return nil
+}
+
+func (l *BulkLoader) _Compare(a _BulkRecord, b _BulkRecord) int {
+	c := bytes.Compare(a.key, b.key)
+	if c == 0 && l.isSet {
+		c = bytes.Compare(a.value, b.value)
+	}
+	return c
+}
+
+// _SortRecords sorts the held records and keeps the last one of each key.
+func (l *BulkLoader) _SortRecords() {
+
+	sort.SliceStable(l.records, func(i, j int) bool {
+		return l._Compare(l.records[i], l.records[j]) < 0
+	})
+
+	n := 0
+	for i, record := range l.records {
+		if i > 0 && l._Compare(record, l.records[n-1]) == 0 {
+			l.records[n-1] = record
+			continue
+		}
+		l.records[n] = record
+		n += 1
+	}
+	l.records = l.records[:n]
+}
+
+// _Spill writes the held records sorted to a new run.
+func (l *BulkLoader) _Spill() error {
+
+	l._SortRecords()
+
+	f, err := os.CreateTemp(l.TempDir, "gokvdb-bulk-*.run")
+	if err != nil {
+		return err
+	}
+	l.runPaths = append(l.runPaths, f.Name())
+
+	w := bufio.NewWriter(f)
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+
+	for _, record := range l.records {
+		for _, data := range [][]byte{record.key, record.value} {
+			n := binary.PutUvarint(lenBuf, uint64(len(data)))
+			_, err = w.Write(lenBuf[:n])
+			if err == nil {
+				_, err = w.Write(data)
+			}
+			if err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	l.records = nil
+	l.size = 0
+
+	return nil
+}
+
+// _Finish merges the records and runs build over them, then saves the
+// storage. A failed Finish leaves pages the storage has not saved yet.
+// It checks for a transaction first, the pages and the meta would land in
+// it and the storage save fails.
+func (l *BulkLoader) _Finish(build func(records *_BulkMerge) error) error {
+	if l.isDone {
+		return DBError{message: "bulk loader is finished"}
+	}
+	if l.storage.tx != nil {
+		return DBError{message: "transaction in progress"}
+	}
+	defer l.Close()
+
+	err := l._CheckNew(l.dictName)
+	if err != nil {
+		return err
+	}
+
+	records, err := l._Merge()
+	if err != nil {
+		return err
+	}
+	defer records.Close()
+
+	err = build(records)
+	if err != nil {
+		return err
+	}
+
+	return l.storage.Save()
+}
+
+func (l *BulkLoader) _NewInternalPager(name string) (IPager, error) {
+	s := l.storage
+	return NewInternalPager(s.pager, s.options._InternalPageSize(128), nil, _DictOwner(l.dbName, name))
+}
+
+// _Trim lets the page cache write back the contexts of the pages written.
+func (l *BulkLoader) _Trim() error {
+	return _PageCacheOf(l.storage.pager)._Trim()
+}
+
+/* */
+
+// _BulkRun reads the records of a spilled run, or of the held records for
+// the last run.
+type _BulkRun struct {
+	order int
+	rd *bufio.Reader
+	records []_BulkRecord
+	record _BulkRecord
+}
+
+func (r *_BulkRun) _Next() (bool, error) {
+
+	if r.rd == nil {
+		if len(r.records) == 0 {
+			return false, nil
+		}
+		r.record = r.records[0]
+		r.records = r.records[1:]
+		return true, nil
+	}
+
+	key, err := r._ReadBytes()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	value, err := r._ReadBytes()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return false, err
+	}
+
+	r.record = _BulkRecord{key: key, value: value}
+
+	return true, nil
+}
+
+func (r *_BulkRun) _ReadBytes() ([]byte, error) {
+	size, err := binary.ReadUvarint(r.rd)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	_, err = io.ReadFull(r.rd, data)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return data, err
+}
+
+// _BulkMerge walks the records of every run in key order. Of the records
+// with the same key it gives the one of the latest run.
+type _BulkMerge struct {
+	loader *BulkLoader
+	runs []*_BulkRun
+	files []*os.File
+	pending _BulkRecord
+	hasPending bool
+	record _BulkRecord
+	err error
+}
+
+func (m *_BulkMerge) Len() int { return len(m.runs) }
+func (m *_BulkMerge) Swap(i, j int) { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+func (m *_BulkMerge) Push(x interface{}) { m.runs = append(m.runs, x.(*_BulkRun)) }
+
+func (m *_BulkMerge) Less(i, j int) bool {
+	c := m.loader._Compare(m.runs[i].record, m.runs[j].record)
+	if c != 0 {
+		return c < 0
+	}
+	return m.runs[i].order < m.runs[j].order
+}
+
+func (m *_BulkMerge) Pop() interface{} {
+	run := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return run
+}
+
+func (l *BulkLoader) _Merge() (*_BulkMerge, error) {
+
+	l._SortRecords()
+
+	m := &_BulkMerge{loader: l}
+
+	var runs []*_BulkRun
+	for i, path := range l.runPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.files = append(m.files, f)
+		runs = append(runs, &_BulkRun{order: i, rd: bufio.NewReader(f)})
+	}
+	runs = append(runs, &_BulkRun{order: len(runs), records: l.records})
+	l.records = nil
+
+	for _, run := range runs {
+		more, err := run._Next()
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		if more {
+			m.runs = append(m.runs, run)
+		}
+	}
+	heap.Init(m)
+
+	return m, nil
+}
+
+// Next moves to the next key, false at the end or on an error.
+func (m *_BulkMerge) Next() bool {
+
+	for m.err == nil && len(m.runs) > 0 {
+		run := m.runs[0]
+		record := run.record
+
+		more, err := run._Next()
+		if err != nil {
+			m.err = err
+			return false
+		}
+		if more {
+			heap.Fix(m, 0)
+		} else {
+			heap.Pop(m)
+		}
+
+		if !m.hasPending {
+			m.pending = record
+			m.hasPending = true
+			continue
+		}
+
+		if m.loader._Compare(record, m.pending) == 0 {
+			m.pending = record
+			continue
+		}
+
+		m.record = m.pending
+		m.pending = record
+		return true
+	}
+
+	if m.err == nil && m.hasPending {
+		m.record = m.pending
+		m.hasPending = false
+		return true
+	}
+
+	return false
+}
+
+func (m *_BulkMerge) Key() []byte {
+	return m.record.key
+}
+
+func (m *_BulkMerge) Value() []byte {
+	return m.record.value
+}
+
+func (m *_BulkMerge) Err() error {
+	return m.err
+}
+
+func (m *_BulkMerge) Close() {
+	for _, f := range m.files {
+		f.Close()
+	}
+	m.files = nil
+	m.runs = nil
+}
+
+// _BulkI64Key encodes key so the byte order is the int64 order.
+func _BulkI64Key(key int64) []byte {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(key) ^ (1 << 63))
+	return data
+}
+
+func _BulkI64(data []byte) int64 {
+	return int64(binary.BigEndian.Uint64(data) ^ (1 << 63))
+}
+
+// _BulkStrKey encodes key in the order its index is built in: the byte
+// order for an ordered index, the hash order first for a hashed one.
+func _BulkStrKey(key string, indexKind byte) []byte {
+	if indexKind == STR_INDEX_ORDERED {
+		return []byte(key)
+	}
+	data := make([]byte, 4 + len(key))
+	binary.BigEndian.PutUint32(data, uint32(_HashString(key)) ^ (1 << 31))
+	copy(data[4:], key)
+	return data
+}
+
+func _BulkStr(data []byte, indexKind byte) string {
+	if indexKind == STR_INDEX_ORDERED {
+		return string(data)
+	}
+	return string(data[4:])
+}
+
+/* */
+
+// _IStrI64IndexBuilder fills an empty IStrI64Index from keys added in the
+// order of _BulkStrKey.
+type _IStrI64IndexBuilder interface {
+	Add(key string, value int64) error
+	Finish() ([]byte, error)
+}
+
+func _NewStrI64IndexBuilder(pager IPager, indexKind byte) (_IStrI64IndexBuilder, error) {
+	if indexKind == STR_INDEX_ORDERED {
+		bt, err := NewStrI64BTree(pager, nil)
+		if err != nil {
+			return nil, err
+		}
+		return _NewStrI64BTreeBuilder(bt), nil
+	}
+
+	factory, err := NewSimpleStrI64Factory(pager, nil)
+	if err != nil {
+		return nil, err
+	}
+	return _NewSimpleStrI64Builder(factory), nil
+}
+
+// _BulkBlobMap fills an empty BTreeBlobMap from keys added in ascending
+// order, a node context is written once its keys are all added.
+type _BulkBlobMap struct {
+	bt *BTreeBlobMap
+	nodeKey int64
+	keys []int64
+	pageIds []uint32
+	nodeKeys []int64
+	dataPageIds []uint32
+}
+
+func (b *_BulkBlobMap) Add(key int64, value []byte) error {
+
+	nodeKey := b.bt._GetBranchKey(key)
+	if len(b.keys) > 0 && nodeKey != b.nodeKey {
+		err := b._WriteNode()
+		if err != nil {
+			return err
+		}
+	}
+
+	pid := b.bt.pager.CreatePageId()
+	err := b.bt.pager.WritePayloadData(pid, value)
+	if err != nil {
+		return err
+	}
+
+	b.nodeKey = nodeKey
+	b.keys = append(b.keys, key)
+	b.pageIds = append(b.pageIds, pid)
+
+	return nil
+}
+
+func (b *_BulkBlobMap) _WriteNode() error {
+
+	w := NewDataStream()
+	w.WriteUInt32(uint32(len(b.keys)))
+	for i, key := range b.keys {
+		w.WriteUInt64(uint64(key))
+		w.WriteUInt32(b.pageIds[i])
+	}
+
+	pid := b.bt.pager.CreatePageId()
+	err := b.bt.pager.WritePayloadData(pid, w.ToBytes())
+	if err != nil {
+		return err
+	}
+
+	b.nodeKeys = append(b.nodeKeys, b.nodeKey)
+	b.dataPageIds = append(b.dataPageIds, pid)
+	b.keys = nil
+	b.pageIds = nil
+
+	return nil
+}
+
+// Finish writes the last node context and the nodes and returns the map
+// meta.
+func (b *_BulkBlobMap) Finish() ([]byte, error) {
+	if len(b.keys) > 0 {
+		err := b._WriteNode()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b.bt._BuildSorted(b.nodeKeys, b.dataPageIds)
+
+	return b.bt.Save()
+}
+
+// _BulkI64Set writes a LazyI64Set from values added in ascending order.
+type _BulkI64Set struct {
+	pager IPager
+	tree *_BranchBuilder
+	branchKey int64
+	values []int64
+}
+
+func _NewBulkI64Set(pager IPager) *_BulkI64Set {
+	b := new(_BulkI64Set)
+	b.pager = pager
+	b.tree = _NewBranchBuilder(NewBranchI64BTreeFactory(pager, nil, 2))
+	return b
+}
+
+func (b *_BulkI64Set) Add(value int64) error {
+
+	branchKey := value / 4096
+	if len(b.values) > 0 && branchKey != b.branchKey {
+		err := b._WriteContext()
+		if err != nil {
+			return err
+		}
+	}
+
+	b.branchKey = branchKey
+	b.values = append(b.values, value)
+
+	return nil
+}
+
+func (b *_BulkI64Set) _WriteContext() error {
+
+	w := NewDataStream()
+	w.WriteUInt24(uint32(len(b.values)))
+	for _, value := range b.values {
+		w.WriteUInt64(uint64(value))
+	}
+
+	pid := b.pager.CreatePageId()
+	err := b.pager.WritePayloadData(pid, w.ToBytes())
+	if err != nil {
+		return err
+	}
+	b.values = nil
+
+	return b.tree.Add(b.branchKey, int64(pid))
+}
+
+// Finish writes the last context and the tree pages and returns the set
+// meta.
+func (b *_BulkI64Set) Finish() ([]byte, error) {
+	if len(b.values) > 0 {
+		err := b._WriteContext()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	treeMeta, err := b.tree.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	w := NewDataStream()
+	w.WriteChunk(treeMeta)
+
+	return w.ToBytes(), nil
+}
+
+/* */
+
+// I64StrBulkLoader loads a new LazyI64StrDict.
+type I64StrBulkLoader struct {
+	*BulkLoader
+}
+
+func NewI64StrBulkLoader(s *Storage, dbName string, dictName string) (*I64StrBulkLoader, error) {
+	l, err := _NewBulkLoader(s, dbName, dictName, false)
+	if err != nil {
+		return nil, err
+	}
+	return &I64StrBulkLoader{l}, nil
+}
+
+func (l *I64StrBulkLoader) Add(key int64, value string) error {
+	err := _CheckStrValue(key, value)
+	if err != nil {
+		return err
+	}
+	return l._Add(_BulkI64Key(key), []byte(value))
+}
+
+// Finish writes a context for each branch key and the branch tree over
+// them, then opens the dict.
+func (l *I64StrBulkLoader) Finish() (*LazyI64StrDict, error) {
+
+	err := l._Finish(func(records *_BulkMerge) error {
+
+		internalPager, err := l._NewInternalPager(l.dictName)
+		if err != nil {
+			return err
+		}
+		keyBuilder := _NewBranchBuilder(NewBranchI64BTreeFactory(internalPager, nil, 3))
+
+		var branchKey int64
+		var keys []int64
+		var values [][]byte
+
+		writeContext := func() error {
+			w := NewDataStream()
+			w.WriteUInt24(uint32(len(keys)))
+			for i, key := range keys {
+				w.WriteUInt64(uint64(key))
+				w.WriteChunk(values[i])
+			}
+
+			pid := internalPager.CreatePageId()
+			err := internalPager.WritePayloadData(pid, w.ToBytes())
+			if err != nil {
+				return err
+			}
+			keys = nil
+			values = nil
+
+			err = keyBuilder.Add(branchKey, int64(pid))
+			if err != nil {
+				return err
+			}
+			return l._Trim()
+		}
+
+		for records.Next() {
+			key := _BulkI64(records.Key())
+			if len(keys) > 0 && key / 4096 != branchKey {
+				err = writeContext()
+				if err != nil {
+					return err
+				}
+			}
+			branchKey = key / 4096
+			keys = append(keys, key)
+			values = append(values, records.Value())
+		}
+		if records.Err() != nil {
+			return records.Err()
+		}
+		if len(keys) > 0 {
+			err = writeContext()
+			if err != nil {
+				return err
+			}
+		}
+
+		keyMeta, err := keyBuilder.Finish()
+		if err != nil {
+			return err
+		}
+		internalPagerMeta, err := internalPager.Save()
+		if err != nil {
+			return err
+		}
+
+		metaW := NewDataStream()
+		metaW.WriteChunk(internalPagerMeta)
+		metaW.WriteChunk(keyMeta)
+
+		return l.db._SetDictMeta(l.dictName, DICT_KIND_I64STR, metaW.ToBytes())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewI64StrDict(l.storage, l.dbName, l.dictName)
+}
+
+// StrI64BulkLoader loads a new LazyStrI64Dict.
+type StrI64BulkLoader struct {
+	*BulkLoader
+	indexKind byte
+}
+
+func NewStrI64BulkLoader(s *Storage, dbName string, dictName string) (*StrI64BulkLoader, error) {
+	return _NewStrI64BulkLoader(s, dbName, dictName, STR_INDEX_HASH)
+}
+
+// NewOrderedStrI64BulkLoader loads a dict with an ordered key index, see
+// NewOrderedStrI64Dict.
+func NewOrderedStrI64BulkLoader(s *Storage, dbName string, dictName string) (*StrI64BulkLoader, error) {
+	return _NewStrI64BulkLoader(s, dbName, dictName, STR_INDEX_ORDERED)
+}
+
+func _NewStrI64BulkLoader(s *Storage, dbName string, dictName string, indexKind byte) (*StrI64BulkLoader, error) {
+	l, err := _NewBulkLoader(s, dbName, dictName, false)
+	if err != nil {
+		return nil, err
+	}
+	return &StrI64BulkLoader{l, indexKind}, nil
+}
+
+func (l *StrI64BulkLoader) Add(key string, value int64) error {
+	err := _CheckStrKey(key)
+	if err != nil {
+		return err
+	}
+	return l._Add(_BulkStrKey(key, l.indexKind), _BulkI64Key(value))
+}
+
+// Finish writes the key index, then opens the dict.
+func (l *StrI64BulkLoader) Finish() (*LazyStrI64Dict, error) {
+
+	err := l._Finish(func(records *_BulkMerge) error {
+
+		internalPager, err := l._NewInternalPager(l.dictName)
+		if err != nil {
+			return err
+		}
+		index, err := _NewStrI64IndexBuilder(internalPager, l.indexKind)
+		if err != nil {
+			return err
+		}
+
+		for records.Next() {
+			err = index.Add(_BulkStr(records.Key(), l.indexKind), _BulkI64(records.Value()))
+			if err == nil {
+				err = l._Trim()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if records.Err() != nil {
+			return records.Err()
+		}
+
+		return _SetBulkStrI64Meta(l.db, l.dictName, internalPager, index, l.indexKind)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return _NewStrI64Dict(l.storage, l.dbName, l.dictName, l.indexKind)
+}
+
+// _SetBulkStrI64Meta installs the meta of a StrI64 dict written by index.
+func _SetBulkStrI64Meta(db *DBContext, dictName string, internalPager IPager, index _IStrI64IndexBuilder, indexKind byte) error {
+
+	indexMeta, err := index.Finish()
+	if err != nil {
+		return err
+	}
+	internalPagerMeta, err := internalPager.Save()
+	if err != nil {
+		return err
+	}
+
+	metaW := NewDataStream()
+	metaW.WriteChunk(internalPagerMeta)
+	metaW.WriteChunk(indexMeta)
+	metaW.WriteUInt8(indexKind)
+
+	return db._SetDictMeta(dictName, DICT_KIND_STRI64, metaW.ToBytes())
+}
+
+// I64BlobBulkLoader loads a new LazyI64BlobDict.
+type I64BlobBulkLoader struct {
+	*BulkLoader
+}
+
+func NewI64BlobBulkLoader(s *Storage, dbName string, dictName string) (*I64BlobBulkLoader, error) {
+	l, err := _NewBulkLoader(s, dbName, dictName, false)
+	if err != nil {
+		return nil, err
+	}
+	return &I64BlobBulkLoader{l}, nil
+}
+
+func (l *I64BlobBulkLoader) Add(key int64, value []byte) error {
+	return l._Add(_BulkI64Key(key), append([]byte(nil), value...))
+}
+
+// Finish writes the values, a context for each node and the balanced
+// nodes over them, then opens the dict.
+func (l *I64BlobBulkLoader) Finish() (*LazyI64BlobDict, error) {
+
+	err := l._Finish(func(records *_BulkMerge) error {
+
+		internalPager, err := l._NewInternalPager(l.dictName)
+		if err != nil {
+			return err
+		}
+		bt, err := NewBTreeBlobMap(internalPager, nil)
+		if err != nil {
+			return err
+		}
+		blobs := &_BulkBlobMap{bt: bt}
+
+		for records.Next() {
+			err = blobs.Add(_BulkI64(records.Key()), records.Value())
+			if err == nil {
+				err = l._Trim()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if records.Err() != nil {
+			return records.Err()
+		}
+
+		btMeta, err := blobs.Finish()
+		if err != nil {
+			return err
+		}
+		internalPagerMeta, err := internalPager.Save()
+		if err != nil {
+			return err
+		}
+
+		metaW := NewDataStream()
+		metaW.WriteChunk(internalPagerMeta)
+		metaW.WriteChunk(btMeta)
+
+		return l.db._SetDictMeta(l.dictName, DICT_KIND_I64BLOB, metaW.ToBytes())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewI64BlobDict(l.storage, l.dbName, l.dictName)
+}
+
+// StrBlobBulkLoader loads a new LazyStrBlobDict. The keys get their ids in
+// the order they are loaded.
+type StrBlobBulkLoader struct {
+	*BulkLoader
+	indexKind byte
+}
+
+func NewStrBlobBulkLoader(s *Storage, dbName string, dictName string) (*StrBlobBulkLoader, error) {
+	return _NewStrBlobBulkLoader(s, dbName, dictName, STR_INDEX_HASH)
+}
+
+// NewOrderedStrBlobBulkLoader loads a dict with an ordered key index, see
+// NewOrderedStrI64Dict.
+func NewOrderedStrBlobBulkLoader(s *Storage, dbName string, dictName string) (*StrBlobBulkLoader, error) {
+	return _NewStrBlobBulkLoader(s, dbName, dictName, STR_INDEX_ORDERED)
+}
+
+func _NewStrBlobBulkLoader(s *Storage, dbName string, dictName string, indexKind byte) (*StrBlobBulkLoader, error) {
+	l, err := _NewBulkLoader(s, dbName, dictName, false)
+	if err != nil {
+		return nil, err
+	}
+	err = l._CheckNew(dictName + STRBLOB_KEYS_SUFFIX)
+	if err != nil {
+		return nil, err
+	}
+	return &StrBlobBulkLoader{l, indexKind}, nil
+}
+
+func (l *StrBlobBulkLoader) Add(key string, value []byte) error {
+	err := _CheckStrKey(key)
+	if err != nil {
+		return err
+	}
+	return l._Add(_BulkStrKey(key, l.indexKind), append([]byte(nil), value...))
+}
+
+// Finish writes the keys dict and the values by id, then opens the dict.
+func (l *StrBlobBulkLoader) Finish() (*LazyStrBlobDict, error) {
+
+	keysName := l.dictName + STRBLOB_KEYS_SUFFIX
+
+	err := l._Finish(func(records *_BulkMerge) error {
+
+		err := l._CheckNew(keysName)
+		if err != nil {
+			return err
+		}
+
+		keysPager, err := l._NewInternalPager(keysName)
+		if err != nil {
+			return err
+		}
+		index, err := _NewStrI64IndexBuilder(keysPager, l.indexKind)
+		if err != nil {
+			return err
+		}
+
+		internalPager, err := l._NewInternalPager(l.dictName)
+		if err != nil {
+			return err
+		}
+		bt, err := NewBTreeBlobMap(internalPager, nil)
+		if err != nil {
+			return err
+		}
+		blobs := &_BulkBlobMap{bt: bt}
+
+		var lastId int64
+		for records.Next() {
+			lastId += 1
+			err = index.Add(_BulkStr(records.Key(), l.indexKind), lastId)
+			if err == nil {
+				err = blobs.Add(lastId, records.Value())
+			}
+			if err == nil {
+				err = l._Trim()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if records.Err() != nil {
+			return records.Err()
+		}
+
+		err = _SetBulkStrI64Meta(l.db, keysName, keysPager, index, l.indexKind)
+		if err != nil {
+			return err
+		}
+
+		btMeta, err := blobs.Finish()
+		if err != nil {
+			return err
+		}
+		internalPagerMeta, err := internalPager.Save()
+		if err != nil {
+			return err
+		}
+
+		metaW := NewDataStream()
+		metaW.WriteUInt64(uint64(lastId))
+		metaW.WriteChunk(internalPagerMeta)
+		metaW.WriteChunk(btMeta)
+
+		return l.db._SetDictMeta(l.dictName, DICT_KIND_STRBLOB, metaW.ToBytes())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return _NewStrBlobDict(l.storage, l.dbName, l.dictName, l.indexKind)
+}
+
+// I64I64SetBulkLoader loads a new LazyI64I64SetDict, a pair added twice is
+// kept once.
+type I64I64SetBulkLoader struct {
+	*BulkLoader
+}
+
+func NewI64I64SetBulkLoader(s *Storage, dbName string, dictName string) (*I64I64SetBulkLoader, error) {
+	l, err := _NewBulkLoader(s, dbName, dictName, true)
+	if err != nil {
+		return nil, err
+	}
+	return &I64I64SetBulkLoader{l}, nil
+}
+
+func (l *I64I64SetBulkLoader) Add(key int64, value int64) error {
+	return l._Add(_BulkI64Key(key), _BulkI64Key(value))
+}
+
+// Finish writes the set of each key and the branch tree over them, then
+// opens the dict.
+func (l *I64I64SetBulkLoader) Finish() (*LazyI64I64SetDict, error) {
+
+	err := l._Finish(func(records *_BulkMerge) error {
+
+		internalPager, err := l._NewInternalPager(l.dictName)
+		if err != nil {
+			return err
+		}
+		keyBuilder := _NewBranchBuilder(NewBranchI64BTreeFactory(internalPager, nil, 3))
+
+		var key int64
+		var set *_BulkI64Set
+
+		writeSet := func() error {
+			setMeta, err := set.Finish()
+			if err != nil {
+				return err
+			}
+			pid := internalPager.CreatePageId()
+			err = internalPager.WritePayloadData(pid, setMeta)
+			if err != nil {
+				return err
+			}
+			set = nil
+			return keyBuilder.Add(key, int64(pid))
+		}
+
+		for records.Next() {
+			recordKey := _BulkI64(records.Key())
+			if set != nil && recordKey != key {
+				err = writeSet()
+				if err != nil {
+					return err
+				}
+			}
+			if set == nil {
+				set = _NewBulkI64Set(internalPager)
+				key = recordKey
+			}
+			err = set.Add(_BulkI64(records.Value()))
+			if err == nil {
+				err = l._Trim()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if records.Err() != nil {
+			return records.Err()
+		}
+		if set != nil {
+			err = writeSet()
+			if err != nil {
+				return err
+			}
+		}
+
+		treeFactoryMeta, err := keyBuilder.Finish()
+		if err != nil {
+			return err
+		}
+		internalPagerMeta, err := internalPager.Save()
+		if err != nil {
+			return err
+		}
+
+		metaW := NewDataStream()
+		metaW.WriteChunk(internalPagerMeta)
+		metaW.WriteChunk(treeFactoryMeta)
+
+		return l.db._SetDictMeta(l.dictName, DICT_KIND_I64I64SET, metaW.ToBytes())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLazyI64I64SetDict(l.storage, l.dbName, l.dictName)
+}
+
+// StrI64SetBulkLoader loads a new LazyStrI64SetDict, a pair added twice is
+// kept once.
+type StrI64SetBulkLoader struct {
+	*BulkLoader
+	indexKind byte
+}
+
+func NewStrI64SetBulkLoader(s *Storage, dbName string, dictName string) (*StrI64SetBulkLoader, error) {
+	return _NewStrI64SetBulkLoader(s, dbName, dictName, STR_INDEX_HASH)
+}
+
+// NewOrderedStrI64SetBulkLoader loads a dict with an ordered key index, see
+// NewOrderedStrI64Dict.
+func NewOrderedStrI64SetBulkLoader(s *Storage, dbName string, dictName string) (*StrI64SetBulkLoader, error) {
+	return _NewStrI64SetBulkLoader(s, dbName, dictName, STR_INDEX_ORDERED)
+}
+
+func _NewStrI64SetBulkLoader(s *Storage, dbName string, dictName string, indexKind byte) (*StrI64SetBulkLoader, error) {
+	l, err := _NewBulkLoader(s, dbName, dictName, true)
+	if err != nil {
+		return nil, err
+	}
+	return &StrI64SetBulkLoader{l, indexKind}, nil
+}
+
+func (l *StrI64SetBulkLoader) Add(key string, value int64) error {
+	err := _CheckStrKey(key)
+	if err != nil {
+		return err
+	}
+	return l._Add(_BulkStrKey(key, l.indexKind), _BulkI64Key(value))
+}
+
+// Finish writes the set of each key and the key index over them, then
+// opens the dict.
+func (l *StrI64SetBulkLoader) Finish() (*LazyStrI64SetDict, error) {
+
+	err := l._Finish(func(records *_BulkMerge) error {
+
+		internalPager, err := l._NewInternalPager(l.dictName)
+		if err != nil {
+			return err
+		}
+		index, err := _NewStrI64IndexBuilder(internalPager, l.indexKind)
+		if err != nil {
+			return err
+		}
+
+		var key string
+		var set *_BulkI64Set
+
+		writeSet := func() error {
+			setMeta, err := set.Finish()
+			if err != nil {
+				return err
+			}
+			pid := internalPager.CreatePageId()
+			err = internalPager.WritePayloadData(pid, setMeta)
+			if err != nil {
+				return err
+			}
+			set = nil
+			return index.Add(key, int64(pid))
+		}
+
+		for records.Next() {
+			recordKey := _BulkStr(records.Key(), l.indexKind)
+			if set != nil && recordKey != key {
+				err = writeSet()
+				if err != nil {
+					return err
+				}
+			}
+			if set == nil {
+				set = _NewBulkI64Set(internalPager)
+				key = recordKey
+			}
+			err = set.Add(_BulkI64(records.Value()))
+			if err == nil {
+				err = l._Trim()
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if records.Err() != nil {
+			return records.Err()
+		}
+		if set != nil {
+			err = writeSet()
+			if err != nil {
+				return err
+			}
+		}
+
+		indexMeta, err := index.Finish()
+		if err != nil {
+			return err
+		}
+		internalPagerMeta, err := internalPager.Save()
+		if err != nil {
+			return err
+		}
+
+		metaW := NewDataStream()
+		metaW.WriteChunk(internalPagerMeta)
+		metaW.WriteChunk(indexMeta)
+		metaW.WriteUInt8(l.indexKind)
+
+		return l.db._SetDictMeta(l.dictName, DICT_KIND_STRI64SET, metaW.ToBytes())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return _NewStrI64SetDict(l.storage, l.dbName, l.dictName, l.indexKind)
+}
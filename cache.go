@@ -0,0 +1,535 @@
+package gokvdb
+
+import (
+	"fmt"
+	"sync"
+	"container/list"
+)
+
+const (
+	PAGE_CACHE_DEFAULT_SIZE int64 = 64 << 20
+	// a smaller budget is raised to it, a context of a dense int64 range
+	// alone may take most of it
+	PAGE_CACHE_MIN_SIZE int64 = 256 << 10
+
+	// rough cost of a cached context and of each of its rows on top of
+	// their bytes
+	PAGE_CACHE_ENTRY_OVERHEAD int = 128
+	PAGE_CACHE_ROW_OVERHEAD int = 48
+)
+
+// IPageCacheItem is a page context a dict keeps in memory. _WriteBack
+// stores a changed context through its pager and returns false when it
+// has to stay until its owner saves, _Release drops it from its owner.
+// _Owner names the dict, see _PagerOwner.
+type IPageCacheItem interface {
+	_IsChanged() bool
+	_WriteBack() (bool, error)
+	_Release()
+	_Owner() string
+	ToString() string
+}
+
+// PageCache is the LRU shared by the dicts of a Storage. It only keeps
+// count of the contexts the dicts load, a dict trims it at the start of
+// its operations and once the outermost one is done. No context the
+// running operation used and not the most recently used one is dropped,
+// so a context larger than the budget is not written back on every
+// operation. Changed contexts are written back to their
+// InternalPager, the contexts of an InternalPager to a new page of the
+// main pager.
+type PageCache struct {
+	budget int64
+	size int64
+	lru *list.List
+	isTrimming bool
+	depth int
+	// opSeq numbers the outermost operations, the entries keep the one
+	// that last used them
+	opSeq uint64
+
+	// dropEpochByOwner counts the drops of each dict, an InternalPager
+	// opened before the last drop of its owner is dropped with it
+	dropEpochByOwner map[string]uint64
+	// unsavedPagers hold pages of the main pager taken since their last
+	// save, a drop of their owner frees them
+	unsavedPagers map[*InternalPager]bool
+	// vacuums counts the Vacuums, the pagers opened before one are stale
+	vacuums uint64
+
+	hits uint64
+	misses uint64
+	evictions uint64
+	writeBacks uint64
+
+	rwlock sync.Mutex
+}
+
+type PageCacheEntry struct {
+	item IPageCacheItem
+	size int64
+	elem *list.Element
+	opSeq uint64
+}
+
+type PageCacheStats struct {
+	Hits uint64
+	Misses uint64
+	Evictions uint64
+	WriteBacks uint64
+	Pages int
+	Bytes int64
+	Budget int64
+}
+
+// NewPageCache returns a cache of budget bytes, zero or less never evicts
+// and a budget below PAGE_CACHE_MIN_SIZE is raised to it.
+func NewPageCache(budget int64) *PageCache {
+	if budget > 0 && budget < PAGE_CACHE_MIN_SIZE {
+		budget = PAGE_CACHE_MIN_SIZE
+	}
+
+	c := new(PageCache)
+	c.budget = budget
+	c.lru = list.New()
+	c.dropEpochByOwner = make(map[string]uint64)
+	c.unsavedPagers = make(map[*InternalPager]bool)
+	return c
+}
+
+func (c *PageCache) ToString() string {
+	return fmt.Sprintf("<PageCache pages=%v bytes=%v budget=%v>", c.lru.Len(), c.size, c.budget)
+}
+
+func (stats PageCacheStats) ToString() string {
+	return fmt.Sprintf("<PageCacheStats hits=%v misses=%v evictions=%v writeBacks=%v pages=%v bytes=%v budget=%v>", stats.Hits, stats.Misses, stats.Evictions, stats.WriteBacks, stats.Pages, stats.Bytes, stats.Budget)
+}
+
+// _PageCacheOf returns the cache of the storage behind pager, nil for a
+// pager opened on its own.
+func _PageCacheOf(pager IPager) *PageCache {
+	switch p := pager.(type) {
+	case *StreamPager:
+		return p.cache
+	case *InternalPager:
+		return p.cache
+	}
+	return nil
+}
+
+// _PagerOwner returns the dict an InternalPager belongs to, "" for any
+// other pager.
+func _PagerOwner(pager IPager) string {
+	p, ok := pager.(*InternalPager)
+	if ok {
+		return p.owner
+	}
+	return ""
+}
+
+// _CheckDropped fails with ErrDropped when the dict of pager was dropped
+// after the pager was opened and with ErrStaleDict when the storage was
+// vacuumed since.
+func _CheckDropped(pager IPager) error {
+	p, ok := pager.(*InternalPager)
+	if !ok {
+		return nil
+	}
+	if p.cache._IsDropped(p.owner, p.dropEpoch) {
+		return fmt.Errorf("%w: %v", ErrDropped, p.owner)
+	}
+	if p.cache._IsStale(p.vacuums) {
+		return fmt.Errorf("%w: %v was opened before Vacuum", ErrStaleDict, p.owner)
+	}
+	return nil
+}
+
+func (c *PageCache) Stats() PageCacheStats {
+	if c == nil {
+		return PageCacheStats{}
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	return PageCacheStats{
+		Hits: c.hits,
+		Misses: c.misses,
+		Evictions: c.evictions,
+		WriteBacks: c.writeBacks,
+		Pages: c.lru.Len(),
+		Bytes: c.size,
+		Budget: c.budget,
+	}
+}
+
+// _Add counts a context loaded from its pager or newly created.
+func (c *PageCache) _Add(item IPageCacheItem, size int, isLoaded bool) *PageCacheEntry {
+
+	entry := new(PageCacheEntry)
+	entry.item = item
+	entry.size = int64(size + PAGE_CACHE_ENTRY_OVERHEAD)
+
+	if c == nil {
+		return entry
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	if isLoaded {
+		c.misses += 1
+	}
+
+	entry.elem = c.lru.PushFront(entry)
+	entry.opSeq = c.opSeq
+	c.size += entry.size
+
+	return entry
+}
+
+// _Touch counts a hit. An entry forgotten by _Reset is counted again.
+func (c *PageCache) _Touch(entry *PageCacheEntry) {
+	if c == nil || entry == nil {
+		return
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	c.hits += 1
+	entry.opSeq = c.opSeq
+
+	if entry.elem == nil {
+		entry.elem = c.lru.PushFront(entry)
+		c.size += entry.size
+		return
+	}
+
+	c.lru.MoveToFront(entry.elem)
+}
+
+func (c *PageCache) _Resize(entry *PageCacheEntry, delta int) {
+	if entry == nil {
+		return
+	}
+
+	entry.size += int64(delta)
+
+	if c == nil || entry.elem == nil {
+		return
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	c.size += int64(delta)
+}
+
+// _Remove forgets a context its owner has dropped.
+func (c *PageCache) _Remove(entry *PageCacheEntry) {
+	if c == nil || entry == nil {
+		return
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	c._RemoveEntry(entry)
+}
+
+func (c *PageCache) _RemoveEntry(entry *PageCacheEntry) {
+	if entry.elem == nil {
+		return
+	}
+
+	c.lru.Remove(entry.elem)
+	entry.elem = nil
+	c.size -= entry.size
+}
+
+// _Reset forgets every entry without releasing it. Tx.Rollback uses it
+// because the contexts of its dicts are never saved.
+func (c *PageCache) _Reset() {
+	if c == nil {
+		return
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*PageCacheEntry).elem = nil
+	}
+
+	c.lru.Init()
+	c.size = 0
+	// the rollback gave their pages back already
+	c.unsavedPagers = make(map[*InternalPager]bool)
+}
+
+// _DropEpoch returns the number of drops of owner, NewInternalPager
+// keeps it.
+func (c *PageCache) _DropEpoch(owner string) uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	return c.dropEpochByOwner[owner]
+}
+
+func (c *PageCache) _IsDropped(owner string, epoch uint64) bool {
+	if c == nil {
+		return false
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	return c.dropEpochByOwner[owner] != epoch
+}
+
+// _Vacuums returns the number of Vacuums, NewInternalPager keeps it.
+func (c *PageCache) _Vacuums() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	return c.vacuums
+}
+
+func (c *PageCache) _IsStale(vacuums uint64) bool {
+	if c == nil {
+		return false
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	return c.vacuums != vacuums
+}
+
+// _Invalidate forgets every entry and makes the pagers open so far stale.
+// Vacuum moves their pages, a context they kept or wrote back would land
+// on a page another one took.
+func (c *PageCache) _Invalidate() {
+	if c == nil {
+		return
+	}
+
+	c._Reset()
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	c.vacuums += 1
+}
+
+// _SetUnsaved records whether p holds pages of the main pager it has not
+// saved yet.
+func (c *PageCache) _SetUnsaved(p *InternalPager, isUnsaved bool) {
+	if c == nil {
+		return
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	if isUnsaved {
+		c.unsavedPagers[p] = true
+	} else {
+		delete(c.unsavedPagers, p)
+	}
+}
+
+// _HasUnsaved reports an open pager holding pages it has not saved.
+func (c *PageCache) _HasUnsaved() bool {
+	if c == nil {
+		return false
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	return len(c.unsavedPagers) > 0
+}
+
+// _Drop marks the pagers opened on owner as dropped. Their contexts are
+// forgotten without a write back, it would land on pages the drop frees,
+// and the pages they took since their last save are freed.
+func (c *PageCache) _Drop(owner string) error {
+	if c == nil {
+		return nil
+	}
+
+	var items []IPageCacheItem
+	var pagers []*InternalPager
+
+	c.rwlock.Lock()
+	c.dropEpochByOwner[owner] += 1
+
+	elem := c.lru.Front()
+	for elem != nil {
+		entry := elem.Value.(*PageCacheEntry)
+		elem = elem.Next()
+		if entry.item._Owner() == owner {
+			c._RemoveEntry(entry)
+			items = append(items, entry.item)
+		}
+	}
+
+	for p, _ := range c.unsavedPagers {
+		if p.owner == owner {
+			delete(c.unsavedPagers, p)
+			pagers = append(pagers, p)
+		}
+	}
+	c.rwlock.Unlock()
+
+	for _, item := range items {
+		item._Release()
+	}
+
+	for _, p := range pagers {
+		err := p._FreeUnsaved()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// _Enter marks the start of a dict operation, operations of one dict may
+// run those of another.
+func (c *PageCache) _Enter() {
+	if c == nil {
+		return
+	}
+
+	c.rwlock.Lock()
+	defer c.rwlock.Unlock()
+
+	if c.depth == 0 {
+		c.opSeq += 1
+	}
+	c.depth += 1
+}
+
+// _Leave trims the cache once the outermost operation is done and sets
+// *err when the operation itself did not fail.
+func (c *PageCache) _Leave(err *error) {
+	if c == nil {
+		return
+	}
+
+	c.rwlock.Lock()
+	c.depth -= 1
+	depth := c.depth
+	c.rwlock.Unlock()
+
+	if depth > 0 {
+		return
+	}
+
+	trimErr := c._Trim()
+	if *err == nil {
+		*err = trimErr
+	}
+}
+
+// _Trim evicts from the least recently used end until the cache fits its
+// budget. The write backs may load pages of their own, a nested _Trim
+// returns at once, and may move entries, so it walks again while a walk
+// evicts something.
+func (c *PageCache) _Trim() error {
+	if c == nil {
+		return nil
+	}
+
+	c.rwlock.Lock()
+	if c.isTrimming || c.budget <= 0 {
+		c.rwlock.Unlock()
+		return nil
+	}
+	c.isTrimming = true
+	c.rwlock.Unlock()
+
+	defer func() {
+		c.rwlock.Lock()
+		c.isTrimming = false
+		c.rwlock.Unlock()
+	}()
+
+	for {
+		evictions, err := c._TrimOnce()
+		if err != nil {
+			return err
+		}
+
+		c.rwlock.Lock()
+		isFull := c.size > c.budget
+		c.rwlock.Unlock()
+
+		if !isFull || evictions == 0 {
+			return nil
+		}
+	}
+}
+
+func (c *PageCache) _TrimOnce() (int, error) {
+
+	c.rwlock.Lock()
+	elem := c.lru.Back()
+	c.rwlock.Unlock()
+
+	evictions := 0
+
+	for elem != nil {
+
+		c.rwlock.Lock()
+		if c.size <= c.budget {
+			c.rwlock.Unlock()
+			break
+		}
+		entry := elem.Value.(*PageCacheEntry)
+		isPinned := elem == c.lru.Front() || (c.depth > 0 && entry.opSeq == c.opSeq)
+		elem = elem.Prev()
+		c.rwlock.Unlock()
+
+		if isPinned {
+			continue
+		}
+
+		if entry.item._IsChanged() {
+			ok, err := entry.item._WriteBack()
+			if err != nil {
+				return evictions, err
+			}
+			if !ok {
+				continue
+			}
+
+			c.rwlock.Lock()
+			c.writeBacks += 1
+			c.rwlock.Unlock()
+		}
+
+		entry.item._Release()
+
+		c.rwlock.Lock()
+		c._RemoveEntry(entry)
+		c.evictions += 1
+		c.rwlock.Unlock()
+
+		evictions += 1
+	}
+
+	return evictions, nil
+}
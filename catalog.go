@@ -0,0 +1,500 @@
+package gokvdb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type DictKind uint8
+
+const (
+	// DICT_KIND_UNKNOWN is the kind of a dict last saved before kinds were
+	// recorded, it is known again once the dict is saved or given with
+	// DBContext.SetDictKind
+	DICT_KIND_UNKNOWN DictKind = 0
+	DICT_KIND_I64STR DictKind = 1
+	DICT_KIND_STRI64 DictKind = 2
+	DICT_KIND_I64BLOB DictKind = 3
+	DICT_KIND_STRBLOB DictKind = 4
+	DICT_KIND_I64I64SET DictKind = 5
+	DICT_KIND_STRI64SET DictKind = 6
+	// DICT_KIND_BTREE is an index opened with DBContext.OpenBTree
+	DICT_KIND_BTREE DictKind = 7
+
+	// DICT_FORMAT_VERSION is the layout of the dict metas saved now, 0 is a
+	// dict saved before versions were recorded
+	DICT_FORMAT_VERSION uint8 = 1
+
+	// STRBLOB_KEYS_SUFFIX names the StrI64 dict holding the keys of a
+	// StrBlob dict
+	STRBLOB_KEYS_SUFFIX = "_idByKey"
+)
+
+// DictInfo describes a dict of a DBContext.
+type DictInfo struct {
+	Name string
+	Kind DictKind
+	Version uint8
+}
+
+// IDict is a dict returned by Storage.OpenDict, a type switch gives its
+// concrete type.
+type IDict interface {
+	Save(commit bool) error
+	ToString() string
+}
+
+func (kind DictKind) String() string {
+	switch kind {
+	case DICT_KIND_UNKNOWN:
+		return "Unknown"
+	case DICT_KIND_I64STR:
+		return "I64Str"
+	case DICT_KIND_STRI64:
+		return "StrI64"
+	case DICT_KIND_I64BLOB:
+		return "I64Blob"
+	case DICT_KIND_STRBLOB:
+		return "StrBlob"
+	case DICT_KIND_I64I64SET:
+		return "I64I64Set"
+	case DICT_KIND_STRI64SET:
+		return "StrI64Set"
+	case DICT_KIND_BTREE:
+		return "BTree"
+	}
+	return fmt.Sprintf("DictKind(%d)", uint8(kind))
+}
+
+func (info DictInfo) ToString() string {
+	return fmt.Sprintf("<DictInfo name=%v kind=%v version=%v>", info.Name, info.Kind, info.Version)
+}
+
+// ListDBs returns the names of the DBs of the storage in order.
+func (s *Storage) ListDBs() []string {
+
+	var names []string
+	for name, _ := range s.dbItems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DropDB drops every dict of the DB and the DB itself, their pages go
+// back to the freelist. It returns ErrNotFound when there is no such DB.
+// The drop reaches the file with the next Save or Tx.Commit, dicts opened
+// from the DB before fail with ErrDropped as after DropDict.
+func (s *Storage) DropDB(name string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	item, ok := s.dbItems[name]
+	if !ok {
+		return fmt.Errorf("%w: db %v", ErrNotFound, name)
+	}
+
+	ctx, err := s.DB(name)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range ctx.ListDicts() {
+		err = ctx.DropDict(info.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.pager.FreePayloadData(item.metaPageId)
+	if err != nil {
+		return err
+	}
+
+	delete(s.dbItems, name)
+
+	return nil
+}
+
+// OpenDict opens the dict dictName of dbName as the kind it was saved as.
+// It returns ErrNotFound when there is no such dict and ErrWrongKind when
+// the kind is unknown, a dict saved before kinds were recorded is opened
+// with its constructor once and saved or its kind is given with
+// DBContext.SetDictKind. BTree indexes are opened with DBContext.OpenBTree.
+func (s *Storage) OpenDict(dbName string, dictName string) (IDict, error) {
+
+	db, err := s.DB(dbName)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := _DictOwner(dbName, dictName)
+
+	_, ok := db.pageIdByMetaName[dictName]
+	if !ok || db._IsStrBlobKeys(dictName) {
+		_, isBTree := db.dbSets[dictName]
+		if isBTree {
+			return nil, fmt.Errorf("%w: %v is a BTree index", ErrWrongKind, owner)
+		}
+		return nil, fmt.Errorf("%w: dict %v", ErrNotFound, owner)
+	}
+
+	switch db._DictKind(dictName) {
+	case DICT_KIND_I64STR:
+		return _OpenedDict(NewI64StrDict(s, dbName, dictName))
+	case DICT_KIND_STRI64:
+		return _OpenedDict(NewStrI64Dict(s, dbName, dictName))
+	case DICT_KIND_I64BLOB:
+		return _OpenedDict(NewI64BlobDict(s, dbName, dictName))
+	case DICT_KIND_STRBLOB:
+		return _OpenedDict(NewStrBlobDict(s, dbName, dictName))
+	case DICT_KIND_I64I64SET:
+		return _OpenedDict(NewLazyI64I64SetDict(s, dbName, dictName))
+	case DICT_KIND_STRI64SET:
+		return _OpenedDict(NewStrI64SetDict(s, dbName, dictName))
+	}
+
+	return nil, fmt.Errorf("%w: %v has no recorded kind", ErrWrongKind, owner)
+}
+
+// _OpenedDict keeps the nil dict of a failed open out of the interface.
+func _OpenedDict(dict IDict, err error) (IDict, error) {
+	if err != nil {
+		return nil, err
+	}
+	return dict, nil
+}
+
+// ListDicts returns the dicts and BTree indexes of the DB in name order.
+// The keys dict of a StrBlob dict is part of it and not listed.
+func (ctx *DBContext) ListDicts() []DictInfo {
+
+	var infos []DictInfo
+
+	for name, _ := range ctx.pageIdByMetaName {
+		if ctx._IsStrBlobKeys(name) {
+			continue
+		}
+		infos = append(infos, DictInfo{Name: name, Kind: ctx._DictKind(name), Version: ctx.versionByMetaName[name]})
+	}
+
+	for name, _ := range ctx.dbSets {
+		_, ok := ctx.pageIdByMetaName[name]
+		if !ok {
+			infos = append(infos, DictInfo{Name: name, Kind: DICT_KIND_BTREE})
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+
+	return infos
+}
+
+// DropDict returns every page of the dict to the freelist and forgets it,
+// a StrBlob dict goes with its keys dict. It returns ErrNotFound when
+// there is no such dict. The drop reaches the file with the next Save or
+// Tx.Commit, the writes and saves of dict objects opened before fail with
+// ErrDropped and their unsaved changes are thrown away.
+func (ctx *DBContext) DropDict(name string) error {
+	if ctx.readOnly {
+		return ErrReadOnly
+	}
+
+	_, ok := ctx.pageIdByMetaName[name]
+	if ok {
+		kind := ctx._DictKind(name)
+		if kind == DICT_KIND_STRBLOB {
+			err := ctx._DropMeta(name + STRBLOB_KEYS_SUFFIX, DICT_KIND_STRI64)
+			if err != nil {
+				return err
+			}
+		}
+		return ctx._DropMeta(name, kind)
+	}
+
+	dset, ok := ctx.dbSets[name]
+	if ok {
+		return ctx._DropBTree(dset)
+	}
+
+	return fmt.Errorf("%w: dict %v", ErrNotFound, _DictOwner(ctx.name, name))
+}
+
+// RenameDict gives the dict oldName the name newName. It fails with
+// ErrNotFound when there is no dict oldName and with ErrExists when
+// newName is taken. Dict objects opened under oldName must not be used
+// afterwards.
+func (ctx *DBContext) RenameDict(oldName string, newName string) error {
+	if ctx.readOnly {
+		return ErrReadOnly
+	}
+
+	if ctx._HasDict(newName) {
+		return fmt.Errorf("%w: dict %v", ErrExists, _DictOwner(ctx.name, newName))
+	}
+
+	_, ok := ctx.pageIdByMetaName[oldName]
+	if ok {
+		kind := ctx._DictKind(oldName)
+		if kind == DICT_KIND_STRBLOB {
+			keysName := newName + STRBLOB_KEYS_SUFFIX
+			if ctx._HasDict(keysName) {
+				return fmt.Errorf("%w: dict %v", ErrExists, _DictOwner(ctx.name, keysName))
+			}
+			ctx._MoveMeta(oldName + STRBLOB_KEYS_SUFFIX, keysName)
+		}
+
+		ctx._MoveMeta(oldName, newName)
+		if kind != DICT_KIND_UNKNOWN {
+			ctx.kindByMetaName[newName] = kind
+		}
+		return nil
+	}
+
+	dset, ok := ctx.dbSets[oldName]
+	if ok {
+		delete(ctx.dbSets, oldName)
+		dset.name = newName
+		ctx.dbSets[newName] = dset
+		return nil
+	}
+
+	return fmt.Errorf("%w: dict %v", ErrNotFound, _DictOwner(ctx.name, oldName))
+}
+
+func (ctx *DBContext) _HasDict(name string) bool {
+	_, ok := ctx.pageIdByMetaName[name]
+	if ok {
+		return true
+	}
+	_, ok = ctx.dbSets[name]
+	return ok
+}
+
+func (ctx *DBContext) _MoveMeta(oldName string, newName string) {
+
+	pid, ok := ctx.pageIdByMetaName[oldName]
+	if !ok {
+		return
+	}
+
+	kind, hasKind := ctx.kindByMetaName[oldName]
+	version, hasVersion := ctx.versionByMetaName[oldName]
+
+	delete(ctx.pageIdByMetaName, oldName)
+	delete(ctx.kindByMetaName, oldName)
+	delete(ctx.versionByMetaName, oldName)
+
+	ctx.pageIdByMetaName[newName] = pid
+	if hasKind {
+		ctx.kindByMetaName[newName] = kind
+	}
+	if hasVersion {
+		ctx.versionByMetaName[newName] = version
+	}
+}
+
+// _SetDictMeta saves the meta of a dict of kind in the current format.
+func (ctx *DBContext) _SetDictMeta(name string, kind DictKind, data []byte) error {
+	err := ctx.SetMeta(name, data)
+	if err != nil {
+		return err
+	}
+	ctx.kindByMetaName[name] = kind
+	ctx.versionByMetaName[name] = DICT_FORMAT_VERSION
+	return nil
+}
+
+// _CheckDictKind fails with ErrWrongKind when the dict name was saved as
+// another kind and with ErrUnsupportedVersion when its format is newer than
+// this one. A dict of unknown kind is let through, its first save records
+// the kind it was opened as.
+func (ctx *DBContext) _CheckDictKind(name string, kind DictKind) error {
+
+	savedKind := ctx._DictKind(name)
+	if savedKind != DICT_KIND_UNKNOWN && savedKind != kind {
+		return fmt.Errorf("%w: %v is %v, not %v", ErrWrongKind, _DictOwner(ctx.name, name), savedKind, kind)
+	}
+
+	version := ctx.versionByMetaName[name]
+	if version > DICT_FORMAT_VERSION {
+		return fmt.Errorf("%w: %v has version %v, %v is supported", ErrUnsupportedVersion, _DictOwner(ctx.name, name), version, DICT_FORMAT_VERSION)
+	}
+
+	return nil
+}
+
+// _DictKind returns the recorded kind of the dict name. Of the dicts saved
+// before kinds were recorded only StrBlob dicts and their keys dicts are
+// told apart, by the name of the keys dict.
+func (ctx *DBContext) _DictKind(name string) DictKind {
+
+	kind, ok := ctx.kindByMetaName[name]
+	if ok && kind != DICT_KIND_UNKNOWN {
+		return kind
+	}
+
+	_, ok = ctx.pageIdByMetaName[name + STRBLOB_KEYS_SUFFIX]
+	if ok {
+		return DICT_KIND_STRBLOB
+	}
+
+	if ctx._IsStrBlobKeys(name) {
+		return DICT_KIND_STRI64
+	}
+
+	return DICT_KIND_UNKNOWN
+}
+
+// SetDictKind records the kind of a dict saved before kinds were recorded
+// without saving the dict, so OpenDict and ListDicts know it. It returns
+// ErrNotFound when there is no such dict and ErrWrongKind when another kind
+// is recorded. The kind reaches the file with the next Save or Tx.Commit.
+func (ctx *DBContext) SetDictKind(name string, kind DictKind) error {
+	if ctx.readOnly {
+		return ErrReadOnly
+	}
+
+	owner := _DictOwner(ctx.name, name)
+
+	_, ok := ctx.pageIdByMetaName[name]
+	if !ok || ctx._IsStrBlobKeys(name) {
+		return fmt.Errorf("%w: dict %v", ErrNotFound, owner)
+	}
+
+	if kind == DICT_KIND_UNKNOWN || kind > DICT_KIND_STRI64SET {
+		return fmt.Errorf("%w: %v is not a dict kind", ErrWrongKind, kind)
+	}
+
+	savedKind := ctx._DictKind(name)
+	if savedKind != DICT_KIND_UNKNOWN && savedKind != kind {
+		return fmt.Errorf("%w: %v is %v, not %v", ErrWrongKind, owner, savedKind, kind)
+	}
+
+	ctx.kindByMetaName[name] = kind
+
+	return nil
+}
+
+func (ctx *DBContext) _IsStrBlobKeys(name string) bool {
+	if !strings.HasSuffix(name, STRBLOB_KEYS_SUFFIX) {
+		return false
+	}
+	blobName := strings.TrimSuffix(name, STRBLOB_KEYS_SUFFIX)
+	_, ok := ctx.pageIdByMetaName[blobName]
+	return ok && ctx._DictKind(blobName) == DICT_KIND_STRBLOB
+}
+
+// _DropMeta frees the InternalPager of the dict name and its meta. Every
+// dict keeps its pages in one InternalPager whose meta is the first chunk
+// of the dict meta, after the last id of a StrBlob dict.
+func (ctx *DBContext) _DropMeta(name string, kind DictKind) error {
+
+	pid, ok := ctx.pageIdByMetaName[name]
+	if !ok {
+		return nil
+	}
+
+	owner := _DictOwner(ctx.name, name)
+
+	metaData, err := ctx.pager.ReadPayloadData(pid)
+	if err != nil {
+		return _OwnedError(err, owner)
+	}
+
+	var internalPagerMeta []byte
+	err = _DecodePage("Dict meta", pid, metaData, func(rd *DataStream) {
+		if kind == DICT_KIND_STRBLOB {
+			rd.ReadUInt64()
+		}
+		internalPagerMeta = rd.ReadChunk()
+	})
+	if err == nil && len(internalPagerMeta) != INTERNAL_PAGER_META_SIZE {
+		err = _CorruptPageError("Dict meta", pid, "internal pager meta bytes=%v", len(internalPagerMeta))
+	}
+	if err != nil {
+		return _OwnedError(err, owner)
+	}
+
+	// open handles must not write back over the pages freed below
+	err = _PageCacheOf(ctx.pager)._Drop(owner)
+	if err != nil {
+		return err
+	}
+
+	err = ctx._DropInternalPager(internalPagerMeta, owner)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.pager.FreePayloadData(pid)
+	if err != nil {
+		return _OwnedError(err, owner)
+	}
+
+	delete(ctx.pageIdByMetaName, name)
+	delete(ctx.kindByMetaName, name)
+	delete(ctx.versionByMetaName, name)
+
+	return nil
+}
+
+// _DropBTree frees the pages of an opened index through its InternalPager,
+// which also knows the ones not saved yet.
+func (ctx *DBContext) _DropBTree(dset *DBSet) error {
+
+	owner := _DictOwner(ctx.name, dset.name)
+
+	var err error
+	if dset.obj != nil {
+		// the open index frees its unsaved pages itself, the cache only
+		// forgets its nodes
+		err = dset.obj.(*BTreeIndex).internalPager.(*InternalPager)._Drop()
+		if err == nil {
+			err = _PageCacheOf(ctx.pager)._Drop(owner)
+		}
+	} else {
+		var metaData []byte
+		metaData, err = ctx.pager.ReadPayloadData(dset.metaPageId)
+		if err != nil {
+			return _OwnedError(err, owner)
+		}
+
+		var internalPagerMeta []byte
+		err = _DecodePage("BTree meta", dset.metaPageId, metaData, func(rd *DataStream) {
+			internalPagerMeta = rd.Read(64)
+		})
+		if err != nil {
+			return _OwnedError(err, owner)
+		}
+
+		err = ctx._DropInternalPager(internalPagerMeta, owner)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = ctx.pager.FreePayloadData(dset.metaPageId)
+	if err != nil {
+		return _OwnedError(err, owner)
+	}
+
+	delete(ctx.dbSets, dset.name)
+
+	return nil
+}
+
+func (ctx *DBContext) _DropInternalPager(meta []byte, owner string) error {
+
+	pager, err := NewInternalPager(ctx.pager, 0, meta, owner)
+	if err != nil {
+		return err
+	}
+
+	return pager.(*InternalPager)._Drop()
+}
@@ -0,0 +1,293 @@
+package gokvdb
+
+import (
+	"fmt"
+	"sort"
+	"errors"
+)
+
+const (
+	// a page up to lastPageId neither reachable nor free
+	CHECK_ORPHAN = "orphan"
+	// a page in the pager freelist that a chain still holds
+	CHECK_REFERENCED_FREE = "referenced free"
+	// a chain that links to a page it cannot hold
+	CHECK_BROKEN_CHAIN = "broken chain"
+	// a chain that links back to one of its own pages
+	CHECK_CYCLE = "cycle"
+	// a page in the pager freelist past lastPageId
+	CHECK_FREE_BEYOND_LAST = "free beyond last page"
+	// a page in two chains
+	CHECK_SHARED_PAGE = "shared page"
+	// a page that fails its checksum or cannot be decoded
+	CHECK_CORRUPT = "corrupt"
+)
+
+// errReported tells the walk of a PageGraph that a chain has already put
+// its problem in the report.
+var errReported = errors.New("reported")
+
+// CheckProblem is one inconsistency a Check found. Repaired is set when a
+// repair has fixed it.
+type CheckProblem struct {
+	Kind string
+	PageId uint32
+	Owner string
+	Message string
+	Repaired bool
+}
+
+// CheckReport is the outcome of a Check. ReachablePages counts the pages
+// of the chains reachable from the root and of the pager freelist chain,
+// FreePages the pages in the pager freelist.
+type CheckReport struct {
+	LastPageId uint32
+	ReachablePages int
+	FreePages int
+	Problems []CheckProblem
+}
+
+func (p CheckProblem) ToString() string {
+	return fmt.Sprintf("<CheckProblem kind=%v pid=%v owner=%v repaired=%v: %v>", p.Kind, p.PageId, p.Owner, p.Repaired, p.Message)
+}
+
+func (r *CheckReport) ToString() string {
+	return fmt.Sprintf("<CheckReport lastPageId=%v reachable=%v free=%v problems=%v>", r.LastPageId, r.ReachablePages, r.FreePages, len(r.Problems))
+}
+
+// OK reports a storage without problems, or with all of them repaired.
+func (r *CheckReport) OK() bool {
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// Count returns the number of problems of kind.
+func (r *CheckReport) Count(kind string) int {
+	count := 0
+	for _, p := range r.Problems {
+		if p.Kind == kind {
+			count += 1
+		}
+	}
+	return count
+}
+
+func (r *CheckReport) _Add(kind string, pid uint32, owner string, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, CheckProblem{Kind: kind, PageId: pid, Owner: owner, Message: fmt.Sprintf(format, args...)})
+}
+
+// _AddError records a failed read of the walk as a corrupt page.
+func (r *CheckReport) _AddError(err error) {
+	var pid uint32
+	var owner string
+
+	var pageErr *PageError
+	if errors.As(err, &pageErr) {
+		pid = pageErr.PageId
+		owner = pageErr.Owner
+	}
+
+	r._Add(CHECK_CORRUPT, pid, owner, "%v", err)
+}
+
+// _WalkChain follows the chain of pgType at pid and returns its pages up
+// to the first one it cannot hold. A freelist chain goes on past its last
+// page in use through the pages a shorter save left linked.
+func (r *CheckReport) _WalkChain(pager IPager, pid uint32, pgType uint8, owner string) ([]uint32, bool) {
+
+	var pageIds []uint32
+	visited := make(map[uint32]bool)
+	from := pid
+
+	for {
+		if pid < 1 || pid > r.LastPageId {
+			r._Add(CHECK_BROKEN_CHAIN, from, owner, "links to page %v, lastPageId=%v", pid, r.LastPageId)
+			return pageIds, false
+		}
+
+		if visited[pid] {
+			r._Add(CHECK_CYCLE, from, owner, "page %v links back to %v", from, pid)
+			return pageIds, false
+		}
+		visited[pid] = true
+
+		data, err := pager.ReadPage(pid, 0)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			r._AddError(_OwnedError(err, owner))
+			return pageIds, false
+		}
+
+		// the freelist of a pager is written at its first save
+		if err != nil || _IsZeroPage(data) {
+			if pgType == PGTYPE_FREELIST && len(pageIds) == 0 {
+				return append(pageIds, pid), true
+			}
+			r._Add(CHECK_BROKEN_CHAIN, pid, owner, "page %v was never written", pid)
+			return pageIds, false
+		}
+
+		var pageType uint8
+		var index uint16
+		var hasNext bool
+		var nextPageId uint32
+
+		err = _DecodePage("Check", pid, data, func(rd *DataStream) {
+			pageType = rd.ReadUInt8()
+			if pageType == PGTYPE_PAYLOAD {
+				index = rd.ReadUInt16()
+			}
+			rd.ReadUInt32() // dataLen
+			hasNext = rd.ReadBool()
+			nextPageId = rd.ReadUInt32()
+		})
+		if err != nil {
+			r._AddError(_OwnedError(err, owner))
+			return pageIds, false
+		}
+
+		if pageType != pgType {
+			// a freelist that was dropped is written empty
+			if pgType == PGTYPE_FREELIST && len(pageIds) == 0 && pageType == 0 {
+				return append(pageIds, pid), true
+			}
+			r._Add(CHECK_BROKEN_CHAIN, pid, owner, "page %v has type %v, expected %v", pid, pageType, pgType)
+			return pageIds, false
+		}
+
+		if pgType == PGTYPE_PAYLOAD && int(index) != len(pageIds) {
+			r._Add(CHECK_BROKEN_CHAIN, pid, owner, "page %v has index %v at %v", pid, index, len(pageIds))
+			return pageIds, false
+		}
+
+		pageIds = append(pageIds, pid)
+
+		if pgType == PGTYPE_PAYLOAD {
+			if !hasNext {
+				return pageIds, true
+			}
+			if nextPageId == 0 {
+				r._Add(CHECK_BROKEN_CHAIN, pid, owner, "page %v has a next page without its id", pid)
+				return pageIds, false
+			}
+		} else if nextPageId == 0 {
+			return pageIds, true
+		}
+
+		from = pid
+		pid = nextPageId
+	}
+}
+
+// Check walks every chain reachable from the root and the pager freelist
+// and reports the pages they do not account for: orphans, free pages still
+// held or past the end, and chains that are broken, cyclic, shared or
+// corrupt. The walk goes on past a bad chain to report the rest.
+//
+// With repair the storage is saved first and the pager freelist is fixed:
+// entries past the end or still held are taken out, and orphans are freed
+// when no chain is damaged, as a damaged chain may be what held them. They
+// stay while an open dict holds pages it has not saved, its new pages and
+// the contexts the cache wrote back are orphans until it saves.
+func (s *Storage) Check(repair bool) (*CheckReport, error) {
+	if repair {
+		if s.readOnly {
+			return nil, ErrReadOnly
+		}
+
+		s.writeLock.Lock()
+		defer s.writeLock.Unlock()
+
+		if s.tx != nil {
+			return nil, DBError{message: "transaction in progress"}
+		}
+
+		err := s._Save()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pager := s.pager.(*StreamPager)
+	meta := pager.basePager.meta
+
+	report := new(CheckReport)
+	report.LastPageId = meta.lastPageId
+
+	// a storage never saved has only its root page id
+	g := _NewPageGraph(pager, s.rootPageId, report)
+	if len(s.dbItems) > 0 {
+		err := g._Walk()
+		if err != nil && !errors.Is(err, errReported) {
+			report._AddError(err)
+		}
+	} else {
+		g._AddChain(&PageChain{pgType: PGTYPE_PAYLOAD, role: PAGE_ROLE_STORAGE_ROOT, pageIds: []uint32{s.rootPageId}})
+	}
+	if meta.freelistPageId != 0 {
+		g._AddFreeList(meta.freelistPageId, "", PAGE_ROLE_FREELIST)
+	}
+
+	isReferenced := make(map[uint32]bool)
+	for pid, _ := range g.chainByPageId {
+		isReferenced[pid] = true
+	}
+	report.ReachablePages = len(isReferenced)
+
+	var freePageIds []uint32
+	for pid, _ := range pager.freelist.pageIdSet {
+		freePageIds = append(freePageIds, pid)
+	}
+	sort.Sort(U32Array(freePageIds))
+	report.FreePages = len(freePageIds)
+
+	var badFree []int
+	for _, pid := range freePageIds {
+		if pid < 1 || pid > meta.lastPageId {
+			report._Add(CHECK_FREE_BEYOND_LAST, pid, "", "free page %v, lastPageId=%v", pid, meta.lastPageId)
+			badFree = append(badFree, len(report.Problems) - 1)
+			continue
+		}
+		chain, ok := g.chainByPageId[pid]
+		if ok {
+			report._Add(CHECK_REFERENCED_FREE, pid, chain.owner, "free page %v is in the %v chain", pid, chain.role)
+			badFree = append(badFree, len(report.Problems) - 1)
+		}
+	}
+
+	var orphans []int
+	for pid:=uint32(1); pid<=meta.lastPageId; pid++ {
+		_, isFree := pager.freelist.pageIdSet[pid]
+		if !isReferenced[pid] && !isFree {
+			report._Add(CHECK_ORPHAN, pid, "", "page %v is neither reachable nor free", pid)
+			orphans = append(orphans, len(report.Problems) - 1)
+		}
+	}
+
+	if !repair {
+		return report, nil
+	}
+
+	for _, i := range badFree {
+		delete(pager.freelist.pageIdSet, report.Problems[i].PageId)
+		report.Problems[i].Repaired = true
+	}
+
+	damaged := report.Count(CHECK_BROKEN_CHAIN) + report.Count(CHECK_CYCLE) + report.Count(CHECK_CORRUPT) + report.Count(CHECK_SHARED_PAGE)
+	if damaged == 0 && !pager.cache._HasUnsaved() {
+		for _, i := range orphans {
+			err := pager.FreePageId(report.Problems[i].PageId)
+			if err != nil {
+				return report, err
+			}
+			report.Problems[i].Repaired = true
+		}
+	}
+
+	report.FreePages = len(pager.freelist.pageIdSet)
+
+	return report, s._Save()
+}
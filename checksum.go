@@ -0,0 +1,113 @@
+package gokvdb
+
+import (
+	"hash/crc32"
+)
+
+const (
+	// bytes 12-15 of every page header hold the CRC32C of the page and are
+	// owned by the pager, page writers must leave them unused
+	PAGE_CHECKSUM_OFFSET int = 12
+	PAGE_CHECKSUM_SIZE int = 4
+
+	STORAGE_HEADER_CHECKSUM_OFFSET int = 60
+	// bytes 56-59 of the storage header hold the format flags, u32
+	STORAGE_FLAGS_OFFSET int = 56
+	// the header and every page of the file carry a checksum
+	STORAGE_FLAG_CHECKSUMS uint32 = 1
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// _Checksum returns the CRC32C of data with the 4 bytes at offset taken
+// as zero.
+func _Checksum(data []byte, offset int) uint32 {
+	zero := make([]byte, PAGE_CHECKSUM_SIZE)
+
+	sum := crc32.Update(0, crc32cTable, data[:offset])
+	sum = crc32.Update(sum, crc32cTable, zero)
+	sum = crc32.Update(sum, crc32cTable, data[offset + PAGE_CHECKSUM_SIZE:])
+
+	return sum
+}
+
+func _StampChecksum(data []byte, offset int) {
+	w := NewDataStreamFromBuffer(data)
+	w.Seek(offset)
+	w.WriteUInt32(_Checksum(data, offset))
+}
+
+func _VerifyChecksum(op string, pid uint32, data []byte, offset int) error {
+	if len(data) < offset + PAGE_CHECKSUM_SIZE {
+		return _CorruptPageError(op, pid, "short page %v bytes", len(data))
+	}
+
+	rd := NewDataStreamFromBuffer(data)
+	rd.Seek(offset)
+	stored := rd.ReadUInt32()
+	sum := _Checksum(data, offset)
+
+	if stored != sum {
+		return _CorruptPageError(op, pid, "checksum %08x, expected %08x", stored, sum)
+	}
+
+	return nil
+}
+
+// _VerifyStorageHeader checks the checksum of a storage header and reports
+// whether the pages of the file carry checksums too. A header with neither
+// STORAGE_FLAG_CHECKSUMS nor a stored checksum was written before checksums
+// were added, it is taken as is and its pages are not verified.
+func _VerifyStorageHeader(op string, data []byte) (bool, error) {
+	if len(data) < STORAGE_HEADER_CHECKSUM_OFFSET + PAGE_CHECKSUM_SIZE {
+		return false, _CorruptPageError(op, 0, "short page %v bytes", len(data))
+	}
+
+	rd := NewDataStreamFromBuffer(data)
+	rd.Seek(STORAGE_FLAGS_OFFSET)
+	flags := rd.ReadUInt32()
+	stored := rd.ReadUInt32()
+
+	if flags & STORAGE_FLAG_CHECKSUMS == 0 && stored == 0 {
+		return false, nil
+	}
+
+	err := _VerifyChecksum(op, 0, data, STORAGE_HEADER_CHECKSUM_OFFSET)
+	if err != nil {
+		return false, err
+	}
+
+	return flags & STORAGE_FLAG_CHECKSUMS != 0, nil
+}
+
+// _HasChecksums reads the flag of a storage header already verified.
+func _HasChecksums(header []byte) bool {
+	rd := NewDataStreamFromBuffer(header)
+	rd.Seek(STORAGE_FLAGS_OFFSET)
+	return rd.ReadUInt32() & STORAGE_FLAG_CHECKSUMS != 0
+}
+
+// _ChecksumsOf reports whether the pages read through pager are verified,
+// false for a file written before checksums were added.
+func _ChecksumsOf(pager IPager) bool {
+	switch p := pager.(type) {
+	case *StreamPager:
+		return !p.basePager.noChecksums
+	case *InternalPager:
+		return p.checksums
+	case *SnapshotPager:
+		return !p.parent.basePager.noChecksums
+	}
+	return true
+}
+
+// _IsZeroPage reports a page that was allocated but never written, such as
+// a hole left in the file by a later page.
+func _IsZeroPage(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
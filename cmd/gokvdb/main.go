@@ -0,0 +1,891 @@
+/*
+	gokvdb looks inside a database file
+
+	gokvdb info <file>
+	gokvdb ls <file> [db]
+	gokvdb get <file> <db> <dict> <key>
+	gokvdb set [-kind kind] <file> <db> <dict> <key> <value>
+	gokvdb del <file> <db> <dict> <key> [value]
+	gokvdb set-kind <file> <db> <dict> <kind>
+	gokvdb scan [-limit n] <file> <db> <dict>
+	gokvdb dump-page [-full] <file> <pid>
+	gokvdb check [-repair] <file>
+	gokvdb export [-format jsonl|csv] <file> <db> <dict>
+	gokvdb import [-format jsonl|csv] [-kind kind] <file> <db> <dict> [input]
+*/
+
+package main
+
+import (
+	"os"
+	"fmt"
+	"flag"
+	"errors"
+	"strings"
+	"strconv"
+	"encoding/hex"
+	"github.com/ahuilee/gokvdb"
+)
+
+const USAGE = `usage:
+	gokvdb info <file>
+	gokvdb ls <file> [db]
+	gokvdb get <file> <db> <dict> <key>
+	gokvdb set [-kind kind] <file> <db> <dict> <key> <value>
+	gokvdb del <file> <db> <dict> <key> [value]
+	gokvdb set-kind <file> <db> <dict> <kind>
+	gokvdb scan [-limit n] <file> <db> <dict>
+	gokvdb dump-page [-full] <file> <pid>
+	gokvdb check [-repair] <file>
+	gokvdb export [-format jsonl|csv] <file> <db> <dict>
+	gokvdb import [-format jsonl|csv] [-kind kind] <file> <db> <dict> [input]
+
+kinds: i64str stri64 i64blob strblob i64i64set stri64set btree
+set-kind records the kind of a dict saved before kinds were recorded
+`
+
+func main() {
+
+	if len(os.Args) < 2 {
+		Usage()
+	}
+
+	var err error
+
+	cmd := os.Args[1]
+	flags := flag.NewFlagSet(cmd, flag.ExitOnError)
+	flags.Usage = Usage
+
+	switch cmd {
+	case "info":
+		args := ParseArgs(flags, 1, 1)
+		err = Info(args[0])
+	case "ls":
+		args := ParseArgs(flags, 1, 2)
+		err = List(args[0], args[1:])
+	case "get":
+		args := ParseArgs(flags, 4, 4)
+		err = Get(args[0], args[1], args[2], args[3])
+	case "set":
+		kind := flags.String("kind", "", "create the dict as this kind when it is missing")
+		args := ParseArgs(flags, 5, 5)
+		err = Set(args[0], args[1], args[2], args[3], args[4], *kind)
+	case "del":
+		args := ParseArgs(flags, 4, 5)
+		err = Delete(args[0], args[1], args[2], args[3], args[4:])
+	case "set-kind":
+		args := ParseArgs(flags, 4, 4)
+		err = SetKind(args[0], args[1], args[2], args[3])
+	case "scan":
+		limit := flags.Int("limit", 100, "stop after this many keys, 0 for all")
+		args := ParseArgs(flags, 3, 3)
+		err = Scan(args[0], args[1], args[2], *limit)
+	case "dump-page":
+		full := flags.Bool("full", false, "dump all the data of the page")
+		args := ParseArgs(flags, 2, 2)
+		err = DumpPage(args[0], args[1], *full)
+	case "check":
+		repair := flags.Bool("repair", false, "free the orphan pages and fix the freelist")
+		args := ParseArgs(flags, 1, 1)
+		err = Check(args[0], *repair)
+	case "export":
+		format := flags.String("format", gokvdb.EXPORT_JSONL, "jsonl or csv")
+		args := ParseArgs(flags, 3, 3)
+		err = Export(args[0], args[1], args[2], *format)
+	case "import":
+		format := flags.String("format", gokvdb.EXPORT_JSONL, "jsonl or csv")
+		kind := flags.String("kind", "", "create the dict as this kind when it is missing")
+		args := ParseArgs(flags, 3, 4)
+		err = Import(args[0], args[1], args[2], args[3:], *format, *kind)
+	default:
+		Usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gokvdb:", err)
+		os.Exit(1)
+	}
+}
+
+func Usage() {
+	fmt.Fprint(os.Stderr, USAGE)
+	os.Exit(2)
+}
+
+// ParseArgs parses the flags of the command and returns from min to max
+// arguments after them.
+func ParseArgs(flags *flag.FlagSet, min int, max int) []string {
+	flags.Parse(os.Args[2:])
+	args := flags.Args()
+	if len(args) < min || len(args) > max {
+		Usage()
+	}
+	return args
+}
+
+func Info(path string) error {
+
+	s, err := gokvdb.OpenStorageReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	info, err := s.Info()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stdout, "path:", info.Path)
+	fmt.Fprintln(os.Stdout, "fileSize:", info.FileSize)
+	fmt.Fprintln(os.Stdout, "walSize:", info.WalSize)
+	fmt.Fprintln(os.Stdout, "epoch:", info.Epoch)
+	fmt.Fprintln(os.Stdout, "rootPageId:", info.RootPageId)
+	fmt.Fprintln(os.Stdout, "pageSize:", info.PageSize)
+	fmt.Fprintln(os.Stdout, "lastPageId:", info.LastPageId)
+	fmt.Fprintln(os.Stdout, "freelistPageId:", info.FreelistPageId)
+	fmt.Fprintln(os.Stdout, "freePages:", info.FreePageCount)
+	fmt.Fprintln(os.Stdout, "options:", info.Options.ToString())
+	fmt.Fprintln(os.Stdout, "dbs:", len(s.ListDBs()))
+
+	return nil
+}
+
+func List(path string, dbNames []string) error {
+
+	s, err := gokvdb.OpenStorageReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if len(dbNames) == 0 {
+		dbNames = s.ListDBs()
+	} else if !HasDB(s, dbNames[0]) {
+		return fmt.Errorf("db %v: %w", dbNames[0], gokvdb.ErrNotFound)
+	}
+
+	for _, dbName := range dbNames {
+		db, err := s.DB(dbName)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, dbName)
+		for _, info := range db.ListDicts() {
+			fmt.Fprintf(os.Stdout, "\t%v\t%v\tv%v\n", info.Name, info.Kind, info.Version)
+		}
+	}
+
+	return nil
+}
+
+func HasDB(s *gokvdb.Storage, dbName string) bool {
+	for _, name := range s.ListDBs() {
+		if name == dbName {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenDict opens a dict of any kind, a BTree index among them.
+func OpenDict(s *gokvdb.Storage, dbName string, dictName string) (interface{}, error) {
+
+	if !HasDB(s, dbName) {
+		return nil, fmt.Errorf("db %v: %w", dbName, gokvdb.ErrNotFound)
+	}
+
+	dict, err := s.OpenDict(dbName, dictName)
+	if !errors.Is(err, gokvdb.ErrWrongKind) {
+		return dict, err
+	}
+
+	db, dbErr := s.DB(dbName)
+	if dbErr != nil {
+		return nil, dbErr
+	}
+
+	// OpenBTree creates a missing index, a dict of no recorded kind is not
+	// one
+	for _, info := range db.ListDicts() {
+		if info.Name == dictName && info.Kind == gokvdb.DICT_KIND_BTREE {
+			return db.OpenBTree(dictName)
+		}
+		if info.Name == dictName && info.Kind == gokvdb.DICT_KIND_UNKNOWN {
+			return nil, fmt.Errorf("%w, give it with set-kind", err)
+		}
+	}
+	return nil, err
+}
+
+// NewDict creates dictName as kind.
+func NewDict(s *gokvdb.Storage, dbName string, dictName string, kind string) (interface{}, error) {
+
+	switch strings.ToLower(kind) {
+	case "i64str":
+		return gokvdb.NewI64StrDict(s, dbName, dictName)
+	case "stri64":
+		return gokvdb.NewOrderedStrI64Dict(s, dbName, dictName)
+	case "i64blob":
+		return gokvdb.NewI64BlobDict(s, dbName, dictName)
+	case "strblob":
+		return gokvdb.NewStrBlobDict(s, dbName, dictName)
+	case "i64i64set":
+		return gokvdb.NewLazyI64I64SetDict(s, dbName, dictName)
+	case "stri64set":
+		return gokvdb.NewOrderedStrI64SetDict(s, dbName, dictName)
+	case "btree":
+		db, err := s.DB(dbName)
+		if err != nil {
+			return nil, err
+		}
+		return db.OpenBTree(dictName)
+	}
+
+	return nil, fmt.Errorf("unknown kind %q", kind)
+}
+
+func ParseInt64(text string) (int64, error) {
+	val, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("not an int64: %q", text)
+	}
+	return val, nil
+}
+
+func Get(path string, dbName string, dictName string, key string) error {
+
+	s, err := gokvdb.OpenStorageReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	dict, err := OpenDict(s, dbName, dictName)
+	if err != nil {
+		return err
+	}
+
+	switch d := dict.(type) {
+	case *gokvdb.LazyI64StrDict:
+		k, err := ParseInt64(key)
+		if err != nil {
+			return err
+		}
+		val, err := d.Get(k)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, val)
+	case *gokvdb.LazyStrI64Dict:
+		val, err := d.Get(key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, val)
+	case *gokvdb.LazyI64BlobDict:
+		k, err := ParseInt64(key)
+		if err != nil {
+			return err
+		}
+		val, err := d.Get(k)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(append(val, '\n'))
+	case *gokvdb.LazyStrBlobDict:
+		val, err := d.Get(key)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(append(val, '\n'))
+	case *gokvdb.LazyI64I64SetDict:
+		k, err := ParseInt64(key)
+		if err != nil {
+			return err
+		}
+		item, err := d.Get(k)
+		if err != nil {
+			return err
+		}
+		return PrintValues(item.Values())
+	case *gokvdb.LazyStrI64SetDict:
+		item, err := d.Get(key)
+		if err != nil {
+			return err
+		}
+		return PrintValues(item.Values())
+	case *gokvdb.BTreeIndex:
+		k, err := ParseInt64(key)
+		if err != nil {
+			return err
+		}
+		val, err := d.Get(k)
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(append(val, '\n'))
+	default:
+		return fmt.Errorf("get from %v: %w", dictName, gokvdb.ErrNotImplemented)
+	}
+
+	return nil
+}
+
+func PrintValues(cur *gokvdb.Cursor[int64, struct{}]) error {
+	defer cur.Close()
+	for cur.Next() {
+		fmt.Fprintln(os.Stdout, cur.Key())
+	}
+	return cur.Err()
+}
+
+func Set(path string, dbName string, dictName string, key string, value string, kind string) error {
+
+	s, err := gokvdb.OpenStorage(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	dict, err := OpenDict(s, dbName, dictName)
+	if errors.Is(err, gokvdb.ErrNotFound) && kind != "" {
+		dict, err = NewDict(s, dbName, dictName, kind)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch d := dict.(type) {
+	case *gokvdb.LazyI64StrDict:
+		k, err := ParseInt64(key)
+		if err != nil {
+			return err
+		}
+		err = d.Set(k, value)
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyStrI64Dict:
+		v, err := ParseInt64(value)
+		if err != nil {
+			return err
+		}
+		err = d.Set(key, v)
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyI64BlobDict:
+		k, err := ParseInt64(key)
+		if err != nil {
+			return err
+		}
+		err = d.Set(k, []byte(value))
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyStrBlobDict:
+		err = d.Set(key, []byte(value))
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyI64I64SetDict:
+		k, err := ParseInt64(key)
+		if err != nil {
+			return err
+		}
+		v, err := ParseInt64(value)
+		if err != nil {
+			return err
+		}
+		err = d.Add(k, v)
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyStrI64SetDict:
+		v, err := ParseInt64(value)
+		if err != nil {
+			return err
+		}
+		err = d.Add(key, v)
+		if err != nil {
+			return err
+		}
+	case *gokvdb.BTreeIndex:
+		k, err := ParseInt64(key)
+		if err != nil {
+			return err
+		}
+		err = d.Set(k, []byte(value))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("set in %v: %w", dictName, gokvdb.ErrNotImplemented)
+	}
+
+	return Save(s, dict)
+}
+
+// Save saves dict and the storage, a BTree index is saved with its DB.
+func Save(s *gokvdb.Storage, dict interface{}) error {
+	d, ok := dict.(gokvdb.IDict)
+	if ok {
+		err := d.Save(false)
+		if err != nil {
+			return err
+		}
+	}
+	return s.Save()
+}
+
+// Delete removes key, or only value from the set of key.
+func Delete(path string, dbName string, dictName string, key string, values []string) error {
+
+	s, err := gokvdb.OpenStorage(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	dict, err := OpenDict(s, dbName, dictName)
+	if err != nil {
+		return err
+	}
+
+	var value int64
+	hasValue := len(values) > 0
+	if hasValue {
+		value, err = ParseInt64(values[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	switch d := dict.(type) {
+	case *gokvdb.LazyI64StrDict:
+		k, err := ParseInt64(key)
+		if err == nil {
+			err = d.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyStrI64Dict:
+		err = d.Delete(key)
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyI64BlobDict:
+		k, err := ParseInt64(key)
+		if err == nil {
+			err = d.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyStrBlobDict:
+		err = d.Delete(key)
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyI64I64SetDict:
+		k, err := ParseInt64(key)
+		if err == nil && hasValue {
+			err = d.Remove(k, value)
+		} else if err == nil {
+			err = d.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	case *gokvdb.LazyStrI64SetDict:
+		if hasValue {
+			err = d.Remove(key, value)
+		} else {
+			err = d.Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	case *gokvdb.BTreeIndex:
+		k, err := ParseInt64(key)
+		if err == nil {
+			err = d.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("del from %v: %w", dictName, gokvdb.ErrNotImplemented)
+	}
+
+	return Save(s, dict)
+}
+
+// SetKind records the kind of a dict saved before kinds were recorded.
+func SetKind(path string, dbName string, dictName string, kind string) error {
+
+	dictKind, ok := map[string]gokvdb.DictKind{
+		"i64str": gokvdb.DICT_KIND_I64STR,
+		"stri64": gokvdb.DICT_KIND_STRI64,
+		"i64blob": gokvdb.DICT_KIND_I64BLOB,
+		"strblob": gokvdb.DICT_KIND_STRBLOB,
+		"i64i64set": gokvdb.DICT_KIND_I64I64SET,
+		"stri64set": gokvdb.DICT_KIND_STRI64SET,
+	}[strings.ToLower(kind)]
+	if !ok {
+		return fmt.Errorf("unknown kind %q", kind)
+	}
+
+	s, err := gokvdb.OpenStorage(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if !HasDB(s, dbName) {
+		return fmt.Errorf("db %v: %w", dbName, gokvdb.ErrNotFound)
+	}
+
+	db, err := s.DB(dbName)
+	if err != nil {
+		return err
+	}
+
+	err = db.SetDictKind(dictName, dictKind)
+	if err != nil {
+		return err
+	}
+
+	return s.Save()
+}
+
+func Scan(path string, dbName string, dictName string, limit int) error {
+
+	s, err := gokvdb.OpenStorageReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	dict, err := OpenDict(s, dbName, dictName)
+	if err != nil {
+		return err
+	}
+
+	switch d := dict.(type) {
+	case *gokvdb.LazyI64StrDict:
+		return PrintItems(d.Items(), limit, FormatValue[string])
+	case *gokvdb.LazyStrI64Dict:
+		return PrintItems(d.Items(), limit, FormatValue[int64])
+	case *gokvdb.LazyI64BlobDict:
+		return PrintItems(d.Items(), limit, FormatBlob)
+	case *gokvdb.LazyStrBlobDict:
+		return PrintItems(d.Items(), limit, FormatBlob)
+	case *gokvdb.LazyI64I64SetDict:
+		return PrintItems(d.Items(), limit, func(item *gokvdb.LazyI64I64SetItem) (string, error) {
+			return FormatValues(item.Values())
+		})
+	case *gokvdb.LazyStrI64SetDict:
+		return PrintItems(d.Items(), limit, func(item *gokvdb.LazyStrI64SetItem) (string, error) {
+			return FormatValues(item.Values())
+		})
+	}
+
+	return fmt.Errorf("scan of %v: %w", dictName, gokvdb.ErrNotImplemented)
+}
+
+// PrintItems prints a key and its value per line, up to limit lines.
+func PrintItems[K any, V any](cur *gokvdb.Cursor[K, V], limit int, format func(V) (string, error)) error {
+	defer cur.Close()
+
+	count := 0
+	for (limit <= 0 || count < limit) && cur.Next() {
+		text, err := format(cur.Value())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%v\t%v\n", cur.Key(), text)
+		count += 1
+	}
+
+	return cur.Err()
+}
+
+func FormatValue[V any](val V) (string, error) {
+	return fmt.Sprint(val), nil
+}
+
+func FormatBlob(val []byte) (string, error) {
+	return strconv.Quote(string(val)), nil
+}
+
+func FormatValues(cur *gokvdb.Cursor[int64, struct{}]) (string, error) {
+	defer cur.Close()
+
+	var values []string
+	for cur.Next() {
+		values = append(values, strconv.FormatInt(cur.Key(), 10))
+	}
+
+	return strings.Join(values, ","), cur.Err()
+}
+
+func DumpPage(path string, pidText string, full bool) error {
+
+	pid, err := strconv.ParseUint(pidText, 10, 32)
+	if err != nil {
+		return fmt.Errorf("not a page id: %q", pidText)
+	}
+
+	s, err := gokvdb.OpenStorageReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	info, err := s.ReadPageInfo(uint32(pid))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stdout, "pid:", info.PageId)
+
+	switch {
+	case info.Zero:
+		fmt.Fprintln(os.Stdout, "type: zero")
+		return nil
+	case info.Type == gokvdb.PGTYPE_PAYLOAD:
+		fmt.Fprintln(os.Stdout, "type: payload")
+		fmt.Fprintln(os.Stdout, "index:", info.Index)
+	case info.Type == gokvdb.PGTYPE_FREELIST:
+		fmt.Fprintln(os.Stdout, "type: freelist")
+	default:
+		fmt.Fprintln(os.Stdout, "type:", info.Type)
+	}
+
+	fmt.Fprintln(os.Stdout, "dataLen:", info.DataLen)
+	fmt.Fprintln(os.Stdout, "hasNext:", info.HasNext)
+	fmt.Fprintln(os.Stdout, "nextPageId:", info.NextPageId)
+	fmt.Fprintf(os.Stdout, "checksum: %08x\n", info.Checksum)
+	if info.Type == gokvdb.PGTYPE_PAYLOAD && info.Index == 0 {
+		fmt.Fprintln(os.Stdout, "payloadLen:", info.PayloadLen)
+	}
+	fmt.Fprintln(os.Stdout, "free:", info.Free)
+
+	roleByPageId, err := s.PageRoles()
+	if err != nil {
+		fmt.Fprintln(os.Stdout, "role: unknown,", err)
+	} else if role, ok := roleByPageId[info.PageId]; ok {
+		fmt.Fprintln(os.Stdout, "role:", role.Role)
+		if role.Owner != "" {
+			fmt.Fprintln(os.Stdout, "owner:", role.Owner)
+		}
+		// the rows of an InternalPager page follow the payload length
+		switch role.Role {
+		case gokvdb.PAGE_ROLE_INTERNAL_ROOT, gokvdb.PAGE_ROLE_INTERNAL_BRANCH, gokvdb.PAGE_ROLE_INTERNAL_CONTEXT:
+			if info.Index == 0 && len(info.Data) >= gokvdb.PAYLOAD_HEADER_SIZE + 4 {
+				rd := gokvdb.NewDataStreamFromBuffer(info.Data[gokvdb.PAYLOAD_HEADER_SIZE:])
+				fmt.Fprintln(os.Stdout, "rows:", rd.ReadUInt32())
+			}
+		}
+	} else {
+		fmt.Fprintln(os.Stdout, "role: unreachable")
+	}
+
+	data := info.Data
+	if !full && len(data) > 256 {
+		data = data[:256]
+	}
+	fmt.Fprint(os.Stdout, hex.Dump(data))
+	if len(data) < len(info.Data) {
+		fmt.Fprintf(os.Stdout, "... %v more bytes\n", len(info.Data) - len(data))
+	}
+
+	return nil
+}
+
+// Check checks the pages of the storage and reads every item of every
+// dict, it fails when a problem is left. With repair the pages a repair can
+// fix are fixed first.
+func Check(path string, repair bool) error {
+
+	var s *gokvdb.Storage
+	var err error
+	if repair {
+		s, err = gokvdb.OpenStorage(path)
+	} else {
+		s, err = gokvdb.OpenStorageReadOnly(path)
+	}
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	checkReport, err := s.Check(repair)
+	if err != nil {
+		return err
+	}
+
+	problems := 0
+	for _, problem := range checkReport.Problems {
+		if problem.Repaired {
+			fmt.Fprintln(os.Stdout, "REPAIRED", problem.Kind, problem.Message)
+			continue
+		}
+		owner := ""
+		if problem.Owner != "" {
+			owner = " " + problem.Owner + ":"
+		}
+		fmt.Fprintf(os.Stdout, "ERROR %v%v %v\n", problem.Kind, owner, problem.Message)
+		problems += 1
+	}
+
+	report := func(err error) {
+		fmt.Fprintln(os.Stdout, "ERROR", err)
+		problems += 1
+	}
+
+	dicts := 0
+	for _, dbName := range s.ListDBs() {
+		db, err := s.DB(dbName)
+		if err != nil {
+			report(err)
+			continue
+		}
+		for _, dictInfo := range db.ListDicts() {
+			if dictInfo.Kind == gokvdb.DICT_KIND_BTREE {
+				continue
+			}
+			err = CheckDict(s, dbName, dictInfo.Name)
+			if err != nil {
+				report(fmt.Errorf("%v/%v: %w", dbName, dictInfo.Name, err))
+				continue
+			}
+			dicts += 1
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "pages=%v reachable=%v free=%v dicts=%v problems=%v\n", checkReport.LastPageId, checkReport.ReachablePages, checkReport.FreePages, dicts, problems)
+	if problems > 0 {
+		return fmt.Errorf("%v problems", problems)
+	}
+
+	return nil
+}
+
+func CheckDict(s *gokvdb.Storage, dbName string, dictName string) error {
+
+	dict, err := s.OpenDict(dbName, dictName)
+	if err != nil {
+		return err
+	}
+
+	switch d := dict.(type) {
+	case *gokvdb.LazyI64StrDict:
+		return ReadItems(d.Items(), nil)
+	case *gokvdb.LazyStrI64Dict:
+		return ReadItems(d.Items(), nil)
+	case *gokvdb.LazyI64BlobDict:
+		return ReadItems(d.Items(), nil)
+	case *gokvdb.LazyStrBlobDict:
+		return ReadItems(d.Items(), nil)
+	case *gokvdb.LazyI64I64SetDict:
+		return ReadItems(d.Items(), func(item *gokvdb.LazyI64I64SetItem) error {
+			return ReadItems(item.Values(), nil)
+		})
+	case *gokvdb.LazyStrI64SetDict:
+		return ReadItems(d.Items(), func(item *gokvdb.LazyStrI64SetItem) error {
+			return ReadItems(item.Values(), nil)
+		})
+	}
+
+	return nil
+}
+
+// ReadItems reads cur to the end, passing each value to read.
+func ReadItems[K any, V any](cur *gokvdb.Cursor[K, V], read func(V) error) error {
+	defer cur.Close()
+
+	for cur.Next() {
+		if read != nil {
+			err := read(cur.Value())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return cur.Err()
+}
+
+// Export writes every item of the dict to stdout.
+func Export(path string, dbName string, dictName string, format string) error {
+
+	s, err := gokvdb.OpenStorageReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	dict, err := OpenDict(s, dbName, dictName)
+	if err != nil {
+		return err
+	}
+	d, ok := dict.(gokvdb.IDict)
+	if !ok {
+		return fmt.Errorf("export of %v: %w", dictName, gokvdb.ErrNotImplemented)
+	}
+
+	_, err = gokvdb.ExportDict(os.Stdout, d, format)
+	return err
+}
+
+// Import reads the items of input, or of stdin without one, into the
+// dict, it fails when a line was malformed.
+func Import(path string, dbName string, dictName string, inputs []string, format string, kind string) error {
+
+	input := os.Stdin
+	if len(inputs) > 0 {
+		f, err := os.Open(inputs[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	s, err := gokvdb.OpenStorage(path)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	dict, err := OpenDict(s, dbName, dictName)
+	if errors.Is(err, gokvdb.ErrNotFound) && kind != "" {
+		dict, err = NewDict(s, dbName, dictName, kind)
+	}
+	if err != nil {
+		return err
+	}
+	d, ok := dict.(gokvdb.IDict)
+	if !ok {
+		return fmt.Errorf("import into %v: %w", dictName, gokvdb.ErrNotImplemented)
+	}
+
+	report, err := gokvdb.ImportDict(input, d, format)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range report.Malformed {
+		fmt.Fprintln(os.Stdout, "MALFORMED line", line)
+	}
+	fmt.Fprintf(os.Stdout, "lines=%v imported=%v malformed=%v\n", report.Lines, report.Imported, len(report.Malformed))
+	if len(report.Malformed) > 0 {
+		return fmt.Errorf("%v malformed lines", len(report.Malformed))
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package gokvdb
+
+import (
+	"fmt"
+	"bytes"
+	"math"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/binary"
+)
+
+// Codec turns the keys and values of Dict and Set into bytes. Keys are
+// kept in the order of their encoded bytes, so a key codec should keep the
+// order of its type for Range to make sense.
+type Codec[T any] interface {
+	Encode(value T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// Int64Codec writes big-endian with the sign bit flipped, which keeps
+// negative keys ahead of positive ones.
+type Int64Codec struct {}
+
+func (Int64Codec) Encode(value int64) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, uint64(value) ^ (1 << 63)), nil
+}
+
+func (Int64Codec) Decode(data []byte) (int64, error) {
+	if len(data) != 8 {
+		return 0, _CodecError("int64", data)
+	}
+	return int64(binary.BigEndian.Uint64(data) ^ (1 << 63)), nil
+}
+
+type Uint64Codec struct {}
+
+func (Uint64Codec) Encode(value uint64) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, value), nil
+}
+
+func (Uint64Codec) Decode(data []byte) (uint64, error) {
+	if len(data) != 8 {
+		return 0, _CodecError("uint64", data)
+	}
+	return binary.BigEndian.Uint64(data), nil
+}
+
+// Float64Codec flips the sign bit of positive numbers and every bit of
+// negative ones, so the bytes sort like the numbers.
+type Float64Codec struct {}
+
+func (Float64Codec) Encode(value float64) ([]byte, error) {
+	bits := math.Float64bits(value)
+	if bits & (1 << 63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits), nil
+}
+
+func (Float64Codec) Decode(data []byte) (float64, error) {
+	if len(data) != 8 {
+		return 0, _CodecError("float64", data)
+	}
+	bits := binary.BigEndian.Uint64(data)
+	if bits & (1 << 63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), nil
+}
+
+type StringCodec struct {}
+
+func (StringCodec) Encode(value string) ([]byte, error) {
+	return []byte(value), nil
+}
+
+func (StringCodec) Decode(data []byte) (string, error) {
+	return string(data), nil
+}
+
+type BytesCodec struct {}
+
+func (BytesCodec) Encode(value []byte) ([]byte, error) {
+	return value, nil
+}
+
+func (BytesCodec) Decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// JSONCodec is meant for values, its bytes do not keep any key order.
+type JSONCodec[T any] struct {}
+
+func (JSONCodec[T]) Encode(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// GobCodec is meant for values, its bytes do not keep any key order.
+type GobCodec[T any] struct {}
+
+func (GobCodec[T]) Encode(value T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return value, err
+}
+
+// _CodecError reports stored bytes that cannot be a value of the codec.
+func _CodecError(typeName string, data []byte) error {
+	return fmt.Errorf("%w: %v codec got %v bytes", ErrCorruptPage, typeName, len(data))
+}
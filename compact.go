@@ -0,0 +1,547 @@
+package gokvdb
+
+import (
+	"os"
+	"fmt"
+	"sort"
+)
+
+// Compact writes the last commit of the storage to a new file at dst whose
+// pages are dense, each dict is loaded again from its items, so the free
+// pages of the storage, the space freed inside the dicts and the dicts
+// dropped since are left out. It reads a snapshot, so writers go on meanwhile. It
+// fails with ErrLocked while dst is open, the storage itself among them,
+// and replaces the file atomically. It returns how many bytes smaller dst
+// is than the storage.
+func (s *Storage) Compact(dst string) (int64, error) {
+
+	var reclaimed int64
+
+	err := s.View(func(snap *Snapshot) error {
+		return _ReplaceFile(dst, func(tmpPath string) error {
+			var err error
+			reclaimed, err = snap.storage._CompactTo(tmpPath, snap.pager.lastPageId)
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	if reclaimed < 0 {
+		reclaimed = 0
+	}
+
+	return reclaimed, nil
+}
+
+// _CompactTo rebuilds the storage in a new storage at path.
+func (s *Storage) _CompactTo(path string, lastPageId uint32) (int64, error) {
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	stream, err := _OpenFileStream(path, false)
+	if err != nil {
+		return 0, err
+	}
+
+	opts := s.options
+	opts.ReadOnly = false
+	opts.Mmap = false
+
+	pageSize := int64(s.pager.GetPageSize())
+
+	dst, err := _OpenStorage(path, stream, nil, uint32(pageSize), opts)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s._RebuildTo(dst)
+	if err == nil {
+		err = dst._Save()
+	}
+	if err == nil {
+		err = dst.stream.Sync()
+	}
+
+	var size int64
+	if err == nil {
+		size, err = dst.stream.Size()
+	}
+
+	dst.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(lastPageId + 1) * pageSize - size, nil
+}
+
+// _RebuildTo loads every dict of the storage into dst from its live items
+// with the bulk loaders, so the space freed inside the dicts is left out
+// too and the pages come out packed. A key of a set dict whose set is
+// empty is left out. BTree indexes and dicts of no recorded kind have no
+// loader, their pages are copied as they are.
+func (s *Storage) _RebuildTo(dst *Storage) error {
+
+	g := _NewPageGraph(s.pager, s.rootPageId, nil)
+
+	var copied []DictInfo
+	var copiedDBNames []string
+
+	for _, dbName := range s.ListDBs() {
+
+		db, err := s.DB(dbName)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range db.ListDicts() {
+			owner := _DictOwner(dbName, info.Name)
+			switch info.Kind {
+			case DICT_KIND_BTREE:
+				err = g._WalkBTreeMeta(db.dbSets[info.Name].metaPageId, owner)
+			case DICT_KIND_UNKNOWN:
+				err = g._WalkDictMeta(db.pageIdByMetaName[info.Name], info.Kind, owner)
+			default:
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			copied = append(copied, info)
+			copiedDBNames = append(copiedDBNames, dbName)
+		}
+	}
+
+	dstPager := dst.pager.(*StreamPager)
+
+	// the internal pages copied from a file written before checksums carry
+	// none, the whole file goes without them then
+	if len(copied) > 0 && !_ChecksumsOf(s.pager) {
+		dstPager.basePager.noChecksums = true
+	}
+
+	// the file is new and nobody else reads it until it is renamed, its
+	// pages go straight to it
+	err := dstPager._DisableWriteBuffer()
+	if err != nil {
+		return err
+	}
+
+	// the DBs go over in order, the empty ones among them
+	for _, dbName := range s.ListDBs() {
+		_, err = dst.DB(dbName)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s._CopyDictPagesTo(dst, g, copiedDBNames, copied)
+	if err != nil {
+		return err
+	}
+
+	for _, dbName := range s.ListDBs() {
+
+		db, err := s.DB(dbName)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range db.ListDicts() {
+			if info.Kind == DICT_KIND_BTREE || info.Kind == DICT_KIND_UNKNOWN {
+				continue
+			}
+			err = s._RebuildDict(dst, dbName, info.Name)
+			if err != nil {
+				return _OwnedError(err, _DictOwner(dbName, info.Name))
+			}
+		}
+	}
+
+	return nil
+}
+
+// _CopyDictPagesTo copies the chains of g to new pages of dst and adds the
+// dicts they belong to to the DB contexts of dst.
+func (s *Storage) _CopyDictPagesTo(dst *Storage, g *PageGraph, dbNames []string, infos []DictInfo) error {
+
+	if len(g.chains) == 0 {
+		return nil
+	}
+
+	dstPager := dst.pager.(*StreamPager)
+
+	newPageIdByPageId := make(map[uint32]uint32)
+	for _, chain := range g.chains {
+		for _, pid := range chain.pageIds {
+			newPageIdByPageId[pid] = dstPager.CreatePageId()
+		}
+	}
+
+	err := g._Relocate(dstPager, newPageIdByPageId, true)
+	if err != nil {
+		return err
+	}
+
+	for i, info := range infos {
+
+		db, err := s.DB(dbNames[i])
+		if err != nil {
+			return err
+		}
+		dstDB, err := dst.DB(dbNames[i])
+		if err != nil {
+			return err
+		}
+
+		if info.Kind == DICT_KIND_BTREE {
+			dset := db.dbSets[info.Name]
+			dstDB.dbSets[info.Name] = &DBSet{name: info.Name, dbType: dset.dbType, metaPageId: newPageIdByPageId[dset.metaPageId]}
+			continue
+		}
+
+		dstDB.pageIdByMetaName[info.Name] = newPageIdByPageId[db.pageIdByMetaName[info.Name]]
+		version, ok := db.versionByMetaName[info.Name]
+		if ok {
+			dstDB.versionByMetaName[info.Name] = version
+		}
+	}
+
+	return nil
+}
+
+// _RebuildDict reads the items of the dict dictName of dbName into a bulk
+// loader of the same kind on dst.
+func (s *Storage) _RebuildDict(dst *Storage, dbName string, dictName string) error {
+
+	dict, err := s.OpenDict(dbName, dictName)
+	if err != nil {
+		return err
+	}
+
+	switch d := dict.(type) {
+	case *LazyI64StrDict:
+		l, err := NewI64StrBulkLoader(dst, dbName, dictName)
+		if err != nil {
+			return err
+		}
+		err = _LoadItems(l.BulkLoader, d.Items(), l.Add)
+		if err == nil {
+			_, err = l.Finish()
+		}
+		return err
+
+	case *LazyStrI64Dict:
+		newLoader := NewStrI64BulkLoader
+		if d.IsOrdered() {
+			newLoader = NewOrderedStrI64BulkLoader
+		}
+		l, err := newLoader(dst, dbName, dictName)
+		if err != nil {
+			return err
+		}
+		err = _LoadItems(l.BulkLoader, d.Items(), l.Add)
+		if err == nil {
+			_, err = l.Finish()
+		}
+		return err
+
+	case *LazyI64BlobDict:
+		l, err := NewI64BlobBulkLoader(dst, dbName, dictName)
+		if err != nil {
+			return err
+		}
+		err = _LoadItems(l.BulkLoader, d.Items(), l.Add)
+		if err == nil {
+			_, err = l.Finish()
+		}
+		return err
+
+	case *LazyStrBlobDict:
+		newLoader := NewStrBlobBulkLoader
+		if d.IsOrdered() {
+			newLoader = NewOrderedStrBlobBulkLoader
+		}
+		l, err := newLoader(dst, dbName, dictName)
+		if err != nil {
+			return err
+		}
+		err = _LoadItems(l.BulkLoader, d.Items(), l.Add)
+		if err == nil {
+			_, err = l.Finish()
+		}
+		return err
+
+	case *LazyI64I64SetDict:
+		l, err := NewI64I64SetBulkLoader(dst, dbName, dictName)
+		if err != nil {
+			return err
+		}
+		err = _LoadItems(l.BulkLoader, d.Items(), func(key int64, item *LazyI64I64SetItem) error {
+			return _LoadItems(l.BulkLoader, item.Values(), func(value int64, _ struct{}) error {
+				return l.Add(key, value)
+			})
+		})
+		if err == nil {
+			_, err = l.Finish()
+		}
+		return err
+
+	case *LazyStrI64SetDict:
+		newLoader := NewStrI64SetBulkLoader
+		if d.IsOrdered() {
+			newLoader = NewOrderedStrI64SetBulkLoader
+		}
+		l, err := newLoader(dst, dbName, dictName)
+		if err != nil {
+			return err
+		}
+		err = _LoadItems(l.BulkLoader, d.Items(), func(key string, item *LazyStrI64SetItem) error {
+			return _LoadItems(l.BulkLoader, item.Values(), func(value int64, _ struct{}) error {
+				return l.Add(key, value)
+			})
+		})
+		if err == nil {
+			_, err = l.Finish()
+		}
+		return err
+	}
+
+	return fmt.Errorf("%w: rebuild of %v", ErrNotImplemented, dict.ToString())
+}
+
+// _LoadItems reads cur to the end into add. The loader is closed when it
+// fails.
+func _LoadItems[K any, V any](l *BulkLoader, cur *Cursor[K, V], add func(key K, value V) error) error {
+	defer cur.Close()
+
+	for cur.Next() {
+		err := add(cur.Key(), cur.Value())
+		if err != nil {
+			l.Close()
+			return err
+		}
+	}
+
+	err := cur.Err()
+	if err != nil {
+		l.Close()
+	}
+	return err
+}
+
+// Vacuum moves the pages at the end of the file into the free pages before
+// them and truncates the file, it returns how many bytes it reclaimed. The
+// space freed inside a dict stays with it, Compact gives that back too. The
+// storage is saved first and changes of dicts not saved are lost. The
+// dicts opened before must be opened again, they fail with ErrStaleDict.
+// It waits for Update and fails while a transaction is open.
+func (s *Storage) Vacuum() (int64, error) {
+	if s.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	if s.tx != nil {
+		return 0, DBError{message: "transaction in progress"}
+	}
+
+	err := s._Save()
+	if err != nil {
+		return 0, err
+	}
+	err = s.Checkpoint()
+	if err != nil {
+		return 0, err
+	}
+
+	pager := s.pager.(*StreamPager)
+
+	// the pages the open dicts hold move or are cut off
+	pager.cache._Invalidate()
+
+	oldSize, err := s.stream.Size()
+	if err != nil {
+		return 0, err
+	}
+
+	g, err := _WalkPageGraph(pager, s.rootPageId)
+	if err != nil {
+		return 0, err
+	}
+
+	// the freelist is written again empty, only its first page stays
+	freelist := pager.freelist
+
+	isLive := make(map[uint32]bool)
+	for pid, _ := range g.chainByPageId {
+		isLive[pid] = true
+	}
+	isLive[freelist.rootPageId] = true
+
+	lastPageId := uint32(len(isLive))
+
+	var freePageIds []uint32
+	for pid:=uint32(1); pid<=lastPageId; pid++ {
+		if !isLive[pid] {
+			freePageIds = append(freePageIds, pid)
+		}
+	}
+
+	var movedPageIds []uint32
+	for pid, _ := range isLive {
+		if pid > lastPageId {
+			movedPageIds = append(movedPageIds, pid)
+		}
+	}
+	sort.Sort(U32Array(movedPageIds))
+
+	newPageIdByPageId := make(map[uint32]uint32)
+	for i, pid := range movedPageIds {
+		newPageIdByPageId[pid] = freePageIds[i]
+	}
+
+	err = s._MovePages(g, newPageIdByPageId, movedPageIds, lastPageId)
+	if err != nil {
+		return 0, err
+	}
+	err = s.Checkpoint()
+	if err != nil {
+		return 0, err
+	}
+
+	newSize := int64(lastPageId + 1) * int64(pager.GetPageSize())
+	if newSize >= oldSize {
+		return 0, nil
+	}
+
+	err = s.stream.Truncate(newSize)
+	if err != nil {
+		return 0, err
+	}
+	if s.options._SyncMode() != SYNC_OFF {
+		err = s.stream.Sync()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return oldSize - newSize, nil
+}
+
+// _MovePages writes the chains of g over the free pages and saves the
+// storage with the file ending at lastPageId. When it fails the pages it
+// buffered are dropped and the storage is left as it was.
+func (s *Storage) _MovePages(g *PageGraph, newPageIdByPageId map[uint32]uint32, movedPageIds []uint32, lastPageId uint32) error {
+
+	pager := s.pager.(*StreamPager)
+	freelist := pager.freelist
+	meta := pager.basePager.meta
+
+	oldMeta := *meta
+	oldFreelistPageId := freelist.rootPageId
+	oldFreePageIdSet := freelist.pageIdSet
+	oldRootPageId := s.rootPageId
+
+	err := s._MovePagesUnsaved(g, newPageIdByPageId, movedPageIds, lastPageId)
+	if err == nil {
+		err = s._Save()
+	}
+	if err != nil {
+		pager.Discard()
+		meta.lastPageId = oldMeta.lastPageId
+		meta.freelistPageId = oldMeta.freelistPageId
+		freelist.rootPageId = oldFreelistPageId
+		freelist.pageIdSet = oldFreePageIdSet
+		s.rootPageId = oldRootPageId
+		s._LoadRoot()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) _MovePagesUnsaved(g *PageGraph, newPageIdByPageId map[uint32]uint32, movedPageIds []uint32, lastPageId uint32) error {
+
+	pager := s.pager.(*StreamPager)
+	freelist := pager.freelist
+
+	err := g._Relocate(pager, newPageIdByPageId, false)
+	if err != nil {
+		return err
+	}
+
+	// an open snapshot keeps the images of the pages that moved away
+	empty := make([]byte, PAYLOAD_PAGE_HEADER_SIZE)
+	for _, pid := range movedPageIds {
+		err = pager.WritePage(pid, empty)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the freelist starts again from an empty first page, the pages it
+	// had behind it were free slots or are cut off
+	freelistPageId := freelist.rootPageId
+	newPageId, ok := newPageIdByPageId[freelistPageId]
+	if ok {
+		freelistPageId = newPageId
+	}
+	err = pager.WritePage(freelistPageId, empty)
+	if err != nil {
+		return err
+	}
+	freelist.rootPageId = freelistPageId
+	freelist.pageIdSet = make(map[uint32]byte)
+
+	meta := pager.basePager.meta
+	meta.lastPageId = lastPageId
+	meta.freelistPageId = freelistPageId
+
+	newPageId, ok = newPageIdByPageId[s.rootPageId]
+	if ok {
+		s.rootPageId = newPageId
+	}
+
+	return s._LoadRoot()
+}
+
+// _ReplaceFile has write fill a file next to path and renames it over path
+// under the exclusive lock of path. It fails with ErrLocked while another
+// storage has path open.
+func _ReplaceFile(path string, write func(tmpPath string) error) error {
+
+	target, err := _OpenFileStream(path, false)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	err = target._Lock(true, 0)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+
+	err = write(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// a log left by an older file at path would be replayed over the new one
+	err = os.Remove(path + "-wal")
+	if err != nil && !os.IsNotExist(err) {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,110 @@
+package gokvdb
+
+import (
+	"fmt"
+	"iter"
+)
+
+// Cursor is a pull iterator over the items of a dict or set.
+//
+//	cur := dict.Items()
+//	defer cur.Close()
+//	for cur.Next() {
+//		fmt.Println(cur.Key(), cur.Value())
+//	}
+//	err := cur.Err()
+//
+// The walk runs lazily inside Next. Close ends it early and is safe to call
+// more than once, a cursor read to the end closes itself.
+type Cursor[K any, V any] struct {
+	next func() (K, V, bool)
+	stop func()
+	key K
+	value V
+	err error
+	isClosed bool
+}
+
+// _NewCursor wraps walk, which must stop once yield returns false and
+// return the error that ended it early.
+func _NewCursor[K any, V any](walk func(yield func(K, V) bool) error) *Cursor[K, V] {
+
+	c := new(Cursor[K, V])
+
+	seq := func(yield func(K, V) bool) {
+		err := walk(yield)
+		if err != nil {
+			c.err = err
+		}
+	}
+
+	c.next, c.stop = iter.Pull2(seq)
+
+	return c
+}
+
+// _ErrCursor returns a cursor that yields nothing and reports err.
+func _ErrCursor[K any, V any](err error) *Cursor[K, V] {
+	c := _NewCursor(func(yield func(K, V) bool) error {
+		return nil
+	})
+	c.err = err
+	return c
+}
+
+func (c *Cursor[K, V]) ToString() string {
+	return fmt.Sprintf("<Cursor key=%v isClosed=%v err=%v>", c.key, c.isClosed, c.err)
+}
+
+func (c *Cursor[K, V]) Next() bool {
+	if c.isClosed {
+		return false
+	}
+
+	key, value, ok := c.next()
+	if !ok {
+		c.Close()
+		return false
+	}
+
+	c.key = key
+	c.value = value
+
+	return true
+}
+
+func (c *Cursor[K, V]) Key() K {
+	return c.key
+}
+
+func (c *Cursor[K, V]) Value() V {
+	return c.value
+}
+
+// Err returns the error that ended the walk, nil when it ran to the end or
+// was closed early.
+func (c *Cursor[K, V]) Err() error {
+	return c.err
+}
+
+func (c *Cursor[K, V]) Close() error {
+	if !c.isClosed {
+		c.isClosed = true
+		c.stop()
+	}
+	return c.err
+}
+
+// All adapts the cursor to a range-over-func loop. The cursor is closed
+// when the loop ends, check Err afterwards.
+func (c *Cursor[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		defer c.Close()
+
+		for c.Next() {
+			if !yield(c.key, c.value) {
+				return
+			}
+		}
+	}
+}
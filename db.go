@@ -8,31 +8,52 @@ package gokvdb
 
 import (
 	"fmt"
+	"sync"
+	"crypto/rand"
 )
 
 const (
 	
 	PAGE_SIZE int = 4096
 	STORAGE_PAGER_META_OFFSET = 64
+	// the number of commits the file has seen, u64
+	STORAGE_EPOCH_OFFSET = 32
+	// the random id of the file, 16 bytes. It is drawn when the file is
+	// created, so a file rebuilt by Compact gets a new one
+	STORAGE_LINEAGE_OFFSET = 40
+	STORAGE_LINEAGE_SIZE = 16
 
 	DBTYPE_BTREE uint8 = 1
 	//DBTYPE_HASH uint8 = 2
 )
 
 type IDBIndex interface {
-	SaveAndGetMeta() []byte
+	SaveAndGetMeta() ([]byte, error)
 	GetIsChanged() bool
 	SetIsChanged(val bool)
 	ToString() string
 }
 
 type Storage struct {
+	path string
 	stream IStream
+	wal *WriteAheadLog
 	pager IPager
 	rootPageId uint32
 	dbItems map[string]*DBItem
+	tx *Tx
+	readOnly bool
+	options Options
+	lineage Lineage
+	// writeLock keeps one Update at a time
+	writeLock sync.Mutex
 }
 
+// Lineage tells the files of a storage and its backups apart, the epochs
+// of two files compare only when their lineages are equal. A file written
+// before lineages were kept has the zero lineage until its next save.
+type Lineage [STORAGE_LINEAGE_SIZE]byte
+
 type DBItem struct {
 	metaPageId uint32
 	name string	
@@ -43,8 +64,11 @@ type DBItem struct {
 type DBContext struct {	
 	name string
 	pageIdByMetaName map[string]uint32
+	kindByMetaName map[string]DictKind
+	versionByMetaName map[string]uint8
 	dbSets map[string]*DBSet
 	pager IPager
+	readOnly bool
 }
 
 type DBSet struct {
@@ -55,7 +79,7 @@ type DBSet struct {
 }
 
 func (s *Storage) ToString() string {
-	return fmt.Sprintf("<Storage rootPageId=%v path=%v>", s.rootPageId, s.stream.ToString())
+	return fmt.Sprintf("<Storage rootPageId=%v readOnly=%v path=%v>", s.rootPageId, s.readOnly, s.stream.ToString())
 }
 
 
@@ -71,31 +95,105 @@ func (s *DBSet) ToString() string {
 	return fmt.Sprintf("<DBSet name=%v dbType=%v metaPageId=%v>", s.name, s.dbType, s.metaPageId)
 }
 
+// OpenStorage opens or creates the file at path and takes its exclusive
+// lock. It fails with ErrLocked while another process has the file open.
 func OpenStorage(path string) (*Storage, error) {
+	return OpenStorageWithOptions(path, Options{})
+}
+
+// OpenStorageReadOnly opens an existing file under a shared lock, which
+// other readers may hold at the same time but a writer may not.
+func OpenStorageReadOnly(path string) (*Storage, error) {
+	return OpenStorageWithOptions(path, Options{ReadOnly: true})
+}
+
+func OpenStorageWithOptions(path string, opts Options) (*Storage, error) {
+
+	opts, err := opts._WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := _OpenFileStream(path, opts.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
 
-	stream, err := OpenFileStream(path)
+	err = stream._Lock(!opts.ReadOnly, opts.LockTimeout)
 	if err != nil {
 		stream.Close()
 		return nil, err
-	}	
+	}
+
+	pageSize := _ReadStoragePageSize(stream, path + "-wal", uint32(opts.PageSize))
+
+	var wal *WriteAheadLog
+	if opts.ReadOnly {
+		wal, err = OpenWriteAheadLogReadOnly(path + "-wal", pageSize)
+	} else {
+		wal, err = OpenWriteAheadLog(path + "-wal", stream, pageSize)
+	}
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	if opts.Mmap {
+		mmapStream, err := _NewMmapStream(stream)
+		if err != nil {
+			if wal != nil {
+				wal.Close()
+			}
+			stream.Close()
+			return nil, err
+		}
+		return _OpenStorage(path, mmapStream, wal, pageSize, opts)
+	}
+
+	return _OpenStorage(path, stream, wal, pageSize, opts)
+}
+
+// _OpenStorage loads the header and the root from stream, which it closes
+// with wal when it fails.
+func _OpenStorage(path string, stream IStream, wal *WriteAheadLog, pageSize uint32, opts Options) (*Storage, error) {
 
 	var rootPageId uint32
-	var pageSize uint32
-	pageSize = 4096
+	checksums := true
 
-	stream.Seek(0)
-	headerData, err := stream.Read(512)
+	// Read copies, the versions keep the header while the file changes
+	var headerData []byte
+	err := stream.SeekTo(0)
+	if err == nil {
+		headerData, err = stream.Read(HEADER_SIZE)
+	}
 	var pagerMeta []byte
 
+	// a reader cannot checkpoint, the latest header may still be in the log
+	if wal != nil {
+		walHeaderData, ok := wal.ReadPage(WAL_HEADER_PAGE_ID, HEADER_SIZE)
+		if ok {
+			headerData = walHeaderData
+			err = nil
+		}
+	}
+
 	if err == nil {
+		checksums, err = _VerifyStorageHeader("Storage header", headerData)
+		if err != nil {
+			if wal != nil {
+				wal.Close()
+			}
+			stream.Close()
+			return nil, err
+		}
+
 		rd := NewDataStreamFromBuffer(headerData)
 
 		rootPageId = rd.ReadUInt32()
 		rd.Seek(STORAGE_PAGER_META_OFFSET)
 		pagerMeta = rd.Read(128)
 
-		
-		
+		opts = opts._ReadSaved(rd, pageSize)
 
 	} else {
 		rootPageId = 0
@@ -105,24 +203,148 @@ func OpenStorage(path string) (*Storage, error) {
 
 	//fmt.Println("PAGER META >>", pagerMeta)
 
+	if wal != nil {
+		wal.syncMode = opts.SyncMode
+	}
+
+	storage := new(Storage)
+	storage.options = opts
+	if rootPageId != 0 {
+		storage.lineage = _ReadStorageLineage(headerData)
+	}
+	if storage.lineage == (Lineage{}) && !opts.ReadOnly {
+		_, err = rand.Read(storage.lineage[:])
+		if err != nil {
+			if wal != nil {
+				wal.Close()
+			}
+			stream.Close()
+			return nil, err
+		}
+	}
+
 	meta := ReadOrNewStreamPagerMeta(pageSize, pagerMeta)
-	pager := NewStreamPager(stream, meta)
+	pager, err := _NewStreamPager(stream, meta, wal, checksums)
+	if err != nil {
+		if wal != nil {
+			wal.Close()
+		}
+		stream.Close()
+		return nil, err
+	}
+	pager.(*StreamPager)._EnableWriteBuffer()
+	pager.(*StreamPager).cache = NewPageCache(opts._CacheSize())
+	pager.(*StreamPager).options = &storage.options
+	if rootPageId != 0 {
+		pager.(*StreamPager).versions = _NewPageVersions(headerData)
+	}
+	if opts.ReadOnly {
+		pager.(*StreamPager)._SetReadOnly()
+	}
 
 	//fmt.Printf("PAGER >> %v\n", pager.ToString())
 
-	storage := new(Storage)
+	storage.path = path
 	storage.stream = stream
+	storage.wal = wal
 	storage.pager = pager
+	storage.readOnly = opts.ReadOnly
 	storage.dbItems = make(map[string]*DBItem)
 
 	if rootPageId == 0 {
 
 		rootPageId = pager.CreatePageId()
+		storage.rootPageId = rootPageId
 
 	} else {
-		rootData, err := pager.ReadPayloadData(rootPageId)
-		_CheckErr("LOAD ROOT", err)
-		rootR := NewDataStreamFromBuffer(rootData)
+		storage.rootPageId = rootPageId
+		err = storage._LoadRoot()
+		if err != nil {
+			storage.Close()
+			return nil, err
+		}
+	}
+
+	//fmt.Println(strings.Repeat("-", 30))
+
+	//fmt.Println("OPEN", storage.ToString())
+
+	return storage, nil
+}
+
+// _ReadStorageEpoch returns the commit count of a storage header, 0 for
+// none or one written before it was kept.
+func _ReadStorageEpoch(header []byte) uint64 {
+	if len(header) < HEADER_SIZE {
+		return 0
+	}
+	rd := NewDataStreamFromBuffer(header)
+	rd.Seek(STORAGE_EPOCH_OFFSET)
+	return rd.ReadUInt64()
+}
+
+// _ReadStorageLineage returns the lineage of a storage header, the zero
+// lineage for none or one written before it was kept.
+func _ReadStorageLineage(header []byte) Lineage {
+	var lineage Lineage
+	if len(header) >= HEADER_SIZE {
+		copy(lineage[:], header[STORAGE_LINEAGE_OFFSET:])
+	}
+	return lineage
+}
+
+// _ReadStoragePageSize returns the page size of an existing file before its
+// log is opened. A file whose header never reached it takes the one of the
+// log, a new file the one of the options.
+func _ReadStoragePageSize(stream IStream, walPath string, pageSize uint32) uint32 {
+
+	headerData, err := stream.ReadAt(0, HEADER_SIZE)
+	if err == nil {
+		_, err = _VerifyStorageHeader("Storage header", headerData)
+	}
+	if err == nil {
+		rd := NewDataStreamFromBuffer(headerData)
+		rd.Seek(STORAGE_PAGER_META_OFFSET)
+		headerPageSize := rd.ReadUInt32()
+		if headerPageSize != 0 {
+			return headerPageSize
+		}
+	}
+
+	walPageSize := _ReadWriteAheadLogPageSize(walPath)
+	if walPageSize != 0 {
+		return walPageSize
+	}
+
+	return pageSize
+}
+
+// Options returns the settings the storage was opened with, the creation
+// settings as saved in its header.
+func (s *Storage) Options() Options {
+	return s.options
+}
+
+func (s *Storage) IsReadOnly() bool {
+	return s.readOnly
+}
+
+// CacheStats reports the hits, misses and evictions of the page cache
+// shared by the dicts of the storage.
+func (s *Storage) CacheStats() PageCacheStats {
+	return s.pager.(*StreamPager).cache.Stats()
+}
+
+func (s *Storage) _LoadRoot() error {
+
+	s.dbItems = make(map[string]*DBItem)
+
+	rootData, err := s.pager.ReadPayloadData(s.rootPageId)
+	if err != nil {
+		return err
+	}
+
+	return _DecodePage("Storage root", s.rootPageId, rootData, func(rootR *DataStream) {
 
 		dbCount := rootR.ReadUInt16()
 
@@ -135,20 +357,12 @@ func OpenStorage(path string) (*Storage, error) {
 			dbMetaPagId = rootR.ReadUInt32()
 			dbName = rootR.ReadHStr()
 
-			dbItem := storage._NewDBItem(dbName, dbMetaPagId)
+			dbItem := s._NewDBItem(dbName, dbMetaPagId)
 
-			storage.dbItems[dbItem.name] = dbItem
+			s.dbItems[dbItem.name] = dbItem
 			//fmt.Println("LOAD DBItem", dbItem.ToString())
 		}
-	}
-
-	storage.rootPageId = rootPageId
-	
-	//fmt.Println(strings.Repeat("-", 30))
-
-	//fmt.Println("OPEN", storage.ToString())
-
-	return storage, nil
+	})
 }
 
 func (s *Storage) _NewDBItem(name string, metaPageId uint32) *DBItem {
@@ -167,8 +381,11 @@ func (s *Storage) DB(name string) (*DBContext, error) {
 		metaPageId := s.pager.CreatePageId()
 		item = s._NewDBItem(name, metaPageId)
 
-		ctx, err := _OpenDBContext(name, s.pager, make([]byte, 256))
-		_CheckErr("_OpenDBContext", err)
+		ctx, err := _OpenDBContext(name, s.pager, metaPageId, make([]byte, 256))
+		if err != nil {
+			return nil, err
+		}
+		ctx.readOnly = s.readOnly
 		item.ctx = ctx		
 
 		s.dbItems[name] = item
@@ -176,25 +393,68 @@ func (s *Storage) DB(name string) (*DBContext, error) {
 
 	if item.ctx == nil {
 		dbMeta, err := s.pager.ReadPayloadData(item.metaPageId)
-		_CheckErr("DB", err)
-		ctx, err := _OpenDBContext(name, s.pager, dbMeta)
-		_CheckErr("_OpenDBContext", err)
+		if err != nil {
+			return nil, err
+		}
+		ctx, err := _OpenDBContext(name, s.pager, item.metaPageId, dbMeta)
+		if err != nil {
+			return nil, err
+		}
+		ctx.readOnly = s.readOnly
 		item.ctx = ctx
 	}
 
 	return item.ctx, nil
 }
 
-func (s *Storage) Close() {
+// Checkpoint folds the committed pages still held by the write-ahead log
+// into the main file.
+func (s *Storage) Checkpoint() error {
+	return s.pager.(*StreamPager).Checkpoint()
+}
+
+// Close checkpoints the write-ahead log and closes the file, which releases
+// its lock. A failed checkpoint leaves the log in place so the next open
+// can replay it.
+func (s *Storage) Close() error {
+
+	var err error
+
+	if s.wal != nil {
+		if !s.readOnly {
+			err = s.Checkpoint()
+		}
+		s.wal.Close()
+		s.wal = nil
+	}
 
 	if s.stream != nil {
 		s.stream.Close()
 		s.stream = nil
 	}
+
+	return err
 }
 
 
-func (s *Storage) Save() {	
+// Save writes every changed DB context and the storage header. It fails
+// while a transaction is open, Tx.Commit writes them then.
+func (s *Storage) Save() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if s.tx != nil {
+		return DBError{message: "transaction in progress"}
+	}
+
+	return s._Save()
+}
+
+func (s *Storage) _Save() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
 	rootW := NewDataStream()
 	rootW.WriteUInt16(uint16(len(s.dbItems)))
 
@@ -202,37 +462,62 @@ func (s *Storage) Save() {
 		//fmt.Println("SAVE DBContext", name)
 
 		if dbItem.ctx != nil {
-			dbCtxMeta := dbItem.ctx.Save()
-			s.pager.WritePayloadData(dbItem.metaPageId, dbCtxMeta)
+			dbCtxMeta, err := dbItem.ctx.Save()
+			if err != nil {
+				return err
+			}
+			err = s.pager.WritePayloadData(dbItem.metaPageId, dbCtxMeta)
+			if err != nil {
+				return err
+			}
 		}		
 
 		rootW.WriteUInt32(dbItem.metaPageId)
 		rootW.WriteHStr(dbItem.name)
 	}
 
-	s.pager.WritePayloadData(s.rootPageId, rootW.ToBytes())
+	err := s.pager.WritePayloadData(s.rootPageId, rootW.ToBytes())
+	if err != nil {
+		return err
+	}
 
 
-	pageMeta := s.pager.Save()
+	pageMeta, err := s.pager.Save()
+	if err != nil {
+		return err
+	}
 
 //	metaWriteOffset := int64(0)
 
-	hdrW := NewDataStream()
+	hdrW := NewDataStreamFromBuffer(make([]byte, HEADER_SIZE))
 
 	hdrW.WriteUInt32(s.rootPageId)
+	s.options._Write(hdrW)
+
+	hdrW.Seek(STORAGE_EPOCH_OFFSET)
+	hdrW.WriteUInt64(s.pager.(*StreamPager).versions.epoch + 1)
+	hdrW.Seek(STORAGE_LINEAGE_OFFSET)
+	hdrW.Write(s.lineage[:])
+
+	// a file written before checksums keeps the flag clear, only Compact
+	// rewrites every one of its pages
+	if !s.pager.(*StreamPager).basePager.noChecksums {
+		hdrW.Seek(STORAGE_FLAGS_OFFSET)
+		hdrW.WriteUInt32(STORAGE_FLAG_CHECKSUMS)
+	}
 
 	if pageMeta != nil {
 		hdrW.Seek(STORAGE_PAGER_META_OFFSET)
 		hdrW.Write(pageMeta)		
 	}	
 
-	s.stream.Seek(0)
-	s.stream.Write(hdrW.ToBytes())
+	header := hdrW.ToBytes()
+	_StampChecksum(header, STORAGE_HEADER_CHECKSUM_OFFSET)
 
-	s.stream.Sync()
+	return s.pager.(*StreamPager).Commit(header)
 }
 
-func (ctx *DBContext) Save() []byte {
+func (ctx *DBContext) Save() ([]byte, error) {
 
 	rootW := NewDataStream()
 	
@@ -245,13 +530,20 @@ func (ctx *DBContext) Save() []byte {
 
 		switch dset.dbType {
 		case DBTYPE_BTREE:
-			db := dset.obj.(IDBIndex)
-			if db.GetIsChanged() {
+			// an index not opened since the load has nothing to save
+			db, ok := dset.obj.(IDBIndex)
+			if ok && db.GetIsChanged() {
 				//fmt.Println("SAVE BTreeBlobMap", dset, db.ToString())
 				//tb := dset.obj.(IDBIndex)
-				meta := db.SaveAndGetMeta()
+				meta, err := db.SaveAndGetMeta()
+				if err != nil {
+					return nil, err
+				}
 				//fmt.Println("SAVE BTree META", meta)
-				pager.WritePayloadData(dset.metaPageId, meta)
+				err = pager.WritePayloadData(dset.metaPageId, meta)
+				if err != nil {
+					return nil, err
+				}
 				db.SetIsChanged(false)	
 			}
 		}		
@@ -268,69 +560,121 @@ func (ctx *DBContext) Save() []byte {
 		rootW.WriteUInt32(pgId)
 	}
 
-	return rootW.ToBytes()
+	rootW.WriteUInt32(uint32(len(ctx.kindByMetaName)))
+
+	for metaName, kind := range ctx.kindByMetaName {
+		rootW.WriteHStr(metaName)
+		rootW.WriteUInt8(uint8(kind))
+	}
+
+	rootW.WriteUInt32(uint32(len(ctx.versionByMetaName)))
+
+	for metaName, version := range ctx.versionByMetaName {
+		rootW.WriteHStr(metaName)
+		rootW.WriteUInt8(version)
+	}
+
+	return rootW.ToBytes(), nil
 }
 
-func (ctx *DBContext) GetMeta(name string) ([]byte, bool) {
+// GetMeta returns ErrNotFound when no meta has been set for name.
+func (ctx *DBContext) GetMeta(name string) ([]byte, error) {
 
 	pid, ok := ctx.pageIdByMetaName[name]
-	if ok {
-		data, err := ctx.pager.ReadPayloadData(pid)
-		if err == nil {
-			return data, true
-		}
+	if !ok {
+		return nil, ErrNotFound
 	}
 
-	return nil, false
+	data, err := ctx.pager.ReadPayloadData(pid)
+	return data, _OwnedError(err, _DictOwner(ctx.name, name))
 }
 
-func (ctx *DBContext) SetMeta(name string, data []byte) {
+func (ctx *DBContext) SetMeta(name string, data []byte) error {
 
 	pid, ok := ctx.pageIdByMetaName[name]
 	if !ok {
 		pid = ctx.pager.CreatePageId()
 		ctx.pageIdByMetaName[name] = pid
 	}
-	ctx.pager.WritePayloadData(pid, data)
+	return ctx.pager.WritePayloadData(pid, data)
+}
+
+// _DictOwner is the name page errors use for a dict.
+func _DictOwner(dbName string, dictName string) string {
+	return dbName + "/" + dictName
 }
 
-func _OpenDBContext(name string, pager IPager, meta []byte) (*DBContext, error) {
+func _OpenDBContext(name string, pager IPager, metaPageId uint32, meta []byte) (*DBContext, error) {
 	ctx := new(DBContext)
 	ctx.name = name
 	ctx.pager = pager
 	ctx.dbSets = make(map[string]*DBSet)
 	ctx.pageIdByMetaName = make(map[string]uint32)
+	ctx.kindByMetaName = make(map[string]DictKind)
+	ctx.versionByMetaName = make(map[string]uint8)
 
-	rootR := NewDataStreamFromBuffer(meta)
+	err := _DecodePage("DBContext meta", metaPageId, meta, func(rootR *DataStream) {
 
-	itemCount := rootR.ReadUInt32()
+		itemCount := rootR.ReadUInt32()
 
-	var i uint32
-	var dsetType uint8
-	var dsetMetaPagId uint32
-	var dsetName string
+		var i uint32
+		var dsetType uint8
+		var dsetMetaPagId uint32
+		var dsetName string
 
-	for i=0; i<itemCount; i++ {
-		dsetType = rootR.ReadUInt8()
-		dsetMetaPagId = rootR.ReadUInt32()
-		dsetName = rootR.ReadHStr()
+		for i=0; i<itemCount; i++ {
+			dsetType = rootR.ReadUInt8()
+			dsetMetaPagId = rootR.ReadUInt32()
+			dsetName = rootR.ReadHStr()
 
-		dset := new(DBSet)
-		dset.dbType = dsetType
-		dset.metaPageId = dsetMetaPagId
-		dset.name = dsetName
+			dset := new(DBSet)
+			dset.dbType = dsetType
+			dset.metaPageId = dsetMetaPagId
+			dset.name = dsetName
 
-		ctx.dbSets[dset.name] = dset
-		//fmt.Println("LOAD DSet", dset.ToString())
-	}
+			ctx.dbSets[dset.name] = dset
+			//fmt.Println("LOAD DSet", dset.ToString())
+		}
+
+		metaCount := rootR.ReadUInt32()
+
+		for i=0 ; i<metaCount; i++ {
+			metaName := rootR.ReadHStr()
+			metaPid := rootR.ReadUInt32()
 
-	metaCount := rootR.ReadUInt32()
+			ctx.pageIdByMetaName[metaName] = metaPid
+		}
+
+		// contexts saved before dict kinds were recorded end here
+		if rootR.Remaining() < 4 {
+			return
+		}
+
+		kindCount := rootR.ReadUInt32()
+
+		for i=0 ; i<kindCount; i++ {
+			metaName := rootR.ReadHStr()
+			kind := DictKind(rootR.ReadUInt8())
+
+			ctx.kindByMetaName[metaName] = kind
+		}
+
+		// and these before format versions were
+		if rootR.Remaining() < 4 {
+			return
+		}
 
-	for i=0 ; i<metaCount; i++ {
-		metaName := rootR.ReadHStr()
-		metaPid := rootR.ReadUInt32()
+		versionCount := rootR.ReadUInt32()
 
-		ctx.pageIdByMetaName[metaName] = metaPid
+		for i=0 ; i<versionCount; i++ {
+			metaName := rootR.ReadHStr()
+			version := rootR.ReadUInt8()
+
+			ctx.versionByMetaName[metaName] = version
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	
@@ -353,15 +697,38 @@ func (ix *BTreeIndex) ToString() string {
 	return fmt.Sprintf("<BTreeTable %v>", ix.bt.ToString())
 }
 
-func (ix *BTreeIndex) Set(key int64, value []byte) {
-	ix.bt.Set(key, value)
+func (ix *BTreeIndex) Set(key int64, value []byte) error {
+	err := _CheckDropped(ix.internalPager)
+	if err != nil {
+		return err
+	}
+	err = ix.bt.Set(key, value)
+	if err != nil {
+		return err
+	}
 	ix.isChanged = true
+	return nil
 }
 
-func (ix *BTreeIndex) Get(key int64) ([]byte, bool){
+// Get returns ErrNotFound when key is not in the index.
+func (ix *BTreeIndex) Get(key int64) ([]byte, error) {
 	return ix.bt.Get(key)
 }
 
+// Delete returns ErrNotFound when key is not in the index.
+func (ix *BTreeIndex) Delete(key int64) error {
+	err := _CheckDropped(ix.internalPager)
+	if err != nil {
+		return err
+	}
+	err = ix.bt.Delete(key)
+	if err != nil {
+		return err
+	}
+	ix.isChanged = true
+	return nil
+}
+
 func (ix *BTreeIndex) GetIsChanged() bool {
 	return ix.isChanged
 	//return bt.bt.isChanged
@@ -373,17 +740,23 @@ func (ix *BTreeIndex) SetIsChanged(val bool) {
 }
 
 
-func (ix *BTreeIndex) SaveAndGetMeta() []byte {
+func (ix *BTreeIndex) SaveAndGetMeta() ([]byte, error) {
 
-	meta2 := ix.bt.Save()
-	meta1 := ix.internalPager.Save()
+	meta2, err := ix.bt.Save()
+	if err != nil {
+		return nil, err
+	}
+	meta1, err := ix.internalPager.Save()
+	if err != nil {
+		return nil, err
+	}
 
 	w := NewDataStreamFromBuffer(make([]byte, 128))
 	w.Write(meta1)
 	w.Seek(64)
 	w.Write(meta2)
 
-	return w.ToBytes()
+	return w.ToBytes(), nil
 }
 
 func _NewBTreeIndex(internalPager IPager, btMap *BTreeBlobMap) *BTreeIndex {
@@ -397,7 +770,7 @@ func _NewBTreeIndex(internalPager IPager, btMap *BTreeBlobMap) *BTreeIndex {
 
 func (s *DBContext) OpenBTree(name string) (*BTreeIndex, error) {
 
-	internalPageSize := uint16(96)
+	internalPageSize := _OptionsOf(s.pager)._InternalPageSize(96)
 
 	dset, ok := s.dbSets[name]
 	if !ok {
@@ -408,8 +781,14 @@ func (s *DBContext) OpenBTree(name string) (*BTreeIndex, error) {
 		metaPageId := s.pager.CreatePageId()
 		dset.metaPageId = metaPageId
 
-		internalPager := NewInternalPager(s.pager, internalPageSize, nil)
-		btMap := NewBTreeBlobMap(internalPager, nil)
+		internalPager, err := NewInternalPager(s.pager, internalPageSize, nil, _DictOwner(s.name, name))
+		if err != nil {
+			return nil, err
+		}
+		btMap, err := NewBTreeBlobMap(internalPager, nil)
+		if err != nil {
+			return nil, err
+		}
 
 		bt := _NewBTreeIndex(internalPager, btMap)
 
@@ -423,19 +802,31 @@ func (s *DBContext) OpenBTree(name string) (*BTreeIndex, error) {
 		metaData, err := s.pager.ReadPayloadData(dset.metaPageId)
 
 		if err != nil {
-			return nil, err
+			return nil, _OwnedError(err, _DictOwner(s.name, name))
 		}
 
 		//fmt.Println("LOAD BTREE META", metaData)
 
-		rd := NewDataStreamFromBuffer(metaData)
+		var internalMeta []byte
+		var btMeta []byte
 
-		internalMeta := rd.Read(64)
-		rd.Seek(64)
-		btMeta := rd.Read(64)
+		err = _DecodePage("BTree meta", dset.metaPageId, metaData, func(rd *DataStream) {
+			internalMeta = rd.Read(64)
+			rd.Seek(64)
+			btMeta = rd.Read(64)
+		})
+		if err != nil {
+			return nil, err
+		}
 
-		internalPager := NewInternalPager(s.pager, internalPageSize, internalMeta)
-		btMap := NewBTreeBlobMap(internalPager, btMeta)
+		internalPager, err := NewInternalPager(s.pager, internalPageSize, internalMeta, _DictOwner(s.name, name))
+		if err != nil {
+			return nil, err
+		}
+		btMap, err := NewBTreeBlobMap(internalPager, btMeta)
+		if err != nil {
+			return nil, err
+		}
 
 		bt := _NewBTreeIndex(internalPager, btMap)
 
@@ -0,0 +1,319 @@
+package gokvdb
+
+import (
+	"fmt"
+	"errors"
+	"encoding/binary"
+)
+
+// Dict is a typed dict over an ordered LazyStrBlobDict. Keys and values
+// are stored as the bytes of their codecs and walked in key byte order.
+type Dict[K comparable, V any] struct {
+	dict *LazyStrBlobDict
+	keyCodec Codec[K]
+	valueCodec Codec[V]
+}
+
+func NewDict[K comparable, V any](s *Storage, dbName string, dictName string, keyCodec Codec[K], valueCodec Codec[V]) (*Dict[K, V], error) {
+
+	dict, err := NewOrderedStrBlobDict(s, dbName, dictName)
+	if err != nil {
+		return nil, err
+	}
+
+	d := new(Dict[K, V])
+	d.dict = dict
+	d.keyCodec = keyCodec
+	d.valueCodec = valueCodec
+
+	return d, nil
+}
+
+func (d *Dict[K, V]) ToString() string {
+	return fmt.Sprintf("<Dict db=%v name=%v>", d.dict.dbName, d.dict.dictName)
+}
+
+func (d *Dict[K, V]) Set(key K, value V) error {
+
+	keyData, err := d.keyCodec.Encode(key)
+	if err != nil {
+		return err
+	}
+	valueData, err := d.valueCodec.Encode(value)
+	if err != nil {
+		return err
+	}
+
+	return d.dict.Set(string(keyData), valueData)
+}
+
+// Get returns ErrNotFound when key is not in the dict.
+func (d *Dict[K, V]) Get(key K) (V, error) {
+
+	var value V
+
+	keyData, err := d.keyCodec.Encode(key)
+	if err != nil {
+		return value, err
+	}
+
+	valueData, err := d.dict.Get(string(keyData))
+	if err != nil {
+		return value, err
+	}
+
+	return d.valueCodec.Decode(valueData)
+}
+
+// GetMany returns the values of keys in their order, with the bitmap of
+// the keys found, see LazyStrBlobDict.GetMany.
+func (d *Dict[K, V]) GetMany(keys []K) ([]V, Found, error) {
+
+	keysData := make([]string, len(keys))
+	for i, key := range keys {
+		keyData, err := d.keyCodec.Encode(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		keysData[i] = string(keyData)
+	}
+
+	valuesData, found, err := d.dict.GetMany(keysData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make([]V, len(keys))
+	for i, valueData := range valuesData {
+		if !found.Has(i) {
+			continue
+		}
+		values[i], err = d.valueCodec.Decode(valueData)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return values, found, nil
+}
+
+// Delete returns ErrNotFound when key is not in the dict.
+func (d *Dict[K, V]) Delete(key K) error {
+
+	keyData, err := d.keyCodec.Encode(key)
+	if err != nil {
+		return err
+	}
+
+	return d.dict.Delete(string(keyData))
+}
+
+func (d *Dict[K, V]) Items() *Cursor[K, V] {
+	return d._Decode(d.dict.Items())
+}
+
+// Range walks the keys in [from, to) in the order of their codec.
+func (d *Dict[K, V]) Range(from K, to K) *Cursor[K, V] {
+
+	fromData, err := d.keyCodec.Encode(from)
+	if err != nil {
+		return _ErrCursor[K, V](err)
+	}
+	toData, err := d.keyCodec.Encode(to)
+	if err != nil {
+		return _ErrCursor[K, V](err)
+	}
+
+	// no key sorts below the empty one, which Range would take as no bound
+	if len(toData) == 0 {
+		return _EmptyCursor[K, V]()
+	}
+
+	return d._Decode(d.dict.Range(string(fromData), string(toData)))
+}
+
+func (d *Dict[K, V]) _Decode(items *Cursor[string, []byte]) *Cursor[K, V] {
+	return _NewCursor(func(yield func(K, V) bool) error {
+		defer items.Close()
+
+		for items.Next() {
+			key, err := d.keyCodec.Decode([]byte(items.Key()))
+			if err != nil {
+				return err
+			}
+			value, err := d.valueCodec.Decode(items.Value())
+			if err != nil {
+				return err
+			}
+
+			if !yield(key, value) {
+				return nil
+			}
+		}
+
+		return items.Err()
+	})
+}
+
+func (d *Dict[K, V]) Save(commit bool) error {
+	return d.dict.Save(commit)
+}
+
+
+// Set is a typed dict of value sets. Every pair is one key of an ordered
+// LazyStrI64Dict, the length of the encoded key followed by the key and
+// value bytes, so the values of a key are one prefix scan.
+type Set[K comparable, V any] struct {
+	dict *LazyStrI64Dict
+	keyCodec Codec[K]
+	valueCodec Codec[V]
+}
+
+func NewSet[K comparable, V any](s *Storage, dbName string, dictName string, keyCodec Codec[K], valueCodec Codec[V]) (*Set[K, V], error) {
+
+	dict, err := NewOrderedStrI64Dict(s, dbName, dictName)
+	if err != nil {
+		return nil, err
+	}
+
+	set := new(Set[K, V])
+	set.dict = dict
+	set.keyCodec = keyCodec
+	set.valueCodec = valueCodec
+
+	return set, nil
+}
+
+func (set *Set[K, V]) ToString() string {
+	return fmt.Sprintf("<Set db=%v name=%v>", set.dict.dbName, set.dict.dictName)
+}
+
+func (set *Set[K, V]) _Prefix(key K) (string, error) {
+
+	keyData, err := set.keyCodec.Encode(key)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := binary.BigEndian.AppendUint32(nil, uint32(len(keyData)))
+	prefix = append(prefix, keyData...)
+
+	return string(prefix), nil
+}
+
+func (set *Set[K, V]) _PairKey(key K, value V) (string, error) {
+
+	prefix, err := set._Prefix(key)
+	if err != nil {
+		return "", err
+	}
+	valueData, err := set.valueCodec.Encode(value)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + string(valueData), nil
+}
+
+func (set *Set[K, V]) Add(key K, value V) error {
+
+	pairKey, err := set._PairKey(key, value)
+	if err != nil {
+		return err
+	}
+
+	return set.dict.Set(pairKey, 0)
+}
+
+func (set *Set[K, V]) Has(key K, value V) (bool, error) {
+
+	pairKey, err := set._PairKey(key, value)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = set.dict.Get(pairKey)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Remove returns ErrNotFound when the pair is not in the set.
+func (set *Set[K, V]) Remove(key K, value V) error {
+
+	pairKey, err := set._PairKey(key, value)
+	if err != nil {
+		return err
+	}
+
+	return set.dict.Delete(pairKey)
+}
+
+// Delete removes key and all its values. It returns ErrNotFound when key
+// has none.
+func (set *Set[K, V]) Delete(key K) error {
+
+	prefix, err := set._Prefix(key)
+	if err != nil {
+		return err
+	}
+
+	var pairKeys []string
+
+	cur := set.dict.PrefixScan(prefix)
+	for cur.Next() {
+		pairKeys = append(pairKeys, cur.Key())
+	}
+	err = cur.Close()
+	if err != nil {
+		return err
+	}
+
+	if len(pairKeys) == 0 {
+		return ErrNotFound
+	}
+
+	for _, pairKey := range pairKeys {
+		err = set.dict.Delete(pairKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Values walks the values of key in the order of their codec. The values
+// are the cursor keys.
+func (set *Set[K, V]) Values(key K) *Cursor[V, struct{}] {
+
+	prefix, err := set._Prefix(key)
+	if err != nil {
+		return _ErrCursor[V, struct{}](err)
+	}
+
+	pairs := set.dict.PrefixScan(prefix)
+
+	return _NewCursor(func(yield func(V, struct{}) bool) error {
+		defer pairs.Close()
+
+		for pairs.Next() {
+			value, err := set.valueCodec.Decode([]byte(pairs.Key()[len(prefix):]))
+			if err != nil {
+				return err
+			}
+
+			if !yield(value, struct{}{}) {
+				return nil
+			}
+		}
+
+		return pairs.Err()
+	})
+}
+
+func (set *Set[K, V]) Save(commit bool) error {
+	return set.dict.Save(commit)
+}
@@ -0,0 +1,80 @@
+package gokvdb
+
+import (
+	"fmt"
+	"errors"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrCorruptPage = errors.New("corrupt page")
+	ErrPageTooLarge = errors.New("page too large")
+	ErrInvalidPageId = errors.New("invalid page id")
+	ErrNotImplemented = errors.New("not implemented")
+	ErrNotOrdered = errors.New("dict is not ordered")
+	ErrLocked = errors.New("database is locked")
+	ErrReadOnly = errors.New("database is read-only")
+	ErrInvalidOptions = errors.New("invalid options")
+	ErrExists = errors.New("already exists")
+	ErrWrongKind = errors.New("wrong dict kind")
+	ErrUnsupportedVersion = errors.New("unsupported dict format version")
+	ErrInvalidBackup = errors.New("invalid backup")
+	ErrInvalidBatch = errors.New("invalid batch")
+	ErrKeyTooLarge = errors.New("key too large")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrDropped = errors.New("dict has been dropped")
+	ErrStaleDict = errors.New("dict must be opened again")
+)
+
+// PageError reports a failed page operation. It wraps one of the sentinel
+// errors so callers can test it with errors.Is. Owner names the dict the
+// page belongs to when it is known.
+type PageError struct {
+	Op string
+	PageId uint32
+	Owner string
+	Err error
+}
+
+func (e *PageError) Error() string {
+	if e.Owner != "" {
+		return fmt.Sprintf("%s pid=%v owner=%v: %v", e.Op, e.PageId, e.Owner, e.Err)
+	}
+	return fmt.Sprintf("%s pid=%v: %v", e.Op, e.PageId, e.Err)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Err
+}
+
+func _PageError(op string, pid uint32, err error) error {
+	return &PageError{Op: op, PageId: pid, Err: err}
+}
+
+// _OwnedError sets owner on the PageError in err unless an inner pager has
+// already named one.
+func _OwnedError(err error, owner string) error {
+	var pageErr *PageError
+	if owner != "" && errors.As(err, &pageErr) && pageErr.Owner == "" {
+		pageErr.Owner = owner
+	}
+	return err
+}
+
+func _CorruptPageError(op string, pid uint32, format string, args ...interface{}) error {
+	return &PageError{Op: op, PageId: pid, Err: fmt.Errorf("%w: %s", ErrCorruptPage, fmt.Sprintf(format, args...))}
+}
+
+// _DecodePage runs decode over the payload of pid. A damaged payload makes
+// the DataStream read past its buffer, which is reported as ErrCorruptPage.
+func _DecodePage(op string, pid uint32, data []byte, decode func(rd *DataStream)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = _CorruptPageError(op, pid, "%v", r)
+		}
+	}()
+
+	decode(NewDataStreamFromBuffer(data))
+
+	return nil
+}
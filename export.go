@@ -0,0 +1,444 @@
+package gokvdb
+
+import (
+	"io"
+	"fmt"
+	"bufio"
+	"bytes"
+	"strconv"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/base64"
+)
+
+const (
+	// EXPORT_JSONL writes a {"key": ..., "value": ...} object a line, blob
+	// values are base64 strings and the value of a set is an array
+	EXPORT_JSONL = "jsonl"
+	// EXPORT_CSV writes a key,value header and a row an item, blob values
+	// are base64 and a set takes a row for each of its values
+	EXPORT_CSV = "csv"
+)
+
+// ImportReport counts the lines an import read and the items it applied.
+// Malformed holds the line numbers it skipped, starting at 1.
+type ImportReport struct {
+	Lines int
+	Imported int
+	Malformed []int
+}
+
+func (r *ImportReport) ToString() string {
+	return fmt.Sprintf("<ImportReport lines=%v imported=%v malformed=%v>", r.Lines, r.Imported, len(r.Malformed))
+}
+
+// _ExportRecord is a JSON Lines record, a []byte value marshals as base64.
+type _ExportRecord struct {
+	Key interface{} `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// _ImportRecord is a parsed line, the fields the kind of the dict uses are
+// set. intValues holds the value of a StrI64 dict and the values of a set.
+type _ImportRecord struct {
+	intKey int64
+	strKey string
+	strValue string
+	blob []byte
+	intValues []int64
+}
+
+// ExportDict streams every item of dict to w in format and returns the
+// number of keys written.
+func ExportDict(w io.Writer, dict IDict, format string) (int, error) {
+
+	kind, err := _ExportKind(dict)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	var write func(key interface{}, value interface{}) error
+	var csvW *csv.Writer
+
+	switch format {
+	case EXPORT_JSONL:
+		write = func(key interface{}, value interface{}) error {
+			data, err := json.Marshal(_ExportRecord{Key: key, Value: value})
+			if err != nil {
+				return err
+			}
+			_, err = bw.Write(append(data, '\n'))
+			return err
+		}
+	case EXPORT_CSV:
+		csvW = csv.NewWriter(bw)
+		err = csvW.Write([]string{"key", "value"})
+		if err != nil {
+			return 0, err
+		}
+		write = func(key interface{}, value interface{}) error {
+			keyText := _FormatCSVField(key)
+			values, isSet := value.([]int64)
+			if !isSet {
+				return csvW.Write([]string{keyText, _FormatCSVField(value)})
+			}
+			for _, v := range values {
+				err := csvW.Write([]string{keyText, strconv.FormatInt(v, 10)})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		return 0, fmt.Errorf("%w: export format %q", ErrNotImplemented, format)
+	}
+
+	count := 0
+	each := func(key interface{}, value interface{}) error {
+		count += 1
+		return write(key, value)
+	}
+
+	switch d := dict.(type) {
+	case *LazyI64StrDict:
+		err = _ExportItems(d.Items(), each)
+	case *LazyStrI64Dict:
+		err = _ExportItems(d.Items(), each)
+	case *LazyI64BlobDict:
+		err = _ExportItems(d.Items(), each)
+	case *LazyStrBlobDict:
+		err = _ExportItems(d.Items(), each)
+	case *LazyI64I64SetDict:
+		err = _ExportItems(d.Items(), func(key interface{}, item interface{}) error {
+			values, err := _SetValues(item.(*LazyI64I64SetItem).Values())
+			if err != nil {
+				return err
+			}
+			return each(key, values)
+		})
+	case *LazyStrI64SetDict:
+		err = _ExportItems(d.Items(), func(key interface{}, item interface{}) error {
+			values, err := _SetValues(item.(*LazyStrI64SetItem).Values())
+			if err != nil {
+				return err
+			}
+			return each(key, values)
+		})
+	default:
+		err = fmt.Errorf("%w: export of %v", ErrNotImplemented, kind)
+	}
+	if err != nil {
+		return count, err
+	}
+
+	if csvW != nil {
+		csvW.Flush()
+		err = csvW.Error()
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, bw.Flush()
+}
+
+// _ExportItems reads cur to the end, passing each item to write.
+func _ExportItems[K any, V any](cur *Cursor[K, V], write func(key interface{}, value interface{}) error) error {
+	defer cur.Close()
+
+	for cur.Next() {
+		err := write(cur.Key(), cur.Value())
+		if err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+func _SetValues(cur *Cursor[int64, struct{}]) ([]int64, error) {
+	defer cur.Close()
+
+	values := make([]int64, 0)
+	for cur.Next() {
+		values = append(values, cur.Key())
+	}
+
+	return values, cur.Err()
+}
+
+func _FormatCSVField(field interface{}) string {
+	switch v := field.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	}
+	return fmt.Sprint(field)
+}
+
+// _ExportKind returns the kind of a dict export and import take.
+func _ExportKind(dict IDict) (DictKind, error) {
+	switch dict.(type) {
+	case *LazyI64StrDict:
+		return DICT_KIND_I64STR, nil
+	case *LazyStrI64Dict:
+		return DICT_KIND_STRI64, nil
+	case *LazyI64BlobDict:
+		return DICT_KIND_I64BLOB, nil
+	case *LazyStrBlobDict:
+		return DICT_KIND_STRBLOB, nil
+	case *LazyI64I64SetDict:
+		return DICT_KIND_I64I64SET, nil
+	case *LazyStrI64SetDict:
+		return DICT_KIND_STRI64SET, nil
+	}
+	return DICT_KIND_UNKNOWN, fmt.Errorf("%w: export of %v", ErrNotImplemented, dict.ToString())
+}
+
+// ImportDict reads the items of r in format into dict and saves it with
+// the storage once at the end. A line that does not parse as an item of
+// the dict is skipped and its number reported, an error of the dict stops
+// the import before the save.
+func ImportDict(r io.Reader, dict IDict, format string) (*ImportReport, error) {
+
+	kind, err := _ExportKind(dict)
+	if err != nil {
+		return nil, err
+	}
+
+	report := new(ImportReport)
+
+	apply := func(rec *_ImportRecord) error {
+		report.Imported += 1
+		return _ApplyImportRecord(dict, rec)
+	}
+
+	switch format {
+	case EXPORT_JSONL:
+		err = _ImportJSONL(r, kind, report, apply)
+	case EXPORT_CSV:
+		err = _ImportCSV(r, kind, report, apply)
+	default:
+		err = fmt.Errorf("%w: import format %q", ErrNotImplemented, format)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	return report, dict.Save(true)
+}
+
+func _ImportJSONL(r io.Reader, kind DictKind, report *ImportReport, apply func(rec *_ImportRecord) error) error {
+
+	br := bufio.NewReader(r)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		report.Lines += 1
+
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			rec, parseErr := _ParseJSONRecord(kind, line)
+			if parseErr != nil {
+				report.Malformed = append(report.Malformed, report.Lines)
+			} else {
+				applyErr := apply(rec)
+				if applyErr != nil {
+					return fmt.Errorf("line %v: %w", report.Lines, applyErr)
+				}
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func _ParseJSONRecord(kind DictKind, line []byte) (*_ImportRecord, error) {
+
+	var raw struct {
+		Key json.RawMessage `json:"key"`
+		Value json.RawMessage `json:"value"`
+	}
+	err := json.Unmarshal(line, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if raw.Key == nil || raw.Value == nil || string(raw.Key) == "null" || string(raw.Value) == "null" {
+		return nil, fmt.Errorf("missing key or value")
+	}
+
+	rec := new(_ImportRecord)
+
+	switch kind {
+	case DICT_KIND_I64STR, DICT_KIND_I64BLOB, DICT_KIND_I64I64SET:
+		err = json.Unmarshal(raw.Key, &rec.intKey)
+	default:
+		err = json.Unmarshal(raw.Key, &rec.strKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case DICT_KIND_I64STR:
+		err = json.Unmarshal(raw.Value, &rec.strValue)
+	case DICT_KIND_STRI64:
+		rec.intValues = make([]int64, 1)
+		err = json.Unmarshal(raw.Value, &rec.intValues[0])
+	case DICT_KIND_I64BLOB, DICT_KIND_STRBLOB:
+		err = json.Unmarshal(raw.Value, &rec.blob)
+	case DICT_KIND_I64I64SET, DICT_KIND_STRI64SET:
+		err = json.Unmarshal(raw.Value, &rec.intValues)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+func _ImportCSV(r io.Reader, kind DictKind, report *ImportReport, apply func(rec *_ImportRecord) error) error {
+
+	// a quoted field can hold line breaks, the lines are counted as read
+	lr := &_LineCountReader{r: r}
+	rd := csv.NewReader(lr)
+	rd.FieldsPerRecord = -1
+	rd.ReuseRecord = true
+
+	for {
+		fields, err := rd.Read()
+		if err == io.EOF {
+			report.Lines = lr._Lines()
+			return nil
+		}
+
+		line, _ := rd.FieldPos(0)
+		parseErr, isParseErr := err.(*csv.ParseError)
+		if isParseErr {
+			line = parseErr.StartLine
+		} else if err != nil {
+			return err
+		}
+
+		// the header is not an item
+		if err == nil && line == 1 && len(fields) == 2 && fields[0] == "key" && fields[1] == "value" {
+			continue
+		}
+
+		var rec *_ImportRecord
+		if err == nil {
+			rec, err = _ParseCSVRecord(kind, fields)
+		}
+		if err != nil {
+			report.Malformed = append(report.Malformed, line)
+			continue
+		}
+
+		err = apply(rec)
+		if err != nil {
+			return fmt.Errorf("line %v: %w", line, err)
+		}
+	}
+}
+
+type _LineCountReader struct {
+	r io.Reader
+	newlines int
+	last byte
+}
+
+func (lr *_LineCountReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		lr.newlines += bytes.Count(p[:n], []byte{'\n'})
+		lr.last = p[n - 1]
+	}
+	return n, err
+}
+
+// _Lines counts a last line without a line break too.
+func (lr *_LineCountReader) _Lines() int {
+	if lr.last != 0 && lr.last != '\n' {
+		return lr.newlines + 1
+	}
+	return lr.newlines
+}
+
+func _ParseCSVRecord(kind DictKind, fields []string) (*_ImportRecord, error) {
+
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%v fields", len(fields))
+	}
+
+	rec := new(_ImportRecord)
+	var err error
+
+	switch kind {
+	case DICT_KIND_I64STR, DICT_KIND_I64BLOB, DICT_KIND_I64I64SET:
+		rec.intKey, err = strconv.ParseInt(fields[0], 10, 64)
+	default:
+		rec.strKey = fields[0]
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case DICT_KIND_I64STR:
+		rec.strValue = fields[1]
+	case DICT_KIND_I64BLOB, DICT_KIND_STRBLOB:
+		rec.blob, err = base64.StdEncoding.DecodeString(fields[1])
+	default:
+		rec.intValues = make([]int64, 1)
+		rec.intValues[0], err = strconv.ParseInt(fields[1], 10, 64)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+func _ApplyImportRecord(dict IDict, rec *_ImportRecord) error {
+
+	switch d := dict.(type) {
+	case *LazyI64StrDict:
+		return d.Set(rec.intKey, rec.strValue)
+	case *LazyStrI64Dict:
+		return d.Set(rec.strKey, rec.intValues[0])
+	case *LazyI64BlobDict:
+		return d.Set(rec.intKey, rec.blob)
+	case *LazyStrBlobDict:
+		return d.Set(rec.strKey, rec.blob)
+	case *LazyI64I64SetDict:
+		for _, v := range rec.intValues {
+			err := d.Add(rec.intKey, v)
+			if err != nil {
+				return err
+			}
+		}
+	case *LazyStrI64SetDict:
+		for _, v := range rec.intValues {
+			err := d.Add(rec.strKey, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -2,9 +2,8 @@ package gokvdb
 
 
 import (
-	"os"
 	"fmt"
-
+	"errors"
 )
 
 
@@ -17,7 +16,7 @@ type FreePageList struct {
 
 
 
-func _NewFreePageList(pager IPager, rootPageId uint32, isDeubg bool) *FreePageList {
+func _NewFreePageList(pager IPager, rootPageId uint32, isDeubg bool) (*FreePageList, error) {
 
 	list := new(FreePageList)
 	list.pager = pager
@@ -30,18 +29,23 @@ func _NewFreePageList(pager IPager, rootPageId uint32, isDeubg bool) *FreePageLi
 	} else {
 
 		data, err := _FreeListReadPayloadData(pager, rootPageId)
+		if err != nil {
+			return nil, err
+		}
+
+		rd := NewDataStreamFromBuffer(data)
+		rowsCount := int(rd.ReadUInt32())
+		if len(data) < 4 + rowsCount * 4 {
+			return nil, _CorruptPageError("FreePageList", rootPageId, "rows=%v bytes=%v", rowsCount, len(data))
+		}
 
-		if err == nil {
-			rd := NewDataStreamFromBuffer(data)
-			rowsCount := int(rd.ReadUInt32())
-			for i:=0; i<rowsCount; i++ {
-				pid := rd.ReadUInt32()
-				list.pageIdSet[pid] = 1
+		for i:=0; i<rowsCount; i++ {
+			pid := rd.ReadUInt32()
+			list.pageIdSet[pid] = 1
 
-				if isDeubg {
+			if isDeubg {
 
-					fmt.Println("READ FREE PAGE", pid)
-				}
+				fmt.Println("READ FREE PAGE", pid)
 			}
 		}
 
@@ -49,7 +53,7 @@ func _NewFreePageList(pager IPager, rootPageId uint32, isDeubg bool) *FreePageLi
 
 	list.rootPageId = rootPageId	
 
-	return list
+	return list, nil
 }
 
 func (fl *FreePageList) Put(pid uint32) {
@@ -68,7 +72,7 @@ func (fl *FreePageList) Pop() (uint32, bool) {
 	return 0, false
 }
 
-func (fl *FreePageList) Save() {
+func (fl *FreePageList) Save() error {
 
 	w := NewDataStream()
 	w.WriteUInt32(uint32(len(fl.pageIdSet)))
@@ -78,15 +82,69 @@ func (fl *FreePageList) Save() {
 
 	//fmt.Println("FreePageList Save", len(fl.pageIdSet))
 
-	_FreeListWritePayloadData(fl.pager, fl.rootPageId, w.ToBytes())
+	return _FreeListWritePayloadData(fl.pager, fl.rootPageId, w.ToBytes())
+}
+
+
+// _Drop returns the pages of the list itself to its pager. The pages a
+// shorter save left behind stay linked after the last one and go too.
+func (fl *FreePageList) _Drop() error {
+
+	pageIds, err := _FreeListPageIds(fl.pager, fl.rootPageId)
+	if err != nil {
+		return err
+	}
 
+	for _, pid := range pageIds {
+		err = fl.pager.FreePageId(pid)
+		if err != nil {
+			return err
+		}
+	}
+
+	fl.rootPageId = 0
+	fl.pageIdSet = make(map[uint32]byte)
+
+	return nil
 }
 
+// _FreeListPageIds follows the chain of the list at rootPageId through the
+// page headers, past the last page in use to the ones a shorter save left
+// linked behind it.
+func _FreeListPageIds(pager IPager, rootPageId uint32) ([]uint32, error) {
+
+	var pageIds []uint32
+	visited := make(map[uint32]bool)
+	pid := rootPageId
+
+	for pid != 0 && !visited[pid] {
+		visited[pid] = true
+		pageIds = append(pageIds, pid)
+
+		headerBytes, err := pager.ReadPage(pid, PAYLOAD_PAGE_HEADER_SIZE)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+
+		var nextPageId uint32
+		if err == nil && len(headerBytes) == PAYLOAD_PAGE_HEADER_SIZE {
+			hdrR := NewDataStreamFromBuffer(headerBytes)
+			if hdrR.ReadUInt8() == PGTYPE_FREELIST {
+				hdrR.ReadUInt32() // contentLen
+				hdrR.ReadBool() // hasNextPage
+				nextPageId = hdrR.ReadUInt32()
+			}
+		}
+
+		pid = nextPageId
+	}
 
-func _FreeListWritePayloadData(pager IPager, pid uint32, data []byte) {
+	return pageIds, nil
+}
+
+func _FreeListWritePayloadData(pager IPager, pid uint32, data []byte) error {
 	if pid < 1 {
-		fmt.Println("WritePayloadData ERROR pid=", pid)
-		os.Exit(1)
+		return _PageError("FreeList WritePayloadData", pid, ErrInvalidPageId)
 	}
 
 	/*
@@ -136,6 +194,9 @@ func _FreeListWritePayloadData(pager IPager, pid uint32, data []byte) {
 
 		headerBytes, err := pager.ReadPage(curPageId, PAYLOAD_PAGE_HEADER_SIZE)
 		//fmt.Println("headerBytes", "curPageId", curPageId, headerBytes, err)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
 		if err == nil && len(headerBytes) == PAYLOAD_PAGE_HEADER_SIZE  {
 			hdrR := NewDataStreamFromBuffer(headerBytes)
 			pgType := hdrR.ReadUInt8() // pgType
@@ -191,18 +252,12 @@ func _FreeListWritePayloadData(pager IPager, pid uint32, data []byte) {
 			pageData = pageData[:pageSize]
 		}
 
-		testRd := NewDataStreamFromBuffer(pageData)
-			testRd.ReadUInt8()
-		testContentLen := testRd.ReadUInt32()
-
-		pager.WritePage(curPageId, pageData)
-
-		//fmt.Println("SAVE pageData", "loopCount", loopCount, "pid=", curPageId, "len", len(pageData), "testContentLen", testContentLen, len(pageContentData))
-
-		if len(pageContentData) != int(testContentLen) {
-			fmt.Println("TEST CONTENT LEN ERROR!", pageContentDataLen, testContentLen, int(testContentLen))
-			os.Exit(1)
+		err = pager.WritePage(curPageId, pageData)
+		if err != nil {
+			return err
 		}
+
+		//fmt.Println("SAVE pageData", "loopCount", loopCount, "pid=", curPageId, "len", len(pageData), "pageContentDataLen", pageContentDataLen)
 		//fmt.Println(pageData)
 		//fmt.Println("pageContentData", pageContentData)	
 		nextPageId = pageHeaderNextPageId
@@ -211,6 +266,7 @@ func _FreeListWritePayloadData(pager IPager, pid uint32, data []byte) {
 		//logF.WriteString(fmt.Sprintf("WritePayloadData loop=%v rootPid=%v curPageId=%v pageData=%v pageContentData=%v hasNextPage=%v nextPageId=%v\n", loopCount, pid, curPageId, len(pageData), len(pageContentData), hasNextPage, nextPageId))
 	}
 
+	return nil
 }
 
 func _FreeListReadPayloadData(pager IPager, pid uint32) ([]byte, error) {
@@ -233,21 +289,31 @@ func _FreeListReadPayloadData(pager IPager, pid uint32) ([]byte, error) {
 		//fmt.Println("ReadPayloadData", "rootPid", pid, "pageCount", pageCount, "readPageId", nextPageId)
 		pageData, err := pager.ReadPage(nextPageId, 0)
 		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, _CorruptPageError("FreeList ReadPayloadData", pid, "missing page %v", nextPageId)
+			}
 			return nil, err
 		}
 
+		if len(pageData) < PAYLOAD_PAGE_HEADER_SIZE {
+			return nil, _CorruptPageError("FreeList ReadPayloadData", pid, "short page %v", nextPageId)
+		}
+
 		pageDataLen = 0
 		rd := NewDataStreamFromBuffer(pageData)
 		pgType := rd.ReadUInt8()
 
 		if pgType != PGTYPE_FREELIST {
-			fmt.Println("_FreeListReadPayloadData pgType!=PGTYPE_FREELIST", pgType)
-			os.Exit(1)
+			return nil, _CorruptPageError("FreeList ReadPayloadData", pid, "page %v pgType=%v", nextPageId, pgType)
 		}
 		pageDataLen = int(rd.ReadUInt32())
 		hasNextPage = rd.ReadBool()
 		nextPayloadPageId = rd.ReadUInt32()
 
+		if pageDataLen > len(pageData) - PAYLOAD_PAGE_HEADER_SIZE {
+			return nil, _CorruptPageError("FreeList ReadPayloadData", pid, "page %v contentLen=%v", nextPageId, pageDataLen)
+		}
+
 		//fmt.Printf("Read PayloadPage rootPid=%v pid=%v pageCount=%v pageDataLen=%v hasNextPage=%v nextPid=%v pageDataLen=%v\n", pid, nextPageId, pageCount, pageDataLen, hasNextPage, nextPayloadPageId, pageDataLen)
 		
 
@@ -261,10 +327,15 @@ func _FreeListReadPayloadData(pager IPager, pid uint32) ([]byte, error) {
 		if !hasNextPage {
 			break
 		}
+
+		// a chain running past its declared length is broken or cyclic
+		if len(allBytes) > PAYLOAD_HEADER_SIZE && uint32(len(allBytes) - PAYLOAD_HEADER_SIZE) > NewDataStreamFromBuffer(allBytes).ReadUInt32() {
+			return nil, _CorruptPageError("FreeList ReadPayloadData", pid, "page %v runs past the payload length", nextPageId)
+		}
 	}
 
 	if len(allBytes) < PAYLOAD_HEADER_SIZE {
-		return nil, DBError{message: fmt.Sprintf("pid=%v bytes=%v not enough!", pid, len(allBytes))}
+		return nil, _CorruptPageError("FreeList ReadPayloadData", pid, "bytes=%v not enough", len(allBytes))
 	}
 
 	buf := NewDataStreamFromBuffer(allBytes)
@@ -274,7 +345,7 @@ func _FreeListReadPayloadData(pager IPager, pid uint32) ([]byte, error) {
 	allBytes = allBytes[PAYLOAD_HEADER_SIZE:]
 
 	if uint32(len(allBytes)) != payloadDataLenRequired {
-		return nil, DBError{message: fmt.Sprintf("bytes length needs %v payload data is %v!", payloadDataLenRequired, len(allBytes))}
+		return nil, _CorruptPageError("FreeList ReadPayloadData", pid, "bytes length needs %v payload data is %v", payloadDataLenRequired, len(allBytes))
 	}
 
 	return allBytes, nil
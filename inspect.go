@@ -0,0 +1,180 @@
+package gokvdb
+
+import (
+	"fmt"
+)
+
+// StorageInfo is what the header and the pager of a storage hold.
+type StorageInfo struct {
+	Path string
+	RootPageId uint32
+	// Epoch counts the commits of the file
+	Epoch uint64
+	PageSize uint32
+	LastPageId uint32
+	FreelistPageId uint32
+	FreePageCount int
+	FileSize int64
+	WalSize int64
+	Options Options
+}
+
+// PageInfo is the decoded header of a page. Type is PGTYPE_PAYLOAD,
+// PGTYPE_FREELIST or 0 for a page written empty, Data holds the DataLen
+// bytes after the header.
+type PageInfo struct {
+	PageId uint32
+	Type uint8
+	// Index is the position of a payload page in its chain
+	Index uint16
+	DataLen uint32
+	HasNext bool
+	NextPageId uint32
+	Checksum uint32
+	// PayloadLen is the length of the payload the first page of a chain
+	// starts
+	PayloadLen uint32
+	// Zero is a page allocated but never written
+	Zero bool
+	// Free is a page in the freelist of the pager
+	Free bool
+	Data []byte
+}
+
+// PageRole tells what a page reachable from the root holds and the dict
+// or DB it belongs to.
+type PageRole struct {
+	Role string
+	Owner string
+}
+
+func (info StorageInfo) ToString() string {
+	return fmt.Sprintf("<StorageInfo path=%v rootPageId=%v epoch=%v pageSize=%v lastPageId=%v freelistPageId=%v freePages=%v fileSize=%v walSize=%v>", info.Path, info.RootPageId, info.Epoch, info.PageSize, info.LastPageId, info.FreelistPageId, info.FreePageCount, info.FileSize, info.WalSize)
+}
+
+func (info PageInfo) ToString() string {
+	return fmt.Sprintf("<PageInfo pid=%v type=%v index=%v dataLen=%v hasNext=%v nextPageId=%v checksum=%08x payloadLen=%v zero=%v free=%v>", info.PageId, info.Type, info.Index, info.DataLen, info.HasNext, info.NextPageId, info.Checksum, info.PayloadLen, info.Zero, info.Free)
+}
+
+func (role PageRole) ToString() string {
+	return fmt.Sprintf("<PageRole role=%v owner=%v>", role.Role, role.Owner)
+}
+
+// Info returns the header fields and the pager meta of the storage as the
+// writer sees them.
+func (s *Storage) Info() (StorageInfo, error) {
+
+	pager := s.pager.(*StreamPager)
+	meta := pager.basePager.meta
+
+	info := StorageInfo{
+		Path: s.path,
+		RootPageId: s.rootPageId,
+		Epoch: pager.versions.epoch,
+		PageSize: meta.pageSize,
+		LastPageId: meta.lastPageId,
+		FreelistPageId: meta.freelistPageId,
+		FreePageCount: len(pager.freelist.pageIdSet),
+		Options: s.options,
+	}
+
+	size, err := s.stream.Size()
+	if err != nil {
+		return info, err
+	}
+	info.FileSize = size
+
+	if s.wal != nil {
+		info.WalSize = s.wal.Size()
+	}
+
+	return info, nil
+}
+
+// ReadPageInfo reads pid, verifying its checksum, and decodes its header.
+func (s *Storage) ReadPageInfo(pid uint32) (PageInfo, error) {
+
+	info := PageInfo{PageId: pid}
+
+	if pid < 1 {
+		return info, _PageError("ReadPageInfo", pid, ErrInvalidPageId)
+	}
+
+	pager := s.pager.(*StreamPager)
+
+	data, err := pager.ReadPage(pid, 0)
+	if err != nil {
+		return info, err
+	}
+
+	_, info.Free = pager.freelist.pageIdSet[pid]
+
+	if _IsZeroPage(data) {
+		info.Zero = true
+		return info, nil
+	}
+
+	err = _DecodePage("ReadPageInfo", pid, data, func(rd *DataStream) {
+		info.Type = rd.ReadUInt8()
+		switch info.Type {
+		case PGTYPE_PAYLOAD:
+			info.Index = rd.ReadUInt16()
+			info.DataLen = rd.ReadUInt32()
+			info.HasNext = rd.ReadBool()
+			info.NextPageId = rd.ReadUInt32()
+		case PGTYPE_FREELIST:
+			info.DataLen = rd.ReadUInt32()
+			info.HasNext = rd.ReadBool()
+			info.NextPageId = rd.ReadUInt32()
+		}
+		rd.Seek(PAGE_CHECKSUM_OFFSET)
+		info.Checksum = rd.ReadUInt32()
+	})
+	if err != nil {
+		return info, err
+	}
+
+	content := data[PAYLOAD_PAGE_HEADER_SIZE:]
+	if int(info.DataLen) > len(content) {
+		return info, _CorruptPageError("ReadPageInfo", pid, "dataLen=%v", info.DataLen)
+	}
+	info.Data = content[:info.DataLen]
+
+	if info.Type == PGTYPE_PAYLOAD && info.Index == 0 && len(info.Data) >= 4 {
+		info.PayloadLen = NewDataStreamFromBuffer(info.Data).ReadUInt32()
+	}
+
+	return info, nil
+}
+
+// PageRoles walks the pages reachable from the root and the freelist of
+// the pager and tells what each one holds. A page it cannot place fails
+// the walk with an ErrCorruptPage.
+func (s *Storage) PageRoles() (map[uint32]PageRole, error) {
+
+	pager := s.pager.(*StreamPager)
+	roleByPageId := make(map[uint32]PageRole)
+
+	// a storage never saved has only its root page id
+	if len(s.dbItems) > 0 {
+		g, err := _WalkPageGraph(pager, s.rootPageId)
+		if err != nil {
+			return nil, err
+		}
+		for pid, chain := range g.chainByPageId {
+			roleByPageId[pid] = PageRole{Role: chain.role, Owner: chain.owner}
+		}
+	}
+
+	if pager.basePager.meta.freelistPageId != 0 {
+		pageIds, err := _FreeListPageIds(pager, pager.basePager.meta.freelistPageId)
+		if err != nil {
+			return nil, err
+		}
+		for _, pid := range pageIds {
+			roleByPageId[pid] = PageRole{Role: PAGE_ROLE_FREELIST}
+		}
+	}
+
+	return roleByPageId, nil
+}
@@ -1,7 +1,6 @@
 package gokvdb
 
 import (
-	"os"
 	"fmt"
 	"sync"
 )
@@ -25,6 +24,24 @@ type InternalPager struct {
 	contextByPageId map[uint32]*InternalDataContext
 	payloadFactory *PayloadPageFactory
 	isChanged bool
+	// owner names the dict in page errors
+	owner string
+	cache *PageCache
+	options *Options
+	// checksums is false under a file written before checksums were added
+	checksums bool
+	// unsavedPageIds are the context pages taken since the last save, a
+	// context evicted before the save is written over them. The ones it
+	// had in the saved pager are in replacedPageIds and freed at the save.
+	unsavedPageIds map[uint32]bool
+	replacedPageIds []uint32
+	// createdPageIds are the root, freelist and branch pages taken since
+	// the last save
+	createdPageIds []uint32
+	// dropEpoch is the drop count of owner and vacuums the Vacuum count
+	// of the storage when the pager was opened
+	dropEpoch uint64
+	vacuums uint64
 	rwlock sync.Mutex
 }
 
@@ -38,12 +55,15 @@ type InternalDataContext struct {
 	pid uint32
 	dataByPageId map[uint32][]byte
 	isChanged bool
+	pager *InternalPager
+	cacheEntry *PageCacheEntry
 }
 
-func NewInternalPager(pager IPager, pageSize uint16, meta []byte) IPager {
+func NewInternalPager(pager IPager, pageSize uint16, meta []byte, owner string) (IPager, error) {
 
 	ip := new(InternalPager)
 	ip.pager = pager
+	ip.owner = owner
 	ip.root = make(map[uint32]uint32)
 	ip.pageSize = pageSize
 	ip.lastPageId = 0
@@ -51,6 +71,12 @@ func NewInternalPager(pager IPager, pageSize uint16, meta []byte) IPager {
 	ip.freelistPageId = 0
 	ip.branchPages = make(map[uint32]*InternalBranchPage)
 	ip.contextByPageId = make(map[uint32]*InternalDataContext)
+	ip.unsavedPageIds = make(map[uint32]bool)
+	ip.cache = _PageCacheOf(pager)
+	ip.options = _OptionsOf(pager)
+	ip.checksums = _ChecksumsOf(pager)
+	ip.dropEpoch = ip.cache._DropEpoch(owner)
+	ip.vacuums = ip.cache._Vacuums()
 	ip.isChanged = false
 
 	
@@ -71,11 +97,15 @@ func NewInternalPager(pager IPager, pageSize uint16, meta []byte) IPager {
 		rootPageId := pager.CreatePageId()
 		ip.rootPageId = rootPageId
 		ip.isChanged = true
+		ip._AddCreatedPageId(rootPageId)
 	} else {
 
 		rootData, err := pager.ReadPayloadData(ip.rootPageId)
-		if err == nil {
-			rootRd := NewDataStreamFromBuffer(rootData)			
+		if err != nil {
+			return nil, _OwnedError(err, owner)
+		}
+
+		err = _DecodePage("InternalPager root", ip.rootPageId, rootData, func(rootRd *DataStream) {
 			var branchRootKey uint32
 			var branchPageId uint32
 
@@ -90,23 +120,32 @@ func NewInternalPager(pager IPager, pageSize uint16, meta []byte) IPager {
 				//fmt.Println("ROOT ITEM", "branchRootKey=", branchRootKey, "branchPageId=", branchPageId)			
 			}
 			//fmt.Println("INTERNAL ROOT", len(ip.root))
+		})
+		if err != nil {
+			return nil, _OwnedError(err, owner)
 		}
 	}
 
 	
 
-	freelist := _NewFreePageList(pager, ip.freelistPageId, false)
+	freelist, err := _NewFreePageList(pager, ip.freelistPageId, false)
+	if err != nil {
+		return nil, _OwnedError(err, owner)
+	}
+	if ip.freelistPageId == 0 {
+		ip._AddCreatedPageId(freelist.rootPageId)
+	}
 	ip.freelist = freelist
 	ip.freelistPageId = freelist.rootPageId
 	ip.payloadFactory = NewPayloadPageFactory(ip)
 	ip.payloadFactory.isDebug = false
 
 
-	return ip
+	return ip, nil
 }
 
 func (p *InternalPager) ToString() string {
-	return fmt.Sprintf("<InternalPager pageSize=%v lastPageId=%v rootPageId=%v>", p.pageSize, p.lastPageId, p.rootPageId)
+	return fmt.Sprintf("<InternalPager owner=%v pageSize=%v lastPageId=%v rootPageId=%v>", p.owner, p.pageSize, p.lastPageId, p.rootPageId)
 }
 
 func (p *InternalPager) CreatePageId() uint32 {
@@ -124,14 +163,22 @@ func (p *InternalPager) CreatePageId() uint32 {
 	return pid
 }
 
-func (p *InternalPager) FreePageId(pid uint32) {
+func (p *InternalPager) FreePageId(pid uint32) error {
 
 	//fmt.Println(">>>>>>> InternalPager FreePageId", pid)
 
+	if pid < 1 {
+		return _PageError("InternalPager FreePageId", pid, ErrInvalidPageId)
+	}
+
 	empty := make([]byte, INTERNAL_PAGE_HEADER_SIZE)
 
-	p.WritePage(pid, empty)
+	err := p.WritePage(pid, empty)
+	if err != nil {
+		return err
+	}
 	p.freelist.Put(pid)
+	return nil
 }
 
 func (p *InternalPager) GetPageSize() int {
@@ -145,17 +192,35 @@ func (p *InternalPager) _GetBranchKeys(pid uint32) (uint32, uint32) {
 	return branchRootKey, branchKey
 }
 
-func (p *InternalPager) WritePayloadData(pid uint32, data []byte) {
+func (p *InternalPager) WritePayloadData(pid uint32, data []byte) error {
 	//fmt.Println("InternalPager WritePayloadData", pid)
-	p.payloadFactory.WritePayloadData(pid, data)
+	err := _CheckDropped(p)
+	if err != nil {
+		return err
+	}
+	return _OwnedError(p.payloadFactory.WritePayloadData(pid, data), p.owner)
 }
 
 func (p *InternalPager) ReadPayloadData(pid uint32) ([]byte, error) {
-	return p.payloadFactory.ReadPayloadData(pid)
+	err := _CheckDropped(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.payloadFactory.ReadPayloadData(pid)
+	return data, _OwnedError(err, p.owner)
+}
+
+func (p *InternalPager) FreePayloadData(pid uint32) error {
+	return _OwnedError(p.payloadFactory.FreePayloadData(pid), p.owner)
 }
 
 
 func (p *InternalPager) ReadPage(pid uint32, count int) ([]byte, error) {
+	data, err := p._ReadPage(pid, count)
+	return data, _OwnedError(err, p.owner)
+}
+
+func (p *InternalPager) _ReadPage(pid uint32, count int) ([]byte, error) {
 
 	p.rwlock.Lock()
 	defer p.rwlock.Unlock()
@@ -167,19 +232,28 @@ func (p *InternalPager) ReadPage(pid uint32, count int) ([]byte, error) {
 	//fmt.Printf("ReadPage pid=%v branchPageId=%v ok=%v\n", pid, branchPageId, ok)
 	if ok {
 
-		branchPage := p._GetBranchPageByPageId(branchPageId)
+		branchPage, err := p._GetBranchPageByPageId(branchPageId)
+		if err != nil {
+			return nil, err
+		}
 
 		contextPageId, ok := branchPage.contextPageIdByBranchKey[branchKey]
 		//fmt.Printf("ReadPage pid=%v branchPageId=%v ok=%v contextPageId=%v\n", pid, branchPageId, ok, contextPageId)
 		if ok {
-			context, ok := p.contextByPageId[contextPageId]
-			if !ok {
-				context = p._ReadDataContext(contextPageId)
-				p.contextByPageId[context.pid] = context			
+			context, err := p._GetDataContext(contextPageId)
+			if err != nil {
+				return nil, err
 			}
 
 			data, ok := context.dataByPageId[pid]
 			if ok {
+				if p.checksums {
+					err = _VerifyChecksum("InternalPager ReadPage", pid, data, PAGE_CHECKSUM_OFFSET)
+					if err != nil {
+						return nil, err
+					}
+				}
+
 				_count := count
 				if _count < 1 {
 					_count = int(p.pageSize)
@@ -192,31 +266,44 @@ func (p *InternalPager) ReadPage(pid uint32, count int) ([]byte, error) {
 		
 	}
 
-	return nil, DBError{message: "no data"}
+	return nil, _PageError("InternalPager ReadPage", pid, ErrNotFound)
 }
 
-func (p *InternalPager) WritePage(pid uint32, data []byte) {
+func (p *InternalPager) WritePage(pid uint32, data []byte) error {
+	return _OwnedError(p._WritePage(pid, data), p.owner)
+}
+
+func (p *InternalPager) _WritePage(pid uint32, data []byte) error {
 	p.rwlock.Lock()
 	defer p.rwlock.Unlock()
 
-	var branchPage *InternalBranchPage
-	var context *InternalDataContext
+	if len(data) > int(p.pageSize) {
+		return _PageError("InternalPager WritePage", pid, fmt.Errorf("%w: %v bytes, page size is %v", ErrPageTooLarge, len(data), p.pageSize))
+	}
 
 	//branchRootKey, branchKey := p._GetBranchKeys(pid)
 	branchRootKey, branchKey := p._GetBranchKeys(pid)
 
-	branchPage = p._GetOrCreateBranchPageByKey(branchRootKey)
+	branchPage, err := p._GetOrCreateBranchPageByKey(branchRootKey)
+	if err != nil {
+		return err
+	}
+
+	var context *InternalDataContext
 	
 	contextPageId, ok := branchPage.contextPageIdByBranchKey[branchKey]
 	if !ok {
 		contextPageId = p.pager.CreatePageId()
 		branchPage.contextPageIdByBranchKey[branchKey] = contextPageId
 		branchPage.isChanged = true
+		p.unsavedPageIds[contextPageId] = true
+		p.cache._SetUnsaved(p, true)
 
-		context = _NewInternalDataContext(contextPageId)
+		context = p._NewDataContext(contextPageId)
 		context.isChanged = true		
 
 		p.contextByPageId[contextPageId] = context
+		context.cacheEntry = p.cache._Add(context, 0, false)
 
 		/*
 		fmt.Println("---------------------------------")
@@ -226,18 +313,61 @@ func (p *InternalPager) WritePage(pid uint32, data []byte) {
 		*/
 	} 
 
-	context, ok = p.contextByPageId[contextPageId]
-	if !ok {
-		context = p._ReadDataContext(contextPageId)
-		p.contextByPageId[context.pid] = context	
+	if context == nil {
+		context, err = p._GetDataContext(contextPageId)
+		if err != nil {
+			return err
+		}
+	}
+
+	pageLen := len(data)
+	if pageLen < INTERNAL_PAGE_HEADER_SIZE {
+		pageLen = INTERNAL_PAGE_HEADER_SIZE
+	}
+
+	pageData := make([]byte, pageLen)
+	copy(pageData, data)
+	_StampChecksum(pageData, PAGE_CHECKSUM_OFFSET)
+
+	oldData, ok := context.dataByPageId[pid]
+	if ok {
+		p.cache._Resize(context.cacheEntry, len(pageData) - len(oldData))
+	} else {
+		p.cache._Resize(context.cacheEntry, len(pageData) + PAGE_CACHE_ROW_OVERHEAD)
 	}
 
-	context.dataByPageId[pid] = data
+	context.dataByPageId[pid] = pageData
 	context.isChanged = true
+
+	return nil
 }
 
+func (p *InternalPager) _GetDataContext(pid uint32) (*InternalDataContext, error) {
+
+	context, ok := p.contextByPageId[pid]
+	if ok {
+		p.cache._Touch(context.cacheEntry)
+		return context, nil
+	}
+
+	context, err := p._ReadDataContext(pid)
+	if err != nil {
+		return nil, err
+	}
 
-func (p *InternalPager) _GetOrCreateBranchPageByKey(branchRootKey uint32) *InternalBranchPage {
+	size := 0
+	for _, pageData := range context.dataByPageId {
+		size += len(pageData) + PAGE_CACHE_ROW_OVERHEAD
+	}
+
+	p.contextByPageId[pid] = context
+	context.cacheEntry = p.cache._Add(context, size, true)
+
+	return context, nil
+}
+
+
+func (p *InternalPager) _GetOrCreateBranchPageByKey(branchRootKey uint32) (*InternalBranchPage, error) {
 
 	branchPageId, ok := p.root[branchRootKey]
 	if !ok {		
@@ -245,6 +375,7 @@ func (p *InternalPager) _GetOrCreateBranchPageByKey(branchRootKey uint32) *Inter
 		branchPageId = p.pager.CreatePageId()
 		p.root[branchRootKey] = branchPageId
 		p.isChanged = true
+		p._AddCreatedPageId(branchPageId)
 
 		branchPage := _NewInternalBranchPage(branchPageId)		
 		branchPage.isChanged = true
@@ -257,17 +388,19 @@ func (p *InternalPager) _GetOrCreateBranchPageByKey(branchRootKey uint32) *Inter
 
 
 
-func (p *InternalPager) _GetBranchPageByPageId(pid uint32) *InternalBranchPage {
+func (p *InternalPager) _GetBranchPageByPageId(pid uint32) (*InternalBranchPage, error) {
 
 	branchPage, ok := p.branchPages[pid]
 
 	if !ok {
 		branchPage = _NewInternalBranchPage(pid)
 
-		data, ok := p.pager.ReadPayloadData(pid)
+		data, err := p.pager.ReadPayloadData(pid)
+		if err != nil {
+			return nil, err
+		}
 
-		if ok == nil {
-			rd := NewDataStreamFromBuffer(data)
+		err = _DecodePage("InternalBranchPage", pid, data, func(rd *DataStream) {
 			var branchKey uint32
 			var contextPageId uint32
 			rowsCount := int(rd.ReadUInt32())
@@ -276,19 +409,39 @@ func (p *InternalPager) _GetBranchPageByPageId(pid uint32) *InternalBranchPage {
 				contextPageId = rd.ReadUInt32()
 				branchPage.contextPageIdByBranchKey[branchKey] = contextPageId
 			}
+		})
+		if err != nil {
+			return nil, err
 		}
 
 		p.branchPages[pid] = branchPage
 	}
 
-	return branchPage
+	return branchPage, nil
+}
+
+// Save writes the pager and trims the cache of the contexts it touched.
+func (p *InternalPager) Save() (_ []byte, err error) {
+	err = _CheckDropped(p)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cache._Enter()
+	defer p.cache._Leave(&err)
+
+	meta, err := p._Save()
+	return meta, _OwnedError(err, p.owner)
 }
 
-func (p *InternalPager) Save() []byte {
+func (p *InternalPager) _Save() ([]byte, error) {
 
-	p.freelist.Save()
+	err := p.freelist.Save()
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Println("[InternalPager SAVE] freelist", len(p.freelist.pageIdSet))
+	//fmt.Println("[InternalPager SAVE] freelist", len(p.freelist.pageIdSet))
 	
 	for _, branchPage := range p.branchPages {
 		if true {
@@ -303,7 +456,10 @@ func (p *InternalPager) Save() []byte {
 				w.WriteUInt32(branchKey)
 				w.WriteUInt32(ctxPageId)
 			}
-			p.pager.WritePayloadData(branchPage.pid, w.ToBytes())
+			err = p.pager.WritePayloadData(branchPage.pid, w.ToBytes())
+			if err != nil {
+				return nil, err
+			}
 
 			branchPage.isChanged = false
 		}
@@ -316,22 +472,30 @@ func (p *InternalPager) Save() []byte {
 	for _, context := range p.contextByPageId {
 		if true {
 		//if context.isChanged {
-			w := NewDataStream()
-
-			w.WriteUInt32(uint32(len(context.dataByPageId)))
-			for pageId, pageData := range context.dataByPageId {
-				w.WriteUInt32(pageId)
-				w.WriteChunk(pageData)
-			}
-			payload := w.ToBytes()
+			payload := context._Encode()
 	//fmt.Println("--------------------------------", context.ToString())
 			//fmt.Println("[SAVE CONTEXT]", "pid", ctxPid, "bytes", len(payload), "rows", len(context.dataByPageId))
-			p.pager.WritePayloadData(context.pid, payload)
+			err = p.pager.WritePayloadData(context.pid, payload)
+			if err != nil {
+				return nil, err
+			}
 
 			context.isChanged = false
 		}
 	}
 
+	// the saved pager no longer points at them
+	for _, pid := range p.replacedPageIds {
+		err = p.pager.FreePayloadData(pid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.replacedPageIds = nil
+	p.unsavedPageIds = make(map[uint32]bool)
+	p.createdPageIds = nil
+	p.cache._SetUnsaved(p, false)
+
 	if true {
 	//if p.isChanged {
 		w := NewDataStream()
@@ -342,7 +506,10 @@ func (p *InternalPager) Save() []byte {
 			w.WriteUInt32(bpid)
 		}
 
-		p.pager.WritePayloadData(p.rootPageId, w.ToBytes())
+		err = p.pager.WritePayloadData(p.rootPageId, w.ToBytes())
+		if err != nil {
+			return nil, err
+		}
 
 		p.isChanged = false
 	}
@@ -354,14 +521,119 @@ func (p *InternalPager) Save() []byte {
 	metaW.WriteUInt32(p.rootPageId)
 	metaW.WriteUInt32(p.freelistPageId)
 
-	return metaW.ToBytes()
+	return metaW.ToBytes(), nil
+}
+
+
+// _Drop returns every page the pager holds in its parent to the parent
+// freelist, its root, branch and context pages and its freelist. The
+// trees a dict keeps in the pager, BranchI64BTreeFactory pages among them,
+// live in those context pages. The pager must not be used afterwards.
+func (p *InternalPager) _Drop() error {
+	err := p._DropPages()
+	return _OwnedError(err, p.owner)
+}
+
+func (p *InternalPager) _DropPages() error {
+
+	p.rwlock.Lock()
+	defer p.rwlock.Unlock()
+
+	for _, branchPageId := range p.root {
+
+		branchPage, err := p._GetBranchPageByPageId(branchPageId)
+		if err != nil {
+			return err
+		}
+
+		for _, contextPageId := range branchPage.contextPageIdByBranchKey {
+			err = p.pager.FreePayloadData(contextPageId)
+			if err != nil {
+				return err
+			}
+			context, ok := p.contextByPageId[contextPageId]
+			if ok {
+				p.cache._Remove(context.cacheEntry)
+				delete(p.contextByPageId, contextPageId)
+			}
+		}
+
+		err = p.pager.FreePayloadData(branchPageId)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, pid := range p.replacedPageIds {
+		err := p.pager.FreePayloadData(pid)
+		if err != nil {
+			return err
+		}
+	}
+	p.replacedPageIds = nil
+	p.unsavedPageIds = make(map[uint32]bool)
+	p.createdPageIds = nil
+	p.cache._SetUnsaved(p, false)
+
+	err := p.pager.FreePayloadData(p.rootPageId)
+	if err != nil {
+		return err
+	}
+
+	err = p.freelist._Drop()
+	if err != nil {
+		return err
+	}
+
+	p.root = make(map[uint32]uint32)
+	p.branchPages = make(map[uint32]*InternalBranchPage)
+	p.rootPageId = 0
+	p.freelistPageId = 0
+
+	return nil
 }
 
 
+func (p *InternalPager) _AddCreatedPageId(pid uint32) {
+	p.createdPageIds = append(p.createdPageIds, pid)
+	p.cache._SetUnsaved(p, true)
+}
+
+// _FreeUnsaved gives back the pages taken since the last save once the
+// dict is dropped, the drop itself frees the saved ones.
+func (p *InternalPager) _FreeUnsaved() error {
+
+	p.rwlock.Lock()
+	defer p.rwlock.Unlock()
+
+	for _, pid := range p.createdPageIds {
+		err := p.pager.FreePayloadData(pid)
+		if err != nil {
+			return _OwnedError(err, p.owner)
+		}
+	}
+
+	for pid, _ := range p.unsavedPageIds {
+		err := p.pager.FreePayloadData(pid)
+		if err != nil {
+			return _OwnedError(err, p.owner)
+		}
+	}
+
+	p.createdPageIds = nil
+	p.unsavedPageIds = make(map[uint32]bool)
+	p.replacedPageIds = nil
+	p.root = make(map[uint32]uint32)
+	p.branchPages = make(map[uint32]*InternalBranchPage)
+	p.contextByPageId = make(map[uint32]*InternalDataContext)
+
+	return nil
+}
 
-func _NewInternalDataContext(pid uint32) *InternalDataContext {
+func (p *InternalPager) _NewDataContext(pid uint32) *InternalDataContext {
 	context := new(InternalDataContext)
 	context.pid = pid
+	context.pager = p
 	context.dataByPageId = make(map[uint32][]byte)
 	context.isChanged = true
 
@@ -385,7 +657,93 @@ func (ctx *InternalDataContext) ToString() string {
 	return fmt.Sprintf("<InternalDataContext pid=%v>", ctx.pid)
 }
 
-func (p *InternalPager) _ReadDataContext(pid uint32) *InternalDataContext {
+func (ctx *InternalDataContext) _Owner() string {
+	return ctx.pager.owner
+}
+
+func (ctx *InternalDataContext) _IsChanged() bool {
+	return ctx.isChanged
+}
+
+// _WriteBack writes a changed context to the main pager so it can be
+// dropped and read again. A context of the saved pager moves to a new page
+// first, the file never holds a half saved dict.
+func (ctx *InternalDataContext) _WriteBack() (bool, error) {
+	p := ctx.pager
+
+	p.rwlock.Lock()
+	defer p.rwlock.Unlock()
+
+	ok, err := p._WriteBackContext(ctx)
+	return ok, _OwnedError(err, p.owner)
+}
+
+func (p *InternalPager) _WriteBackContext(ctx *InternalDataContext) (bool, error) {
+
+	if !p.unsavedPageIds[ctx.pid] {
+
+		// the pages of a context share its branch key
+		var branchPage *InternalBranchPage
+		var branchKey uint32
+		for pid, _ := range ctx.dataByPageId {
+			branchRootKey, _branchKey := p._GetBranchKeys(pid)
+			branchPageId, ok := p.root[branchRootKey]
+			if !ok {
+				return false, _CorruptPageError("InternalPager WriteBack", ctx.pid, "no branch for page %v", pid)
+			}
+			var err error
+			branchPage, err = p._GetBranchPageByPageId(branchPageId)
+			if err != nil {
+				return false, err
+			}
+			branchKey = _branchKey
+			break
+		}
+		if branchPage == nil {
+			return false, nil
+		}
+
+		newPageId := p.pager.CreatePageId()
+		branchPage.contextPageIdByBranchKey[branchKey] = newPageId
+		branchPage.isChanged = true
+
+		p.replacedPageIds = append(p.replacedPageIds, ctx.pid)
+		delete(p.contextByPageId, ctx.pid)
+
+		ctx.pid = newPageId
+		p.contextByPageId[newPageId] = ctx
+		p.unsavedPageIds[newPageId] = true
+		p.cache._SetUnsaved(p, true)
+	}
+
+	err := p.pager.WritePayloadData(ctx.pid, ctx._Encode())
+	if err != nil {
+		return false, err
+	}
+	ctx.isChanged = false
+
+	return true, nil
+}
+
+func (ctx *InternalDataContext) _Encode() []byte {
+	w := NewDataStream()
+
+	w.WriteUInt32(uint32(len(ctx.dataByPageId)))
+	for pageId, pageData := range ctx.dataByPageId {
+		w.WriteUInt32(pageId)
+		w.WriteChunk(pageData)
+	}
+
+	return w.ToBytes()
+}
+
+func (ctx *InternalDataContext) _Release() {
+	ctx.pager.rwlock.Lock()
+	defer ctx.pager.rwlock.Unlock()
+	delete(ctx.pager.contextByPageId, ctx.pid)
+}
+
+func (p *InternalPager) _ReadDataContext(pid uint32) (*InternalDataContext, error) {
 
 	//fmt.Println("")
 	//fmt.Println("----------------------------------")
@@ -393,14 +751,13 @@ func (p *InternalPager) _ReadDataContext(pid uint32) *InternalDataContext {
 	contextPageData, err := p.pager.ReadPayloadData(pid)
 	//fmt.Println("_ReadDataContext >>>>>>>>---- pid=", pid, "err", err, len(contextPageData))
 	if err != nil {
-		fmt.Println("err != nil", err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	context := _NewInternalDataContext(pid)
+	context := p._NewDataContext(pid)
+	context.isChanged = false
 
-	if err == nil {
-		rd := NewDataStreamFromBuffer(contextPageData)
+	err = _DecodePage("InternalDataContext", pid, contextPageData, func(rd *DataStream) {
 		rowsCount := int(rd.ReadUInt32())
 		
 		for i:=0; i<rowsCount; i++ {
@@ -410,6 +767,10 @@ func (p *InternalPager) _ReadDataContext(pid uint32) *InternalDataContext {
 
 			//fmt.Println("[_ReadDataContext]", "_pid", _pid, "bytes", len(_data))
 		}			
+	})
+	if err != nil {
+		return nil, err
 	}
-	return context
+
+	return context, nil
 }
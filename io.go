@@ -238,15 +238,17 @@ func _PackPgMeta(m PgMeta) []byte {
 	return buf.ToBytes()
 }*/
 
-func _Pack2Bytes(obj interface{}) []byte {
+func _Pack2Bytes(obj interface{}) ([]byte, error) {
 
 	var buf bytes.Buffer
 
 	enc := gob.NewEncoder(&buf)
 	err := enc.Encode(obj)
-	_CheckErr("_Pack2Bytes", err)
+	if err != nil {
+		return nil, err
+	}
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 /*
 func _UnpackBytes(data []byte, unpackType interface{}) interface{} {
@@ -272,7 +274,7 @@ func _UnpackBytes(data []byte, unpackType interface{}) interface{} {
 			return dict
 			
 		case map[uint64]uint64:
-			fmt.Println("_UnpackBytes map[uint64]uint64")
+			//fmt.Println("_UnpackBytes map[uint64]uint64")
 			var dict map[uint64]uint64
 			err = dec.Decode(&dict)
 			_CheckErr("_UnpackBytes map[uint64]uint64", err)
@@ -345,6 +347,11 @@ func (ds *DataStream) Seek(offset int) {
 	ds.offset = offset
 }
 
+// Remaining returns the bytes left to read after the offset.
+func (ds *DataStream) Remaining() int {
+	return len(ds.buf) - ds.offset
+}
+
 func (ds *DataStream) Read(count int) []byte {
 
 	var value = make([]byte, count)
@@ -413,7 +420,7 @@ func (ds *DataStream) _CheckSize(size int) {
 
 	if (ds.offset + size) > len(ds.buf) {
 		if ds.isFixed {
-			fmt.Println(fmt.Sprintf("DataStream is over fixed length %v", len(ds.buf)))
+			//fmt.Println(fmt.Sprintf("DataStream is over fixed length %v", len(ds.buf)))
 			return
 		}
 		appendSize := 4096
@@ -502,10 +509,12 @@ func (ds *DataStream) WriteUInt64(value uint64) {
 	ds.Write(data)
 }
 
+// WriteHStr writes a u16 length, a longer string is cut to
+// MAX_STR_KEY_SIZE bytes. The dicts refuse such keys before they get here.
 func (ds *DataStream) WriteHStr(value string) {
 	data := []byte(value)
-	if len(data) > 65536 {
-		data = data[:65536] 
+	if len(data) > MAX_STR_KEY_SIZE {
+		data = data[:MAX_STR_KEY_SIZE]
 	}
 
 	dataLen := uint16(len(data))
@@ -14,6 +14,12 @@ func (self I64Array) Len() int { return len(self) }
 func (self I64Array) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
 func (self I64Array) Less(i, j int) bool { return self[i] < self[j] }
 
+type U32Array []uint32
+
+func (self U32Array) Len() int { return len(self) }
+func (self U32Array) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self U32Array) Less(i, j int) bool { return self[i] < self[j] }
+
 
 
 
@@ -23,6 +29,7 @@ type LazyI64Set struct {
 	pager IPager
 	treeFactory *BranchI64BTreeFactory
 	contextByPageId map[uint32]*LazyI64SetContext
+	cache *PageCache
 }
 
 type LazyI64SetContext struct {
@@ -30,8 +37,15 @@ type LazyI64SetContext struct {
 	branchKey int64
 	data map[int64]byte
 	isChanged bool
+	set *LazyI64Set
+	cacheEntry *PageCacheEntry
 }
 
+const (
+	// cache cost of a member
+	LAZY_I64SET_ROW_SIZE = 9 + PAGE_CACHE_ROW_OVERHEAD
+)
+
 func (self *LazyI64Set) ToString() string {
 	return fmt.Sprintf("<LazyI64Set %v>", self.treeFactory.ToString())
 }
@@ -43,121 +57,295 @@ func (self *LazyI64SetContext) ToString() string {
 
 
 
-func (self *LazyI64Set) Save() []byte {
+func (self *LazyI64Set) Save() ([]byte, error) {
 
 	//fmt.Println("SAVE...", self.ToString())
 
-	for pid, ctx := range self.contextByPageId {
+	for _, ctx := range self.contextByPageId {
 		if ctx.isChanged {
-			ctx.isChanged = false
-
-			ctxW := NewDataStream()
-			ctxW.WriteUInt24(uint32(len(ctx.data)))
-			for v, _ := range ctx.data {
-				ctxW.WriteUInt64(uint64(v))
+			_, err := ctx._WriteBack()
+			if err != nil {
+				return nil, err
 			}
-			ctxData := ctxW.ToBytes()
-			self.pager.WritePayloadData(pid, ctxData)
-
-			//fmt.Println("SAVE CTX pid", pid, "bytes", len(ctxData))
 		}
 	}
 
 
-	treeMeta := self.treeFactory.Save()
+	treeMeta, err := self.treeFactory.Save()
+	if err != nil {
+		return nil, err
+	}
 
 	metaW := NewDataStream()
 	metaW.WriteChunk(treeMeta)
 
-	return metaW.ToBytes()
+	return metaW.ToBytes(), nil
 }
 
 
-func (self *LazyI64Set) Values() chan int64 {
-	q := make(chan int64)
+// Values walks the set in order. The members are the cursor keys.
+func (self *LazyI64Set) Values() *Cursor[int64, struct{}] {
+	return _NewCursor(self._Each)
+}
 
-	go func(ch chan int64) {
+func (self *LazyI64Set) _Each(yield func(value int64, _ struct{}) bool) error {
+
+	var branchKeys []int64
+	var pageIds []uint32
+	err := self.treeFactory._EachItems(func(key int64, value int64) bool {
+		branchKeys = append(branchKeys, key)
+		pageIds = append(pageIds, uint32(value))
+		return true
+	})
+	if err != nil {
+		return err
+	}
 
-		for item := range self.treeFactory.Items() {
+	for i, pageId := range pageIds {
 
-			pageId := uint32(item.Value())
-			ctx, ok := self.contextByPageId[pageId]
-			if !ok {
+		err = self.cache._Trim()
+		if err != nil {
+			return err
+		}
 
-				branchKey := item.Key()
-				
-				ctx = self.LoadContext(pageId, branchKey)
+		ctx, ok := self.contextByPageId[pageId]
+		if !ok {
+			ctx, err = self.LoadContext(pageId, branchKeys[i])
+			if err != nil {
+				return err
 			}
+		}
 
-			var vals I64Array
-			for k, _ := range ctx.data {
-				vals = append(vals, k)
-			}
-			sort.Sort(vals)
-			for _, v := range vals {
-				ch <- v
+		var vals I64Array
+		for k, _ := range ctx.data {
+			vals = append(vals, k)
+		}
+		sort.Sort(vals)
+		for _, v := range vals {
+			if !yield(v, struct{}{}) {
+				return nil
 			}
-
 		}
 
-		close(ch)
-	} (q)
+	}
 
-	return q
+	return nil
 }
 
 
-func (self *LazyI64Set) Add(value int64) {
+func (self *LazyI64Set) Add(value int64) (err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = self.cache._Trim()
+	if err != nil {
+		return err
+	}
 
 	branchKey := value / 4096
 
-	page := self.treeFactory.GetOrCreatePage(branchKey)	
+	ctx, err := self._GetOrCreateContext(branchKey)
+	if err != nil {
+		return err
+	}
+
+	_, ok := ctx.data[value]
+	if !ok {
+		self.cache._Resize(ctx.cacheEntry, LAZY_I64SET_ROW_SIZE)
+	}
+
+	ctx.data[value] = 1
+	ctx.isChanged = true
+
+	//fmt.Println("ADD", ctx.ToString())
+
+	return nil
+}
+
+// AddMany adds every value, each context is loaded and resized once.
+func (self *LazyI64Set) AddMany(values []int64) (err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
 
-	var ctxPageId uint32
-	var ctx *LazyI64SetContext
+	sorted := _SortI64Values(values)
+
+	for i:=0; i<len(sorted); {
+		branchKey := sorted[i] / 4096
+		j := i + 1
+		for j < len(sorted) && sorted[j] / 4096 == branchKey {
+			j++
+		}
+
+		err := self.cache._Trim()
+		if err != nil {
+			return err
+		}
+
+		ctx, err := self._GetOrCreateContext(branchKey)
+		if err != nil {
+			return err
+		}
+
+		added := 0
+		for _, value := range sorted[i:j] {
+			_, ok := ctx.data[value]
+			if !ok {
+				ctx.data[value] = 1
+				added++
+			}
+		}
+
+		self.cache._Resize(ctx.cacheEntry, added * LAZY_I64SET_ROW_SIZE)
+		ctx.isChanged = true
+		i = j
+	}
+
+	return nil
+}
+
+func (self *LazyI64Set) _GetOrCreateContext(branchKey int64) (*LazyI64SetContext, error) {
+
+	page, err := self.treeFactory.GetOrCreatePage(branchKey)
+	if err != nil {
+		return nil, err
+	}
 
 	_ctxPageId, ok := page.Get(branchKey)
 	if ok {
-		ctxPageId = uint32(_ctxPageId)
-	} else {
-		ctxPageId = self.pager.CreatePageId()
-		page.Set(branchKey, int64(ctxPageId))
-	
-		ctx = self.NewContext(ctxPageId, branchKey)
-		ctx.isChanged = true
-		self.contextByPageId[ctxPageId] = ctx
+		return self._GetContext(uint32(_ctxPageId), branchKey)
 	}
 
-	if ctx == nil {
-		ctx, ok = self.contextByPageId[ctxPageId]
-		if !ok {
-			ctx = self.LoadContext(ctxPageId, branchKey)
-			self.contextByPageId[ctxPageId] = ctx
-		}
+	ctxPageId := self.pager.CreatePageId()
+	page.Set(branchKey, int64(ctxPageId))
+
+	ctx := self.NewContext(ctxPageId, branchKey)
+	ctx.isChanged = true
+	self.contextByPageId[ctxPageId] = ctx
+	ctx.cacheEntry = self.cache._Add(ctx, 0, false)
+
+	return ctx, nil
+}
+
+
+// Remove returns ErrNotFound when value is not in the set.
+func (self *LazyI64Set) Remove(value int64) (err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = self.cache._Trim()
+	if err != nil {
+		return err
 	}
 
-	ctx.data[value] = 1
+	branchKey := value / 4096
+
+	page, err := self.treeFactory.GetPage(branchKey)
+	if err != nil {
+		return err
+	}
+	if page == nil {
+		return ErrNotFound
+	}
+
+	_ctxPageId, ok := page.Get(branchKey)
+	if !ok {
+		return ErrNotFound
+	}
+
+	ctxPageId := uint32(_ctxPageId)
+
+	ctx, err := self._GetContext(ctxPageId, branchKey)
+	if err != nil {
+		return err
+	}
+
+	_, ok = ctx.data[value]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(ctx.data, value)
 	ctx.isChanged = true
+	self.cache._Resize(ctx.cacheEntry, -LAZY_I64SET_ROW_SIZE)
+
+	if len(ctx.data) == 0 {
+		delete(self.contextByPageId, ctxPageId)
+		self.cache._Remove(ctx.cacheEntry)
+		err = self.pager.FreePayloadData(ctxPageId)
+		if err != nil {
+			return err
+		}
+		_, err = self.treeFactory.Delete(branchKey)
+		if err != nil {
+			return err
+		}
+	}
 
-	//fmt.Println("ADD", ctx.ToString())
+	return nil
+}
+
+func (self *LazyI64Set) IsEmpty() (bool, error) {
+	root, err := self.treeFactory.GetRootPage()
+	if err != nil {
+		return false, err
+	}
+	return root == nil || root.tree.Count() == 0, nil
 }
 
+// Free releases every context and tree page of the set back to the pager.
+func (self *LazyI64Set) Free() error {
 
-func (self *LazyI64Set) LoadContext(pid uint32, branchKey int64) *LazyI64SetContext {
+	var pageIds []uint32
+	err := self.treeFactory._EachItems(func(key int64, value int64) bool {
+		pageIds = append(pageIds, uint32(value))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, pageId := range pageIds {
+		err = self.pager.FreePayloadData(pageId)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = self.treeFactory.Free()
+	if err != nil {
+		return err
+	}
+
+	for _, ctx := range self.contextByPageId {
+		self.cache._Remove(ctx.cacheEntry)
+	}
+	self.contextByPageId = make(map[uint32]*LazyI64SetContext)
+
+	return nil
+}
+
+func (self *LazyI64Set) LoadContext(pid uint32, branchKey int64) (*LazyI64SetContext, error) {
 	ctx := self.NewContext(pid, branchKey)
 
-	data, _ := self.pager.ReadPayloadData(pid)
+	data, err := self.pager.ReadPayloadData(pid)
+	if err != nil {
+		return nil, err
+	}
 
-	rd := NewDataStreamFromBuffer(data)
-	rowsCount := int(rd.ReadUInt24())
-	//fmt.Println("LoadContext rowsCount", rowsCount)
-	
-	for i:=0; i<rowsCount; i++ {
-		v := int64(rd.ReadUInt64())
-		ctx.data[v] = 1
+	err = _DecodePage("LazyI64SetContext", pid, data, func(rd *DataStream) {
+		rowsCount := int(rd.ReadUInt24())
+		//fmt.Println("LoadContext rowsCount", rowsCount)
+		
+		for i:=0; i<rowsCount; i++ {
+			v := int64(rd.ReadUInt64())
+			ctx.data[v] = 1
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return ctx
+	return ctx, nil
 }
 
 func (self *LazyI64Set) NewContext(pid uint32, branchKey int64) *LazyI64SetContext {
@@ -165,11 +353,69 @@ func (self *LazyI64Set) NewContext(pid uint32, branchKey int64) *LazyI64SetConte
 	ctx.pid = pid
 	ctx.branchKey = branchKey
 	ctx.data = make(map[int64]byte)
+	ctx.set = self
 	return ctx
 }
 
+func (self *LazyI64Set) _GetContext(pid uint32, branchKey int64) (*LazyI64SetContext, error) {
+
+	ctx, ok := self.contextByPageId[pid]
+	if ok {
+		self.cache._Touch(ctx.cacheEntry)
+		return ctx, nil
+	}
+
+	ctx, err := self.LoadContext(pid, branchKey)
+	if err != nil {
+		return nil, err
+	}
+	self.contextByPageId[pid] = ctx
+	ctx.cacheEntry = self.cache._Add(ctx, len(ctx.data) * LAZY_I64SET_ROW_SIZE, true)
+
+	return ctx, nil
+}
+
+// ReleaseCache drops the contexts without unsaved changes.
 func (self *LazyI64Set) ReleaseCache() {
 
+	for pid, ctx := range self.contextByPageId {
+		if !ctx.isChanged {
+			delete(self.contextByPageId, pid)
+			self.cache._Remove(ctx.cacheEntry)
+		}
+	}
+}
+
+func (ctx *LazyI64SetContext) _Owner() string {
+	return _PagerOwner(ctx.set.pager)
+}
+
+func (ctx *LazyI64SetContext) _IsChanged() bool {
+	return ctx.isChanged
+}
+
+func (ctx *LazyI64SetContext) _WriteBack() (bool, error) {
+
+	ctxW := NewDataStream()
+	ctxW.WriteUInt24(uint32(len(ctx.data)))
+	for v, _ := range ctx.data {
+		ctxW.WriteUInt64(uint64(v))
+	}
+	ctxData := ctxW.ToBytes()
+	err := ctx.set.pager.WritePayloadData(ctx.pid, ctxData)
+	if err != nil {
+		return false, err
+	}
+
+	ctx.isChanged = false
+
+	//fmt.Println("SAVE CTX pid", ctx.pid, "bytes", len(ctxData))
+
+	return true, nil
+}
+
+func (ctx *LazyI64SetContext) _Release() {
+	delete(ctx.set.contextByPageId, ctx.pid)
 }
 
 
@@ -177,6 +423,7 @@ func NewLazyI64Set(pager IPager, meta []byte) *LazyI64Set {
 	self := new(LazyI64Set)
 	self.pager = pager
 	self.contextByPageId = make(map[uint32]*LazyI64SetContext)
+	self.cache = _PageCacheOf(pager)
 
 	var treeFactoryMeta []byte
 
@@ -3,7 +3,7 @@ package gokvdb
 
 import (
 	"fmt"
-	"os"
+	"errors"
 	//"hash/fnv"
 )
 
@@ -17,7 +17,7 @@ type LazyI64BlobDict struct {
 	isChanged bool
 }
 
-func NewI64BlobDict(s *Storage, dbName string, dictName string) *LazyI64BlobDict {
+func NewI64BlobDict(s *Storage, dbName string, dictName string) (*LazyI64BlobDict, error) {
 
 	dict := new(LazyI64BlobDict)
 	dict.dbName = dbName
@@ -26,35 +26,46 @@ func NewI64BlobDict(s *Storage, dbName string, dictName string) *LazyI64BlobDict
 
 	db, err := s.DB(dbName)
 	if err != nil {
-		fmt.Println("error dbName", dbName)
-		os.Exit(1)
+		return nil, err
+	}
+	err = db._CheckDictKind(dictName, DICT_KIND_I64BLOB)
+	if err != nil {
+		return nil, err
 	}
 
-	metaData, ok := db.GetMeta(dictName)
+	metaData, err := db.GetMeta(dictName)
 
-	//fmt.Println("NewI64StrDict metaData", ok, metaData)
+	//fmt.Println("NewI64StrDict metaData", err, metaData)
 
 	var internalPagerMeta []byte
 	var btMeta []byte
 
-	if ok {
+	if err == nil {
 
 		rd := NewDataStreamFromBuffer(metaData)
 		internalPagerMeta = rd.ReadChunk()
 		btMeta = rd.ReadChunk()
 
-	} 
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
 
-	internalPageSize := uint16(128)
-	internalPager := NewInternalPager(s.pager, internalPageSize, internalPagerMeta)
+	internalPageSize := s.options._InternalPageSize(128)
+	internalPager, err := NewInternalPager(s.pager, internalPageSize, internalPagerMeta, _DictOwner(dbName, dictName))
+	if err != nil {
+		return nil, err
+	}
 
-	bt := NewBTreeBlobMap(internalPager, btMeta)
+	bt, err := NewBTreeBlobMap(internalPager, btMeta)
+	if err != nil {
+		return nil, err
+	}
 	
 
 	dict.internalPager = internalPager
 	dict.bt = bt
 
-	return dict
+	return dict, nil
 }
 
 func (d *LazyI64BlobDict) ToString() string {
@@ -62,66 +73,93 @@ func (d *LazyI64BlobDict) ToString() string {
 }
 
 
-type LazyI64BlobDictItem struct {
-	key int64
-	item BTreeBlobMapItem
+func (d *LazyI64BlobDict) Items() *Cursor[int64, []byte] {
+	return d.bt.Items()
 }
 
-func (i *LazyI64BlobDictItem) Key() int64 {
-	return i.key
+// Range walks the keys in [from, to) in order.
+func (d *LazyI64BlobDict) Range(from int64, to int64) *Cursor[int64, []byte] {
+	return d.bt.Range(from, to)
 }
 
-func (i *LazyI64BlobDictItem) Value() []byte {
-	return i.item.Value()
+// RangeClosed walks the keys in [from, to] in order, unlike Range it can
+// end at math.MaxInt64.
+func (d *LazyI64BlobDict) RangeClosed(from int64, to int64) *Cursor[int64, []byte] {
+	return d.bt.RangeClosed(from, to)
 }
 
-func (d *LazyI64BlobDict) Items() chan LazyI64BlobDictItem {
-	q := make(chan LazyI64BlobDictItem)
-
-	go func(ch chan LazyI64BlobDictItem) {
-
-		bt := d.bt
-
-		for btItem := range bt.Items() {
-			fmt.Println("LazyI64BlobDict Items bt", btItem)
-
-			item := LazyI64BlobDictItem{key: btItem.Key(), item: btItem}
-			ch <- item
-			
-		}
-
-		close(ch)
-	}(q)
-
-	return q
+// SeekKey walks up from the first key >= key, or down from the last key
+// <= key when reverse is set.
+func (d *LazyI64BlobDict) SeekKey(key int64, reverse bool) *Cursor[int64, []byte] {
+	return d.bt.SeekKey(key, reverse)
 }
 
-func (d *LazyI64BlobDict) Set(key int64, value []byte) {
+func (d *LazyI64BlobDict) Set(key int64, value []byte) error {
 	//bt := d._GetBt()
 
-	d.bt.Set(key, value)
+	err := _CheckDropped(d.internalPager)
+	if err != nil {
+		return err
+	}
+	return d.bt.Set(key, value)
 }
 
-func (d *LazyI64BlobDict) Get(key int64) ([]byte, bool) {
+// Get returns ErrNotFound when key is not in the dict.
+func (d *LazyI64BlobDict) Get(key int64) ([]byte, error) {
 	//bt := d._GetBt()
-	value, ok := d.bt.Get(key)
+	return d.bt.Get(key)
+}
 
-	return value, ok
+// GetMany returns the values of keys in their order, with the bitmap of
+// the keys found. The pages are read once each, in ascending page id
+// order.
+func (d *LazyI64BlobDict) GetMany(keys []int64) ([][]byte, Found, error) {
+	unique, at := _UniqueKeys(keys, _LessI64)
+	values, found, err := d.bt._GetMany(unique)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spread, spreadFound := _SpreadMany(values, found, at)
+	return spread, spreadFound, nil
+}
+
+// Delete returns ErrNotFound when key is not in the dict.
+func (d *LazyI64BlobDict) Delete(key int64) error {
+	err := _CheckDropped(d.internalPager)
+	if err != nil {
+		return err
+	}
+	return d.bt.Delete(key)
 }
 
-func (d *LazyI64BlobDict) Save(commit bool) {
+func (d *LazyI64BlobDict) Save(commit bool) error {
+
+	err := d.storage._CheckDictSave(d)
+	if err != nil {
+		return err
+	}
+	err = _CheckDropped(d.internalPager)
+	if err != nil {
+		return err
+	}
 	//fmt.Println("Save", d.ToString())
 
 	bt := d.bt
 
 	db, err := d.storage.DB(d.dbName)
 	if err != nil {
-		fmt.Println("error dbName", d.dbName)
-		os.Exit(1)
+		return err
 	}
 
-	btMeta := bt.Save()
-	internalPagerMeta := d.internalPager.Save()
+	btMeta, err := bt.Save()
+	if err != nil {
+		return err
+	}
+	internalPagerMeta, err := d.internalPager.Save()
+	if err != nil {
+		return err
+	}
 
 	metaW := NewDataStream()
 	metaW.WriteChunk(internalPagerMeta)
@@ -129,10 +167,15 @@ func (d *LazyI64BlobDict) Save(commit bool) {
 
 	metaBytes := metaW.ToBytes()
 
-	db.SetMeta(d.dictName, metaBytes)
+	err = db._SetDictMeta(d.dictName, DICT_KIND_I64BLOB, metaBytes)
+	if err != nil {
+		return err
+	}
 
 	if commit {
-		d.storage.Save()
+		return d.storage._SaveAfterDict()
 	}
+
+	return nil
 }
 
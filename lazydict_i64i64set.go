@@ -2,8 +2,9 @@ package gokvdb
 
 
 import (
-	"os"
 	"fmt"
+	"math"
+	"errors"
 )
 
 type LazyI64I64SetDict struct {
@@ -15,6 +16,7 @@ type LazyI64I64SetDict struct {
 	internalPager IPager
 	treeFactory *BranchI64BTreeFactory
 	ctxByKey map[int64]*LazyI64I64SetContext
+	cache *PageCache
 }
 
 type LazyI64I64SetContext struct {
@@ -43,20 +45,32 @@ func (self *LazyI64I64SetDict) ReleaseCache() {
 	}
 
 	for _, key := range keys {
-		ctx, _ := self.ctxByKey[key]
-		fmt.Println("ReleaseCache", ctx.ToString())
 		delete(self.ctxByKey, key)
-		ctx = nil		
 	}
 }
 
-func (self *LazyI64I64SetDict) Save(commit bool) {
+func (self *LazyI64I64SetDict) Save(commit bool) error {
+
+	err := self.storage._CheckDictSave(self)
+	if err != nil {
+		return err
+	}
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
 
 	for _, ctx := range self.ctxByKey {
 		if ctx.isChanged {
+			ctxData, err := ctx.set.Save()
+			if err != nil {
+				return err
+			}
+			err = self.internalPager.WritePayloadData(ctx.pid, ctxData)
+			if err != nil {
+				return err
+			}
 			ctx.isChanged = false
-			ctxData := ctx.set.Save()
-			self.internalPager.WritePayloadData(ctx.pid, ctxData)
 
 			//fmt.Println("LazyI64I64SetDict SAVE ctx", ctx.ToString(), "len", len(ctxData))
 		}
@@ -64,12 +78,17 @@ func (self *LazyI64I64SetDict) Save(commit bool) {
 
 	db, err := self.storage.DB(self.dbName)
 	if err != nil {
-		fmt.Println("error dbName", self.dbName)
-		os.Exit(1)
+		return err
 	}
 
-	treeFactoryMeta := self.treeFactory.Save()
-	internalPagerMeta := self.internalPager.Save()
+	treeFactoryMeta, err := self.treeFactory.Save()
+	if err != nil {
+		return err
+	}
+	internalPagerMeta, err := self.internalPager.Save()
+	if err != nil {
+		return err
+	}
 
 	metaW := NewDataStream()
 	metaW.WriteChunk(internalPagerMeta)
@@ -77,15 +96,19 @@ func (self *LazyI64I64SetDict) Save(commit bool) {
 
 	metaBytes := metaW.ToBytes()
 
-	db.SetMeta(self.ixName, metaBytes)
+	err = db._SetDictMeta(self.ixName, DICT_KIND_I64I64SET, metaBytes)
+	if err != nil {
+		return err
+	}
 
 	if commit {
-		self.storage.Save()
+		return self.storage._SaveAfterDict()
 	}
 
+	return nil
 }
 
-func NewLazyI64I64SetDict(storage *Storage, dbName string, ixName string) *LazyI64I64SetDict {
+func NewLazyI64I64SetDict(storage *Storage, dbName string, ixName string) (*LazyI64I64SetDict, error) {
 
 	self := new(LazyI64I64SetDict)
 	self.storage = storage
@@ -95,27 +118,36 @@ func NewLazyI64I64SetDict(storage *Storage, dbName string, ixName string) *LazyI
 
 	db, err := storage.DB(dbName)
 	if err != nil {
-		fmt.Println("error dbName", dbName)
-		os.Exit(1)
+		return nil, err
+	}
+	err = db._CheckDictKind(ixName, DICT_KIND_I64I64SET)
+	if err != nil {
+		return nil, err
 	}
 
-	metaData, ok := db.GetMeta(ixName)
+	metaData, err := db.GetMeta(ixName)
 
 	var internalPagerMeta []byte
 	var treeFactoryMeta []byte
-	if ok {
+	if err == nil {
 
 		rd := NewDataStreamFromBuffer(metaData)
 		internalPagerMeta = rd.ReadChunk()
 		treeFactoryMeta = rd.ReadChunk()
-	} 
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
 
-	internalPageSize := uint16(128)
-	internalPager := NewInternalPager(storage.pager, internalPageSize, internalPagerMeta)
+	internalPageSize := storage.options._InternalPageSize(128)
+	internalPager, err := NewInternalPager(storage.pager, internalPageSize, internalPagerMeta, _DictOwner(dbName, ixName))
+	if err != nil {
+		return nil, err
+	}
 
 	self.internalPager = internalPager
 	self.treeFactory = NewBranchI64BTreeFactory(internalPager, treeFactoryMeta, 3)
-	return self
+	self.cache = _PageCacheOf(storage.pager)
+	return self, nil
 }
 
 func (self *LazyI64I64SetDict) NewContext(pid uint32, key int64, data []byte) *LazyI64I64SetContext {
@@ -137,64 +169,259 @@ func (self *LazyI64I64SetItem) Key() int64 {
 	return self.key
 }
 
-func (self *LazyI64I64SetItem) Values() chan int64 {
+func (self *LazyI64I64SetItem) Values() *Cursor[int64, struct{}] {
 
 	ctx, ok := self.i64i64set.ctxByKey[self.key]
 	if !ok {
-		ctx = self.i64i64set.LoadContext(self.ctxPageId, self.key)
+		var err error
+		ctx, err = self.i64i64set.LoadContext(self.ctxPageId, self.key)
+		if err != nil {
+			return _ErrCursor[int64, struct{}](err)
+		}
 	}
 	
 	return ctx.set.Values()
 }
 
-func (self *LazyI64I64SetDict) LoadContext(ctxPageId uint32, key int64) *LazyI64I64SetContext {
+func (self *LazyI64I64SetDict) LoadContext(ctxPageId uint32, key int64) (*LazyI64I64SetContext, error) {
 
-	setData, _ := self.internalPager.ReadPayloadData(ctxPageId)
+	setData, err := self.internalPager.ReadPayloadData(ctxPageId)
+	if err != nil {
+		return nil, err
+	}
 	ctx := self.NewContext(ctxPageId, key, setData)
 
-	return ctx
+	return ctx, nil
 }
 
-func (self *LazyI64I64SetDict) Items() chan LazyI64I64SetItem {
-
-	q := make(chan LazyI64I64SetItem)
-
-	go func(ch chan LazyI64I64SetItem) {
-		
-		for _item := range self.treeFactory.Items() {
-
-			key := _item.Key()
-			ctxPageId := uint32(_item.Value())
+func (self *LazyI64I64SetDict) Items() *Cursor[int64, *LazyI64I64SetItem] {
+	return self.SeekKey(math.MinInt64, false)
+}
 
-			item := LazyI64I64SetItem{key: key, ctxPageId:ctxPageId, i64i64set:self}
-			ch <- item
-		}
-		close(ch)
+// Range walks the keys in [from, to) in order.
+func (self *LazyI64I64SetDict) Range(from int64, to int64) *Cursor[int64, *LazyI64I64SetItem] {
+	first, last, ok := _RangeBounds(from, to)
+	if !ok {
+		return _EmptyCursor[int64, *LazyI64I64SetItem]()
+	}
+	return _NewCursor(func(yield func(int64, *LazyI64I64SetItem) bool) error {
+		return self._EachRange(first, last, false, yield)
+	})
+}
 
-	}(q)
+// RangeClosed walks the keys in [from, to] in order, unlike Range it can
+// end at math.MaxInt64.
+func (self *LazyI64I64SetDict) RangeClosed(from int64, to int64) *Cursor[int64, *LazyI64I64SetItem] {
+	if to < from {
+		return _EmptyCursor[int64, *LazyI64I64SetItem]()
+	}
+	return _NewCursor(func(yield func(int64, *LazyI64I64SetItem) bool) error {
+		return self._EachRange(from, to, false, yield)
+	})
+}
 
+// SeekKey walks up from the first key >= key, or down from the last key
+// <= key when reverse is set.
+func (self *LazyI64I64SetDict) SeekKey(key int64, reverse bool) *Cursor[int64, *LazyI64I64SetItem] {
+	first, last := _SeekBounds(key, reverse)
+	return _NewCursor(func(yield func(int64, *LazyI64I64SetItem) bool) error {
+		return self._EachRange(first, last, reverse, yield)
+	})
+}
 
-	return q
+func (self *LazyI64I64SetDict) _EachRange(from int64, to int64, reverse bool, yield func(key int64, item *LazyI64I64SetItem) bool) error {
+	return self.treeFactory._EachRange(from, to, reverse, func(key int64, value int64) bool {
+		item := &LazyI64I64SetItem{key: key, ctxPageId: uint32(value), i64i64set: self}
+		return yield(key, item)
+	})
 }
 
-func (self *LazyI64I64SetDict) Get(key int64) (LazyI64I64SetItem, bool) {
+// Get returns ErrNotFound when key is not in the dict.
+func (self *LazyI64I64SetDict) Get(key int64) (_ LazyI64I64SetItem, err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = self.cache._Trim()
+	if err != nil {
+		return LazyI64I64SetItem{}, err
+	}
 
-	page := self.treeFactory.GetPage(key)	
+	page, err := self.treeFactory.GetPage(key)
+	if err != nil {
+		return LazyI64I64SetItem{}, err
+	}
 	if page != nil {
 		_ctxPageId, ok := page.Get(key)
 		if ok {
 			ctxPageId := uint32(_ctxPageId)
 			item := LazyI64I64SetItem{key: key, ctxPageId:ctxPageId, i64i64set:self}
-			return item, true
+			return item, nil
 		}
 	}
 
-	return LazyI64I64SetItem{}, false
+	return LazyI64I64SetItem{}, ErrNotFound
 }
 
+// GetMany returns the items of keys in their order, with the bitmap of the
+// keys found. The tree pages are read once each, a level at a time in
+// ascending page id order.
+func (self *LazyI64I64SetDict) GetMany(keys []int64) (_ []LazyI64I64SetItem, _ Found, err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = self.cache._Trim()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unique, at := _UniqueKeys(keys, _LessI64)
+	ctxPageIds, found, err := self.treeFactory._GetMany(unique)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items := make([]LazyI64I64SetItem, len(unique))
+	for i, key := range unique {
+		if found[i] {
+			items[i] = LazyI64I64SetItem{key: key, ctxPageId: uint32(ctxPageIds[i]), i64i64set: self}
+		}
+	}
+
+	spread, spreadFound := _SpreadMany(items, found, at)
+	return spread, spreadFound, nil
+}
+
+func (self *LazyI64I64SetDict) _GetContext(key int64) (*LazyI64I64SetContext, error) {
+
+	ctx, ok := self.ctxByKey[key]
+	if ok {
+		return ctx, nil
+	}
+
+	item, err := self.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err = self.LoadContext(item.ctxPageId, key)
+	if err != nil {
+		return nil, err
+	}
+	self.ctxByKey[key] = ctx
+
+	return ctx, nil
+}
 
+// Delete removes key and its whole value set. It returns ErrNotFound when
+// key is not in the dict.
+func (self *LazyI64I64SetDict) Delete(key int64) error {
 
-func (self *LazyI64I64SetDict) Add(key int64, value int64) {
+	err := _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := self._GetContext(key)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.set.Free()
+	if err != nil {
+		return err
+	}
+	err = self.internalPager.FreePayloadData(ctx.pid)
+	if err != nil {
+		return err
+	}
+	_, err = self.treeFactory.Delete(key)
+	if err != nil {
+		return err
+	}
+	delete(self.ctxByKey, key)
+
+	return nil
+}
+
+// Remove removes value from the set of key. The key itself is deleted
+// once its set becomes empty. It returns ErrNotFound when the pair is not
+// in the dict.
+func (self *LazyI64I64SetDict) Remove(key int64, value int64) error {
+
+	err := _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := self._GetContext(key)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.set.Remove(value)
+	if err != nil {
+		return err
+	}
+	ctx.isChanged = true
+
+	isEmpty, err := ctx.set.IsEmpty()
+	if err != nil {
+		return err
+	}
+
+	if isEmpty {
+		return self.Delete(key)
+	}
+
+	return nil
+}
+
+func (self *LazyI64I64SetDict) Add(key int64, value int64) error {
+	err := _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := self._GetOrCreateContext(key)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.set.Add(value)
+	if err != nil {
+		return err
+	}
+	ctx.isChanged = true
+
+	return nil
+}
+
+// AddMany adds every value to the set of key. The values are grouped by
+// the set context they go to, an empty batch leaves the dict unchanged.
+func (self *LazyI64I64SetDict) AddMany(key int64, values []int64) error {
+	err := _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	ctx, err := self._GetOrCreateContext(key)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.set.AddMany(values)
+	if err != nil {
+		return err
+	}
+	ctx.isChanged = true
+
+	return nil
+}
+
+func (self *LazyI64I64SetDict) _GetOrCreateContext(key int64) (*LazyI64I64SetContext, error) {
 	var ctx *LazyI64I64SetContext
 	var ok bool
 	ctx, ok = self.ctxByKey[key]
@@ -202,14 +429,20 @@ func (self *LazyI64I64SetDict) Add(key int64, value int64) {
 	//fmt.Println("LazyI64I64Set Add", "key=", key, ctx, ok)
 
 	if !ok {
-		page := self.treeFactory.GetOrCreatePage(key)	
+		page, err := self.treeFactory.GetOrCreatePage(key)	
+		if err != nil {
+			return nil, err
+		}
 
 		var ctxPageId uint32
 
 		_ctxPageId, ok := page.Get(key)
 		if ok {
 			ctxPageId = uint32(_ctxPageId)
-			ctx = self.LoadContext(ctxPageId, key)
+			ctx, err = self.LoadContext(ctxPageId, key)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			ctxPageId = self.internalPager.CreatePageId()
 			page.Set(key, int64(ctxPageId))
@@ -222,7 +455,5 @@ func (self *LazyI64I64SetDict) Add(key int64, value int64) {
 		self.ctxByKey[key] = ctx
 	}
 
-	ctx.set.Add(value)
-	ctx.isChanged = true
-
+	return ctx, nil
 }
@@ -3,8 +3,9 @@ package gokvdb
 
 import (
 	"fmt"
-	"os"
+	"math"
 	"sort"
+	"errors"
 	//"hash/fnv"
 )
 
@@ -18,6 +19,7 @@ type LazyI64StrDict struct {
 	internalPager IPager
 	keyFactory *BranchI64BTreeFactory
 	//bt *BTreeBlobMap
+	cache *PageCache
 	isChanged bool
 }
 
@@ -27,9 +29,10 @@ type LazyI64StrContext struct {
 	getValueByKey map[int64]string
 	isChanged bool
 	dict *LazyI64StrDict
+	cacheEntry *PageCacheEntry
 }
 
-func NewI64StrDict(s *Storage, dbName string, dictName string) *LazyI64StrDict {
+func NewI64StrDict(s *Storage, dbName string, dictName string) (*LazyI64StrDict, error) {
 
 	self := new(LazyI64StrDict)
 	self.dbName = dbName
@@ -39,31 +42,40 @@ func NewI64StrDict(s *Storage, dbName string, dictName string) *LazyI64StrDict {
 
 	db, err := s.DB(dbName)
 	if err != nil {
-		fmt.Println("error dbName", dbName)
-		os.Exit(1)
+		return nil, err
+	}
+	err = db._CheckDictKind(dictName, DICT_KIND_I64STR)
+	if err != nil {
+		return nil, err
 	}
 
-	metaData, ok := db.GetMeta(dictName)
+	metaData, err := db.GetMeta(dictName)
 
 	var internalPagerMeta []byte
 	var keyFactoryMeta []byte
 
-	if ok {
+	if err == nil {
 
 		rd := NewDataStreamFromBuffer(metaData)
 		internalPagerMeta = rd.ReadChunk()
 		keyFactoryMeta = rd.ReadChunk()
 		//fmt.Println("NewI64StrDict keyFactoryMeta", keyFactoryMeta)
 
-	} 
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
 
-	internalPageSize := uint16(128)
-	internalPager := NewInternalPager(s.pager, internalPageSize, internalPagerMeta)	
+	internalPageSize := s.options._InternalPageSize(128)
+	internalPager, err := NewInternalPager(s.pager, internalPageSize, internalPagerMeta, _DictOwner(dbName, dictName))	
+	if err != nil {
+		return nil, err
+	}
 
 	self.internalPager = internalPager
 	self.keyFactory = NewBranchI64BTreeFactory(internalPager, keyFactoryMeta, 3)
+	self.cache = _PageCacheOf(s.pager)
 
-	return self
+	return self, nil
 }
 
 func (d *LazyI64StrDict) ToString() string {
@@ -79,87 +91,374 @@ func (d *LazyI64StrDict) _GetBranchKey(key int64) int64 {
 	return key / 4096
 }
 
-type LazyI64StrDictItem struct {
-	key int64
-	value string
+func (self *LazyI64StrDict) Items() *Cursor[int64, string] {
+	return self.SeekKey(math.MinInt64, false)
 }
 
-func (i *LazyI64StrDictItem) Key() int64 {
-	return i.key
+// Range walks the keys in [from, to) in order.
+func (self *LazyI64StrDict) Range(from int64, to int64) *Cursor[int64, string] {
+	first, last, ok := _RangeBounds(from, to)
+	if !ok {
+		return _EmptyCursor[int64, string]()
+	}
+	return _NewCursor(func(yield func(int64, string) bool) error {
+		return self._EachRange(first, last, false, yield)
+	})
 }
 
-func (i *LazyI64StrDictItem) Value() string {
-	return i.value
+// RangeClosed walks the keys in [from, to] in order, unlike Range it can
+// end at math.MaxInt64.
+func (self *LazyI64StrDict) RangeClosed(from int64, to int64) *Cursor[int64, string] {
+	if to < from {
+		return _EmptyCursor[int64, string]()
+	}
+	return _NewCursor(func(yield func(int64, string) bool) error {
+		return self._EachRange(from, to, false, yield)
+	})
 }
 
-func (self *LazyI64StrDict) Items() chan LazyI64StrDictItem {
-	q := make(chan LazyI64StrDictItem)
-	go func(ch chan LazyI64StrDictItem) {
+// SeekKey walks up from the first key >= key, or down from the last key
+// <= key when reverse is set.
+func (self *LazyI64StrDict) SeekKey(key int64, reverse bool) *Cursor[int64, string] {
+	first, last := _SeekBounds(key, reverse)
+	return _NewCursor(func(yield func(int64, string) bool) error {
+		return self._EachRange(first, last, reverse, yield)
+	})
+}
 
+func (self *LazyI64StrDict) _EachRange(from int64, to int64, reverse bool, yield func(key int64, value string) bool) error {
 
-		for _item := range self.keyFactory.Items() {
+	var branchKeys []int64
+	var ctxPageIds []uint32
+	err := self.keyFactory._EachRange(self._GetBranchKey(from), self._GetBranchKey(to), reverse, func(key int64, value int64) bool {
+		branchKeys = append(branchKeys, key)
+		ctxPageIds = append(ctxPageIds, uint32(value))
+		return true
+	})
+	if err != nil {
+		return err
+	}
 
-			branchKey := _item.Key()
-			ctxPageId := uint32(_item.Value())
+	for i, branchKey := range branchKeys {
 
-			ctx, ok := self.contextByBranchKey[branchKey]
-			if !ok {
-				ctx = self._ReadContext(ctxPageId, branchKey)
+		err = self.cache._Trim()
+		if err != nil {
+			return err
+		}
+
+		ctx, ok := self.contextByBranchKey[branchKey]
+		if !ok {
+			ctx, err = self._ReadContext(ctxPageIds[i], branchKey)
+			if err != nil {
+				return err
 			}
+		}
 
-			var keys I64Array
+		var keys I64Array
 
-			for k, _ := range ctx.getValueByKey {
+		for k, _ := range ctx.getValueByKey {
+			if k >= from && k <= to {
 				keys = append(keys, k)
 			}
+		}
 
+		if reverse {
+			sort.Sort(sort.Reverse(keys))
+		} else {
 			sort.Sort(keys)
+		}
 
-			for _, k := range keys {
-				v, _ := ctx.getValueByKey[k]
-				ch <- LazyI64StrDictItem{key: k, value: v}
+		for _, k := range keys {
+			v, ok := ctx.getValueByKey[k]
+			if !ok {
+				continue
+			}
+			if !yield(k, v) {
+				return nil
 			}
 		}
+	}
 
-		close(ch)
-	}(q)
-
-	return q
+	return nil
 }
 
-func (self *LazyI64StrDict) Set(key int64, value string) {
+// Set fails with ErrValueTooLarge for a value over MAX_STR_VALUE_SIZE.
+func (self *LazyI64StrDict) Set(key int64, value string) (err error) {
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = _CheckStrValue(key, value)
+	if err != nil {
+		return err
+	}
+
+	err = self.cache._Trim()
+	if err != nil {
+		return err
+	}
+
 	branchKey := self._GetBranchKey(key)
-	ctx := self._GetContextByBranchKey(branchKey)
+	ctx, err := self._GetOrCreateContext(branchKey)
+	if err != nil {
+		return err
+	}
+
+	//fmt.Println(d.ToString(), "SET", key, value, ctx.ToString())
+
+	oldValue, ok := ctx.getValueByKey[key]
+	if ok {
+		self.cache._Resize(ctx.cacheEntry, len(value) - len(oldValue))
+	} else {
+		self.cache._Resize(ctx.cacheEntry, _I64StrRowSize(value))
+	}
+
+	ctx.getValueByKey[key] = value
+	ctx.isChanged = true
+
+	return nil
+}
+
+func (self *LazyI64StrDict) _GetOrCreateContext(branchKey int64) (*LazyI64StrContext, error) {
+	ctx, err := self._GetContextByBranchKey(branchKey)
+	if err != nil {
+		return nil, err
+	}
 
 	if ctx == nil {
+		page, err := self.keyFactory.GetOrCreatePage(branchKey)
+		if err != nil {
+			return nil, err
+		}
+
 		ctxPageId := self.internalPager.CreatePageId()
-		
-		page := self.keyFactory.GetOrCreatePage(branchKey)	
 		page.Set(branchKey, int64(ctxPageId))
 
 		ctx = self._NewContext(ctxPageId, branchKey)
 		ctx.isChanged = true
 		self.contextByBranchKey[branchKey] = ctx
+		ctx.cacheEntry = self.cache._Add(ctx, 0, false)
 	}
 
-	//fmt.Println(d.ToString(), "SET", key, value, ctx.ToString())
+	return ctx, nil
+}
 
-	ctx.getValueByKey[key] = value
+// SetMany sets every item of items, see SetPairs.
+func (self *LazyI64StrDict) SetMany(items map[int64]string) error {
+	pairs := make([]I64StrPair, 0, len(items))
+	for k, v := range items {
+		pairs = append(pairs, I64StrPair{Key: k, Value: v})
+	}
+	return self.SetPairs(pairs)
+}
+
+// SetPairs sets the pairs in order, the last pair of a key wins. The pairs
+// are checked before any is set and a bad one fails the batch with
+// ErrInvalidBatch. Each branch context is loaded and resized once.
+func (self *LazyI64StrDict) SetPairs(pairs []I64StrPair) (err error) {
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	for _, pair := range pairs {
+		err := _CheckStrValue(pair.Key, pair.Value)
+		if err != nil {
+			return _BatchError(err)
+		}
+	}
+
+	sorted := _SortI64Pairs(pairs)
+
+	for i:=0; i<len(sorted); {
+		branchKey := self._GetBranchKey(sorted[i].Key)
+		j := i + 1
+		for j < len(sorted) && self._GetBranchKey(sorted[j].Key) == branchKey {
+			j++
+		}
+
+		err := self._SetGroup(branchKey, sorted[i:j])
+		if err != nil {
+			return err
+		}
+		i = j
+	}
+
+	return nil
+}
+
+// _SetGroup sets pairs, all of them under branchKey.
+func (self *LazyI64StrDict) _SetGroup(branchKey int64, pairs []I64StrPair) error {
+	err := self.cache._Trim()
+	if err != nil {
+		return err
+	}
+
+	ctx, err := self._GetOrCreateContext(branchKey)
+	if err != nil {
+		return err
+	}
+
+	size := 0
+	for _, pair := range pairs {
+		oldValue, ok := ctx.getValueByKey[pair.Key]
+		if ok {
+			size += len(pair.Value) - len(oldValue)
+		} else {
+			size += _I64StrRowSize(pair.Value)
+		}
+		ctx.getValueByKey[pair.Key] = pair.Value
+	}
+
+	self.cache._Resize(ctx.cacheEntry, size)
 	ctx.isChanged = true
+
+	return nil
 }
 
-func (self *LazyI64StrDict) Get(key int64) (string, bool) {
+// Get returns ErrNotFound when key is not in the dict.
+func (self *LazyI64StrDict) Get(key int64) (_ string, err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
 	//bt := d._GetBt()
+	err = self.cache._Trim()
+	if err != nil {
+		return "", err
+	}
+
 	branchKey := self._GetBranchKey(key)
-	ctx := self._GetContextByBranchKey(branchKey)
+	ctx, err := self._GetContextByBranchKey(branchKey)
+	if err != nil {
+		return "", err
+	}
 	if ctx != nil {
 		val, ok := ctx.getValueByKey[key]
 		if ok {
-			return val, true
+			return val, nil
 		}
 	}
 
-	return "", false
+	return "", ErrNotFound
+}
+
+// GetMany returns the values of keys in their order, with the bitmap of
+// the keys found. The contexts are read once each, in ascending page id
+// order.
+func (self *LazyI64StrDict) GetMany(keys []int64) (_ []string, _ Found, err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	unique, at := _UniqueKeys(keys, _LessI64)
+
+	var branchKeys []int64
+	for _, key := range unique {
+		branchKey := self._GetBranchKey(key)
+		if len(branchKeys) == 0 || branchKeys[len(branchKeys)-1] != branchKey {
+			branchKeys = append(branchKeys, branchKey)
+		}
+	}
+
+	ctxPageIds, ctxFound, err := self.keyFactory._GetMany(branchKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// both are sorted, the branch key of a key is at k or after it
+	indexesByPageId := make(map[uint32][]int)
+	k := 0
+	for i, key := range unique {
+		for branchKeys[k] != self._GetBranchKey(key) {
+			k++
+		}
+		if ctxFound[k] {
+			pid := uint32(ctxPageIds[k])
+			indexesByPageId[pid] = append(indexesByPageId[pid], i)
+		}
+	}
+
+	values := make([]string, len(unique))
+	found := make([]bool, len(unique))
+
+	for _, pid := range _SortPageIds(indexesByPageId) {
+		indexes := indexesByPageId[pid]
+
+		err = self.cache._Trim()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ctx, err := self._GetContextByBranchKey(self._GetBranchKey(unique[indexes[0]]))
+		if err != nil {
+			return nil, nil, err
+		}
+		if ctx == nil {
+			continue
+		}
+
+		for _, i := range indexes {
+			values[i], found[i] = ctx.getValueByKey[unique[i]]
+		}
+	}
+
+	spread, spreadFound := _SpreadMany(values, found, at)
+	return spread, spreadFound, nil
+}
+
+// Delete returns ErrNotFound when key is not in the dict.
+func (self *LazyI64StrDict) Delete(key int64) (err error) {
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = self.cache._Trim()
+	if err != nil {
+		return err
+	}
+
+	branchKey := self._GetBranchKey(key)
+	ctx, err := self._GetContextByBranchKey(branchKey)
+	if err != nil {
+		return err
+	}
+	if ctx == nil {
+		return ErrNotFound
+	}
+
+	value, ok := ctx.getValueByKey[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(ctx.getValueByKey, key)
+	ctx.isChanged = true
+	self.cache._Resize(ctx.cacheEntry, -_I64StrRowSize(value))
+
+	if len(ctx.getValueByKey) == 0 {
+		delete(self.contextByBranchKey, branchKey)
+		self.cache._Remove(ctx.cacheEntry)
+		err = self.internalPager.FreePayloadData(ctx.pid)
+		if err != nil {
+			return err
+		}
+		_, err = self.keyFactory.Delete(branchKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (self *LazyI64StrDict) ReleaseCache() {
@@ -172,46 +471,49 @@ func (self *LazyI64StrDict) ReleaseCache() {
 
 	for _, key := range keys {
 		ctx, _ := self.contextByBranchKey[key]
-		fmt.Println("[ReleaseCache]", ctx.ToString())
+		//fmt.Println("[ReleaseCache]", ctx.ToString())
 		delete(self.contextByBranchKey, key)
+		self.cache._Remove(ctx.cacheEntry)
 		ctx = nil
 	}
 }
 
-func (self *LazyI64StrDict) Save(commit bool) {
+func (self *LazyI64StrDict) Save(commit bool) error {
+
+	err := self.storage._CheckDictSave(self)
+	if err != nil {
+		return err
+	}
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
 	//fmt.Println("Save", d.ToString())
 	//isChanged := false
 
 	for _, ctx := range self.contextByBranchKey {
 		if ctx.isChanged {
-
-			w := NewDataStream()
-			w.WriteUInt24(uint32(len(ctx.getValueByKey)))
-
-			for k, v := range ctx.getValueByKey {
-				w.WriteUInt64(uint64(k))
-				w.WriteChunk([]byte(v))
-				//fmt.Println("SAVE CONTEXT", k, v)
+			_, err := ctx._WriteBack()
+			if err != nil {
+				return err
 			}
-
-			ctxData := w.ToBytes()
-			self.internalPager.WritePayloadData(ctx.pid, ctxData)
-
-			ctx.isChanged = false
-			//isChanged = true
-			//fmt.Println("Save", ctx.ToString(), "bytes", len(ctxData))
 		}
 	}
 
 
 	db, err := self.storage.DB(self.dbName)
 	if err != nil {
-		fmt.Println("error dbName", self.dbName)
-		os.Exit(1)
+		return err
 	}
 
-	keyMeta := self.keyFactory.Save()
-	internalPagerMeta := self.internalPager.Save()
+	keyMeta, err := self.keyFactory.Save()
+	if err != nil {
+		return err
+	}
+	internalPagerMeta, err := self.internalPager.Save()
+	if err != nil {
+		return err
+	}
 
 	metaW := NewDataStream()
 	metaW.WriteChunk(internalPagerMeta)
@@ -219,17 +521,23 @@ func (self *LazyI64StrDict) Save(commit bool) {
 
 	metaBytes := metaW.ToBytes()
 
-	db.SetMeta(self.dictName, metaBytes)
+	err = db._SetDictMeta(self.dictName, DICT_KIND_I64STR, metaBytes)
+	if err != nil {
+		return err
+	}
 
 	if commit {
-		self.storage.Save()
+		return self.storage._SaveAfterDict()
 	}
+
+	return nil
 }
 
 func (d *LazyI64StrDict) _NewContext(pid uint32, branchKey int64) *LazyI64StrContext {
 	ctx := new(LazyI64StrContext)
 	ctx.pid = pid
 	ctx.branchKey = branchKey
+	ctx.dict = d
 	ctx.getValueByKey = make(map[int64]string)
 	ctx.isChanged = false
 
@@ -237,41 +545,101 @@ func (d *LazyI64StrDict) _NewContext(pid uint32, branchKey int64) *LazyI64StrCon
 }
 
 
-func (self *LazyI64StrDict) _ReadContext(pid uint32, branchKey int64) *LazyI64StrContext {
+func _I64StrRowSize(value string) int {
+	return 8 + len(value) + PAGE_CACHE_ROW_OVERHEAD
+}
+
+func (ctx *LazyI64StrContext) _Owner() string {
+	return _PagerOwner(ctx.dict.internalPager)
+}
+
+func (ctx *LazyI64StrContext) _IsChanged() bool {
+	return ctx.isChanged
+}
+
+func (ctx *LazyI64StrContext) _WriteBack() (bool, error) {
+
+	w := NewDataStream()
+	w.WriteUInt24(uint32(len(ctx.getValueByKey)))
+
+	for k, v := range ctx.getValueByKey {
+		w.WriteUInt64(uint64(k))
+		w.WriteChunk([]byte(v))
+		//fmt.Println("SAVE CONTEXT", k, v)
+	}
+
+	ctxData := w.ToBytes()
+	err := ctx.dict.internalPager.WritePayloadData(ctx.pid, ctxData)
+	if err != nil {
+		return false, err
+	}
+
+	ctx.isChanged = false
+	//fmt.Println("Save", ctx.ToString(), "bytes", len(ctxData))
+
+	return true, nil
+}
+
+func (ctx *LazyI64StrContext) _Release() {
+	delete(ctx.dict.contextByBranchKey, ctx.branchKey)
+}
+
+func (self *LazyI64StrDict) _ReadContext(pid uint32, branchKey int64) (*LazyI64StrContext, error) {
 
 	ctx := self._NewContext(pid, branchKey)
 
-	data, _ := self.internalPager.ReadPayloadData(pid)
+	data, err := self.internalPager.ReadPayloadData(pid)
+	if err != nil {
+		return nil, err
+	}
 
-			
-	rd := NewDataStreamFromBuffer(data)
-	rowsCount := int(rd.ReadUInt24())
-	for i:=0; i<rowsCount; i++ {
-		key := int64(rd.ReadUInt64())
-		valChunk := rd.ReadChunk()
-		ctx.getValueByKey[key] = string(valChunk)
+	err = _DecodePage("LazyI64StrContext", pid, data, func(rd *DataStream) {
+		rowsCount := int(rd.ReadUInt24())
+		for i:=0; i<rowsCount; i++ {
+			key := int64(rd.ReadUInt64())
+			valChunk := rd.ReadChunk()
+			ctx.getValueByKey[key] = string(valChunk)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
-	return ctx
+
+	return ctx, nil
 }
 
-func (self *LazyI64StrDict) _GetContextByBranchKey(branchKey int64) *LazyI64StrContext {
+func (self *LazyI64StrDict) _GetContextByBranchKey(branchKey int64) (*LazyI64StrContext, error) {
 
 	ctx, ok := self.contextByBranchKey[branchKey]
-	if !ok {
-		page := self.keyFactory.GetPage(branchKey)	
+	if ok {
+		self.cache._Touch(ctx.cacheEntry)
+	} else {
+		page, err := self.keyFactory.GetPage(branchKey)	
+		if err != nil {
+			return nil, err
+		}
 		
 		if page != nil {
 			_ctxPageId, ok := page.Get(branchKey)
 			if ok {
 				ctxPageId := uint32(_ctxPageId)
-				ctx = self._ReadContext(ctxPageId, branchKey)
+				ctx, err = self._ReadContext(ctxPageId, branchKey)
+				if err != nil {
+					return nil, err
+				}
 				self.contextByBranchKey[branchKey] = ctx
 
-				return ctx
+				size := 0
+				for _, v := range ctx.getValueByKey {
+					size += _I64StrRowSize(v)
+				}
+				ctx.cacheEntry = self.cache._Add(ctx, size, true)
+
+				return ctx, nil
 			}
 		}
 	}
 
-	return ctx
+	return ctx, nil
 
 }
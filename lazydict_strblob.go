@@ -2,8 +2,8 @@ package gokvdb
 
 
 import (
-	"os"
 	"fmt"
+	"errors"
 )
 
 
@@ -18,28 +18,45 @@ type LazyStrBlobDict struct {
 	storage *Storage
 }
 
-func NewStrBlobDict(s *Storage, dbName string, dictName string) *LazyStrBlobDict {
+func NewStrBlobDict(s *Storage, dbName string, dictName string) (*LazyStrBlobDict, error) {
+	return _NewStrBlobDict(s, dbName, dictName, STR_INDEX_HASH)
+}
+
+// NewOrderedStrBlobDict opens the dict with an ordered key index, see
+// NewOrderedStrI64Dict.
+func NewOrderedStrBlobDict(s *Storage, dbName string, dictName string) (*LazyStrBlobDict, error) {
+	return _NewStrBlobDict(s, dbName, dictName, STR_INDEX_ORDERED)
+}
+
+func _NewStrBlobDict(s *Storage, dbName string, dictName string, indexKind byte) (*LazyStrBlobDict, error) {
 
 	dict := new(LazyStrBlobDict)
 	dict.storage = s
 	dict.dbName = dbName
 	dict.dictName = dictName
-	dict.idByKeyDict = NewStrI64Dict(s, dbName, fmt.Sprintf("%s_idByKey", dictName))
-	
 
 	db, err := s.DB(dbName)
 	if err != nil {
-		fmt.Println("error dbName", dbName)
-		os.Exit(1)
+		return nil, err
+	}
+	err = db._CheckDictKind(dictName, DICT_KIND_STRBLOB)
+	if err != nil {
+		return nil, err
 	}
 
+	idByKeyDict, err := _NewStrI64Dict(s, dbName, dictName + STRBLOB_KEYS_SUFFIX, indexKind)
+	if err != nil {
+		return nil, err
+	}
+	dict.idByKeyDict = idByKeyDict
+
 	var lastId int64
 	var internalPagerMeta []byte
 	var btMeta []byte
 
-	metaData, ok := db.GetMeta(dictName)
+	metaData, err := db.GetMeta(dictName)
 
-	if ok {
+	if err == nil {
 		rd := NewDataStreamFromBuffer(metaData)
 
 		lastId = int64(rd.ReadUInt64())
@@ -47,60 +64,71 @@ func NewStrBlobDict(s *Storage, dbName string, dictName string) *LazyStrBlobDict
 		internalPagerMeta = rd.ReadChunk()
 		btMeta = rd.ReadChunk()
 
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
 	}
 
-	internalPageSize := uint16(128)
-	internalPager := NewInternalPager(s.pager, internalPageSize, internalPagerMeta)
-	bt := NewBTreeBlobMap(internalPager, btMeta)
+	internalPageSize := s.options._InternalPageSize(128)
+	internalPager, err := NewInternalPager(s.pager, internalPageSize, internalPagerMeta, _DictOwner(dbName, dictName))
+	if err != nil {
+		return nil, err
+	}
+	bt, err := NewBTreeBlobMap(internalPager, btMeta)
+	if err != nil {
+		return nil, err
+	}
 
 	dict.lastId = lastId
 	dict.internalPager = internalPager
 	dict.bt = bt
 
-	fmt.Println("NewStrBlobDict", dict.ToString())
+	//fmt.Println("NewStrBlobDict", dict.ToString())
 
-	return dict
+	return dict, nil
 }
 
 func (d *LazyStrBlobDict) ToString() string {
 	return fmt.Sprintf("<LazyStrBlobDict lastId=%v>", d.lastId)
 }
 
-type LazyStrBlobItem struct {
-	id int64
-	key string
-	dict *LazyStrBlobDict
+func (d *LazyStrBlobDict) Items() *Cursor[string, []byte] {
+	return d._Items(d.idByKeyDict.Items())
 }
 
-func (i *LazyStrBlobItem) Key() string {
-	return i.key
+// Range walks the keys in [startKey, endKey) in order. An empty endKey
+// runs to the last key. It needs an ordered dict.
+func (d *LazyStrBlobDict) Range(startKey string, endKey string) *Cursor[string, []byte] {
+	return d._Items(d.idByKeyDict.Range(startKey, endKey))
 }
 
-func (i *LazyStrBlobItem) Value() []byte {
-	data, ok := i.dict.bt.Get(i.id)
-	if !ok {
-		fmt.Println("Error no key", i.key)
-	}
-	return data
+// PrefixScan walks the keys starting with prefix in order. It needs an
+// ordered dict.
+func (d *LazyStrBlobDict) PrefixScan(prefix string) *Cursor[string, []byte] {
+	return d._Items(d.idByKeyDict.PrefixScan(prefix))
 }
 
-func (d *LazyStrBlobDict) Items() chan LazyStrBlobItem {
-
-	q := make(chan LazyStrBlobItem)
+func (d *LazyStrBlobDict) IsOrdered() bool {
+	return d.idByKeyDict.IsOrdered()
+}
 
-	go func(ch chan LazyStrBlobItem) {
+// _Items reads the value of every key from ids as the cursor reaches it.
+func (d *LazyStrBlobDict) _Items(ids *Cursor[string, int64]) *Cursor[string, []byte] {
+	return _NewCursor(func(yield func(string, []byte) bool) error {
+		defer ids.Close()
 
-		for item := range d.idByKeyDict.Items() {
-			key := item.Key()
-			id := item.Value()
+		for ids.Next() {
+			value, err := d.bt.Get(ids.Value())
+			if err != nil {
+				return err
+			}
 
-			ch <- LazyStrBlobItem{id: id, key: key, dict: d}
+			if !yield(ids.Key(), value) {
+				return nil
+			}
 		}
 
-		close(ch)
-	} (q)
-
-	return q
+		return ids.Err()
+	})
 }
 
 func (d *LazyStrBlobDict) _CreateId() int64 {
@@ -109,44 +137,114 @@ func (d *LazyStrBlobDict) _CreateId() int64 {
 	return id
 }
 
-func (d *LazyStrBlobDict) Set(key string, value []byte) {
+func (d *LazyStrBlobDict) Set(key string, value []byte) error {
 
-	id, ok :=	d.idByKeyDict.Get(key)
-	if !ok {
+	err := _CheckDropped(d.internalPager)
+	if err != nil {
+		return err
+	}
+
+	id, err :=	d.idByKeyDict.Get(key)
+	if errors.Is(err, ErrNotFound) {
 		id = d._CreateId()
-		d.idByKeyDict.Set(key, id)
+		err = d.idByKeyDict.Set(key, id)
+	}
+	if err != nil {
+		return err
 	}
 
-	d.bt.Set(id, value)
+	return d.bt.Set(id, value)
 }
 
-func (d *LazyStrBlobDict) Get(key string) ([]byte, bool) {
-	id, ok :=	d.idByKeyDict.Get(key)
-	if ok {
-		data, ok := d.bt.Get(id)
-		return data, ok
+// Get returns ErrNotFound when key is not in the dict.
+func (d *LazyStrBlobDict) Get(key string) ([]byte, error) {
+	id, err :=	d.idByKeyDict.Get(key)
+	if err != nil {
+		return nil, err
 	}
-	return nil, false
+	return d.bt.Get(id)
 }
 
 
-func (d *LazyStrBlobDict) _GetDB() *DBContext {
+// GetMany returns the values of keys in their order, with the bitmap of
+// the keys found. The ids are looked up as LazyStrI64Dict.GetMany does,
+// then the values are read in ascending page id order.
+func (d *LazyStrBlobDict) GetMany(keys []string) ([][]byte, Found, error) {
+	unique, at := _UniqueKeys(keys, _LessStr)
+	ids, idFound, err := d.idByKeyDict.GetMany(unique)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	db, err := d.storage.DB(d.dbName)
+	var foundIds []int64
+	var foundIndexes []int
+	for i, id := range ids {
+		if idFound.Has(i) {
+			foundIds = append(foundIds, id)
+			foundIndexes = append(foundIndexes, i)
+		}
+	}
+
+	idValues, valueFound, err := d.bt._GetMany(foundIds)
 	if err != nil {
-		fmt.Println("Error dbName", d.dbName)
-		os.Exit(1)
+		return nil, nil, err
 	}
 
-	return db
+	values := make([][]byte, len(unique))
+	found := make([]bool, len(unique))
+	for k, i := range foundIndexes {
+		values[i] = idValues[k]
+		found[i] = valueFound[k]
+	}
+
+	spread, spreadFound := _SpreadMany(values, found, at)
+	return spread, spreadFound, nil
 }
 
-func (d *LazyStrBlobDict) Save(commit bool) {
+// Delete returns ErrNotFound when key is not in the dict.
+func (d *LazyStrBlobDict) Delete(key string) error {
+	err := _CheckDropped(d.internalPager)
+	if err != nil {
+		return err
+	}
 
-	db := d._GetDB()
+	id, err :=	d.idByKeyDict.Get(key)
+	if err != nil {
+		return err
+	}
 
-	btMeta := d.bt.Save()
-	internalPagerMeta := d.internalPager.Save()
+	err = d.idByKeyDict.Delete(key)
+	if err != nil {
+		return err
+	}
+
+	return d.bt.Delete(id)
+}
+
+func (d *LazyStrBlobDict) Save(commit bool) error {
+
+	err := d.storage._CheckDictSave(d)
+	if err != nil {
+		return err
+	}
+	err = _CheckDropped(d.internalPager)
+	if err != nil {
+		return err
+	}
+
+	db, err := d.storage.DB(d.dbName)
+	if err != nil {
+		return err
+	}
+
+	btMeta, err := d.bt.Save()
+	if err != nil {
+		return err
+	}
+	internalPagerMeta, err := d.internalPager.Save()
+	if err != nil {
+		return err
+	}
 
 	metaW := NewDataStream()
 
@@ -155,11 +253,10 @@ func (d *LazyStrBlobDict) Save(commit bool) {
 	metaW.WriteChunk(internalPagerMeta)
 	metaW.WriteChunk(btMeta)
 
-	db.SetMeta(d.dictName, metaW.ToBytes())
-
-	d.idByKeyDict.Save(commit)
-
-	if commit {
-		d.storage.Save()
+	err = db._SetDictMeta(d.dictName, DICT_KIND_STRBLOB, metaW.ToBytes())
+	if err != nil {
+		return err
 	}
+
+	return d.idByKeyDict._Save(commit)
 }
@@ -2,8 +2,9 @@ package gokvdb
 
 
 import (
-	"os"
 	"fmt"
+	"sort"
+	"errors"
 	"hash/fnv"
 	"sync"
 )
@@ -11,6 +12,10 @@ import (
 const (
 	LAZYSTRI64_DATA = 1
 	LAZYSTRI64_BRANCH = 2
+
+	// the key index of a string keyed dict, saved after the dict meta
+	STR_INDEX_HASH byte = 0
+	STR_INDEX_ORDERED byte = 1
 )
 
 type SimpleStrI64Factory struct {
@@ -23,7 +28,11 @@ type SimpleStrI64Factory struct {
 	pager IPager
 	
 	splitCount int
-	rwlock sync.Mutex
+	splitKeys int
+	splitsPerSave int
+	// rwlock guards the context maps, loaded contexts are looked up under
+	// its read lock so several Gets can run at once
+	rwlock sync.RWMutex
 }
 
 type SimpleStrI64Context struct {
@@ -38,13 +47,15 @@ type SimpleStrI64Context struct {
 	dict *SimpleStrI64Factory
 }
 
-func NewSimpleStrI64Factory(pager IPager, data []byte) *SimpleStrI64Factory {
+func NewSimpleStrI64Factory(pager IPager, data []byte) (*SimpleStrI64Factory, error) {
 
 	self := new(SimpleStrI64Factory)
 	self.pager = pager
 	self.contextById = make(map[uint32]*SimpleStrI64Context)
 	self.pageIdByContextId = make(map[uint32]uint32)
 	self.splitCount = 0
+	self.splitKeys = _OptionsOf(pager)._SplitKeys()
+	self.splitsPerSave = _OptionsOf(pager)._SplitsPerSave()
 	
 	var lastContextId uint32
 	var rootContextId uint32
@@ -52,21 +63,24 @@ func NewSimpleStrI64Factory(pager IPager, data []byte) *SimpleStrI64Factory {
 	//fmt.Println("NewSimpleStrI64Factory", data)
 	if data != nil {
 
+		err := _DecodePage("SimpleStrI64Factory meta", 0, data, func(rd *DataStream) {
 
-		rd := NewDataStreamFromBuffer(data)
-
-		lastContextId = rd.ReadUInt32()
-		rootContextId = rd.ReadUInt32()
+			lastContextId = rd.ReadUInt32()
+			rootContextId = rd.ReadUInt32()
 
-		//internalPagerMeta = rd.ReadChunk()
+			//internalPagerMeta = rd.ReadChunk()
 
-		rowsCount := int(rd.ReadUInt32())
+			rowsCount := int(rd.ReadUInt32())
 
-		for i:=0; i<rowsCount; i++ {
-			ctxId := rd.ReadUInt32()
-			pgId := rd.ReadUInt32()
-			self.pageIdByContextId[ctxId] = pgId	
-			//fmt.Println("NewStrI64Dict LOAD item", "ctxId", ctxId, "pid", pgId)
+			for i:=0; i<rowsCount; i++ {
+				ctxId := rd.ReadUInt32()
+				pgId := rd.ReadUInt32()
+				self.pageIdByContextId[ctxId] = pgId	
+				//fmt.Println("NewStrI64Dict LOAD item", "ctxId", ctxId, "pid", pgId)
+			}
+		})
+		if err != nil {
+			return nil, err
 		}
 
 	}
@@ -74,58 +88,125 @@ func NewSimpleStrI64Factory(pager IPager, data []byte) *SimpleStrI64Factory {
 	self.lastContextId = lastContextId
 	self.rootContextId = rootContextId
 
-	fmt.Println("NewSimpleStrI64Factory", self.ToString())
+	//fmt.Println("NewSimpleStrI64Factory", self.ToString())
 
-	return self
+	return self, nil
 }
 
 func (self *SimpleStrI64Factory) ToString() string {
 	return fmt.Sprintf("<SimpleStrI64Factory lastContextId=%v rootContextId=%v>", self.lastContextId, self.rootContextId)
 }
 
-type SimpleStrI64Item struct {
-	key string
-	value int64
+func (d *SimpleStrI64Factory) Items() *Cursor[string, int64] {
+	return _NewCursor(func(yield func(string, int64) bool) error {
+		root, err := d._GetRoot()
+		if err != nil {
+			return err
+		}
+		_, err = root._Each(yield)
+		return err
+	})
 }
 
-func (i *SimpleStrI64Item) Key() string {
-	return i.key
+func (d *SimpleStrI64Factory) Set(key string, value int64) error {
+	root, err := d._GetRoot()
+	if err != nil {
+		return err
+	}
+	return root.Set(key, value)
 }
 
-func (i *SimpleStrI64Item) Value() int64 {
-	return i.value
+// Get returns ErrNotFound when key is not in the factory.
+func (d *SimpleStrI64Factory) Get(key string) (int64, error) {
+	root, err := d._GetRoot()
+	if err != nil {
+		return 0, err
+	}
+	//fmt.Println("SimpleStrI64Factory root", root)	
+	return root.Get(key)
 }
 
-func (d *SimpleStrI64Factory) Items() chan SimpleStrI64Item {
 
-	q := make(chan SimpleStrI64Item)
+// _GetMany looks up every key a depth at a time, the contexts of a depth
+// are read in ascending page id order. found[i] tells whether keys[i] is
+// in the factory.
+func (d *SimpleStrI64Factory) _GetMany(keys []string) ([]int64, []bool, error) {
 
-	go func(ch chan SimpleStrI64Item) {
+	values := make([]int64, len(keys))
+	found := make([]bool, len(keys))
 
-		root := d._GetRoot()
-		
-		root.TakeItems(ch)
+	d.rwlock.RLock()
+	rootContextId := d.rootContextId
+	d.rwlock.RUnlock()
 
-		close(ch)
-	} (q)
+	if rootContextId == 0 || len(keys) == 0 {
+		return values, found, nil
+	}
 
-	return q
-}
+	indexes := make([]int, len(keys))
+	for i := range keys {
+		indexes[i] = i
+	}
+	indexesByContextId := map[uint32][]int{rootContextId: indexes}
+
+	for len(indexesByContextId) > 0 {
+
+		nextIndexesByContextId := make(map[uint32][]int)
+
+		for _, ctxId := range d._SortContextIds(indexesByContextId) {
+
+			ctx, err := d._GetContextById(ctxId)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for _, i := range indexesByContextId[ctxId] {
+				if ctx.ctxType == LAZYSTRI64_BRANCH {
+					childCtxId, ok := ctx.childContextIdByBranchKey[_StrBranchKey(keys[i], ctx.depth)]
+					if ok {
+						nextIndexesByContextId[childCtxId] = append(nextIndexesByContextId[childCtxId], i)
+					}
+				} else {
+					values[i], found[i] = ctx.valueByKey[keys[i]]
+				}
+			}
+		}
+
+		indexesByContextId = nextIndexesByContextId
+	}
 
-func (d *SimpleStrI64Factory) Set(key string, value int64) {
-	root := d._GetRoot()
-	root.Set(key, value)
+	return values, found, nil
 }
 
-func (d *SimpleStrI64Factory) Get(key string) (int64, bool) {
-	root := d._GetRoot()
-	//fmt.Println("SimpleStrI64Factory root", root)	
-	val, ok := root.Get(key)
-	return val, ok
+// _SortContextIds returns the context ids of indexesByContextId in the
+// order of their pages.
+func (d *SimpleStrI64Factory) _SortContextIds(indexesByContextId map[uint32][]int) []uint32 {
+	d.rwlock.RLock()
+	defer d.rwlock.RUnlock()
+
+	ctxIds := make([]uint32, 0, len(indexesByContextId))
+	for ctxId := range indexesByContextId {
+		ctxIds = append(ctxIds, ctxId)
+	}
+	sort.Slice(ctxIds, func(i, j int) bool {
+		return d.pageIdByContextId[ctxIds[i]] < d.pageIdByContextId[ctxIds[j]]
+	})
+
+	return ctxIds
 }
 
+// Delete returns ErrNotFound when key is not in the factory.
+func (d *SimpleStrI64Factory) Delete(key string) error {
+	root, err := d._GetRoot()
+	if err != nil {
+		return err
+	}
+	return root.Delete(key)
+}
 
 func (self *SimpleStrI64Factory) ReleaseCache() {
+	self.rwlock.Lock()
+	defer self.rwlock.Unlock()
 
 	var keys []uint32
 
@@ -136,50 +217,28 @@ func (self *SimpleStrI64Factory) ReleaseCache() {
 	}
 
 	for _, key := range keys {
-		ctx, _ := self.contextById[key]
-		fmt.Println("[ReleaseCache]", ctx.ToString())
 		delete(self.contextById, key)
-		ctx = nil
 	}
 
 }
 
-func (d *SimpleStrI64Factory) Save() []byte {
+func (d *SimpleStrI64Factory) Save() ([]byte, error) {
 
 	d.rwlock.Lock()
 	defer d.rwlock.Unlock()
 
 	for _, ctx := range d.contextById {
 		if ctx.isChanged {
-			//
-			ctx.isChanged = false
-
-			w := NewDataStream()
-			w.WriteUInt8(ctx.ctxType)
-			w.WriteUInt8(ctx.depth)
-
-			switch ctx.ctxType {
-			case LAZYSTRI64_DATA:
-				w.WriteUInt24(uint32(len(ctx.valueByKey)))
-
-				for k, v := range ctx.valueByKey {
-					w.WriteHStr(k)
-					w.WriteUInt64(uint64(v))
-				}
 
-			case LAZYSTRI64_BRANCH:
-				w.WriteUInt24(uint32(len(ctx.childContextIdByBranchKey)))
+			ctxData := ctx.ToBytes()
 
-				for k, v := range ctx.childContextIdByBranchKey {
-					w.WriteUInt32(uint32(k))
-					w.WriteUInt32(v)
-				}
+			//bt.Set(int64(ctxId), ctxData)
+			err := d.pager.WritePayloadData(ctx.pid, ctxData)
+			if err != nil {
+				return nil, err
 			}
 
-			ctxData := w.ToBytes()
-
-			//bt.Set(int64(ctxId), ctxData)
-			d.pager.WritePayloadData(ctx.pid, ctxData)
+			ctx.isChanged = false
 
 			//fmt.Println("LazyStrI64Dict SAVE ctxId=", ctxId, "bytes", len(ctxData), "rows", len( ctx.valueByKey))
 		}
@@ -203,72 +262,114 @@ func (d *SimpleStrI64Factory) Save() []byte {
 
 	d.splitCount = 0
 
-	return metaW.ToBytes()
+	return metaW.ToBytes(), nil
 }
 
 
 
-func (d *SimpleStrI64Factory) _GetRoot() *SimpleStrI64Context {
-	if d.rootContextId == 0 {
-		root := d._CreateContext(LAZYSTRI64_DATA, 0)
-		d.rootContextId = root.id
-		return root
+func (d *SimpleStrI64Factory) _GetRoot() (*SimpleStrI64Context, error) {
+	d.rwlock.RLock()
+	rootContextId := d.rootContextId
+	d.rwlock.RUnlock()
+
+	if rootContextId == 0 {
+		d.rwlock.Lock()
+		defer d.rwlock.Unlock()
+
+		if d.rootContextId == 0 {
+			root := d._AddContext(LAZYSTRI64_DATA, 0)
+			d.rootContextId = root.id
+			return root, nil
+		}
+		return d._GetOrLoadContext(d.rootContextId)
 	}
 
-	return d._GetContextById(d.rootContextId)
+	return d._GetContextById(rootContextId)
 }
 
-func (self *SimpleStrI64Factory) _LoadContext(id uint32, pid uint32) *SimpleStrI64Context {
-	self.rwlock.Lock()
-	defer self.rwlock.Unlock()
+// _LoadContext runs with rwlock held.
+func (self *SimpleStrI64Factory) _LoadContext(id uint32, pid uint32) (*SimpleStrI64Context, error) {
 
-	data, _ := self.pager.ReadPayloadData(pid)
-	rd := NewDataStreamFromBuffer(data)
+	data, err := self.pager.ReadPayloadData(pid)
+	if err != nil {
+		return nil, err
+	}
 
-	ctxType := rd.ReadUInt8()
-	depth := rd.ReadUInt8()
+	var ctx *SimpleStrI64Context
 
-	ctx := self._NewContext(id, pid, ctxType, depth)	
+	err = _DecodePage("SimpleStrI64Context", pid, data, func(rd *DataStream) {
+		ctxType := rd.ReadUInt8()
+		depth := rd.ReadUInt8()
 
-	var rowsCount int
-	rowsCount = int(rd.ReadUInt24())
-	//fmt.Println("_GetContext", ctx.ToString(), "rowsCount", rowsCount)
-	switch ctx.ctxType {
-	case LAZYSTRI64_DATA:
+		ctx = self._NewContext(id, pid, ctxType, depth)	
 
-		for i:=0; i<rowsCount; i++ {
-			k := rd.ReadHStr()
-			v := int64(rd.ReadUInt64())
-			ctx.valueByKey[k] = v
-		}
+		var rowsCount int
+		rowsCount = int(rd.ReadUInt24())
+		//fmt.Println("_GetContext", ctx.ToString(), "rowsCount", rowsCount)
+		switch ctx.ctxType {
+		case LAZYSTRI64_DATA:
 
-	case LAZYSTRI64_BRANCH:
+			for i:=0; i<rowsCount; i++ {
+				k := rd.ReadHStr()
+				v := int64(rd.ReadUInt64())
+				ctx.valueByKey[k] = v
+			}
+
+		case LAZYSTRI64_BRANCH:
 
-		for i:=0; i<rowsCount; i++ {
-			k := int32(rd.ReadUInt32())
-			v := rd.ReadUInt32()
-			ctx.childContextIdByBranchKey[k] = v
+			for i:=0; i<rowsCount; i++ {
+				k := int32(rd.ReadUInt32())
+				v := rd.ReadUInt32()
+				ctx.childContextIdByBranchKey[k] = v
+			}
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return ctx
+	if ctx.ctxType != LAZYSTRI64_DATA && ctx.ctxType != LAZYSTRI64_BRANCH {
+		return nil, _CorruptPageError("SimpleStrI64Context", pid, "ctxType=%v", ctx.ctxType)
+	}
+
+	return ctx, nil
 }
 
-func (self *SimpleStrI64Factory) _GetContextById(id uint32) *SimpleStrI64Context {
+func (self *SimpleStrI64Factory) _GetContextById(id uint32) (*SimpleStrI64Context, error) {
+	self.rwlock.RLock()
+	ctx, ok := self.contextById[id]
+	self.rwlock.RUnlock()
+
+	if ok {
+		return ctx, nil
+	}
+
+	self.rwlock.Lock()
+	defer self.rwlock.Unlock()
+
+	return self._GetOrLoadContext(id)
+}
+
+// _GetOrLoadContext runs with rwlock held, another Get may have loaded the
+// context while this one waited for it.
+func (self *SimpleStrI64Factory) _GetOrLoadContext(id uint32) (*SimpleStrI64Context, error) {
 	ctx, ok := self.contextById[id]
 
 	if !ok {
 		pid, ok := self.pageIdByContextId[id]
 		if !ok {
-			fmt.Printf("[ERROR] no contextId=%v pid=%v\n", id, pid)
-			os.Exit(1)
+			return nil, fmt.Errorf("%w: no contextId=%v", ErrCorruptPage, id)
 		}		
 		
-		ctx = self._LoadContext(id, pid)
+		var err error
+		ctx, err = self._LoadContext(id, pid)
+		if err != nil {
+			return nil, err
+		}
 		self.contextById[id] = ctx
 	}
 
-	return ctx
+	return ctx, nil
 }
 
 func (d *SimpleStrI64Factory) _NewContext(id uint32, pid uint32, ctxType byte, depth byte) *SimpleStrI64Context {
@@ -290,6 +391,10 @@ func (d *SimpleStrI64Factory) _CreateContext(ctxType byte, depth byte) *SimpleSt
 	d.rwlock.Lock()
 	defer d.rwlock.Unlock()
 
+	return d._AddContext(ctxType, depth)
+}
+
+func (d *SimpleStrI64Factory) _AddContext(ctxType byte, depth byte) *SimpleStrI64Context {
 	id := d.lastContextId + 1
 	d.lastContextId = id
 
@@ -313,31 +418,34 @@ func _GetBranchSize(depth byte) int32 {
 	return 4096
 }
 
-func (c *SimpleStrI64Context) GetChildContext(key string) *SimpleStrI64Context {
-
+// _StrBranchKey returns the branch key of key in a branch context of depth.
+func _StrBranchKey(key string, depth byte) int32 {
 	hashKey := _HashString(key)
-	branchSize := _GetBranchSize(c.depth)
-	branchKey := (hashKey / branchSize) * branchSize
+	branchSize := _GetBranchSize(depth)
+	return (hashKey / branchSize) * branchSize
+}
 
-	return c.GetChildContextByBranchKey(branchKey)
+func (c *SimpleStrI64Context) GetChildContext(key string) (*SimpleStrI64Context, error) {
+	return c.GetChildContextByBranchKey(_StrBranchKey(key, c.depth))
 }
 
-func (c *SimpleStrI64Context) GetChildContextByBranchKey(branchKey int32) *SimpleStrI64Context {
+func (c *SimpleStrI64Context) GetChildContextByBranchKey(branchKey int32) (*SimpleStrI64Context, error) {
 
 	childCtxId, ok := c.childContextIdByBranchKey[branchKey]
 	if ok {
 		return c.dict._GetContextById(childCtxId)
 	}
 
-	return nil
+	return nil, nil
 }
 
-func (c *SimpleStrI64Context) GetOrCreateChildContext(key string) *SimpleStrI64Context {
-	hashKey := _HashString(key)
-	branchSize := _GetBranchSize(c.depth)
-	branchKey := (hashKey / branchSize) * branchSize
+func (c *SimpleStrI64Context) GetOrCreateChildContext(key string) (*SimpleStrI64Context, error) {
+	branchKey := _StrBranchKey(key, c.depth)
 
-	ctx := c.GetChildContextByBranchKey(branchKey)
+	ctx, err := c.GetChildContextByBranchKey(branchKey)
+	if err != nil {
+		return nil, err
+	}
 
 	if ctx == nil {
 		ctx = c.dict._CreateContext(LAZYSTRI64_DATA, c.depth + 1)
@@ -345,7 +453,34 @@ func (c *SimpleStrI64Context) GetOrCreateChildContext(key string) *SimpleStrI64C
 		c.isChanged = true
 	}
 	
-	return ctx
+	return ctx, nil
+}
+
+func (c *SimpleStrI64Context) ToBytes() []byte {
+
+	w := NewDataStream()
+	w.WriteUInt8(c.ctxType)
+	w.WriteUInt8(c.depth)
+
+	switch c.ctxType {
+	case LAZYSTRI64_DATA:
+		w.WriteUInt24(uint32(len(c.valueByKey)))
+
+		for k, v := range c.valueByKey {
+			w.WriteHStr(k)
+			w.WriteUInt64(uint64(v))
+		}
+
+	case LAZYSTRI64_BRANCH:
+		w.WriteUInt24(uint32(len(c.childContextIdByBranchKey)))
+
+		for k, v := range c.childContextIdByBranchKey {
+			w.WriteUInt32(uint32(k))
+			w.WriteUInt32(v)
+		}
+	}
+
+	return w.ToBytes()
 }
 
 func (c *SimpleStrI64Context) ToString() string {
@@ -353,77 +488,195 @@ func (c *SimpleStrI64Context) ToString() string {
 }
 
 
-func (c *SimpleStrI64Context) TakeItems(q chan SimpleStrI64Item) {
+// _Each calls yield for every item below c until it returns false and
+// reports whether it ran to the end.
+func (c *SimpleStrI64Context) _Each(yield func(key string, value int64) bool) (bool, error) {
 
 	if c.ctxType == LAZYSTRI64_BRANCH {
 
+		var ctxIds []uint32
 		for _, ctxId := range c.childContextIdByBranchKey {
+			ctxIds = append(ctxIds, ctxId)
+		}
 
-			childChildCtx := c.dict._GetContextById(ctxId)
-			childChildCtx.TakeItems(q)
+		for _, ctxId := range ctxIds {
+
+			childChildCtx, err := c.dict._GetContextById(ctxId)
+			if err != nil {
+				return false, err
+			}
+			more, err := childChildCtx._Each(yield)
+			if err != nil || !more {
+				return false, err
+			}
 
 		}
 
-		return
+		return true, nil
 	}
 
+	// copy the context first, yield may change it
+	keys := make([]string, 0, len(c.valueByKey))
+	values := make([]int64, 0, len(c.valueByKey))
 	for k, v := range c.valueByKey {
-		q <- SimpleStrI64Item{key: k, value: v}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+
+	for i, k := range keys {
+		if !yield(k, values[i]) {
+			return false, nil
+		}
 	}
 
+	return true, nil
 }
 
-func (c *SimpleStrI64Context) Get(key string) (int64, bool) {
+func (c *SimpleStrI64Context) Get(key string) (int64, error) {
 	//fmt.Println(c.ToString(), "Get", key)
 	if c.ctxType == LAZYSTRI64_BRANCH {
 
-		branchCtx := c.GetChildContext(key)
+		branchCtx, err := c.GetChildContext(key)
+		if err != nil {
+			return 0, err
+		}
 		if branchCtx == nil {
-			return 0, false
+			return 0, ErrNotFound
 		}
 
 		return branchCtx.Get(key)
 	}
 
 	val, ok := c.valueByKey[key]
+	if !ok {
+		return 0, ErrNotFound
+	}
 
-	return val, ok
+	return val, nil
 }
 
-func (c *SimpleStrI64Context) Set(key string, value int64) {
-
-	//fmt.Println("[SET]", c.ToString(), key, value)
+func (c *SimpleStrI64Context) Delete(key string) error {
 
 	if c.ctxType == LAZYSTRI64_BRANCH {
 
-		branchCtx := c.GetOrCreateChildContext(key)
+		branchCtx, err := c.GetChildContext(key)
+		if err != nil {
+			return err
+		}
+		if branchCtx == nil {
+			return ErrNotFound
+		}
 
-		branchCtx.Set(key, value)
-		return
+		return branchCtx.Delete(key)
 	}
 
-	if c.ctxType != LAZYSTRI64_DATA {
-		fmt.Println("c.ctxType != LAZYSTRI64_DATA")
-		os.Exit(1)
+	_, ok := c.valueByKey[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(c.valueByKey, key)
+	c.isChanged = true
+
+	return nil
+}
+
+func (c *SimpleStrI64Context) Set(key string, value int64) error {
+
+	//fmt.Println("[SET]", c.ToString(), key, value)
+
+	if c.ctxType == LAZYSTRI64_BRANCH {
+
+		branchCtx, err := c.GetOrCreateChildContext(key)
+		if err != nil {
+			return err
+		}
+
+		return branchCtx.Set(key, value)
 	}
 	
 	c.valueByKey[key] = value
 	c.isChanged = true
 
-	if c.dict.splitCount < 8 {
-		if c.depth < 2 && len(c.valueByKey) > 8192 {
+	return c._SplitIfFull()
+}
+
+// _SplitIfFull turns a data context over splitKeys keys into a branch and
+// sets its keys into new children.
+func (c *SimpleStrI64Context) _SplitIfFull() error {
+
+	if c.dict.splitCount < c.dict.splitsPerSave {
+		if c.depth < 2 && len(c.valueByKey) > c.dict.splitKeys {
 			c.ctxType = LAZYSTRI64_BRANCH
 
 			for k, v := range c.valueByKey {
 				//fmt.Println("[SPLIT]", c.ToString(), k, v)
-				c.Set(k, v)
+				err := c.Set(k, v)
+				if err != nil {
+					return err
+				}
 			}
 
 			c.valueByKey = nil
 			c.isChanged = true
-			c.dict.splitCount += 1			
+			c.dict.splitCount += 1
 		}
 	}
+
+	return nil
+}
+
+// _GetOrCreateDataContext walks down from c to the data context of key.
+func (c *SimpleStrI64Context) _GetOrCreateDataContext(key string) (*SimpleStrI64Context, error) {
+	ctx := c
+	for ctx.ctxType == LAZYSTRI64_BRANCH {
+		child, err := ctx.GetOrCreateChildContext(key)
+		if err != nil {
+			return nil, err
+		}
+		ctx = child
+	}
+	return ctx, nil
+}
+
+// _SetMany sets pairs sorted by the hash of their key. The pairs of one
+// data context follow each other, they are set together and the context
+// is split at most once.
+func (d *SimpleStrI64Factory) _SetMany(pairs []StrI64Pair) error {
+	root, err := d._GetRoot()
+	if err != nil {
+		return err
+	}
+
+	for i:=0; i<len(pairs); {
+		ctx, err := root._GetOrCreateDataContext(pairs[i].Key)
+		if err != nil {
+			return err
+		}
+
+		j := i + 1
+		if ctx.depth == 0 {
+			j = len(pairs)
+		} else {
+			branchKey := _StrBranchKey(pairs[i].Key, ctx.depth - 1)
+			for j < len(pairs) && _StrBranchKey(pairs[j].Key, ctx.depth - 1) == branchKey {
+				j++
+			}
+		}
+
+		for _, pair := range pairs[i:j] {
+			ctx.valueByKey[pair.Key] = pair.Value
+		}
+		ctx.isChanged = true
+
+		err = ctx._SplitIfFull()
+		if err != nil {
+			return err
+		}
+		i = j
+	}
+
+	return nil
 }
 
 
@@ -436,6 +689,199 @@ func _HashString(key string) int32 {
 
 
 
+func _NewStrI64Index(pager IPager, indexKind byte, meta []byte) (IStrI64Index, error) {
+	if indexKind == STR_INDEX_ORDERED {
+		bt, err := NewStrI64BTree(pager, meta)
+		if err != nil {
+			return nil, err
+		}
+		return bt, nil
+	}
+
+	factory, err := NewSimpleStrI64Factory(pager, meta)
+	if err != nil {
+		return nil, err
+	}
+	return factory, nil
+}
+
+// _ReadStrIndexKind reads the index kind saved after the meta in rd, dicts
+// saved without one are hashed. Asking for an ordered index on a hashed
+// dict fails with ErrNotOrdered.
+func _ReadStrIndexKind(rd *DataStream, indexKind byte, owner string) (byte, error) {
+
+	storedKind := STR_INDEX_HASH
+	if rd.Remaining() > 0 {
+		storedKind = rd.ReadUInt8()
+	}
+
+	if indexKind == STR_INDEX_ORDERED && storedKind != STR_INDEX_ORDERED {
+		return 0, fmt.Errorf("%w: %v", ErrNotOrdered, owner)
+	}
+
+	return storedKind, nil
+}
+
+func _StrIndexKind(index IStrI64Index) byte {
+	_, ok := index.(*StrI64BTree)
+	if ok {
+		return STR_INDEX_ORDERED
+	}
+	return STR_INDEX_HASH
+}
+
+// _SimpleStrI64Builder fills an empty factory from keys added in the order
+// of their hash, see _BulkStrKey. It lays the contexts out as the splits
+// of Set do: a data root while there are at most splitKeys keys, else a
+// branch root over depth 1 contexts, split again into depth 2 ones when
+// they hold more than splitKeys keys. The keys of the open depth 1 context
+// are held until it is written.
+type _SimpleStrI64Builder struct {
+	factory *SimpleStrI64Factory
+	keys []string
+	values []int64
+	groupKey int32
+	childIdByBranchKey map[int32]uint32
+	count int
+}
+
+func _NewSimpleStrI64Builder(factory *SimpleStrI64Factory) *_SimpleStrI64Builder {
+	b := new(_SimpleStrI64Builder)
+	b.factory = factory
+	return b
+}
+
+func (b *_SimpleStrI64Builder) ToString() string {
+	return fmt.Sprintf("<_SimpleStrI64Builder count=%v held=%v>", b.count, len(b.keys))
+}
+
+func (b *_SimpleStrI64Builder) Add(key string, value int64) error {
+
+	groupKey := _StrBranchKey(key, 0)
+
+	if b.childIdByBranchKey != nil && len(b.keys) > 0 && groupKey != b.groupKey {
+		err := b._WriteGroups(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.keys = append(b.keys, key)
+	b.values = append(b.values, value)
+	b.groupKey = groupKey
+	b.count += 1
+
+	if b.childIdByBranchKey == nil && len(b.keys) > b.factory.splitKeys {
+		b.childIdByBranchKey = make(map[int32]uint32)
+		return b._WriteGroups(false)
+	}
+
+	return nil
+}
+
+// _WriteGroups writes the held keys as depth 1 contexts, the last one
+// stays open unless all is set.
+func (b *_SimpleStrI64Builder) _WriteGroups(all bool) error {
+
+	start := 0
+	for i:=1; i<=len(b.keys); i++ {
+		if i < len(b.keys) && _StrBranchKey(b.keys[i], 0) == _StrBranchKey(b.keys[start], 0) {
+			continue
+		}
+		if i == len(b.keys) && !all {
+			break
+		}
+
+		id, err := b._WriteGroup(b.keys[start:i], b.values[start:i])
+		if err != nil {
+			return err
+		}
+		b.childIdByBranchKey[_StrBranchKey(b.keys[start], 0)] = id
+		start = i
+	}
+
+	b.keys = append([]string(nil), b.keys[start:]...)
+	b.values = append([]int64(nil), b.values[start:]...)
+
+	return nil
+}
+
+func (b *_SimpleStrI64Builder) _WriteGroup(keys []string, values []int64) (uint32, error) {
+
+	if len(keys) <= b.factory.splitKeys {
+		return b._WriteData(1, keys, values)
+	}
+
+	ctx := b._NewContext(LAZYSTRI64_BRANCH, 1)
+
+	start := 0
+	for i:=1; i<=len(keys); i++ {
+		if i < len(keys) && _StrBranchKey(keys[i], 1) == _StrBranchKey(keys[start], 1) {
+			continue
+		}
+		id, err := b._WriteData(2, keys[start:i], values[start:i])
+		if err != nil {
+			return 0, err
+		}
+		ctx.childContextIdByBranchKey[_StrBranchKey(keys[start], 1)] = id
+		start = i
+	}
+
+	return ctx.id, b.factory.pager.WritePayloadData(ctx.pid, ctx.ToBytes())
+}
+
+func (b *_SimpleStrI64Builder) _WriteData(depth byte, keys []string, values []int64) (uint32, error) {
+
+	ctx := b._NewContext(LAZYSTRI64_DATA, depth)
+	for i, key := range keys {
+		ctx.valueByKey[key] = values[i]
+	}
+
+	return ctx.id, b.factory.pager.WritePayloadData(ctx.pid, ctx.ToBytes())
+}
+
+// _NewContext is _AddContext without keeping the context loaded.
+func (b *_SimpleStrI64Builder) _NewContext(ctxType byte, depth byte) *SimpleStrI64Context {
+	f := b.factory
+	id := f.lastContextId + 1
+	f.lastContextId = id
+	pid := f.pager.CreatePageId()
+	f.pageIdByContextId[id] = pid
+	return f._NewContext(id, pid, ctxType, depth)
+}
+
+// Finish writes the contexts still open and returns the factory meta.
+func (b *_SimpleStrI64Builder) Finish() ([]byte, error) {
+
+	if b.childIdByBranchKey == nil {
+		if len(b.keys) > 0 {
+			id, err := b._WriteData(0, b.keys, b.values)
+			if err != nil {
+				return nil, err
+			}
+			b.factory.rootContextId = id
+		}
+	} else {
+		err := b._WriteGroups(true)
+		if err != nil {
+			return nil, err
+		}
+
+		root := b._NewContext(LAZYSTRI64_BRANCH, 0)
+		root.childContextIdByBranchKey = b.childIdByBranchKey
+		err = b.factory.pager.WritePayloadData(root.pid, root.ToBytes())
+		if err != nil {
+			return nil, err
+		}
+		b.factory.rootContextId = root.id
+	}
+
+	b.keys = nil
+	b.values = nil
+
+	return b.factory.Save()
+}
+
 /* */
 
 type LazyStrI64Dict struct {
@@ -443,10 +889,22 @@ type LazyStrI64Dict struct {
 	dictName string
 	storage *Storage
 	internalPager IPager
-	stri64Factory *SimpleStrI64Factory
+	index IStrI64Index
+	cache *PageCache
+}
+
+func NewStrI64Dict(s *Storage, dbName string, dictName string) (*LazyStrI64Dict, error) {
+	return _NewStrI64Dict(s, dbName, dictName, STR_INDEX_HASH)
 }
 
-func NewStrI64Dict(s *Storage, dbName string, dictName string) *LazyStrI64Dict {
+// NewOrderedStrI64Dict opens the dict with an ordered key index, which
+// gives it Range and PrefixScan. The index is chosen when the dict is
+// created, an existing hashed dict fails with ErrNotOrdered.
+func NewOrderedStrI64Dict(s *Storage, dbName string, dictName string) (*LazyStrI64Dict, error) {
+	return _NewStrI64Dict(s, dbName, dictName, STR_INDEX_ORDERED)
+}
+
+func _NewStrI64Dict(s *Storage, dbName string, dictName string, indexKind byte) (*LazyStrI64Dict, error) {
 
 	dict := new(LazyStrI64Dict)
 	dict.storage = s
@@ -458,83 +916,248 @@ func NewStrI64Dict(s *Storage, dbName string, dictName string) *LazyStrI64Dict {
 
 	db, err := s.DB(dbName)
 	if err != nil {
-		fmt.Println("error dbName", dbName)
-		os.Exit(1)
+		return nil, err
+	}
+	err = db._CheckDictKind(dictName, DICT_KIND_STRI64)
+	if err != nil {
+		return nil, err
 	}
 
-	metaData, ok := db.GetMeta(dictName)
+	metaData, err := db.GetMeta(dictName)
 
-	if ok {
+	if err == nil {
 		rd := NewDataStreamFromBuffer(metaData)
 
 		internalPagerMeta = rd.ReadChunk()
 		factoryMeta = rd.ReadChunk()
+
+		indexKind, err = _ReadStrIndexKind(rd, indexKind, _DictOwner(dbName, dictName))
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
 	}
 
 
-	internalPageSize := uint16(128)
+	internalPageSize := s.options._InternalPageSize(128)
 
-	internalPager := NewInternalPager(s.pager, internalPageSize, internalPagerMeta)
+	internalPager, err := NewInternalPager(s.pager, internalPageSize, internalPagerMeta, _DictOwner(dbName, dictName))
+	if err != nil {
+		return nil, err
+	}
 	dict.internalPager = internalPager
-	dict.stri64Factory = NewSimpleStrI64Factory(internalPager, factoryMeta)
+	dict.cache = _PageCacheOf(s.pager)
 
-	fmt.Println("NewStrI64Dict", dict.ToString())
+	dict.index, err = _NewStrI64Index(internalPager, indexKind, factoryMeta)
+	if err != nil {
+		return nil, err
+	}
 
-	return dict
+	//fmt.Println("NewStrI64Dict", dict.ToString())
+
+	return dict, nil
 }
 
 func (self *LazyStrI64Dict) ReleaseCache() {
-	self.stri64Factory.ReleaseCache()
+	self.index.ReleaseCache()
 }
 
-func (self *LazyStrI64Dict) Save(commit bool) {
+func (self *LazyStrI64Dict) Save(commit bool) error {
+	err := self.storage._CheckDictSave(self)
+	if err != nil {
+		return err
+	}
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+	return self._Save(commit)
+}
 
+// _Save also saves the keys of a LazyStrBlobDict, which is checked itself.
+func (self *LazyStrI64Dict) _Save(commit bool) error {
 
-	db := self._GetDB()
+	db, err := self.storage.DB(self.dbName)
+	if err != nil {
+		return err
+	}
 
-	factoryMeta := self.stri64Factory.Save()
-	internalPagerMeta := self.internalPager.Save()
+	factoryMeta, err := self.index.Save()
+	if err != nil {
+		return err
+	}
+	internalPagerMeta, err := self.internalPager.Save()
+	if err != nil {
+		return err
+	}
 
 	//fmt.Println("LazyStrI64Dict Save factoryMeta", factoryMeta)
 
 	metaW := NewDataStream()
 	metaW.WriteChunk(internalPagerMeta)
 	metaW.WriteChunk(factoryMeta)
+	metaW.WriteUInt8(_StrIndexKind(self.index))
 
-	db.SetMeta(self.dictName, metaW.ToBytes())
+	err = db._SetDictMeta(self.dictName, DICT_KIND_STRI64, metaW.ToBytes())
+	if err != nil {
+		return err
+	}
 
 	if commit {
-		self.storage.Save()
+		return self.storage._SaveAfterDict()
 	}
 	 
+	return nil
 }
 
 
-func (self *LazyStrI64Dict) Set(key string, value int64) {
-	self.stri64Factory.Set(key, value)
+// Set fails with ErrKeyTooLarge for a key over MAX_STR_KEY_SIZE.
+func (self *LazyStrI64Dict) Set(key string, value int64) (err error) {
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = _CheckStrKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = self.cache._Trim()
+	if err != nil {
+		return err
+	}
+	return self.index.Set(key, value)
 }
 
-func (self *LazyStrI64Dict) Get(key string) (int64, bool) {
-	return self.stri64Factory.Get(key)
+// SetMany sets every item of items, see SetPairs.
+func (self *LazyStrI64Dict) SetMany(items map[string]int64) error {
+	pairs := make([]StrI64Pair, 0, len(items))
+	for k, v := range items {
+		pairs = append(pairs, StrI64Pair{Key: k, Value: v})
+	}
+	return self.SetPairs(pairs)
 }
 
-func (self *LazyStrI64Dict) Items() chan SimpleStrI64Item {
-	return self.stri64Factory.Items()
+// SetPairs sets the pairs in order, the last pair of a key wins. The pairs
+// are checked before any is set and a bad one fails the batch with
+// ErrInvalidBatch. A hashed dict sets the keys of each context together.
+func (self *LazyStrI64Dict) SetPairs(pairs []StrI64Pair) (err error) {
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	for _, pair := range pairs {
+		err := _CheckStrKey(pair.Key)
+		if err != nil {
+			return _BatchError(err)
+		}
+	}
+
+	err = self.cache._Trim()
+	if err != nil {
+		return err
+	}
+	return self.index._SetMany(_SortStrPairs(pairs, _StrIndexKind(self.index)))
 }
 
+// Get returns ErrNotFound when key is not in the dict. Gets on a hashed
+// dict may run from several goroutines at once, not alongside a write.
+func (self *LazyStrI64Dict) Get(key string) (_ int64, err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
 
+	err = self.cache._Trim()
+	if err != nil {
+		return 0, err
+	}
+	return self.index.Get(key)
+}
 
-func (d *LazyStrI64Dict) _GetDB() *DBContext {
+// GetMany returns the values of keys in their order, with the bitmap of
+// the keys found. The index pages are read once each, a level at a time in
+// ascending page id order.
+func (self *LazyStrI64Dict) GetMany(keys []string) (_ []int64, _ Found, err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
 
-	db, err := d.storage.DB(d.dbName)
+	err = self.cache._Trim()
 	if err != nil {
-		fmt.Println("Error dbName", d.dbName)
-		os.Exit(1)
+		return nil, nil, err
 	}
 
-	return db
+	unique, at := _UniqueKeys(keys, _LessStr)
+	values, found, err := self.index._GetMany(unique)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	spread, spreadFound := _SpreadMany(values, found, at)
+	return spread, spreadFound, nil
 }
 
+// Delete returns ErrNotFound when key is not in the dict.
+func (self *LazyStrI64Dict) Delete(key string) (err error) {
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = self.cache._Trim()
+	if err != nil {
+		return err
+	}
+	return self.index.Delete(key)
+}
+
+func (self *LazyStrI64Dict) Items() *Cursor[string, int64] {
+	return self.index.Items()
+}
+
+// Range walks the keys in [startKey, endKey) in order. An empty endKey
+// runs to the last key. It needs an ordered dict.
+func (self *LazyStrI64Dict) Range(startKey string, endKey string) *Cursor[string, int64] {
+	bt, err := self._OrderedIndex()
+	if err != nil {
+		return _ErrCursor[string, int64](err)
+	}
+	return bt.Range(startKey, endKey)
+}
+
+// PrefixScan walks the keys starting with prefix in order. It needs an
+// ordered dict.
+func (self *LazyStrI64Dict) PrefixScan(prefix string) *Cursor[string, int64] {
+	bt, err := self._OrderedIndex()
+	if err != nil {
+		return _ErrCursor[string, int64](err)
+	}
+	return bt.PrefixScan(prefix)
+}
+
+func (self *LazyStrI64Dict) IsOrdered() bool {
+	return _StrIndexKind(self.index) == STR_INDEX_ORDERED
+}
+
+func (self *LazyStrI64Dict) _OrderedIndex() (*StrI64BTree, error) {
+	bt, ok := self.index.(*StrI64BTree)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNotOrdered, _DictOwner(self.dbName, self.dictName))
+	}
+	return bt, nil
+}
+
+
 func (d *LazyStrI64Dict) ToString() string {
-	return fmt.Sprintf("<LazyStrI64Dict %v>", d.stri64Factory.ToString())
+	return fmt.Sprintf("<LazyStrI64Dict %v>", d.index.ToString())
 }
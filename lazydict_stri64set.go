@@ -1,8 +1,8 @@
 package gokvdb
 
 import (
-	"os"
 	"fmt"
+	"errors"
 )
 
 type LazyStrI64SetDict struct {
@@ -10,8 +10,9 @@ type LazyStrI64SetDict struct {
 	dictName string
 	storage *Storage
 	internalPager IPager
-	keyFactory *SimpleStrI64Factory
+	keyIndex IStrI64Index
 	contextByKey map[string]*LazyStrI64SetContext
+	cache *PageCache
 }
 
 type LazyStrI64SetContext struct {
@@ -22,7 +23,7 @@ type LazyStrI64SetContext struct {
 }
 
 func (self *LazyStrI64SetDict) ToString() string {
-	return fmt.Sprintf("<LazyStrI64SetDict %v>", self.keyFactory.ToString())
+	return fmt.Sprintf("<LazyStrI64SetDict %v>", self.keyIndex.ToString())
 }
 
 func (self *LazyStrI64SetContext) ToString() string {
@@ -39,12 +40,15 @@ func (self *LazyStrI64SetDict) _NewContext(pid uint32, key string, data []byte)
 	return ctx
 }
 
-func (self *LazyStrI64SetDict) _LoadContext(pid uint32, key string) *LazyStrI64SetContext {
+func (self *LazyStrI64SetDict) _LoadContext(pid uint32, key string) (*LazyStrI64SetContext, error) {
 	
-	data, _ := self.internalPager.ReadPayloadData(pid)
+	data, err := self.internalPager.ReadPayloadData(pid)
+	if err != nil {
+		return nil, err
+	}
 	//fmt.Println("_LoadContext", "pid", pid, "data", data)
 	ctx := self._NewContext(pid, key, data)
-	return ctx
+	return ctx, nil
 }
 
 type LazyStrI64SetItem struct {
@@ -56,73 +60,332 @@ func (self *LazyStrI64SetItem) Key() string {
 	return self.ctx.key
 }
 
-func (self *LazyStrI64SetItem) Values() chan int64 {
+func (self *LazyStrI64SetItem) Values() *Cursor[int64, struct{}] {
 	return self.ctx.set.Values()
 }
 
-func (self *LazyStrI64SetDict) Get(key string) (LazyStrI64SetItem, bool) {
+func (self *LazyStrI64SetDict) Items() *Cursor[string, *LazyStrI64SetItem] {
+	return self._Items(self.keyIndex.Items())
+}
+
+// Range walks the keys in [startKey, endKey) in order. An empty endKey
+// runs to the last key. It needs an ordered dict.
+func (self *LazyStrI64SetDict) Range(startKey string, endKey string) *Cursor[string, *LazyStrI64SetItem] {
+	bt, err := self._OrderedIndex()
+	if err != nil {
+		return _ErrCursor[string, *LazyStrI64SetItem](err)
+	}
+	return self._Items(bt.Range(startKey, endKey))
+}
+
+// PrefixScan walks the keys starting with prefix in order. It needs an
+// ordered dict.
+func (self *LazyStrI64SetDict) PrefixScan(prefix string) *Cursor[string, *LazyStrI64SetItem] {
+	bt, err := self._OrderedIndex()
+	if err != nil {
+		return _ErrCursor[string, *LazyStrI64SetItem](err)
+	}
+	return self._Items(bt.PrefixScan(prefix))
+}
+
+func (self *LazyStrI64SetDict) IsOrdered() bool {
+	return _StrIndexKind(self.keyIndex) == STR_INDEX_ORDERED
+}
+
+func (self *LazyStrI64SetDict) _OrderedIndex() (*StrI64BTree, error) {
+	bt, ok := self.keyIndex.(*StrI64BTree)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNotOrdered, _DictOwner(self.dbName, self.dictName))
+	}
+	return bt, nil
+}
+
+// _Items loads the set of every key from keys as the cursor reaches it.
+func (self *LazyStrI64SetDict) _Items(keys *Cursor[string, int64]) *Cursor[string, *LazyStrI64SetItem] {
+	return _NewCursor(func(yield func(string, *LazyStrI64SetItem) bool) error {
+		defer keys.Close()
+
+		for keys.Next() {
+			key := keys.Key()
+
+			ctx, ok := self.contextByKey[key]
+			if !ok {
+				var err error
+				ctx, err = self._LoadContext(uint32(keys.Value()), key)
+				if err != nil {
+					return err
+				}
+			}
+
+			if !yield(key, &LazyStrI64SetItem{dict: self, ctx: ctx}) {
+				return nil
+			}
+		}
+
+		return keys.Err()
+	})
+}
+
+// Get returns ErrNotFound when key is not in the dict.
+func (self *LazyStrI64SetDict) Get(key string) (_ LazyStrI64SetItem, err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
 	//fmt.Println("(self *LazyStrI64SetDict) Get(key string) (LazyStrI64SetItem, bool) {")
-	ctx := self._GetOrLoadContext(key)
+	err = self.cache._Trim()
+	if err != nil {
+		return LazyStrI64SetItem{}, err
+	}
+
+	ctx, err := self._GetOrLoadContext(key)
+	if err != nil {
+		return LazyStrI64SetItem{}, err
+	}
+
+	return LazyStrI64SetItem{dict: self, ctx:ctx}, nil
+}
+
+// GetMany returns the items of keys in their order, with the bitmap of the
+// keys found. The index pages and then the sets are read once each, in
+// ascending page id order.
+func (self *LazyStrI64SetDict) GetMany(keys []string) (_ []LazyStrI64SetItem, _ Found, err error) {
+	self.cache._Enter()
+	defer self.cache._Leave(&err)
+
+	err = self.cache._Trim()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	unique, at := _UniqueKeys(keys, _LessStr)
+	ctxPageIds, found, err := self.keyIndex._GetMany(unique)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if ctx != nil {
+	items := make([]LazyStrI64SetItem, len(unique))
+	indexesByPageId := make(map[uint32][]int)
+	for i, key := range unique {
+		if !found[i] {
+			continue
+		}
+		ctx, ok := self.contextByKey[key]
+		if ok {
+			items[i] = LazyStrI64SetItem{dict: self, ctx: ctx}
+		} else {
+			pid := uint32(ctxPageIds[i])
+			indexesByPageId[pid] = append(indexesByPageId[pid], i)
+		}
+	}
 
-		return LazyStrI64SetItem{dict: self, ctx:ctx}, true
+	for _, pid := range _SortPageIds(indexesByPageId) {
+		for _, i := range indexesByPageId[pid] {
+			ctx, err := self._LoadContext(pid, unique[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			items[i] = LazyStrI64SetItem{dict: self, ctx: ctx}
+		}
 	}
 
-	return LazyStrI64SetItem{}, false
+	spread, spreadFound := _SpreadMany(items, found, at)
+	return spread, spreadFound, nil
 }
 
-func (self *LazyStrI64SetDict) _GetOrLoadContext(key string) *LazyStrI64SetContext {
+func (self *LazyStrI64SetDict) _GetOrLoadContext(key string) (*LazyStrI64SetContext, error) {
 	ctx, ok := self.contextByKey[key]
 	if ok {
-		return ctx
+		return ctx, nil
 	}
 
-	_pgId, ok := self.keyFactory.Get(key)	
+	_pgId, err := self.keyIndex.Get(key)	
 	//fmt.Println("_GetOrLoadContext _pgId, ok ", _pgId, ok )
-	if ok {
-		pgId := uint32(_pgId)
-		ctx = self._LoadContext(pgId, key)
-		return ctx
+	if err != nil {
+		return nil, err
+	}
+
+	pgId := uint32(_pgId)
+	return self._LoadContext(pgId, key)
+}
+
+// Add fails with ErrKeyTooLarge for a key over MAX_STR_KEY_SIZE.
+func (self *LazyStrI64SetDict) Add(key string, value int64) error {
+
+	err := _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	err = _CheckStrKey(key)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := self._GetOrCreateContext(key)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.set.Add(value)
+	if err != nil {
+		return err
+	}
+	ctx.isChanged = true
+	
+	return nil
+}
+
+// AddMany adds every value to the set of key. The values are grouped by
+// the set context they go to, an empty batch leaves the dict unchanged. A
+// key too long for the index fails with ErrInvalidBatch.
+func (self *LazyStrI64SetDict) AddMany(key string, values []int64) error {
+
+	err := _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	err = _CheckStrKey(key)
+	if err != nil {
+		return _BatchError(err)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	ctx, err := self._GetOrCreateContext(key)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.set.AddMany(values)
+	if err != nil {
+		return err
 	}
+	ctx.isChanged = true
 
 	return nil
 }
 
-func (self *LazyStrI64SetDict) Add(key string, value int64) {
+func (self *LazyStrI64SetDict) _GetOrCreateContext(key string) (*LazyStrI64SetContext, error) {
 
 	ctx, ok := self.contextByKey[key]
 	
 	if !ok {
-		//fmt.Println("self.keyFactory.Get(key)..", key)
-		_pgId, ok := self.keyFactory.Get(key)
-		//fmt.Println("self.keyFactory.Get(key)..",_pgId, ok )
-		if ok {
+		//fmt.Println("self.keyIndex.Get(key)..", key)
+		_pgId, err := self.keyIndex.Get(key)
+		//fmt.Println("self.keyIndex.Get(key)..",_pgId, ok )
+		if err == nil {
 			pgId := uint32(_pgId)
-			ctx = self._LoadContext(pgId, key)
-		} else {
+			ctx, err = self._LoadContext(pgId, key)
+			if err != nil {
+				return nil, err
+			}
+		} else if errors.Is(err, ErrNotFound) {
 			pgId := self.internalPager.CreatePageId()
 			ctx = self._NewContext(pgId, key, nil)
 			ctx.isChanged = true
-			self.keyFactory.Set(key, int64(pgId))
+			err = self.keyIndex.Set(key, int64(pgId))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			return nil, err
 		}
 
 		self.contextByKey[key] = ctx
 	}
 
-	ctx.set.Add(value)
+	return ctx, nil
+}
+
+
+// Delete removes key and its whole value set. It returns ErrNotFound when
+// key is not in the dict.
+func (self *LazyStrI64SetDict) Delete(key string) error {
+
+	err := _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := self._GetOrLoadContext(key)
+	if err != nil {
+		return err
+	}
+
+	err = ctx.set.Free()
+	if err != nil {
+		return err
+	}
+	err = self.internalPager.FreePayloadData(ctx.pid)
+	if err != nil {
+		return err
+	}
+	err = self.keyIndex.Delete(key)
+	if err != nil {
+		return err
+	}
+	delete(self.contextByKey, key)
+
+	return nil
+}
+
+// Remove removes value from the set of key. The key itself is deleted
+// once its set becomes empty. It returns ErrNotFound when the pair is not
+// in the dict.
+func (self *LazyStrI64SetDict) Remove(key string, value int64) error {
+
+	err := _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := self._GetOrLoadContext(key)
+	if err != nil {
+		return err
+	}
+	self.contextByKey[key] = ctx
+
+	err = ctx.set.Remove(value)
+	if err != nil {
+		return err
+	}
 	ctx.isChanged = true
-	
-	
+
+	isEmpty, err := ctx.set.IsEmpty()
+	if err != nil {
+		return err
+	}
+
+	if isEmpty {
+		return self.Delete(key)
+	}
+
+	return nil
 }
 
+func (self *LazyStrI64SetDict) Save(commit bool) error {
 
-func (self *LazyStrI64SetDict) Save(commit bool) {
+	err := self.storage._CheckDictSave(self)
+	if err != nil {
+		return err
+	}
+	err = _CheckDropped(self.internalPager)
+	if err != nil {
+		return err
+	}
 
 	for _, ctx := range self.contextByKey {
 		if ctx.isChanged {
-			ctxData := ctx.set.Save()
-			self.internalPager.WritePayloadData(ctx.pid, ctxData)
+			ctxData, err := ctx.set.Save()
+			if err != nil {
+				return err
+			}
+			err = self.internalPager.WritePayloadData(ctx.pid, ctxData)
+			if err != nil {
+				return err
+			}
 			//fmt.Println("LazyStrI64SetDict SAVE", ctx.ToString(), "bytes", len(ctxData))
 			ctx.isChanged = false
 		}
@@ -130,26 +393,47 @@ func (self *LazyStrI64SetDict) Save(commit bool) {
 
 	db, err := self.storage.DB(self.dbName)
 	if err != nil {
-		fmt.Println("error dbName", self.dbName)
-		os.Exit(1)
+		return err
 	}
 
-	keyFactoryData := self.keyFactory.Save()
-	pagerData := self.internalPager.Save()
+	keyIndexData, err := self.keyIndex.Save()
+	if err != nil {
+		return err
+	}
+	pagerData, err := self.internalPager.Save()
+	if err != nil {
+		return err
+	}
 
 	metaW := NewDataStream()
 	metaW.WriteChunk(pagerData)
-	metaW.WriteChunk(keyFactoryData)
+	metaW.WriteChunk(keyIndexData)
+	metaW.WriteUInt8(_StrIndexKind(self.keyIndex))
 
-	db.SetMeta(self.dictName, metaW.ToBytes())
+	err = db._SetDictMeta(self.dictName, DICT_KIND_STRI64SET, metaW.ToBytes())
+	if err != nil {
+		return err
+	}
 
 	if commit {
-		self.storage.Save()
+		return self.storage._SaveAfterDict()
 	}
+
+	return nil
 }
 
 
-func NewStrI64SetDict(storage *Storage, dbName string, dictName string) *LazyStrI64SetDict {
+func NewStrI64SetDict(storage *Storage, dbName string, dictName string) (*LazyStrI64SetDict, error) {
+	return _NewStrI64SetDict(storage, dbName, dictName, STR_INDEX_HASH)
+}
+
+// NewOrderedStrI64SetDict opens the dict with an ordered key index, see
+// NewOrderedStrI64Dict.
+func NewOrderedStrI64SetDict(storage *Storage, dbName string, dictName string) (*LazyStrI64SetDict, error) {
+	return _NewStrI64SetDict(storage, dbName, dictName, STR_INDEX_ORDERED)
+}
+
+func _NewStrI64SetDict(storage *Storage, dbName string, dictName string, indexKind byte) (*LazyStrI64SetDict, error) {
 
 	self := new(LazyStrI64SetDict)
 	self.storage = storage
@@ -162,31 +446,48 @@ func NewStrI64SetDict(storage *Storage, dbName string, dictName string) *LazyStr
 
 	db, err := storage.DB(dbName)
 	if err != nil {
-		fmt.Println("error dbName", dbName)
-		os.Exit(1)
+		return nil, err
+	}
+	err = db._CheckDictKind(dictName, DICT_KIND_STRI64SET)
+	if err != nil {
+		return nil, err
 	}
 
-	metaData, ok := db.GetMeta(dictName)
+	metaData, err := db.GetMeta(dictName)
 
 
-	if ok {
+	if err == nil {
 		rd := NewDataStreamFromBuffer(metaData)
 
 		pagerData = rd.ReadChunk()
 		keyData = rd.ReadChunk()
+
+		indexKind, err = _ReadStrIndexKind(rd, indexKind, _DictOwner(dbName, dictName))
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
 	}
 
-	fmt.Println("keyData", keyData)
+	//fmt.Println("keyData", keyData)
 
 
-	internalPageSize := uint16(128)
+	internalPageSize := storage.options._InternalPageSize(128)
 
-	internalPager := NewInternalPager(storage.pager, internalPageSize, pagerData)
+	internalPager, err := NewInternalPager(storage.pager, internalPageSize, pagerData, _DictOwner(dbName, dictName))
+	if err != nil {
+		return nil, err
+	}
 	self.internalPager = internalPager
-	self.keyFactory = NewSimpleStrI64Factory(internalPager, keyData)
+	self.cache = _PageCacheOf(storage.pager)
 
-	fmt.Println("...NewLazyStrI64SetDict", self.ToString(), self.internalPager.ToString())
+	self.keyIndex, err = _NewStrI64Index(internalPager, indexKind, keyData)
+	if err != nil {
+		return nil, err
+	}
 
-	return self
-}
+	//fmt.Println("...NewLazyStrI64SetDict", self.ToString(), self.internalPager.ToString())
 
+	return self, nil
+}
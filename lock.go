@@ -0,0 +1,34 @@
+package gokvdb
+
+import (
+	"fmt"
+	"time"
+)
+
+const LOCK_RETRY_INTERVAL = 10 * time.Millisecond
+
+// _Lock takes the advisory lock of the file, exclusive for writers and
+// shared for readers. It retries until timeout has passed and then fails
+// with ErrLocked, a zero timeout tries once. The lock goes with the file
+// when the stream is closed.
+func (s *FileStream) _Lock(exclusive bool, timeout time.Duration) error {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ok, err := _TryLockFile(s.file, exclusive)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: %v", ErrLocked, s.path)
+		}
+		time.Sleep(LOCK_RETRY_INTERVAL)
+	}
+}
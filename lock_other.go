@@ -0,0 +1,13 @@
+//go:build !unix
+
+package gokvdb
+
+import (
+	"os"
+)
+
+// _TryLockFile does not lock on platforms without flock, callers must keep
+// a single writer themselves.
+func _TryLockFile(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
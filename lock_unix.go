@@ -0,0 +1,28 @@
+//go:build unix
+
+package gokvdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// _TryLockFile returns false when another open file holds a conflicting
+// flock.
+func _TryLockFile(f *os.File, exclusive bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how | syscall.LOCK_NB)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EWOULDBLOCK {
+			return false, nil
+		}
+		return err == nil, err
+	}
+}
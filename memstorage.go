@@ -0,0 +1,98 @@
+package gokvdb
+
+import (
+	"os"
+	"fmt"
+)
+
+// OpenMemoryStorage returns an empty storage held in a MemoryStream. It has
+// no lock and no write-ahead log, Close drops it.
+func OpenMemoryStorage() (*Storage, error) {
+	return OpenMemoryStorageWithOptions(Options{})
+}
+
+func OpenMemoryStorageWithOptions(opts Options) (*Storage, error) {
+
+	opts, err := opts._WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	return _OpenStorage("", NewMemoryStream(nil), nil, uint32(opts.PageSize), opts)
+}
+
+// LoadMemoryStorage copies the file at path into memory together with the
+// commits its write-ahead log still holds. The file is read under a shared
+// lock and left as it is, changes stay in memory until WriteSnapshot.
+func LoadMemoryStorage(path string) (*Storage, error) {
+	return LoadMemoryStorageWithOptions(path, Options{})
+}
+
+func LoadMemoryStorageWithOptions(path string, opts Options) (*Storage, error) {
+
+	opts, err := opts._WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := _OpenFileStream(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	err = stream._Lock(false, opts.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := _ReadStoragePageSize(stream, path + "-wal", uint32(opts.PageSize))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mem := NewMemoryStream(data)
+
+	wal, err := OpenWriteAheadLogReadOnly(path + "-wal", pageSize)
+	if err != nil {
+		return nil, err
+	}
+	if wal != nil {
+		err = wal.WritePages(mem)
+		wal.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return _OpenStorage("", mem, nil, pageSize, opts)
+}
+
+// WriteSnapshot writes the image of a memory storage to path, which then
+// opens like any database file. The image holds what was last saved, the
+// changes of an open transaction are left out. It fails with ErrLocked
+// while another process has path open and replaces the file atomically.
+func (s *Storage) WriteSnapshot(path string) error {
+
+	mem, ok := s.stream.(*MemoryStream)
+	if !ok {
+		return fmt.Errorf("%w: snapshot of %v", ErrNotImplemented, s.stream.ToString())
+	}
+
+	return _ReplaceFile(path, func(tmpPath string) error {
+
+		f, err := os.OpenFile(tmpPath, os.O_RDWR | os.O_CREATE | os.O_TRUNC, 0666)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(mem.Bytes())
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+
+		return err
+	})
+}
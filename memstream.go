@@ -0,0 +1,183 @@
+package gokvdb
+
+import (
+	"io"
+	"fmt"
+	"sync"
+)
+
+// MemoryStream is an IStream over a byte slice that grows on write. The
+// image it holds has the layout of a database file, so it can be written
+// to a file and opened from one.
+type MemoryStream struct {
+	data []byte
+	offset int64
+	isClosed bool
+	rwlock sync.RWMutex
+}
+
+// NewMemoryStream returns a stream over a copy of data, nil starts empty.
+func NewMemoryStream(data []byte) *MemoryStream {
+	s := new(MemoryStream)
+	s.data = make([]byte, len(data))
+	copy(s.data, data)
+	return s
+}
+
+func (s *MemoryStream) ToString() string {
+	return fmt.Sprintf("<MemoryStream size=%v>", len(s.data))
+}
+
+// Write overwrites at the offset and pads a gap past the end with zeros,
+// like a file does.
+func (s *MemoryStream) Write(data []byte) error {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+
+	if s.isClosed {
+		return io.ErrClosedPipe
+	}
+
+	end := s.offset + int64(len(data))
+	if end > int64(len(s.data)) {
+		if end > int64(cap(s.data)) {
+			newCap := int64(cap(s.data)) * 2
+			if newCap < end {
+				newCap = end
+			}
+			grown := make([]byte, len(s.data), newCap)
+			copy(grown, s.data)
+			s.data = grown
+		}
+		s.data = s.data[:end]
+	}
+
+	copy(s.data[s.offset:end], data)
+	s.offset = end
+
+	return nil
+}
+
+// Read returns count bytes, zero padded past the end. It fails with io.EOF
+// when the offset is at or past the end.
+func (s *MemoryStream) Read(count int) ([]byte, error) {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+
+	if s.isClosed {
+		return nil, io.ErrClosedPipe
+	}
+
+	if s.offset >= int64(len(s.data)) {
+		return nil, io.EOF
+	}
+
+	data := make([]byte, count)
+	n := copy(data, s.data[s.offset:])
+	s.offset += int64(n)
+
+	return data, nil
+}
+
+func (s *MemoryStream) ReadAt(offset int64, count int) ([]byte, error) {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+
+	if s.isClosed {
+		return nil, io.ErrClosedPipe
+	}
+
+	if offset >= int64(len(s.data)) {
+		return nil, io.EOF
+	}
+
+	data := make([]byte, count)
+	copy(data, s.data[offset:])
+
+	return data, nil
+}
+
+func (s *MemoryStream) SeekTo(offset int64) error {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+
+	if offset < 0 {
+		return fmt.Errorf("MemoryStream SeekTo offset=%v", offset)
+	}
+	s.offset = offset
+	return nil
+}
+
+func (s *MemoryStream) Sync() error {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+
+	if s.isClosed {
+		return io.ErrClosedPipe
+	}
+	return nil
+}
+
+func (s *MemoryStream) Close() {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	s.data = nil
+	s.isClosed = true
+}
+
+func (s *MemoryStream) Size() (int64, error) {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+
+	if s.isClosed {
+		return 0, io.ErrClosedPipe
+	}
+
+	return int64(len(s.data)), nil
+}
+
+// Truncate cuts the image to size or pads it with zeros up to it, like a
+// file does. The bytes cut off are zeroed so a later write past the end
+// does not bring them back.
+func (s *MemoryStream) Truncate(size int64) error {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+
+	if s.isClosed {
+		return io.ErrClosedPipe
+	}
+	if size < 0 {
+		return fmt.Errorf("MemoryStream Truncate size=%v", size)
+	}
+
+	if size > int64(len(s.data)) {
+		grown := make([]byte, size)
+		copy(grown, s.data)
+		s.data = grown
+		return nil
+	}
+
+	tail := s.data[size:]
+	for i := range tail {
+		tail[i] = 0
+	}
+	s.data = s.data[:size]
+
+	return nil
+}
+
+func (s *MemoryStream) Len() int {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+	return len(s.data)
+}
+
+// Bytes returns a copy of the image.
+func (s *MemoryStream) Bytes() []byte {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+
+	data := make([]byte, len(s.data))
+	copy(data, s.data)
+	return data
+}
@@ -0,0 +1,184 @@
+package gokvdb
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"errors"
+)
+
+const (
+	MMAP_MIN_SIZE int64 = 1 << 20
+)
+
+// MmapStream is a FileStream whose ReadAt returns slices of a read-only
+// shared mapping of the file instead of copies, so readers take no lock
+// on the file and make no syscall. The mapping doubles past the end of the
+// file when the file outgrows it. An outgrown mapping stays until Close
+// because slices of it may still be read, the bytes ReadAt returns must
+// not be changed.
+type MmapStream struct {
+	stream *FileStream
+	data []byte
+	// size is the part of data known to be inside the file
+	size int64
+	oldRegions [][]byte
+	isMapped bool
+	maplock sync.RWMutex
+}
+
+// _NewMmapStream maps stream, which it closes with itself. Where the
+// platform cannot map it reads through stream.
+func _NewMmapStream(stream *FileStream) (*MmapStream, error) {
+	s := new(MmapStream)
+	s.stream = stream
+	s.isMapped = true
+
+	err := s._Remap()
+	if errors.Is(err, ErrNotImplemented) {
+		s.isMapped = false
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *MmapStream) ToString() string {
+	return fmt.Sprintf("<MmapStream path=%v mapped=%v size=%v>", s.stream.path, len(s.data), s.size)
+}
+
+func (s *MmapStream) Write(data []byte) error {
+	return s.stream.Write(data)
+}
+
+func (s *MmapStream) Read(count int) ([]byte, error) {
+	return s.stream.Read(count)
+}
+
+func (s *MmapStream) SeekTo(offset int64) error {
+	return s.stream.SeekTo(offset)
+}
+
+func (s *MmapStream) Sync() error {
+	return s.stream.Sync()
+}
+
+// ReadAt only looks at the file size when the range is past the part
+// known to be written, a read past the end of the file falls back to the
+// FileStream and fails with io.EOF like it.
+func (s *MmapStream) ReadAt(offset int64, count int) ([]byte, error) {
+
+	end := offset + int64(count)
+
+	data, ok := s._Slice(offset, end)
+	if ok {
+		return data, nil
+	}
+
+	if s.isMapped {
+		err := s._Remap()
+		if err != nil {
+			return nil, err
+		}
+
+		data, ok = s._Slice(offset, end)
+		if ok {
+			return data, nil
+		}
+	}
+
+	return s.stream.ReadAt(offset, count)
+}
+
+func (s *MmapStream) _Slice(offset int64, end int64) ([]byte, bool) {
+	s.maplock.RLock()
+	defer s.maplock.RUnlock()
+
+	if offset < 0 || end > s.size {
+		return nil, false
+	}
+
+	return s.data[offset:end:end], true
+}
+
+// _Remap maps the file again when it grew past the mapping.
+func (s *MmapStream) _Remap() error {
+	s.maplock.Lock()
+	defer s.maplock.Unlock()
+
+	s.stream.rwlock.RLock()
+	defer s.stream.rwlock.RUnlock()
+
+	if s.stream.file == nil {
+		return os.ErrClosed
+	}
+
+	info, err := s.stream.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	if fileSize <= int64(len(s.data)) {
+		s.size = fileSize
+		return nil
+	}
+
+	length := int64(len(s.data))
+	if length < MMAP_MIN_SIZE {
+		length = MMAP_MIN_SIZE
+	}
+	for length < fileSize {
+		length *= 2
+	}
+
+	data, err := _MmapFile(s.stream.file, length)
+	if err != nil {
+		return err
+	}
+
+	if s.data != nil {
+		s.oldRegions = append(s.oldRegions, s.data)
+	}
+	s.data = data
+	s.size = fileSize
+
+	return nil
+}
+
+func (s *MmapStream) Size() (int64, error) {
+	return s.stream.Size()
+}
+
+// Truncate shrinks the part of the mapping reads may use before the file,
+// a page past the end of the file must never be touched through it.
+func (s *MmapStream) Truncate(size int64) error {
+	s.maplock.Lock()
+	defer s.maplock.Unlock()
+
+	if size < s.size {
+		s.size = size
+	}
+
+	return s.stream.Truncate(size)
+}
+
+func (s *MmapStream) Close() {
+	s.maplock.Lock()
+	defer s.maplock.Unlock()
+
+	for _, region := range s.oldRegions {
+		_MunmapFile(region)
+	}
+	if s.data != nil {
+		_MunmapFile(s.data)
+	}
+	s.oldRegions = nil
+	s.data = nil
+	s.size = 0
+
+	s.stream.Close()
+}
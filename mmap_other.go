@@ -0,0 +1,16 @@
+//go:build !unix
+
+package gokvdb
+
+import (
+	"os"
+)
+
+// _MmapFile is not available, MmapStream then reads like a FileStream.
+func _MmapFile(f *os.File, length int64) ([]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+func _MunmapFile(data []byte) error {
+	return nil
+}
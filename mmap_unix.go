@@ -0,0 +1,19 @@
+//go:build unix
+
+package gokvdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// _MmapFile maps length bytes of f read-only and shared, so writes through
+// f show in the mapping. Length may run past the end of the file, the part
+// past the end must not be touched until the file grows over it.
+func _MmapFile(f *os.File, length int64) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, int(length), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func _MunmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,220 @@
+package gokvdb
+
+import (
+	"fmt"
+	"time"
+)
+
+type SyncMode uint8
+
+const (
+	// SYNC_FULL fsyncs the write-ahead log on every commit and the main
+	// file on every checkpoint
+	SYNC_FULL SyncMode = 0
+	// SYNC_NORMAL fsyncs only at checkpoints, the last commits may be lost
+	// on power failure but the file stays consistent
+	SYNC_NORMAL SyncMode = 1
+	// SYNC_OFF never fsyncs and leaves it to the operating system
+	SYNC_OFF SyncMode = 2
+
+	MIN_PAGE_SIZE int = 1024
+	MAX_PAGE_SIZE int = 65536
+
+	MIN_INTERNAL_PAGE_SIZE int = 64
+	MAX_INTERNAL_PAGE_SIZE int = 4096
+
+	STR_SPLIT_KEYS int = 8192
+	STR_SPLITS_PER_SAVE int = 8
+
+	BRANCH_FAN_OUT int = 4096
+	MIN_BRANCH_FAN_OUT int = 16
+	MAX_BRANCH_FAN_OUT int = 65536
+
+	// the creation settings are saved in the storage header, a header
+	// without them was written with the defaults
+	STORAGE_OPTIONS_OFFSET = 8
+	STORAGE_OPTIONS_VERSION uint8 = 1
+)
+
+// Options are the settings of OpenStorageWithOptions, the zero value is
+// what OpenStorage uses.
+//
+// PageSize, InternalPageSize, SplitKeys, SplitsPerSave, BranchFanOut and
+// SyncMode are chosen when the file is created and saved in its header,
+// reopening uses the saved values whatever is passed.
+type Options struct {
+	// ReadOnly takes a shared lock so several readers can open the file at
+	// once, every write fails with ErrReadOnly.
+	ReadOnly bool
+	// LockTimeout is how long to wait for another process to release the
+	// file before failing with ErrLocked, zero fails at once.
+	LockTimeout time.Duration
+	// CacheSize is the byte budget of the page contexts the dicts keep in
+	// memory, zero means PAGE_CACHE_DEFAULT_SIZE and less never evicts.
+	// A budget below PAGE_CACHE_MIN_SIZE is raised to it.
+	// The pages written since the last commit are held to it too, past it
+	// they go to the write-ahead log.
+	CacheSize int64
+	// Mmap reads pages from a shared read-only mapping of the file through
+	// an MmapStream, so lookups of several goroutines do not wait on it.
+	Mmap bool
+
+	// PageSize is a power of two from MIN_PAGE_SIZE to MAX_PAGE_SIZE, zero
+	// means PAGE_SIZE.
+	PageSize int
+	// InternalPageSize is the page size of the InternalPager of every new
+	// dict, zero keeps the size each dict kind picks.
+	InternalPageSize int
+	// SplitKeys is how many keys a hashed string index context holds before
+	// it splits, at most SplitsPerSave contexts split between two saves.
+	SplitKeys int
+	SplitsPerSave int
+	// BranchFanOut is the divisor of each level of the int64 branch trees.
+	BranchFanOut int
+	SyncMode SyncMode
+}
+
+func (opts Options) ToString() string {
+	return fmt.Sprintf("<Options pageSize=%v internalPageSize=%v splitKeys=%v splitsPerSave=%v branchFanOut=%v syncMode=%v>", opts.PageSize, opts.InternalPageSize, opts.SplitKeys, opts.SplitsPerSave, opts.BranchFanOut, opts.SyncMode)
+}
+
+func (mode SyncMode) String() string {
+	switch mode {
+	case SYNC_FULL:
+		return "full"
+	case SYNC_NORMAL:
+		return "normal"
+	case SYNC_OFF:
+		return "off"
+	}
+	return fmt.Sprintf("SyncMode(%d)", uint8(mode))
+}
+
+func (opts Options) _CacheSize() int64 {
+	if opts.CacheSize == 0 {
+		return PAGE_CACHE_DEFAULT_SIZE
+	}
+	return opts.CacheSize
+}
+
+// _WithDefaults fills the zero settings and checks the others.
+func (opts Options) _WithDefaults() (Options, error) {
+
+	if opts.PageSize == 0 {
+		opts.PageSize = PAGE_SIZE
+	}
+	if opts.SplitKeys == 0 {
+		opts.SplitKeys = STR_SPLIT_KEYS
+	}
+	if opts.SplitsPerSave == 0 {
+		opts.SplitsPerSave = STR_SPLITS_PER_SAVE
+	}
+	if opts.BranchFanOut == 0 {
+		opts.BranchFanOut = BRANCH_FAN_OUT
+	}
+
+	if opts.PageSize < MIN_PAGE_SIZE || opts.PageSize > MAX_PAGE_SIZE || opts.PageSize & (opts.PageSize - 1) != 0 {
+		return opts, fmt.Errorf("%w: page size %v", ErrInvalidOptions, opts.PageSize)
+	}
+	if opts.InternalPageSize != 0 && (opts.InternalPageSize < MIN_INTERNAL_PAGE_SIZE || opts.InternalPageSize > MAX_INTERNAL_PAGE_SIZE) {
+		return opts, fmt.Errorf("%w: internal page size %v", ErrInvalidOptions, opts.InternalPageSize)
+	}
+	if opts.SplitKeys < 16 || opts.SplitKeys > 1 << 24 {
+		return opts, fmt.Errorf("%w: split keys %v", ErrInvalidOptions, opts.SplitKeys)
+	}
+	if opts.SplitsPerSave < 1 || opts.SplitsPerSave > 1 << 16 {
+		return opts, fmt.Errorf("%w: splits per save %v", ErrInvalidOptions, opts.SplitsPerSave)
+	}
+	if opts.BranchFanOut < MIN_BRANCH_FAN_OUT || opts.BranchFanOut > MAX_BRANCH_FAN_OUT {
+		return opts, fmt.Errorf("%w: branch fan-out %v", ErrInvalidOptions, opts.BranchFanOut)
+	}
+	if opts.SyncMode > SYNC_OFF {
+		return opts, fmt.Errorf("%w: sync mode %v", ErrInvalidOptions, opts.SyncMode)
+	}
+
+	return opts, nil
+}
+
+// _ReadSaved replaces the creation settings with the ones saved in the
+// storage header rd, the defaults when the header has none.
+func (opts Options) _ReadSaved(rd *DataStream, pageSize uint32) Options {
+
+	opts.PageSize = int(pageSize)
+	opts.InternalPageSize = 0
+	opts.SplitKeys = STR_SPLIT_KEYS
+	opts.SplitsPerSave = STR_SPLITS_PER_SAVE
+	opts.BranchFanOut = BRANCH_FAN_OUT
+	opts.SyncMode = SYNC_FULL
+
+	rd.Seek(STORAGE_OPTIONS_OFFSET)
+	if rd.ReadUInt8() != STORAGE_OPTIONS_VERSION {
+		return opts
+	}
+
+	opts.SyncMode = SyncMode(rd.ReadUInt8())
+	opts.InternalPageSize = int(rd.ReadUInt16())
+	opts.SplitKeys = int(rd.ReadUInt32())
+	opts.SplitsPerSave = int(rd.ReadUInt32())
+	opts.BranchFanOut = int(rd.ReadUInt32())
+
+	return opts
+}
+
+func (opts *Options) _Write(w *DataStream) {
+	w.Seek(STORAGE_OPTIONS_OFFSET)
+	w.WriteUInt8(STORAGE_OPTIONS_VERSION)
+	w.WriteUInt8(uint8(opts.SyncMode))
+	w.WriteUInt16(uint16(opts.InternalPageSize))
+	w.WriteUInt32(uint32(opts.SplitKeys))
+	w.WriteUInt32(uint32(opts.SplitsPerSave))
+	w.WriteUInt32(uint32(opts.BranchFanOut))
+}
+
+// _OptionsOf returns the settings of the storage behind pager, nil for a
+// pager opened on its own. The getters below return the defaults on nil.
+func _OptionsOf(pager IPager) *Options {
+	switch p := pager.(type) {
+	case *StreamPager:
+		return p.options
+	case *InternalPager:
+		return p.options
+	case *SnapshotPager:
+		return p.parent.options
+	}
+	return nil
+}
+
+func (opts *Options) _InternalPageSize(dictDefault uint16) uint16 {
+	if opts == nil || opts.InternalPageSize == 0 {
+		return dictDefault
+	}
+	return uint16(opts.InternalPageSize)
+}
+
+func (opts *Options) _SplitKeys() int {
+	if opts == nil {
+		return STR_SPLIT_KEYS
+	}
+	return opts.SplitKeys
+}
+
+func (opts *Options) _SplitsPerSave() int {
+	if opts == nil {
+		return STR_SPLITS_PER_SAVE
+	}
+	return opts.SplitsPerSave
+}
+
+func (opts *Options) _BranchFanOut() int64 {
+	if opts == nil {
+		return int64(BRANCH_FAN_OUT)
+	}
+	return int64(opts.BranchFanOut)
+}
+
+func (opts *Options) _SyncMode() SyncMode {
+	if opts == nil {
+		return SYNC_FULL
+	}
+	return opts.SyncMode
+}
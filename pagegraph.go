@@ -0,0 +1,542 @@
+package gokvdb
+
+import (
+	"fmt"
+	"sort"
+	"errors"
+)
+
+const (
+	// the roles of the chains of a PageGraph
+	PAGE_ROLE_STORAGE_ROOT = "storage root"
+	PAGE_ROLE_DB_META = "db meta"
+	PAGE_ROLE_DICT_META = "dict meta"
+	PAGE_ROLE_BTREE_META = "btree meta"
+	PAGE_ROLE_INTERNAL_ROOT = "internal root"
+	PAGE_ROLE_INTERNAL_BRANCH = "internal branch"
+	PAGE_ROLE_INTERNAL_CONTEXT = "internal context"
+	PAGE_ROLE_INTERNAL_FREELIST = "internal freelist"
+	PAGE_ROLE_FREELIST = "freelist"
+)
+
+// PageChain is a run of pages linked through their headers, a payload or
+// a freelist. A chain whose payload holds page ids of the storage pager
+// keeps the payload and the offsets of those ids in it.
+type PageChain struct {
+	pgType uint8
+	role string
+	pageIds []uint32
+	data []byte
+	refOffsets []int
+	owner string
+}
+
+// PageGraph is every page reachable from the storage root: the root, the
+// DB contexts, the dict and BTree metas and the root, branch, context and
+// freelist pages of each InternalPager. The pager freelist is not in it.
+type PageGraph struct {
+	pager IPager
+	rootPageId uint32
+	chains []*PageChain
+	chainByPageId map[uint32]*PageChain
+	// report, when set, takes the problems of a walk for Check, which
+	// then goes on with the next chain
+	report *CheckReport
+}
+
+func (c *PageChain) ToString() string {
+	return fmt.Sprintf("<PageChain pgType=%v role=%v pages=%v refs=%v owner=%v>", c.pgType, c.role, len(c.pageIds), len(c.refOffsets), c.owner)
+}
+
+func (g *PageGraph) ToString() string {
+	return fmt.Sprintf("<PageGraph rootPageId=%v chains=%v pages=%v>", g.rootPageId, len(g.chains), len(g.chainByPageId))
+}
+
+// _WalkPageGraph reads every chain reachable from rootPageId. A page in two
+// chains is an ErrCorruptPage.
+func _WalkPageGraph(pager IPager, rootPageId uint32) (*PageGraph, error) {
+	g := _NewPageGraph(pager, rootPageId, nil)
+	return g, g._Walk()
+}
+
+func _NewPageGraph(pager IPager, rootPageId uint32, report *CheckReport) *PageGraph {
+	g := new(PageGraph)
+	g.pager = pager
+	g.rootPageId = rootPageId
+	g.chainByPageId = make(map[uint32]*PageChain)
+	g.report = report
+	return g
+}
+
+func (g *PageGraph) _Walk() error {
+
+	rootPageId := g.rootPageId
+
+	root, err := g._AddPayload(rootPageId, "", PAGE_ROLE_STORAGE_ROOT, true)
+	if err != nil {
+		return err
+	}
+
+	var dbNames []string
+	var dbMetaPageIds []uint32
+
+	err = _DecodePage("Storage root", rootPageId, root.data, func(rd *DataStream) {
+		dbCount := int(rd.ReadUInt16())
+		for i:=0; i<dbCount; i++ {
+			root.refOffsets = append(root.refOffsets, rd.offset)
+			dbMetaPageIds = append(dbMetaPageIds, rd.ReadUInt32())
+			dbNames = append(dbNames, rd.ReadHStr())
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, dbName := range dbNames {
+		err = g._Failed(g._WalkDBContext(dbName, dbMetaPageIds[i]))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// _Failed hands err to the report when there is one and returns it
+// otherwise.
+func (g *PageGraph) _Failed(err error) error {
+	if err == nil || g.report == nil {
+		return err
+	}
+	if !errors.Is(err, errReported) {
+		g.report._AddError(err)
+	}
+	return nil
+}
+
+func (g *PageGraph) _WalkDBContext(name string, pid uint32) error {
+
+	chain, err := g._AddPayload(pid, name, PAGE_ROLE_DB_META, true)
+	if err != nil {
+		return err
+	}
+
+	// the kinds tell which metas start with a StrBlob lastId
+	ctx, err := _OpenDBContext(name, g.pager, pid, chain.data)
+	if err != nil {
+		return err
+	}
+
+	var dsetNames []string
+	var dsetTypes []uint8
+	var dsetPageIds []uint32
+	var metaNames []string
+	var metaPageIds []uint32
+
+	err = _DecodePage("DBContext meta", pid, chain.data, func(rd *DataStream) {
+		dsetCount := int(rd.ReadUInt32())
+		for i:=0; i<dsetCount; i++ {
+			dsetTypes = append(dsetTypes, rd.ReadUInt8())
+			chain.refOffsets = append(chain.refOffsets, rd.offset)
+			dsetPageIds = append(dsetPageIds, rd.ReadUInt32())
+			dsetNames = append(dsetNames, rd.ReadHStr())
+		}
+
+		metaCount := int(rd.ReadUInt32())
+		for i:=0; i<metaCount; i++ {
+			metaNames = append(metaNames, rd.ReadHStr())
+			chain.refOffsets = append(chain.refOffsets, rd.offset)
+			metaPageIds = append(metaPageIds, rd.ReadUInt32())
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, dsetName := range dsetNames {
+		owner := _DictOwner(name, dsetName)
+		if dsetTypes[i] != DBTYPE_BTREE {
+			err = _OwnedError(_CorruptPageError("DBContext meta", pid, "dbType=%v", dsetTypes[i]), owner)
+		} else {
+			err = g._WalkBTreeMeta(dsetPageIds[i], owner)
+		}
+		err = g._Failed(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, metaName := range metaNames {
+		err = g._Failed(g._WalkDictMeta(metaPageIds[i], ctx._DictKind(metaName), _DictOwner(name, metaName)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *PageGraph) _WalkDictMeta(pid uint32, kind DictKind, owner string) error {
+
+	chain, err := g._AddPayload(pid, owner, PAGE_ROLE_DICT_META, true)
+	if err != nil {
+		return err
+	}
+
+	var offset int
+	var size uint32
+
+	err = _DecodePage("Dict meta", pid, chain.data, func(rd *DataStream) {
+		if kind == DICT_KIND_STRBLOB {
+			rd.ReadUInt64()
+		}
+		size = rd.ReadUInt24()
+		offset = rd.offset
+	})
+	if err == nil && (size != uint32(INTERNAL_PAGER_META_SIZE) || offset + INTERNAL_PAGER_META_SIZE > len(chain.data)) {
+		err = _CorruptPageError("Dict meta", pid, "internal pager meta bytes=%v", size)
+	}
+	if err != nil {
+		return _OwnedError(err, owner)
+	}
+
+	return g._WalkInternalPager(chain, offset, owner)
+}
+
+func (g *PageGraph) _WalkBTreeMeta(pid uint32, owner string) error {
+
+	chain, err := g._AddPayload(pid, owner, PAGE_ROLE_BTREE_META, true)
+	if err != nil {
+		return err
+	}
+
+	if len(chain.data) < INTERNAL_PAGER_META_SIZE {
+		return _OwnedError(_CorruptPageError("BTree meta", pid, "bytes=%v", len(chain.data)), owner)
+	}
+
+	return g._WalkInternalPager(chain, 0, owner)
+}
+
+// _WalkInternalPager adds the pages of the InternalPager whose meta is at
+// offset in the payload of meta.
+func (g *PageGraph) _WalkInternalPager(meta *PageChain, offset int, owner string) error {
+
+	var rootPageId uint32
+	var freelistPageId uint32
+
+	rd := NewDataStreamFromBuffer(meta.data)
+	rd.Seek(offset + 6)
+	meta.refOffsets = append(meta.refOffsets, rd.offset)
+	rootPageId = rd.ReadUInt32()
+	meta.refOffsets = append(meta.refOffsets, rd.offset)
+	freelistPageId = rd.ReadUInt32()
+
+	if rootPageId != 0 {
+		err := g._Failed(g._WalkInternalRoot(rootPageId, owner))
+		if err != nil {
+			return err
+		}
+	}
+
+	if freelistPageId != 0 {
+		_, err := g._AddFreeList(freelistPageId, owner, PAGE_ROLE_INTERNAL_FREELIST)
+		err = g._Failed(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *PageGraph) _WalkInternalRoot(rootPageId uint32, owner string) error {
+
+	root, err := g._AddPayload(rootPageId, owner, PAGE_ROLE_INTERNAL_ROOT, true)
+	if err != nil {
+		return err
+	}
+
+	var branchPageIds []uint32
+
+	err = _DecodePage("InternalPager root", rootPageId, root.data, func(rd *DataStream) {
+		rowsCount := int(rd.ReadUInt32())
+		for i:=0; i<rowsCount; i++ {
+			rd.ReadUInt32() // branchRootKey
+			root.refOffsets = append(root.refOffsets, rd.offset)
+			branchPageIds = append(branchPageIds, rd.ReadUInt32())
+		}
+	})
+	if err != nil {
+		return _OwnedError(err, owner)
+	}
+
+	for _, branchPageId := range branchPageIds {
+		err = g._Failed(g._WalkInternalBranch(branchPageId, owner))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *PageGraph) _WalkInternalBranch(branchPageId uint32, owner string) error {
+
+	branch, err := g._AddPayload(branchPageId, owner, PAGE_ROLE_INTERNAL_BRANCH, true)
+	if err != nil {
+		return err
+	}
+
+	var contextPageIds []uint32
+
+	err = _DecodePage("InternalBranchPage", branchPageId, branch.data, func(rd *DataStream) {
+		rowsCount := int(rd.ReadUInt32())
+		for i:=0; i<rowsCount; i++ {
+			rd.ReadUInt32() // branchKey
+			branch.refOffsets = append(branch.refOffsets, rd.offset)
+			contextPageIds = append(contextPageIds, rd.ReadUInt32())
+		}
+	})
+	if err != nil {
+		return _OwnedError(err, owner)
+	}
+
+	// a context holds ids of the InternalPager only
+	for _, contextPageId := range contextPageIds {
+		_, err = g._AddPayload(contextPageId, owner, PAGE_ROLE_INTERNAL_CONTEXT, false)
+		err = g._Failed(err)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// _AddPayload adds the payload chain at pid, with its data when keepData.
+func (g *PageGraph) _AddPayload(pid uint32, owner string, role string, keepData bool) (*PageChain, error) {
+
+	chain := new(PageChain)
+	chain.pgType = PGTYPE_PAYLOAD
+	chain.role = role
+	chain.owner = owner
+
+	var err error
+
+	if g.report != nil {
+		var ok bool
+		chain.pageIds, ok = g.report._WalkChain(g.pager, pid, PGTYPE_PAYLOAD, owner)
+		if !ok {
+			// the pages before the broken link are still taken
+			g._AddChain(chain)
+			return nil, errReported
+		}
+	} else {
+		w := new(PayloadPageWriter)
+		w.pager = g.pager
+		w.factory = NewPayloadPageFactory(g.pager)
+		err = w.CalcPageIds(pid)
+		if err != nil {
+			return nil, _OwnedError(err, owner)
+		}
+		chain.pageIds = w.pageIds
+	}
+
+	if keepData {
+		chain.data, err = g.pager.ReadPayloadData(pid)
+		if err != nil {
+			return nil, _OwnedError(err, owner)
+		}
+	}
+
+	return chain, g._AddChain(chain)
+}
+
+// _AddFreeList adds the chain of a freelist, the pages a shorter save left
+// linked behind it among them.
+func (g *PageGraph) _AddFreeList(pid uint32, owner string, role string) (*PageChain, error) {
+
+	chain := new(PageChain)
+	chain.pgType = PGTYPE_FREELIST
+	chain.role = role
+	chain.owner = owner
+
+	if g.report != nil {
+		var ok bool
+		chain.pageIds, ok = g.report._WalkChain(g.pager, pid, PGTYPE_FREELIST, owner)
+		g._AddChain(chain)
+		if !ok {
+			return nil, errReported
+		}
+		return chain, nil
+	}
+
+	pageIds, err := _FreeListPageIds(g.pager, pid)
+	if err != nil {
+		return nil, _OwnedError(err, owner)
+	}
+	chain.pageIds = pageIds
+
+	return chain, g._AddChain(chain)
+}
+
+func (g *PageGraph) _AddChain(chain *PageChain) error {
+
+	// a check reports the shared pages and leaves them to the first chain
+	if g.report != nil {
+		for _, pid := range chain.pageIds {
+			other, ok := g.chainByPageId[pid]
+			if ok {
+				g.report._Add(CHECK_SHARED_PAGE, pid, chain.owner, "page is in two chains, %v of %q and %v of %q", other.role, other.owner, chain.role, chain.owner)
+				continue
+			}
+			g.chainByPageId[pid] = chain
+		}
+		g.chains = append(g.chains, chain)
+		return nil
+	}
+
+	for _, pid := range chain.pageIds {
+		if pid < 1 {
+			return _OwnedError(_PageError("PageGraph", pid, ErrInvalidPageId), chain.owner)
+		}
+		other, ok := g.chainByPageId[pid]
+		if ok {
+			return _OwnedError(_CorruptPageError("PageGraph", pid, "page is in two chains, of %q and %q", other.owner, chain.owner), chain.owner)
+		}
+	}
+
+	for _, pid := range chain.pageIds {
+		g.chainByPageId[pid] = chain
+	}
+	g.chains = append(g.chains, chain)
+
+	return nil
+}
+
+// _PageIds returns the page ids of the graph in order.
+func (g *PageGraph) _PageIds() []uint32 {
+	var pageIds []uint32
+	for pid, _ := range g.chainByPageId {
+		pageIds = append(pageIds, pid)
+	}
+	sort.Sort(U32Array(pageIds))
+	return pageIds
+}
+
+// _Relocate writes every chain to dst with its page ids and the page ids
+// its payload holds mapped through newPageIdByPageId. Unless all is set
+// only the chains a moved page touches are written, a page id missing
+// from the map then stays where it is.
+func (g *PageGraph) _Relocate(dst IPager, newPageIdByPageId map[uint32]uint32, all bool) error {
+
+	mapPageId := func(pid uint32) uint32 {
+		newPageId, ok := newPageIdByPageId[pid]
+		if ok {
+			return newPageId
+		}
+		if all {
+			return 0
+		}
+		return pid
+	}
+
+	factory := NewPayloadPageFactory(dst)
+
+	for _, chain := range g.chains {
+
+		newPageIds := make([]uint32, len(chain.pageIds))
+		isMoved := all
+		for i, pid := range chain.pageIds {
+			newPageIds[i] = mapPageId(pid)
+			if newPageIds[i] != pid {
+				isMoved = true
+			}
+		}
+
+		if chain.data != nil {
+
+			data := make([]byte, len(chain.data))
+			copy(data, chain.data)
+
+			w := NewDataStreamFromBuffer(data)
+			for _, offset := range chain.refOffsets {
+				w.Seek(offset)
+				pid := w.ReadUInt32()
+				if pid == 0 {
+					continue
+				}
+				newPageId := mapPageId(pid)
+				if newPageId != pid {
+					w.Seek(offset)
+					w.WriteUInt32(newPageId)
+					isMoved = true
+				}
+			}
+
+			if !isMoved {
+				continue
+			}
+
+			err := factory._WritePayloadDataTo(newPageIds, data)
+			if err != nil {
+				return _OwnedError(err, chain.owner)
+			}
+			continue
+		}
+
+		err := g._CopyPages(dst, chain, newPageIds, mapPageId, all)
+		if err != nil {
+			return _OwnedError(err, chain.owner)
+		}
+	}
+
+	return nil
+}
+
+// _CopyPages copies the pages of a chain that holds no storage page ids as
+// they are, with the next page id in each header mapped.
+func (g *PageGraph) _CopyPages(dst IPager, chain *PageChain, newPageIds []uint32, mapPageId func(uint32) uint32, all bool) error {
+
+	nextOffset := 8
+	if chain.pgType == PGTYPE_FREELIST {
+		nextOffset = 6
+	}
+
+	for i, pid := range chain.pageIds {
+
+		pageData, err := g.pager.ReadPage(pid, 0)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				// allocated but never written
+				continue
+			}
+			return err
+		}
+
+		data := make([]byte, len(pageData))
+		copy(data, pageData)
+
+		w := NewDataStreamFromBuffer(data)
+		w.Seek(nextOffset)
+		nextPageId := w.ReadUInt32()
+		newNextPageId := nextPageId
+		if nextPageId != 0 {
+			newNextPageId = mapPageId(nextPageId)
+		}
+
+		if !all && newPageIds[i] == pid && newNextPageId == nextPageId {
+			continue
+		}
+
+		w.Seek(nextOffset)
+		w.WriteUInt32(newNextPageId)
+
+		err = dst.WritePage(newPageIds[i], data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
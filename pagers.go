@@ -2,8 +2,11 @@ package gokvdb
 
 import (
 	"os"
+	"io"
 	"fmt"
+	"sort"
 	"sync"
+	"errors"
 	"path/filepath"
 	//"strings"
 )
@@ -18,23 +21,32 @@ const (
 	PAYLOAD_PAGE_HEADER_SIZE int = 16
 )
 
+// IStream is the file under a StreamPager. ReadAt does not move the offset
+// of Read and Write and may run from several goroutines at once. Write,
+// SeekTo and Sync report the error of the file so a failed write is never
+// taken for a durable one.
 type IStream interface {
-	Write(data []byte)
+	Write(data []byte) error
 	Read(count int) ([]byte, error)
-	Seek(offset int64)
-	Sync()
+	ReadAt(offset int64, count int) ([]byte, error)
+	SeekTo(offset int64) error
+	Sync() error
 	Close()
+	// Size is the length of the stream, Truncate cuts it to size
+	Size() (int64, error)
+	Truncate(size int64) error
 	ToString() string
 }
 
 type IPager interface {
 	ReadPage(pid uint32, count int) ([]byte, error)
-	WritePage(pid uint32, data []byte)
+	WritePage(pid uint32, data []byte) error
 	CreatePageId() uint32
-	FreePageId(pid uint32)
-	WritePayloadData(pid uint32, data []byte)
+	FreePageId(pid uint32) error
+	WritePayloadData(pid uint32, data []byte) error
 	ReadPayloadData(pid uint32) ([]byte, error)
-	Save() []byte
+	FreePayloadData(pid uint32) error
+	Save() ([]byte, error)
 	GetPageSize() int
 	ToString() string 
 }
@@ -43,7 +55,7 @@ type IPager interface {
 type FileStream struct {
 	path string
 	file *os.File
-	rwlock sync.Mutex
+	rwlock sync.RWMutex
 }
 
 
@@ -53,6 +65,13 @@ type BaseStreamPager struct {
 	stream IStream
 	meta *StreamPagerMeta
 	isChanged bool
+	// dirtyPages buffers page writes until Flush, nil means write-through
+	dirtyPages map[uint32][]byte
+	wal *WriteAheadLog
+	readOnly bool
+	// noChecksums is set for a file written before checksums were added,
+	// its pages are stamped on write but not verified on read
+	noChecksums bool
 }
 
 type StreamPagerMeta struct {
@@ -66,6 +85,10 @@ type StreamPager struct {
 	freelist *FreePageList
 	
 	payloadFactory *PayloadPageFactory
+	walCheckpointSize int64
+	cache *PageCache
+	options *Options
+	versions *PageVersions
 }
 
 
@@ -74,11 +97,15 @@ func (s *FileStream) ToString() string {
 	return fmt.Sprintf("<FileStream path=%v", s.path)
 }
 
-func (s *FileStream) Write(data []byte) {
+func (s *FileStream) Write(data []byte) error {
 	s.rwlock.Lock()
 	defer s.rwlock.Unlock()
-	s.file.Write(data)
 
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	_, err := s.file.Write(data)
+	return err
 }
 
 func (s *FileStream) Read(count int) ([]byte, error) {
@@ -95,16 +122,72 @@ func (s *FileStream) Read(count int) ([]byte, error) {
 	return data, err
 }
 
-func (s *FileStream) Seek(offset int64) {
+// ReadAt fails with io.EOF at or past the end of the file like Read and
+// pads a short read with zeros.
+func (s *FileStream) ReadAt(offset int64, count int) ([]byte, error) {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+
+	if s.file == nil {
+		return nil, os.ErrClosed
+	}
+
+	data := make([]byte, count)
+	n, err := s.file.ReadAt(data, offset)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *FileStream) SeekTo(offset int64) error {
+	s.rwlock.Lock()
+	defer s.rwlock.Unlock()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	_, err := s.file.Seek(offset, os.SEEK_SET)
+	return err
+}
+
+func (s *FileStream) Sync() error {
 	s.rwlock.Lock()
 	defer s.rwlock.Unlock()
-	s.file.Seek(offset, os.SEEK_SET)
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	return s.file.Sync()
+}
+
+func (s *FileStream) Size() (int64, error) {
+	s.rwlock.RLock()
+	defer s.rwlock.RUnlock()
+
+	if s.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	info, err := s.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
 }
 
-func (s *FileStream) Sync() {
+func (s *FileStream) Truncate(size int64) error {
 	s.rwlock.Lock()
 	defer s.rwlock.Unlock()
-	s.file.Sync()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	return s.file.Truncate(size)
 }
 
 func (s *FileStream) Close() {
@@ -118,11 +201,28 @@ func (s *FileStream) Close() {
 }
 
 func OpenFileStream(path string) (IStream, error) {
+	stream, err := _OpenFileStream(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// _OpenFileStream creates the file and its directory unless readOnly, a
+// read-only stream needs an existing file.
+func _OpenFileStream(path string, readOnly bool) (*FileStream, error) {
 
 	fullpath, _ := filepath.Abs(path)
-	_CheckCreateDirpath(fullpath)
 
-	f, err := os.OpenFile(fullpath, os.O_RDWR | os.O_CREATE, 0666)
+	var f *os.File
+	var err error
+
+	if readOnly {
+		f, err = os.Open(fullpath)
+	} else {
+		_CheckCreateDirpath(fullpath)
+		f, err = os.OpenFile(fullpath, os.O_RDWR | os.O_CREATE, 0666)
+	}
 	if err != nil  {
 		return nil, err
 	}
@@ -165,14 +265,27 @@ func ReadOrNewStreamPagerMeta(pageSize uint32, data []byte) *StreamPagerMeta {
 	return meta
 }
 
-func NewStreamPager(stream IStream, meta *StreamPagerMeta) IPager {
+func NewStreamPager(stream IStream, meta *StreamPagerMeta) (IPager, error) {
+	return _NewStreamPager(stream, meta, nil, true)
+}
+
+// _NewStreamPager reads through wal from the start, a read-only storage
+// loads its freelist from pages the log was never checkpointed into. The
+// pages are not verified without checksums, a file written before them.
+func _NewStreamPager(stream IStream, meta *StreamPagerMeta, wal *WriteAheadLog, checksums bool) (IPager, error) {
 
 	pager := new(StreamPager)
 	basePager := new(BaseStreamPager)
 	basePager.stream = stream
 	basePager.meta = meta
+	basePager.wal = wal
+	basePager.noChecksums = !checksums
+	pager.versions = _NewPageVersions(nil)
 
-	freelist := _NewFreePageList(basePager, meta.freelistPageId, false)
+	freelist, err := _NewFreePageList(basePager, meta.freelistPageId, false)
+	if err != nil {
+		return nil, err
+	}
 
 	pager.basePager = basePager
 	pager.freelist = freelist
@@ -180,18 +293,22 @@ func NewStreamPager(stream IStream, meta *StreamPagerMeta) IPager {
 
 	basePager.meta.freelistPageId = freelist.rootPageId
 
-	return pager
+	return pager, nil
 }
 
-func (p *StreamPager) WritePayloadData(pid uint32, data []byte) {
+func (p *StreamPager) WritePayloadData(pid uint32, data []byte) error {
 	
-	p.payloadFactory.WritePayloadData(pid, data)
+	return p.payloadFactory.WritePayloadData(pid, data)
 }
 
 func (p *StreamPager) ReadPayloadData(pid uint32) ([]byte, error) {
 	return p.payloadFactory.ReadPayloadData(pid)
 }	
 
+func (p *StreamPager) FreePayloadData(pid uint32) error {
+	return p.payloadFactory.FreePayloadData(pid)
+}
+
 func (p *StreamPager) GetPageSize() int {
 	return int(p.basePager.meta.pageSize)
 }
@@ -200,34 +317,177 @@ func (p *StreamPager) ToString() string {
 	return fmt.Sprintf("<StreamPager meta=%v>", p.basePager.meta.ToString())
 }
 
-func (p *StreamPager) Save() []byte {
-	p.freelist.Save()
+func (p *StreamPager) Save() ([]byte, error) {
+	err := p.freelist.Save()
+	if err != nil {
+		return nil, err
+	}
+
+	// the meta is returned even when unchanged because the storage header
+	// is rewritten and checksummed as a whole
+	w := NewDataStreamFromBuffer(make([]byte, STREAM_PAGER_HEADER_SIZE))
+	w.WriteUInt32(p.basePager.meta.pageSize)
+	w.WriteUInt32(p.basePager.meta.lastPageId)
+	w.WriteUInt32(p.basePager.meta.freelistPageId)
+
+	//p.stream.Seek(0)
+	//p.stream.Write(w.ToBytes())
+
+	p.basePager.isChanged = false
+
+	//fmt.Println(">>>>>>>>>>>>>>>>> StreamRawPager SAVE", "p.meta.lastPageId", p.meta.lastPageId)
+
+	return w.ToBytes(), nil
+}
+
+func (p *StreamPager) _EnableWriteBuffer() {
+	if p.basePager.dirtyPages == nil {
+		p.basePager.dirtyPages = make(map[uint32][]byte)
+	}
+}
+
+// _DisableWriteBuffer writes the buffered pages and sends the later ones
+// straight to the stream.
+func (p *StreamPager) _DisableWriteBuffer() error {
+	err := p.basePager.Flush()
+	if err != nil {
+		return err
+	}
+	p.basePager.dirtyPages = nil
+	return nil
+}
+
+func (p *StreamPager) Flush() error {
+	return p.basePager.Flush()
+}
+
+func (p *StreamPager) Discard() {
+	p.basePager.Discard()
+}
+
+// _SetReadOnly makes every page write and commit fail with ErrReadOnly.
+func (p *StreamPager) _SetReadOnly() {
+	p.basePager.readOnly = true
+}
+
+// Commit makes the buffered pages and the storage header durable. With a
+// write-ahead log they are logged and fsynced first and folded into the
+// main file once the log grows past walCheckpointSize.
+func (p *StreamPager) Commit(header []byte) error {
 
-	if p.basePager.isChanged {
-		w := NewDataStreamFromBuffer(make([]byte, STREAM_PAGER_HEADER_SIZE))
-		w.WriteUInt32(p.basePager.meta.pageSize)
-		w.WriteUInt32(p.basePager.meta.lastPageId)
-		w.WriteUInt32(p.basePager.meta.freelistPageId)
+	base := p.basePager
+
+	if base.readOnly {
+		return ErrReadOnly
+	}
 
-		//p.stream.Seek(0)
-		//p.stream.Write(w.ToBytes())
+	// open snapshots must not see the commit until it is whole
+	p.versions.rwlock.Lock()
+	defer p.versions.rwlock.Unlock()
 
-		p.basePager.isChanged = false
+	err := p.versions._KeepImages(base)
+	if err != nil {
+		return err
+	}
 
-		//fmt.Println(">>>>>>>>>>>>>>>>> StreamRawPager SAVE", "p.meta.lastPageId", p.meta.lastPageId)
+	pageIds := base._UncommittedPageIds()
 
-		return w.ToBytes()		
+	err = p._Commit(header)
+	if err != nil {
+		return err
 	}
 
+	p.versions._Committed(header, pageIds)
+
 	return nil
 }
 
+func (p *StreamPager) _Commit(header []byte) error {
+
+	base := p.basePager
+
+	if base.wal == nil {
+		err := base.Flush()
+		if err != nil {
+			return err
+		}
+
+		err = base._WriteAt(0, header)
+		if err != nil {
+			return err
+		}
+		if p.options._SyncMode() != SYNC_OFF {
+			return base.stream.Sync()
+		}
+
+		return nil
+	}
+
+	var pageIds []uint32
+	for pid, _ := range base.dirtyPages {
+		pageIds = append(pageIds, pid)
+	}
+	sort.Sort(U32Array(pageIds))
+
+	err := base.wal.Append(pageIds, base.dirtyPages, header)
+	if err != nil {
+		return err
+	}
+
+	base.Discard()
+
+	if base.wal.Size() > p.walCheckpointSize {
+		return p._Checkpoint()
+	}
+
+	return nil
+}
+
+func (p *StreamPager) Checkpoint() error {
+	if p.basePager.readOnly {
+		return ErrReadOnly
+	}
+
+	p.versions.rwlock.Lock()
+	defer p.versions.rwlock.Unlock()
+
+	return p._Checkpoint()
+}
+
+func (p *StreamPager) _Checkpoint() error {
+	if p.basePager.wal == nil {
+		return nil
+	}
+	return p.basePager.wal.Checkpoint(p.basePager.stream)
+}
+
 func (p *StreamPager) ReadPage(pid uint32, count int) ([]byte, error) {
 	return p.basePager.ReadPage(pid, count)
 }
 
-func (p *StreamPager) WritePage(pid uint32, data []byte) {
-	p.basePager.WritePage(pid, data)
+func (p *StreamPager) WritePage(pid uint32, data []byte) error {
+	err := p.basePager.WritePage(pid, data)
+	if err != nil {
+		return err
+	}
+	return p._SpillIfFull()
+}
+
+// _SpillIfFull moves the write buffer to the write-ahead log once it holds
+// more than the cache budget, the pages wait there for the next commit.
+// Without a log the buffer is not limited.
+func (p *StreamPager) _SpillIfFull() error {
+
+	base := p.basePager
+	if base.wal == nil || base.dirtyPages == nil || p.cache == nil || p.cache.budget <= 0 {
+		return nil
+	}
+
+	if int64(len(base.dirtyPages)) * int64(base.meta.pageSize) <= p.cache.budget {
+		return nil
+	}
+
+	return base._Spill()
 }
 
 func (p *StreamPager)	CreatePageId() uint32 {
@@ -239,15 +499,18 @@ func (p *StreamPager)	CreatePageId() uint32 {
 	return p.basePager.CreatePageId()
 }
 
-func (p *StreamPager)	FreePageId(pid uint32) {
+func (p *StreamPager)	FreePageId(pid uint32) error {
 	//fmt.Println(p.ToString(), "FreePageId", pid)
 	if pid < 1 {
-		fmt.Printf("cant free pageId=%v\n", pid)
-		os.Exit(1)
+		return _PageError("FreePageId", pid, ErrInvalidPageId)
 	}
 	empty := make([]byte, PAYLOAD_PAGE_HEADER_SIZE)
-	p.basePager.WritePage(pid, empty)
+	err := p.basePager.WritePage(pid, empty)
+	if err != nil {
+		return err
+	}
 	p.freelist.Put(pid)
+	return nil
 }
 
 
@@ -256,39 +519,127 @@ func (p *BaseStreamPager) GetPageSize() int {
 }
 
 func (p *BaseStreamPager) CalcPageOffset(pid uint32) int64 {
-	return int64(pid * p.meta.pageSize)
+	return int64(pid) * int64(p.meta.pageSize)
 }
 
+// ReadPage always loads the whole page so its checksum can be verified and
+// then returns the first count bytes. Over an MmapStream the bytes are
+// those of the mapping and must not be changed.
 func (p *BaseStreamPager) ReadPage(pid uint32, count int) ([]byte, error) {
 
 	if count == 0 || count > int(p.meta.pageSize) {
 		count = int(p.meta.pageSize)
 	}
 
-	seek2 := p.CalcPageOffset(pid)
-	p.stream.Seek(seek2)
-	data, err := p.stream.Read(count)
+	if p.dirtyPages != nil {
+		pageData, ok := p.dirtyPages[pid]
+		if ok {
+			output := make([]byte, count)
+			copy(output, pageData)
+			return output, nil
+		}
+	}
 
-	//fmt.Printf("ReadPage pid=%v pageSize=%v count=%v seek=%v dataLen=%v\n", pid, p.meta.pageSize, count, seek2, len(data))
+	if p.wal != nil {
+		pageData, ok := p.wal.ReadPendingPage(pid, 0)
+		if ok {
+			if !p.noChecksums {
+				err := _VerifyChecksum("ReadPage", pid, pageData, PAGE_CHECKSUM_OFFSET)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return pageData[:count], nil
+		}
+	}
 
-	return data, err
+	return p._ReadCommittedPage(pid, count)
+}
+
+// _ReadCommittedPage reads pid from the log or the file, leaving out the
+// pages buffered since the last commit.
+func (p *BaseStreamPager) _ReadCommittedPage(pid uint32, count int) ([]byte, error) {
+
+	if count == 0 || count > int(p.meta.pageSize) {
+		count = int(p.meta.pageSize)
+	}
+
+	var data []byte
+
+	if p.wal != nil {
+		pageData, ok := p.wal.ReadPage(pid, 0)
+		if ok {
+			data = pageData
+		}
+	}
+
+	if data == nil {
+		seek2 := p.CalcPageOffset(pid)
+
+		var err error
+		data, err = p.stream.ReadAt(seek2, int(p.meta.pageSize))
+
+		//fmt.Printf("ReadPage pid=%v pageSize=%v count=%v seek=%v dataLen=%v\n", pid, p.meta.pageSize, count, seek2, len(data))
+
+		if err == io.EOF {
+			// allocated but never written
+			return nil, _PageError("ReadPage", pid, ErrNotFound)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) < int(p.meta.pageSize) {
+		return nil, _CorruptPageError("ReadPage", pid, "short page %v bytes", len(data))
+	}
+
+	if !p.noChecksums && !_IsZeroPage(data) {
+		err := _VerifyChecksum("ReadPage", pid, data, PAGE_CHECKSUM_OFFSET)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data[:count], nil
 }
 
 
-func (p *BaseStreamPager) WritePage(pid uint32, data []byte) {
+func (p *BaseStreamPager) WritePage(pid uint32, data []byte) error {
+	if p.readOnly {
+		return _PageError("WritePage", pid, ErrReadOnly)
+	}
 	if len(data) > int(p.meta.pageSize) {
-		fmt.Printf("page size needs <= %v\n", p.meta.pageSize)
-		os.Exit(1)
+		return _PageError("WritePage", pid, fmt.Errorf("%w: %v bytes, page size is %v", ErrPageTooLarge, len(data), p.meta.pageSize))
 	}
 
 	pageData := make([]byte, p.meta.pageSize)
 	copy(pageData, data)
+	_StampChecksum(pageData, PAGE_CHECKSUM_OFFSET)
+
+	if p.dirtyPages != nil {
+		p.dirtyPages[pid] = pageData
+		return nil
+	}
 
 	seek2 := p.CalcPageOffset(pid)
-	p.stream.Seek(seek2)
-	p.stream.Write(pageData)
+	err := p._WriteAt(seek2, pageData)
+	if err != nil {
+		return _PageError("WritePage", pid, err)
+	}
 
 	//fmt.Printf("WritePage pid=%v seek=%v dataLen=%v\n", pid, seek2, len(data))
+
+	return nil
+}
+
+func (p *BaseStreamPager) _WriteAt(offset int64, data []byte) error {
+	err := p.stream.SeekTo(offset)
+	if err != nil {
+		return err
+	}
+	return p.stream.Write(data)
 }
 	
 func (p *BaseStreamPager)	CreatePageId() uint32 {
@@ -301,7 +652,87 @@ func (p *BaseStreamPager)	CreatePageId() uint32 {
 	return pid
 }
 
-func (p *BaseStreamPager)	FreePageId(pid uint32) {
+func (p *BaseStreamPager)	FreePageId(pid uint32) error {
+	return nil
+}
+
+// Flush writes the buffered pages to the stream in page order. The pages
+// stay buffered when a write fails.
+func (p *BaseStreamPager) Flush() error {
+
+	if len(p.dirtyPages) == 0 {
+		return nil
+	}
+
+	var pageIds []uint32
+	for pid, _ := range p.dirtyPages {
+		pageIds = append(pageIds, pid)
+	}
+	sort.Sort(U32Array(pageIds))
+
+	for _, pid := range pageIds {
+		err := p._WriteAt(p.CalcPageOffset(pid), p.dirtyPages[pid])
+		if err != nil {
+			return _PageError("Flush", pid, err)
+		}
+	}
+
+	p.dirtyPages = make(map[uint32][]byte)
+
+	return nil
+}
+
+// Discard drops the buffered pages and the ones spilled to the log
+// without writing them.
+func (p *BaseStreamPager) Discard() {
+	if p.dirtyPages != nil {
+		p.dirtyPages = make(map[uint32][]byte)
+	}
+	if p.wal != nil {
+		// a tail left by a failed truncate is never committed
+		p.wal.RestorePending(WALPending{})
+	}
+}
+
+// _Spill logs the buffered pages ahead of the next commit and empties the
+// buffer.
+func (p *BaseStreamPager) _Spill() error {
+
+	var pageIds []uint32
+	for pid, _ := range p.dirtyPages {
+		pageIds = append(pageIds, pid)
+	}
+	sort.Sort(U32Array(pageIds))
+
+	err := p.wal.Spill(pageIds, p.dirtyPages)
+	if err != nil {
+		return err
+	}
+
+	p.dirtyPages = make(map[uint32][]byte)
+
+	return nil
+}
+
+// _UncommittedPageIds returns the pages written since the last commit, in
+// the buffer or spilled to the log.
+func (p *BaseStreamPager) _UncommittedPageIds() []uint32 {
+
+	var pageIds []uint32
+	for pid, _ := range p.dirtyPages {
+		pageIds = append(pageIds, pid)
+	}
+
+	if p.wal != nil {
+		for _, pid := range p.wal.PendingPageIds() {
+			_, ok := p.dirtyPages[pid]
+			if !ok {
+				pageIds = append(pageIds, pid)
+			}
+		}
+	}
+
+	return pageIds
 }
 
 
@@ -310,23 +741,22 @@ func (p *BaseStreamPager) ToString() string {
 	return fmt.Sprintf("<BaseStreamPager lastPageId=%v>", p.meta.lastPageId)
 }
 
-func (p *BaseStreamPager) Save() []byte {
-	fmt.Println("NO Implemented Save")	
-	os.Exit(1)
-	return nil
+func (p *BaseStreamPager) Save() ([]byte, error) {
+	return nil, ErrNotImplemented
 }
 
-func (p *BaseStreamPager) WritePayloadData(pid uint32, data []byte) {
-	fmt.Println("NO Implemented WritePayloadData")	
-	os.Exit(1)
+func (p *BaseStreamPager) WritePayloadData(pid uint32, data []byte) error {
+	return ErrNotImplemented
 }
 
 func (p *BaseStreamPager) ReadPayloadData(pid uint32) ([]byte, error) {
-	fmt.Println("NO Implemented ReadPayloadData")	
-	os.Exit(1)
-	return nil, DBError{message: "NO Implemented ReadPayloadData"}
+	return nil, ErrNotImplemented
 }	
 
+func (p *BaseStreamPager) FreePayloadData(pid uint32) error {
+	return ErrNotImplemented
+}
+
 
 type PayloadPageFactory struct {
 	pager IPager
@@ -370,11 +800,12 @@ func _ReadPayloadPageHeader(rd *DataStream) PayloadPageHeader {
 	return hdr
 }
 
-func (w *PayloadPageWriter) CalcPageIds(pid uint32) {	
+func (w *PayloadPageWriter) CalcPageIds(pid uint32) error {	
 
 	//fmt.Println("CalcPageIds pid", pid)
 
 	var pageIds []uint32
+	visited := make(map[uint32]bool)
 
 	var curPageId uint32
 	var nextPageId uint32
@@ -385,20 +816,18 @@ func (w *PayloadPageWriter) CalcPageIds(pid uint32) {
 	for {
 		//fmt.Println("PayloadPageWriter CalcPageIds", nextPageId)
 
-		if curPageId == nextPageId {
-			fmt.Printf("ERROR curPageId=%v nextPageId=%v\n", curPageId, nextPageId)
-			os.Exit(1)
-
-			break
+		if visited[nextPageId] {
+			return _CorruptPageError("CalcPageIds", pid, "page %v links back to %v", curPageId, nextPageId)
 		}
 
 		curPageId = nextPageId
 
 		if curPageId < 1 {
-			fmt.Printf("ERROR payloadPageId=%v\n", curPageId)
-			os.Exit(1)
+			return _PageError("CalcPageIds", pid, ErrInvalidPageId)
 		}
 
+		visited[curPageId] = true
+
 		pageIds = append(pageIds, curPageId)
 
 		headerBytes, err := w.pager.ReadPage(curPageId, PAYLOAD_PAGE_HEADER_SIZE)
@@ -406,6 +835,10 @@ func (w *PayloadPageWriter) CalcPageIds(pid uint32) {
 		if w.factory.isDebug {
 			fmt.Printf("[CalcPageIds] rootId=%v curPageId=%v err=%v headerBytes=%v\n", pid, curPageId, err, headerBytes)
 		}
+
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
 		//
 		if err == nil && len(headerBytes) == PAYLOAD_PAGE_HEADER_SIZE  {
 			hdrR := NewDataStreamFromBuffer(headerBytes)
@@ -435,6 +868,7 @@ func (w *PayloadPageWriter) CalcPageIds(pid uint32) {
 	w.pageIdIndex = 0
 	w.pageIds = pageIds
 
+	return nil
 }
 
 func (w *PayloadPageWriter) GetOrCreateNextWritePageId() uint32 {	
@@ -448,7 +882,7 @@ func (w *PayloadPageWriter) GetOrCreateNextWritePageId() uint32 {
 	return w.pager.CreatePageId()
 }
 
-func (w *PayloadPageWriter) FreePageIds() {
+func (w *PayloadPageWriter) FreePageIds() error {
 
 
 	for {
@@ -458,18 +892,30 @@ func (w *PayloadPageWriter) FreePageIds() {
 		}
 		pid := w.pageIds[w.pageIdIndex]
 		//fmt.Println("PayloadPageWriter FreePageIds", pid)
-		if pid == 0 {
-			fmt.Println("FreePageIds cant == 0")
-			os.Exit(1)
+		err := w.pager.FreePageId(pid)
+		if err != nil {
+			return err
 		}
-		w.pager.FreePageId(pid)
 		w.pageIdIndex += 1
 	}
 
+	return nil
+}
+
+func (w *PayloadPageWriter) Write(pid uint32, data []byte) error {
+	err := w.CalcPageIds(pid)
+	if err != nil {
+		return err
+	}
+
+	return w._Write(pid, data)
 }
 
-func (w *PayloadPageWriter) Write(pid uint32, data []byte) {
-	w.CalcPageIds(pid)
+// _Write lays data over the page ids from CalcPageIds, creating more pages
+// when they run out and freeing the ones left over.
+func (w *PayloadPageWriter) _Write(pid uint32, data []byte) error {
+
+	var err error
 
 	ds := NewDataStream()
 	ds.WriteUInt32(uint32(len(data)))
@@ -532,8 +978,7 @@ func (w *PayloadPageWriter) Write(pid uint32, data []byte) {
 		}
 
 		if hasNextPage && nextPageId < 1 {
-			fmt.Println("Error nextPageId", nextPageId)
-			os.Exit(1)
+			return _PageError("WritePayloadData", pid, ErrInvalidPageId)
 		}
 
 		pageContentData := writeData[iStart:iEnd]
@@ -564,39 +1009,62 @@ func (w *PayloadPageWriter) Write(pid uint32, data []byte) {
 			pageData = pageData[:pageSize]
 		}
 
-		testRd := NewDataStreamFromBuffer(pageData)
-		testRd.ReadUInt8()
-		testRd.ReadUInt16()
-		testContentLen := testRd.ReadUInt32()
-
-		w.pager.WritePage(curPageId, pageData)
-
-		//fmt.Println("SAVE pageData", "loopCount", loopCount, "pid=", curPageId, "len", len(pageData), "testContentLen", testContentLen, len(pageContentData))
-
-		if len(pageContentData) != int(testContentLen) {
-			fmt.Println("TEST CONTENT LEN ERROR!", pageContentDataLen, testContentLen, int(testContentLen))
-			os.Exit(1)
+		err = w.pager.WritePage(curPageId, pageData)
+		if err != nil {
+			return err
 		}
 
+		//fmt.Println("SAVE pageData", "loopCount", loopCount, "pid=", curPageId, "len", len(pageData), "pageContentDataLen", pageContentDataLen)
 
 		iStart += pageContentSize
 		pageIndex += 1
 	}
 
-	w.FreePageIds()
+	return w.FreePageIds()
 }
 
 
-func (f *PayloadPageFactory) WritePayloadData(pid uint32, data []byte) {
+func (f *PayloadPageFactory) WritePayloadData(pid uint32, data []byte) error {
 	if pid < 1 {
-		fmt.Println("WritePayloadData ERROR pid=", pid)
-		os.Exit(1)
+		return _PageError("WritePayloadData", pid, ErrInvalidPageId)
 	}
 
 	w := new(PayloadPageWriter)
 	w.pager = f.pager
 	w.factory = f
-	w.Write(pid, data)
+	return w.Write(pid, data)
+}
+
+// _WritePayloadDataTo writes data over exactly pageIds, the chain it had
+// where the pages were moved from.
+func (f *PayloadPageFactory) _WritePayloadDataTo(pageIds []uint32, data []byte) error {
+	if len(pageIds) < 1 {
+		return _PageError("WritePayloadData", 0, ErrInvalidPageId)
+	}
+
+	w := new(PayloadPageWriter)
+	w.pager = f.pager
+	w.factory = f
+	w.pageIds = pageIds
+	w.pageIdIndex = 0
+	return w._Write(pageIds[0], data)
+}
+
+// FreePayloadData returns every page of the payload chain starting at pid
+// to the pager freelist.
+func (f *PayloadPageFactory) FreePayloadData(pid uint32) error {
+	if pid < 1 {
+		return _PageError("FreePayloadData", pid, ErrInvalidPageId)
+	}
+
+	w := new(PayloadPageWriter)
+	w.pager = f.pager
+	w.factory = f
+	err := w.CalcPageIds(pid)
+	if err != nil {
+		return err
+	}
+	return w.FreePageIds()
 }
 
 
@@ -618,16 +1086,29 @@ func (f *PayloadPageFactory) 	ReadPayloadData(pid uint32) ([]byte, error) {
 		//fmt.Println("ReadPayloadData", "rootPid", pid, "pageCount", pageCount, "curPageId", curPageId)
 		pageData, err := f.pager.ReadPage(curPageId, 0)
 		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, _CorruptPageError("ReadPayloadData", pid, "missing page %v", curPageId)
+			}
 			return nil, err
 		}
 
+		if len(pageData) < PAYLOAD_PAGE_HEADER_SIZE {
+			return nil, _CorruptPageError("ReadPayloadData", pid, "short page %v", curPageId)
+		}
 
 		rd := NewDataStreamFromBuffer(pageData)
 		pgType := rd.ReadUInt8()
 		header := _ReadPayloadPageHeader(rd)
 		if pgType != PGTYPE_PAYLOAD {
-			fmt.Println("ERROR PayloadPage pgType=", pgType)
-			os.Exit(1)
+			return nil, _CorruptPageError("ReadPayloadData", pid, "page %v pgType=%v", curPageId, pgType)
+		}
+
+		if int(header.contentLen) > len(pageData) - PAYLOAD_PAGE_HEADER_SIZE {
+			return nil, _CorruptPageError("ReadPayloadData", pid, "page %v contentLen=%v", curPageId, header.contentLen)
+		}
+
+		if header.hasNextPage && header.nextPageId == 0 {
+			return nil, _CorruptPageError("ReadPayloadData", pid, "page %v nextPageId=%v", curPageId, header.nextPageId)
 		}
 
 		//fmt.Printf("Read PayloadPage rootPid=%v pageIndex=%v pageDataLen=%v hasNextPage=%v curPageId=%v nextPageId=%v\n", pid, header.pageIndex, header.contentLen, header.hasNextPage, curPageId,  header.nextPageId )
@@ -644,11 +1125,16 @@ func (f *PayloadPageFactory) 	ReadPayloadData(pid uint32) ([]byte, error) {
 			break
 		}
 
+		// a chain running past its declared length is broken or cyclic
+		if len(allBytes) > PAYLOAD_HEADER_SIZE && uint32(len(allBytes) - PAYLOAD_HEADER_SIZE) > NewDataStreamFromBuffer(allBytes).ReadUInt32() {
+			return nil, _CorruptPageError("ReadPayloadData", pid, "page %v runs past the payload length", curPageId)
+		}
+
 		curPageId = header.nextPageId
 	}
 
 	if len(allBytes) < PAYLOAD_HEADER_SIZE {
-		return nil, DBError{message: fmt.Sprintf("pid=%v bytes=%v not enough!", pid, len(allBytes))}
+		return nil, _CorruptPageError("ReadPayloadData", pid, "bytes=%v not enough", len(allBytes))
 	}
 
 	buf := NewDataStreamFromBuffer(allBytes)
@@ -657,7 +1143,7 @@ func (f *PayloadPageFactory) 	ReadPayloadData(pid uint32) ([]byte, error) {
 	allBytes = allBytes[PAYLOAD_HEADER_SIZE:]
 
 	if uint32(len(allBytes)) != payloadDataLenRequired {
-		return nil, DBError{message: fmt.Sprintf("bytes length needs %v payload data is %v!", payloadDataLenRequired, len(allBytes))}
+		return nil, _CorruptPageError("ReadPayloadData", pid, "bytes length needs %v payload data is %v", payloadDataLenRequired, len(allBytes))
 	}
 
 	return allBytes, nil
@@ -0,0 +1,28 @@
+package gokvdb
+
+import (
+	"math"
+)
+
+// _RangeBounds turns the half-open Range(from, to) into the inclusive
+// bounds the walkers take. ok is false when the range is empty.
+func _RangeBounds(from int64, to int64) (int64, int64, bool) {
+	if to <= from {
+		return 0, 0, false
+	}
+	return from, to - 1, true
+}
+
+// _SeekBounds returns the inclusive bounds of SeekKey(key, reverse), from key
+// up to the last key or down to the first one.
+func _SeekBounds(key int64, reverse bool) (int64, int64) {
+	if reverse {
+		return math.MinInt64, key
+	}
+	return key, math.MaxInt64
+}
+
+// _EmptyCursor returns a cursor with no items.
+func _EmptyCursor[K any, V any]() *Cursor[K, V] {
+	return _ErrCursor[K, V](nil)
+}
@@ -0,0 +1,436 @@
+package gokvdb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"errors"
+)
+
+// PageVersions keeps, while a snapshot older than a commit is open, the
+// committed image of every page that commit overwrites. A commit holds its
+// write lock from the first page it replaces to the new header, snapshot
+// reads hold its read lock, so a reader never sees half a commit.
+type PageVersions struct {
+	// seq counts the commits since the storage was opened
+	seq uint64
+	header []byte
+	imagesByPageId map[uint32][]PageImage
+	snapshotCountBySeq map[uint64]int
+	// epoch is the commit count of the file, epochByPageId the epoch of
+	// the last commit that wrote each page since the open at openEpoch
+	epoch uint64
+	openEpoch uint64
+	epochByPageId map[uint32]uint64
+	rwlock sync.RWMutex
+}
+
+// PageImage is a page as it was before commit seq, nil data for a page
+// that was not written yet.
+type PageImage struct {
+	seq uint64
+	data []byte
+}
+
+// SnapshotPager reads the pages of a StreamPager as they were at commit
+// seq. It never writes, the page ids it creates are only known to the
+// dicts of its snapshot.
+type SnapshotPager struct {
+	parent *StreamPager
+	seq uint64
+	pageSize uint32
+	lastPageId uint32
+	payloadFactory *PayloadPageFactory
+}
+
+// Snapshot is the point-in-time view of the last commit that View hands to
+// its function. Its dicts are loaded apart from the ones of the writer and
+// see no later commit, saving them fails with ErrReadOnly.
+type Snapshot struct {
+	storage *Storage
+	pager *SnapshotPager
+	header []byte
+	isDone bool
+}
+
+func _NewPageVersions(header []byte) *PageVersions {
+	v := new(PageVersions)
+	v.header = header
+	v.imagesByPageId = make(map[uint32][]PageImage)
+	v.snapshotCountBySeq = make(map[uint64]int)
+	v.epoch = _ReadStorageEpoch(header)
+	v.openEpoch = v.epoch
+	v.epochByPageId = make(map[uint32]uint64)
+	return v
+}
+
+func (v *PageVersions) ToString() string {
+	return fmt.Sprintf("<PageVersions seq=%v epoch=%v pages=%v snapshots=%v>", v.seq, v.epoch, len(v.imagesByPageId), len(v.snapshotCountBySeq))
+}
+
+func (p *SnapshotPager) ToString() string {
+	return fmt.Sprintf("<SnapshotPager seq=%v lastPageId=%v>", p.seq, p.lastPageId)
+}
+
+func (snap *Snapshot) ToString() string {
+	return fmt.Sprintf("<Snapshot seq=%v isDone=%v>", snap.pager.seq, snap.isDone)
+}
+
+// _KeepImages saves the committed image of the pages about to be replaced
+// by commit seq+1, unless each open snapshot already has one of a later
+// commit to read. It runs with the write lock held.
+func (v *PageVersions) _KeepImages(pager *BaseStreamPager) error {
+
+	if len(v.snapshotCountBySeq) == 0 {
+		return nil
+	}
+
+	var newestSeq uint64
+	for seq, _ := range v.snapshotCountBySeq {
+		if seq > newestSeq {
+			newestSeq = seq
+		}
+	}
+
+	for _, pid := range pager._UncommittedPageIds() {
+
+		images := v.imagesByPageId[pid]
+		if len(images) > 0 && images[len(images) - 1].seq > newestSeq {
+			continue
+		}
+
+		data, err := pager._ReadCommittedPage(pid, 0)
+		if errors.Is(err, ErrNotFound) {
+			data, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// a mapped page changes with the file
+		var image []byte
+		if data != nil {
+			image = make([]byte, len(data))
+			copy(image, data)
+		}
+
+		v.imagesByPageId[pid] = append(images, PageImage{seq: v.seq + 1, data: image})
+	}
+
+	return nil
+}
+
+func (v *PageVersions) _Committed(header []byte, pageIds []uint32) {
+	v.seq += 1
+	v.header = make([]byte, len(header))
+	copy(v.header, header)
+
+	v.epoch = _ReadStorageEpoch(header)
+	for _, pid := range pageIds {
+		v.epochByPageId[pid] = v.epoch
+	}
+}
+
+// _ChangedPageIds returns the pages up to lastPageId written by a commit
+// after epoch, sorted. A page written after the snapshot asking may come
+// along too, which only costs a copy. It reports false when epoch is older
+// than the open, the pages written before are not known.
+func (v *PageVersions) _ChangedPageIds(epoch uint64, lastPageId uint32) ([]uint32, bool) {
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+
+	if epoch < v.openEpoch {
+		return nil, false
+	}
+
+	var pageIds []uint32
+	for pid, pageEpoch := range v.epochByPageId {
+		if pageEpoch > epoch && pid <= lastPageId {
+			pageIds = append(pageIds, pid)
+		}
+	}
+	sort.Sort(U32Array(pageIds))
+
+	return pageIds, true
+}
+
+func (v *PageVersions) _OpenSnapshot() (uint64, []byte) {
+	v.rwlock.Lock()
+	defer v.rwlock.Unlock()
+
+	v.snapshotCountBySeq[v.seq] += 1
+
+	return v.seq, v.header
+}
+
+// _CloseSnapshot drops the images no open snapshot reads any more.
+func (v *PageVersions) _CloseSnapshot(seq uint64) {
+	v.rwlock.Lock()
+	defer v.rwlock.Unlock()
+
+	v.snapshotCountBySeq[seq] -= 1
+	if v.snapshotCountBySeq[seq] <= 0 {
+		delete(v.snapshotCountBySeq, seq)
+	}
+
+	if len(v.snapshotCountBySeq) == 0 {
+		v.imagesByPageId = make(map[uint32][]PageImage)
+		return
+	}
+
+	oldestSeq := v.seq
+	for seq, _ := range v.snapshotCountBySeq {
+		if seq < oldestSeq {
+			oldestSeq = seq
+		}
+	}
+
+	for pid, images := range v.imagesByPageId {
+		i := 0
+		for i < len(images) && images[i].seq <= oldestSeq {
+			i += 1
+		}
+		if i == len(images) {
+			delete(v.imagesByPageId, pid)
+		} else if i > 0 {
+			v.imagesByPageId[pid] = images[i:]
+		}
+	}
+}
+
+func (p *StreamPager) _NewSnapshotPager() (*SnapshotPager, []byte) {
+
+	seq, header := p.versions._OpenSnapshot()
+
+	sp := new(SnapshotPager)
+	sp.parent = p
+	sp.seq = seq
+	sp.pageSize = p.basePager.meta.pageSize
+	sp.payloadFactory = NewPayloadPageFactory(sp)
+
+	if header != nil {
+		rd := NewDataStreamFromBuffer(header)
+		rd.Seek(STORAGE_PAGER_META_OFFSET)
+		rd.ReadUInt32()
+		sp.lastPageId = rd.ReadUInt32()
+	}
+
+	return sp, header
+}
+
+// ReadPage returns the image the first commit after the snapshot replaced,
+// or the committed page when no commit did.
+func (p *SnapshotPager) ReadPage(pid uint32, count int) ([]byte, error) {
+
+	if count == 0 || count > int(p.pageSize) {
+		count = int(p.pageSize)
+	}
+
+	v := p.parent.versions
+	v.rwlock.RLock()
+	defer v.rwlock.RUnlock()
+
+	for _, image := range v.imagesByPageId[pid] {
+		if image.seq > p.seq {
+			if image.data == nil {
+				return nil, _PageError("Snapshot ReadPage", pid, ErrNotFound)
+			}
+			output := make([]byte, count)
+			copy(output, image.data)
+			return output, nil
+		}
+	}
+
+	data, err := p.parent.basePager._ReadCommittedPage(pid, count)
+	if err != nil {
+		return nil, err
+	}
+
+	output := make([]byte, len(data))
+	copy(output, data)
+
+	return output, nil
+}
+
+func (p *SnapshotPager) WritePage(pid uint32, data []byte) error {
+	return _PageError("Snapshot WritePage", pid, ErrReadOnly)
+}
+
+func (p *SnapshotPager) CreatePageId() uint32 {
+	p.lastPageId += 1
+	return p.lastPageId
+}
+
+func (p *SnapshotPager) FreePageId(pid uint32) error {
+	return _PageError("Snapshot FreePageId", pid, ErrReadOnly)
+}
+
+func (p *SnapshotPager) WritePayloadData(pid uint32, data []byte) error {
+	return _PageError("Snapshot WritePayloadData", pid, ErrReadOnly)
+}
+
+func (p *SnapshotPager) ReadPayloadData(pid uint32) ([]byte, error) {
+	return p.payloadFactory.ReadPayloadData(pid)
+}
+
+func (p *SnapshotPager) FreePayloadData(pid uint32) error {
+	return _PageError("Snapshot FreePayloadData", pid, ErrReadOnly)
+}
+
+func (p *SnapshotPager) Save() ([]byte, error) {
+	return nil, ErrReadOnly
+}
+
+func (p *SnapshotPager) GetPageSize() int {
+	return int(p.pageSize)
+}
+
+// _OpenSnapshot loads the root of the last commit into a read-only
+// Storage of its own.
+func (s *Storage) _OpenSnapshot() (*Snapshot, error) {
+
+	pager, header := s.pager.(*StreamPager)._NewSnapshotPager()
+
+	storage := new(Storage)
+	storage.path = s.path
+	storage.stream = s.stream
+	storage.pager = pager
+	storage.readOnly = true
+	storage.options = s.options
+	storage.dbItems = make(map[string]*DBItem)
+
+	snap := new(Snapshot)
+	snap.storage = storage
+	snap.pager = pager
+	snap.header = header
+
+	if header != nil {
+		storage.rootPageId = NewDataStreamFromBuffer(header).ReadUInt32()
+	}
+
+	if storage.rootPageId == 0 {
+		storage.rootPageId = pager.CreatePageId()
+	} else {
+		err := storage._LoadRoot()
+		if err != nil {
+			snap._Release()
+			return nil, err
+		}
+	}
+
+	return snap, nil
+}
+
+func (snap *Snapshot) _Release() {
+	if snap.isDone {
+		return
+	}
+	snap.isDone = true
+	snap.pager.parent.versions._CloseSnapshot(snap.pager.seq)
+}
+
+func (snap *Snapshot) _Check() error {
+	if snap.isDone {
+		return DBError{message: "snapshot is released"}
+	}
+	return nil
+}
+
+// View runs fn on a snapshot of the last commit. Views run alongside each
+// other and alongside one writer, a view sees no commit made after it
+// started. Dicts opened from the snapshot must not outlive fn.
+func (s *Storage) View(fn func(snap *Snapshot) error) error {
+
+	snap, err := s._OpenSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap._Release()
+
+	return fn(snap)
+}
+
+// Update runs fn in a transaction which is committed when fn returns nil
+// and rolled back otherwise. Updates wait for each other, the storage must
+// not be written outside of them meanwhile.
+func (s *Storage) Update(fn func(tx *Tx) error) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	tx, err := s._Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if !tx.isDone {
+			tx.Rollback()
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (snap *Snapshot) DB(name string) (*DBContext, error) {
+	err := snap._Check()
+	if err != nil {
+		return nil, err
+	}
+	return snap.storage.DB(name)
+}
+
+func (snap *Snapshot) I64StrDict(dbName string, dictName string) (*LazyI64StrDict, error) {
+	err := snap._Check()
+	if err != nil {
+		return nil, err
+	}
+	return NewI64StrDict(snap.storage, dbName, dictName)
+}
+
+func (snap *Snapshot) StrI64Dict(dbName string, dictName string) (*LazyStrI64Dict, error) {
+	err := snap._Check()
+	if err != nil {
+		return nil, err
+	}
+	return NewStrI64Dict(snap.storage, dbName, dictName)
+}
+
+func (snap *Snapshot) I64BlobDict(dbName string, dictName string) (*LazyI64BlobDict, error) {
+	err := snap._Check()
+	if err != nil {
+		return nil, err
+	}
+	return NewI64BlobDict(snap.storage, dbName, dictName)
+}
+
+func (snap *Snapshot) StrBlobDict(dbName string, dictName string) (*LazyStrBlobDict, error) {
+	err := snap._Check()
+	if err != nil {
+		return nil, err
+	}
+	return NewStrBlobDict(snap.storage, dbName, dictName)
+}
+
+func (snap *Snapshot) I64I64SetDict(dbName string, dictName string) (*LazyI64I64SetDict, error) {
+	err := snap._Check()
+	if err != nil {
+		return nil, err
+	}
+	return NewLazyI64I64SetDict(snap.storage, dbName, dictName)
+}
+
+func (snap *Snapshot) StrI64SetDict(dbName string, dictName string) (*LazyStrI64SetDict, error) {
+	err := snap._Check()
+	if err != nil {
+		return nil, err
+	}
+	return NewStrI64SetDict(snap.storage, dbName, dictName)
+}
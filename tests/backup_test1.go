@@ -0,0 +1,270 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"bytes"
+	"strings"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 3000
+)
+
+func main() {
+
+	stamp := time.Now().UTC().UnixNano()
+	dbPath := fmt.Sprintf("./testdata/backup_%v.kv", stamp)
+	restorePath := fmt.Sprintf("./testdata/backup_%v_restore.kv", stamp)
+	fullPath := fmt.Sprintf("./testdata/backup_%v.full", stamp)
+	inc1Path := fmt.Sprintf("./testdata/backup_%v.inc1", stamp)
+	inc2Path := fmt.Sprintf("./testdata/backup_%v.inc2", stamp)
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	Fill(s, "mydb", 0, KEY_COUNT)
+
+	// a writer goes on while the backup reads its snapshot
+	done := make(chan error)
+	go func() {
+		done <- s.Update(func(tx *gokvdb.Tx) error {
+			nameById, err := tx.I64StrDict("mydb", "nameById")
+			if err != nil {
+				return err
+			}
+			for i:=KEY_COUNT; i<KEY_COUNT * 2; i++ {
+				err = nameById.Set(int64(i), fmt.Sprintf("name-%v", i))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+
+	full, err := s.BackupTo(fullPath)
+	testutils.CheckErr(err)
+	testutils.CheckErr(<-done)
+	fmt.Println("FULL", full.ToString())
+
+	info, err := gokvdb.ReadBackupInfo(fullPath)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(info, full)
+
+	// the storage holds its own file locked
+	_, err = gokvdb.RestoreFrom(dbPath, fullPath)
+	testutils.ExpectErr(err, gokvdb.ErrLocked)
+
+	_, err = gokvdb.RestoreFrom(restorePath, fullPath)
+	testutils.CheckErr(err)
+	r := Open(restorePath)
+	Verify(r, "mydb", 0, KEY_COUNT)
+	testutils.CheckErr(r.Close())
+
+	// an incremental backup holds the pages of the commits after its base
+	Fill(s, "mydb", KEY_COUNT * 2, KEY_COUNT * 3)
+	inc1, err := s.BackupIncrementalTo(inc1Path, full)
+	testutils.CheckErr(err)
+	fmt.Println("INC1", inc1.ToString())
+	if !inc1.Incremental || inc1.BaseEpoch != full.Epoch || inc1.PageCount >= int(inc1.LastPageId) {
+		fmt.Println("INC1 ERROR!", inc1.ToString(), full.ToString())
+		os.Exit(1)
+	}
+
+	// the pages moved by a vacuum are written since too
+	testutils.CheckErr(s.DropDB("otherdb"))
+	testutils.CheckErr(s.Save())
+	reclaimed, err := s.Vacuum()
+	testutils.CheckErr(err)
+	fmt.Println("VACUUM", reclaimed)
+	Fill(s, "mydb", KEY_COUNT * 3, KEY_COUNT * 4)
+
+	inc2, err := s.BackupIncrementalTo(inc2Path, inc1)
+	testutils.CheckErr(err)
+	fmt.Println("INC2", inc2.ToString())
+
+	// an incremental backup needs the file of its base
+	_, err = gokvdb.RestoreFrom(fmt.Sprintf("./testdata/backup_%v_none.kv", stamp), inc1Path)
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBackup)
+
+	// a later chain member cannot apply before an earlier one
+	_, err = gokvdb.RestoreFrom(restorePath, inc2Path)
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBackup)
+
+	_, err = gokvdb.RestoreFrom(restorePath, inc1Path)
+	testutils.CheckErr(err)
+	r = Open(restorePath)
+	Verify(r, "mydb", 0, KEY_COUNT * 3)
+	testutils.ExpectDBs(r, "mydb", "otherdb")
+	testutils.CheckErr(r.Close())
+
+	_, err = gokvdb.RestoreFrom(restorePath, inc2Path)
+	testutils.CheckErr(err)
+
+	// the chain cannot go back
+	_, err = gokvdb.RestoreFrom(restorePath, inc1Path)
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBackup)
+
+	r = Open(restorePath)
+	Verify(r, "mydb", 0, KEY_COUNT * 4)
+	testutils.ExpectDBs(r, "mydb")
+	testutils.CheckErr(r.Close())
+	testutils.ExpectEqual(testutils.FileSize(restorePath), testutils.FileSize(dbPath))
+
+	testutils.CheckErr(s.Close())
+
+	// after a reopen the pages changed before are not known, every page
+	// is written and the backup still applies over its base
+	s = Open(dbPath)
+	Fill(s, "mydb", KEY_COUNT * 4, KEY_COUNT * 5)
+	var buf bytes.Buffer
+	inc3, err := s.BackupIncremental(&buf, inc1)
+	testutils.CheckErr(err)
+	fmt.Println("INC3", inc3.ToString())
+	if inc3.PageCount <= inc2.PageCount {
+		fmt.Println("INC3 ERROR!", inc3.ToString(), inc2.ToString())
+		os.Exit(1)
+	}
+	_, err = gokvdb.Restore(restorePath, bytes.NewReader(buf.Bytes()))
+	testutils.CheckErr(err)
+	r = Open(restorePath)
+	Verify(r, "mydb", 0, KEY_COUNT * 5)
+	testutils.CheckErr(r.Close())
+
+	// a damaged backup leaves the file as it was
+	damaged := buf.Bytes()
+	damaged[len(damaged) / 2] ^= 0xff
+	_, err = gokvdb.Restore(restorePath, bytes.NewReader(damaged))
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBackup)
+	_, err = gokvdb.Restore(restorePath, bytes.NewReader(damaged[:len(damaged) - 10]))
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBackup)
+	r = Open(restorePath)
+	Verify(r, "mydb", 0, KEY_COUNT * 5)
+	testutils.CheckErr(r.Close())
+
+	TestLineage(s, fmt.Sprintf("./testdata/backup_%v_compact.kv", stamp), inc1)
+
+	testutils.CheckErr(s.Close())
+
+	// a memory storage backs up like a file
+	mem, err := gokvdb.LoadMemoryStorage(dbPath)
+	testutils.CheckErr(err)
+	Fill(mem, "memdb", 0, KEY_COUNT)
+	buf.Reset()
+	_, err = mem.Backup(&buf)
+	testutils.CheckErr(err)
+	testutils.CheckErr(mem.Close())
+
+	_, err = gokvdb.Restore(restorePath, &buf)
+	testutils.CheckErr(err)
+	r = Open(restorePath)
+	Verify(r, "mydb", 0, KEY_COUNT * 5)
+	Verify(r, "memdb", 0, KEY_COUNT)
+	testutils.CheckErr(r.Close())
+
+	fmt.Println("OK")
+}
+
+// TestLineage expects the backups of s and of the file Compact rebuilds
+// from it, whose epochs restart, to never apply over each other.
+func TestLineage(s *gokvdb.Storage, compactPath string, base gokvdb.BackupInfo) {
+
+	_, err := s.Compact(compactPath)
+	testutils.CheckErr(err)
+
+	c := Open(compactPath)
+	var buf bytes.Buffer
+	compactFull, err := c.Backup(&buf)
+	testutils.CheckErr(err)
+	fmt.Println("COMPACT FULL", compactFull.ToString())
+	if compactFull.Lineage == base.Lineage || compactFull.Epoch > base.Epoch {
+		fmt.Println("LINEAGE ERROR!", compactFull.ToString(), base.ToString())
+		os.Exit(1)
+	}
+
+	// neither file takes an incremental backup over a base of the other
+	_, err = s.BackupIncremental(&buf, compactFull)
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBackup)
+	_, err = c.BackupIncremental(&buf, base)
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBackup)
+	testutils.CheckErr(c.Close())
+
+	// nor does an incremental backup of s apply over the rebuilt file
+	buf.Reset()
+	_, err = s.BackupIncremental(&buf, base)
+	testutils.CheckErr(err)
+	_, err = gokvdb.Restore(compactPath, &buf)
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBackup)
+	fmt.Println("LINEAGE", err)
+	if !strings.Contains(err.Error(), "lineage") {
+		fmt.Println("LINEAGE ERROR!", err)
+		os.Exit(1)
+	}
+
+	c = Open(compactPath)
+	Verify(c, "mydb", 0, KEY_COUNT * 5)
+	testutils.CheckErr(c.Close())
+}
+
+func Open(path string) *gokvdb.Storage {
+	s, err := gokvdb.OpenStorage(path)
+	testutils.CheckErr(err)
+	return s
+}
+
+// Fill writes keys start to end into dbName, the first keys also into a
+// dict of otherdb for the vacuum to drop.
+func Fill(s *gokvdb.Storage, dbName string, start int, end int) {
+
+	nameById, err := gokvdb.NewI64StrDict(s, dbName, "nameById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, dbName, "blobByName")
+	testutils.CheckErr(err)
+	var other *gokvdb.LazyI64StrDict
+	if start == 0 {
+		other, err = gokvdb.NewI64StrDict(s, "otherdb", "nameById")
+		testutils.CheckErr(err)
+	}
+
+	testutils.SetNames(nameById, start, end)
+	if start == 0 {
+		testutils.SetNames(other, start, end)
+	}
+	for i:=start; i<end; i++ {
+		name := fmt.Sprintf("name-%v", i)
+		testutils.CheckErr(blobByName.Set(name, []byte(fmt.Sprintf("blob-%v", i))))
+	}
+
+	testutils.CheckErr(nameById.Save(false))
+	testutils.CheckErr(blobByName.Save(false))
+	if start == 0 {
+		testutils.CheckErr(other.Save(false))
+	}
+	testutils.CheckErr(s.Save())
+}
+
+func Verify(s *gokvdb.Storage, dbName string, start int, end int) {
+
+	nameById, err := gokvdb.NewI64StrDict(s, dbName, "nameById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, dbName, "blobByName")
+	testutils.CheckErr(err)
+
+	testutils.VerifyNames(nameById, start, end)
+
+	for i:=start; i<end; i++ {
+		name := fmt.Sprintf("name-%v", i)
+
+		// the keys written by the concurrent Update have no blob
+		if i >= KEY_COUNT && i < KEY_COUNT * 2 {
+			continue
+		}
+		blob, err := blobByName.Get(name)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(string(blob), fmt.Sprintf("blob-%v", i))
+	}
+}
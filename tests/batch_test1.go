@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"strings"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	ROUNDS = 20
+	BATCH_SIZE = 2000
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/batch_%v.kv", time.Now().UTC().UnixNano())
+
+	// a small SplitKeys makes the batches split the hash contexts
+	opts := gokvdb.Options{SplitKeys: 16, BranchFanOut: 16}
+	s, err := gokvdb.OpenStorageWithOptions(dbPath, opts)
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	orderedIdByName, err := gokvdb.NewOrderedStrI64Dict(s, "mydb", "orderedIdByName")
+	testutils.CheckErr(err)
+	idSetById, err := gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+	idSetByName, err := gokvdb.NewStrI64SetDict(s, "mydb", "idSetByName")
+	testutils.CheckErr(err)
+
+	strById := make(map[int64]string)
+	idByStr := make(map[string]int64)
+	setById := make(map[int64]map[int64]bool)
+	setByStr := make(map[string]map[int64]bool)
+
+	for round:=0; round<ROUNDS; round++ {
+
+		// keys on both sides of zero, some of them twice in a batch
+		var i64Pairs []gokvdb.I64StrPair
+		var strPairs []gokvdb.StrI64Pair
+		for i:=0; i<BATCH_SIZE; i++ {
+			key := rand.Int63n(1 << 24) - (1 << 23)
+			if i % 8 == 0 && i > 0 {
+				key = i64Pairs[rand.Intn(len(i64Pairs))].Key
+			}
+			value := fmt.Sprintf("value-%v-%v", round, i)
+			name := fmt.Sprintf("name-%v", key)
+
+			i64Pairs = append(i64Pairs, gokvdb.I64StrPair{Key: key, Value: value})
+			strPairs = append(strPairs, gokvdb.StrI64Pair{Key: name, Value: int64(round * BATCH_SIZE + i)})
+			strById[key] = value
+			idByStr[name] = int64(round * BATCH_SIZE + i)
+		}
+
+		testutils.CheckErr(nameById.SetPairs(i64Pairs))
+		testutils.CheckErr(idByName.SetPairs(strPairs))
+		testutils.CheckErr(orderedIdByName.SetPairs(strPairs))
+
+		// a map batch next to single writes
+		items := make(map[string]int64)
+		for i:=0; i<100; i++ {
+			name := fmt.Sprintf("map-%v-%v", round, i)
+			items[name] = int64(i)
+			idByStr[name] = int64(i)
+		}
+		testutils.CheckErr(idByName.SetMany(items))
+		testutils.CheckErr(orderedIdByName.SetMany(items))
+
+		key := rand.Int63n(1 << 24)
+		strById[key] = "single"
+		testutils.CheckErr(nameById.Set(key, "single"))
+		testutils.CheckErr(nameById.SetMany(map[int64]string{key + 1: "many"}))
+		strById[key + 1] = "many"
+
+		// members across several set contexts, some given twice
+		for k:=0; k<10; k++ {
+			setKey := rand.Int63n(100)
+			var members []int64
+			for i:=0; i<200; i++ {
+				member := rand.Int63n(1 << 16) - (1 << 15)
+				if i % 5 == 0 && i > 0 {
+					member = members[0]
+				}
+				members = append(members, member)
+				testutils.AddMember(setById, setKey, member)
+				testutils.AddMember(setByStr, fmt.Sprintf("set-%v", setKey), member)
+			}
+			testutils.CheckErr(idSetById.AddMany(setKey, members))
+			testutils.CheckErr(idSetByName.AddMany(fmt.Sprintf("set-%v", setKey), members))
+		}
+
+		testutils.CheckErr(nameById.Save(false))
+		testutils.CheckErr(idByName.Save(false))
+		testutils.CheckErr(orderedIdByName.Save(false))
+		testutils.CheckErr(idSetById.Save(false))
+		testutils.CheckErr(idSetByName.Save(true))
+
+		fmt.Println("ROUND", round, "keys", len(strById), len(idByStr), "sets", len(setById))
+	}
+
+	testutils.VerifyI64Str(nameById, strById)
+	testutils.VerifyStrI64(idByName, idByStr)
+	testutils.VerifyStrI64(orderedIdByName, idByStr)
+	testutils.VerifyI64Set(idSetById, setById)
+	testutils.VerifyStrSet(idSetByName, setByStr)
+	testutils.ExpectClean(s)
+
+	// a bad pair fails the batch before any pair is set
+	badKey := strings.Repeat("k", gokvdb.MAX_STR_KEY_SIZE + 1)
+	err = idByName.SetPairs([]gokvdb.StrI64Pair{{Key: "first", Value: 1}, {Key: badKey, Value: 2}})
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBatch)
+	testutils.ExpectErr(err, gokvdb.ErrKeyTooLarge)
+	_, err = idByName.Get("first")
+	testutils.ExpectErr(err, gokvdb.ErrNotFound)
+
+	badValue := strings.Repeat("v", gokvdb.MAX_STR_VALUE_SIZE + 1)
+	err = nameById.SetPairs([]gokvdb.I64StrPair{{Key: -1, Value: "first"}, {Key: -2, Value: badValue}})
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBatch)
+	_, ok := strById[-1]
+	if !ok {
+		_, err = nameById.Get(-1)
+		testutils.ExpectErr(err, gokvdb.ErrNotFound)
+	}
+
+	err = idSetByName.AddMany(badKey, []int64{1})
+	testutils.ExpectErr(err, gokvdb.ErrInvalidBatch)
+
+	// so does a single write, the longest key still fits
+	testutils.ExpectErr(idByName.Set(badKey, 1), gokvdb.ErrKeyTooLarge)
+	testutils.ExpectErr(idByName.Set(badKey + "k", 1), gokvdb.ErrKeyTooLarge)
+	testutils.ExpectErr(idSetByName.Add(badKey, 1), gokvdb.ErrKeyTooLarge)
+	testutils.ExpectErr(nameById.Set(-2, badValue), gokvdb.ErrValueTooLarge)
+	_, err = idByName.Get(badKey)
+	testutils.ExpectErr(err, gokvdb.ErrNotFound)
+
+	longKey := badKey[1:]
+	testutils.CheckErr(idByName.Set(longKey, 7))
+	val, err := idByName.Get(longKey)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(val, int64(7))
+	testutils.CheckErr(idByName.Delete(longKey))
+
+	// an empty batch adds no key
+	testutils.CheckErr(idSetById.AddMany(1000, nil))
+	_, err = idSetById.Get(1000)
+	testutils.ExpectErr(err, gokvdb.ErrNotFound)
+	testutils.CheckErr(nameById.SetPairs(nil))
+
+	// the batches mix with single deletes
+	for _, key := range testutils.Keys(strById)[:500] {
+		delete(strById, key)
+		testutils.CheckErr(nameById.Delete(key))
+	}
+	testutils.CheckErr(nameById.Save(true))
+	testutils.ExpectClean(s)
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	nameById, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	testutils.VerifyI64Str(nameById, strById)
+	idByName, err = gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	testutils.VerifyStrI64(idByName, idByStr)
+	orderedIdByName, err = gokvdb.NewOrderedStrI64Dict(s, "mydb", "orderedIdByName")
+	testutils.CheckErr(err)
+	testutils.VerifyStrI64(orderedIdByName, idByStr)
+	idSetById, err = gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+	testutils.VerifyI64Set(idSetById, setById)
+	idSetByName, err = gokvdb.NewStrI64SetDict(s, "mydb", "idSetByName")
+	testutils.CheckErr(err)
+	testutils.VerifyStrSet(idSetByName, setByStr)
+	testutils.CheckErr(s.Close())
+
+	fmt.Println("OK")
+}
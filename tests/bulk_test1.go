@@ -0,0 +1,230 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 20000
+	// small enough for several spilled runs
+	SPILL_SIZE = 64 << 10
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	stamp := time.Now().UTC().UnixNano()
+	dbPath := fmt.Sprintf("./testdata/bulk_%v.kv", stamp)
+	tempDir := fmt.Sprintf("./testdata/bulk_%v_runs", stamp)
+	testutils.CheckErr(os.MkdirAll(tempDir, 0755))
+
+	// a small SplitKeys and fan-out give the indexes and trees several levels
+	opts := gokvdb.Options{SplitKeys: 16, BranchFanOut: 16}
+	s, err := gokvdb.OpenStorageWithOptions(dbPath, opts)
+	testutils.CheckErr(err)
+
+	strById := make(map[int64]string)
+	idByStr := make(map[string]int64)
+	blobById := make(map[int64][]byte)
+	blobByStr := make(map[string][]byte)
+	setById := make(map[int64]map[int64]bool)
+	setByStr := make(map[string]map[int64]bool)
+
+	nameById, err := gokvdb.NewI64StrBulkLoader(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewStrI64BulkLoader(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	orderedIdByName, err := gokvdb.NewOrderedStrI64BulkLoader(s, "mydb", "orderedIdByName")
+	testutils.CheckErr(err)
+	blobs, err := gokvdb.NewI64BlobBulkLoader(s, "mydb", "blobById")
+	testutils.CheckErr(err)
+	namedBlobs, err := gokvdb.NewStrBlobBulkLoader(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	idSetById, err := gokvdb.NewI64I64SetBulkLoader(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+	idSetByName, err := gokvdb.NewOrderedStrI64SetBulkLoader(s, "mydb", "idSetByName")
+	testutils.CheckErr(err)
+
+	for _, l := range []*gokvdb.BulkLoader{nameById.BulkLoader, idByName.BulkLoader, orderedIdByName.BulkLoader, blobs.BulkLoader, namedBlobs.BulkLoader, idSetById.BulkLoader, idSetByName.BulkLoader} {
+		l.SpillSize = SPILL_SIZE
+		l.TempDir = tempDir
+	}
+
+	// unsorted keys on both sides of zero, some added twice
+	var added []int64
+	for i:=0; i<KEY_COUNT; i++ {
+		key := rand.Int63n(1 << 32) - (1 << 31)
+		if i % 10 == 0 && i > 0 {
+			key = added[rand.Intn(len(added))]
+		}
+		added = append(added, key)
+		name := fmt.Sprintf("name-%v", key)
+		value := fmt.Sprintf("value-%v-%v", key, i)
+		blob := testutils.Blob(int64(i), 200)
+
+		strById[key] = value
+		idByStr[name] = int64(i)
+		blobById[key] = blob
+		blobByStr[name] = blob
+
+		testutils.CheckErr(nameById.Add(key, value))
+		testutils.CheckErr(idByName.Add(name, int64(i)))
+		testutils.CheckErr(orderedIdByName.Add(name, int64(i)))
+		testutils.CheckErr(blobs.Add(key, blob))
+		testutils.CheckErr(namedBlobs.Add(name, blob))
+
+		if i % 4 == 0 {
+			for j:=0; j<=i % 7; j++ {
+				setKey := key % 500
+				member := rand.Int63n(1 << 20) - (1 << 19)
+				testutils.AddMember(setById, setKey, member)
+				testutils.AddMember(setByStr, fmt.Sprintf("set-%v", setKey), member)
+				testutils.CheckErr(idSetById.Add(setKey, member))
+				testutils.CheckErr(idSetByName.Add(fmt.Sprintf("set-%v", setKey), member))
+			}
+		}
+	}
+
+	before, err := s.Info()
+	testutils.CheckErr(err)
+
+	nameByIdDict, err := nameById.Finish()
+	testutils.CheckErr(err)
+	idByNameDict, err := idByName.Finish()
+	testutils.CheckErr(err)
+	orderedIdByNameDict, err := orderedIdByName.Finish()
+	testutils.CheckErr(err)
+	blobByIdDict, err := blobs.Finish()
+	testutils.CheckErr(err)
+	blobByNameDict, err := namedBlobs.Finish()
+	testutils.CheckErr(err)
+	idSetByIdDict, err := idSetById.Finish()
+	testutils.CheckErr(err)
+	idSetByNameDict, err := idSetByName.Finish()
+	testutils.CheckErr(err)
+
+	// one save for each loader, the runs are gone
+	after, err := s.Info()
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(after.Epoch, before.Epoch + 7)
+	entries, err := os.ReadDir(tempDir)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(len(entries), 0)
+	testutils.ExpectEqual(orderedIdByNameDict.IsOrdered(), true)
+	testutils.ExpectEqual(idSetByNameDict.IsOrdered(), true)
+
+	testutils.VerifyI64Str(nameByIdDict, strById)
+	testutils.VerifyStrI64(idByNameDict, idByStr)
+	testutils.VerifyStrI64(orderedIdByNameDict, idByStr)
+	testutils.VerifyI64Blob(blobByIdDict, blobById)
+	testutils.VerifyStrBlob(blobByNameDict, blobByStr)
+	testutils.VerifyI64Set(idSetByIdDict, setById)
+	testutils.VerifyStrSet(idSetByNameDict, setByStr)
+	testutils.ExpectClean(s)
+
+	// a loaded dict takes the usual writes
+	for i:=0; i<1000; i++ {
+		key := rand.Int63n(1 << 32) - (1 << 31)
+		strById[key] = "set"
+		testutils.CheckErr(nameByIdDict.Set(key, "set"))
+		name := fmt.Sprintf("more-%v", i)
+		idByStr[name] = int64(-i)
+		testutils.CheckErr(idByNameDict.Set(name, int64(-i)))
+		testutils.CheckErr(orderedIdByNameDict.Set(name, int64(-i)))
+	}
+	for _, key := range testutils.Keys(strById)[:500] {
+		delete(strById, key)
+		testutils.CheckErr(nameByIdDict.Delete(key))
+	}
+	testutils.CheckErr(nameByIdDict.Save(false))
+	testutils.CheckErr(idByNameDict.Save(false))
+	testutils.CheckErr(orderedIdByNameDict.Save(true))
+	testutils.ExpectClean(s)
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	nameByIdDict, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	testutils.VerifyI64Str(nameByIdDict, strById)
+	idByNameDict, err = gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	testutils.VerifyStrI64(idByNameDict, idByStr)
+	orderedIdByNameDict, err = gokvdb.NewOrderedStrI64Dict(s, "mydb", "orderedIdByName")
+	testutils.CheckErr(err)
+	testutils.VerifyStrI64(orderedIdByNameDict, idByStr)
+	blobByNameDict, err = gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	testutils.VerifyStrBlob(blobByNameDict, blobByStr)
+
+	// an empty load gives an empty dict
+	empty, err := gokvdb.NewStrI64BulkLoader(s, "mydb", "empty")
+	testutils.CheckErr(err)
+	emptyDict, err := empty.Finish()
+	testutils.CheckErr(err)
+	testutils.VerifyStrI64(emptyDict, map[string]int64{})
+	testutils.CheckErr(emptyDict.Set("one", 1))
+	testutils.CheckErr(emptyDict.Save(true))
+
+	// a dict that exists is not loaded over, a finished loader takes no more
+	_, err = gokvdb.NewI64StrBulkLoader(s, "mydb", "nameById")
+	testutils.ExpectErr(err, gokvdb.ErrExists)
+	err = empty.Add("two", 2)
+	if err == nil {
+		fmt.Println("FINISHED ADD ERROR!")
+		os.Exit(1)
+	}
+
+	TestFinishInTx(s)
+
+	testutils.ExpectClean(s)
+	testutils.CheckErr(s.Close())
+
+	fmt.Println("OK")
+}
+
+// TestFinishInTx expects a Finish while a transaction is open to fail
+// before it writes, the transaction commits its own writes only and the
+// loader finishes once it is done.
+func TestFinishInTx(s *gokvdb.Storage) {
+
+	loader, err := gokvdb.NewI64StrBulkLoader(s, "mydb", "txNames")
+	testutils.CheckErr(err)
+	expected := make(map[int64]string)
+	for i:=0; i<1000; i++ {
+		expected[int64(i)] = fmt.Sprintf("name-%v", i)
+		testutils.CheckErr(loader.Add(int64(i), fmt.Sprintf("name-%v", i)))
+	}
+
+	tx, err := s.Begin()
+	testutils.CheckErr(err)
+	dict, err := tx.I64StrDict("mydb", "inTx")
+	testutils.CheckErr(err)
+	testutils.SetNames(dict, 0, 100)
+
+	_, err = loader.Finish()
+	if err == nil {
+		fmt.Println("FINISH IN TX ERROR!")
+		os.Exit(1)
+	}
+	_, err = s.OpenDict("mydb", "txNames")
+	testutils.ExpectErr(err, gokvdb.ErrNotFound)
+
+	testutils.CheckErr(dict.Save(false))
+	testutils.CheckErr(tx.Commit())
+
+	loaded, err := loader.Finish()
+	testutils.CheckErr(err)
+	testutils.VerifyI64Str(loaded, expected)
+	testutils.ExpectClean(s)
+
+	dict, err = gokvdb.NewI64StrDict(s, "mydb", "inTx")
+	testutils.CheckErr(err)
+	testutils.VerifyNames(dict, 0, 100)
+}
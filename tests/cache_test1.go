@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"math/rand"
+	"gokvdb"
+	"gokvdb/testutils"
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/cache_%v.kv", time.Now().UTC().UnixNano())
+	opts := gokvdb.Options{CacheSize: 256 << 10}
+
+	testData := make(map[int64]string)
+
+	s, err := gokvdb.OpenStorageWithOptions(dbPath, opts)
+	testutils.CheckErr(err)
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+
+	for round:=0; round<4; round++ {
+
+		for i:=0; i<20000; i++ {
+			key := rand.Int63n(1 << 32)
+			val := fmt.Sprintf("name-%v-%v", key, round)
+			testData[key] = val
+			testutils.CheckErr(dict.Set(key, val))
+			ExpectFits(s)
+		}
+
+		// evicted contexts are written back and loaded again
+		CheckDict(s, dict, testData)
+
+		testutils.CheckErr(dict.Save(true))
+		ExpectFits(s)
+
+		stats := s.CacheStats()
+		fmt.Println("ROUND", round, stats.ToString())
+
+		if stats.Evictions == 0 {
+			fmt.Println("CACHE ERROR!", stats.ToString())
+			os.Exit(1)
+		}
+	}
+
+	// the pages written before the save go to the log past the budget
+	for i:=0; i<100000; i++ {
+		key := rand.Int63n(1 << 32)
+		val := fmt.Sprintf("name-%v-unsaved", key)
+		testData[key] = val
+		testutils.CheckErr(dict.Set(key, val))
+		ExpectFits(s)
+	}
+	CheckDict(s, dict, testData)
+
+	info, err := os.Stat(dbPath + "-wal")
+	testutils.CheckErr(err)
+	fmt.Println("UNSAVED", s.CacheStats().ToString(), "wal", info.Size())
+	if info.Size() <= opts.CacheSize {
+		fmt.Println("SPILL ERROR!", info.Size())
+		os.Exit(1)
+	}
+
+	testutils.CheckErr(dict.Save(true))
+
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorageWithOptions(dbPath, opts)
+	testutils.CheckErr(err)
+
+	dict, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+
+	CheckDict(s, dict, testData)
+	fmt.Println("REOPEN", s.CacheStats().ToString())
+
+	testutils.CheckErr(s.Close())
+
+	TestSmallBudget(fmt.Sprintf("./testdata/cache_small_%v.kv", time.Now().UTC().UnixNano()))
+}
+
+// TestSmallBudget sets dense keys, their context outgrows a budget below
+// the minimum and has to stay instead of being written back on every set.
+func TestSmallBudget(dbPath string) {
+
+	s, err := gokvdb.OpenStorageWithOptions(dbPath, gokvdb.Options{CacheSize: 64 << 10})
+	testutils.CheckErr(err)
+
+	if s.CacheStats().Budget != gokvdb.PAGE_CACHE_MIN_SIZE {
+		fmt.Println("MIN BUDGET ERROR!", s.CacheStats().ToString())
+		os.Exit(1)
+	}
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+
+	count := 50000
+
+	for i:=0; i<count; i++ {
+		key := int64(i)
+		testutils.CheckErr(dict.Set(key, fmt.Sprintf("name-%v", key)))
+	}
+
+	stats := s.CacheStats()
+	fmt.Println("SMALL", stats.ToString())
+	if stats.WriteBacks > uint64(count / 20) {
+		fmt.Println("WRITE BACK ERROR!", stats.ToString())
+		os.Exit(1)
+	}
+
+	CheckInOrder(s, dict, count)
+	testutils.CheckErr(dict.Save(true))
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorageWithOptions(dbPath, gokvdb.Options{CacheSize: 64 << 10})
+	testutils.CheckErr(err)
+
+	dict, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+
+	CheckInOrder(s, dict, count)
+	testutils.CheckErr(s.Close())
+}
+
+// CheckInOrder reads the keys TestSmallBudget set, in order since a random
+// walk loads a context the budget cannot keep on nearly every get.
+func CheckInOrder(s *gokvdb.Storage, dict *gokvdb.LazyI64StrDict, count int) {
+
+	for i:=0; i<count; i++ {
+		key := int64(i)
+		val, err := dict.Get(key)
+		testutils.CheckErr(err)
+		if val != fmt.Sprintf("name-%v", key) {
+			fmt.Println("VALID ERROR!", key, val)
+			os.Exit(1)
+		}
+		ExpectFits(s)
+	}
+}
+
+func CheckDict(s *gokvdb.Storage, dict *gokvdb.LazyI64StrDict, testData map[int64]string) {
+
+	for key, val := range testData {
+		val2, err := dict.Get(key)
+		testutils.CheckErr(err)
+		if val != val2 {
+			fmt.Println("VALID ERROR!", key, val, val2)
+			os.Exit(1)
+		}
+		ExpectFits(s)
+	}
+}
+
+// ExpectFits expects the cache within its budget once an operation is done,
+// only the most recently used context may stay past it on its own.
+func ExpectFits(s *gokvdb.Storage) {
+	stats := s.CacheStats()
+	if stats.Bytes > stats.Budget && stats.Pages > 1 {
+		fmt.Println("BUDGET ERROR!", stats.ToString())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 20000
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/catalog_%v.kv", time.Now().UTC().UnixNano())
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	testutils.FillDicts(s, "mydb", KEY_COUNT, false)
+	testutils.FillDicts(s, "otherdb", KEY_COUNT, false)
+	testutils.CheckErr(s.Close())
+
+	fullSize := testutils.FileSize(dbPath)
+	fmt.Println("FULL SIZE", fullSize)
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	testutils.ExpectDBs(s, "mydb", "otherdb")
+
+	db, err := s.DB("mydb")
+	testutils.CheckErr(err)
+
+	ExpectDicts(db, map[string]gokvdb.DictKind{
+		"nameById": gokvdb.DICT_KIND_I64STR,
+		"idByName": gokvdb.DICT_KIND_STRI64,
+		"blobById": gokvdb.DICT_KIND_I64BLOB,
+		"blobByName": gokvdb.DICT_KIND_STRBLOB,
+		"idSetById": gokvdb.DICT_KIND_I64I64SET,
+		"idSetByName": gokvdb.DICT_KIND_STRI64SET,
+		"btree": gokvdb.DICT_KIND_BTREE,
+	})
+
+	// rename keeps the data, the StrBlob keys dict moves along
+	testutils.CheckErr(db.RenameDict("nameById", "nameById2"))
+	testutils.CheckErr(db.RenameDict("blobByName", "blobByName2"))
+	testutils.CheckErr(db.RenameDict("btree", "btree2"))
+	testutils.ExpectErr(db.RenameDict("nameById", "x"), gokvdb.ErrNotFound)
+	testutils.ExpectErr(db.RenameDict("idByName", "blobById"), gokvdb.ErrExists)
+	testutils.CheckErr(s.Save())
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	db, err = s.DB("mydb")
+	testutils.CheckErr(err)
+
+	ExpectDicts(db, map[string]gokvdb.DictKind{
+		"nameById2": gokvdb.DICT_KIND_I64STR,
+		"idByName": gokvdb.DICT_KIND_STRI64,
+		"blobById": gokvdb.DICT_KIND_I64BLOB,
+		"blobByName2": gokvdb.DICT_KIND_STRBLOB,
+		"idSetById": gokvdb.DICT_KIND_I64I64SET,
+		"idSetByName": gokvdb.DICT_KIND_STRI64SET,
+		"btree2": gokvdb.DICT_KIND_BTREE,
+	})
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById2")
+	testutils.CheckErr(err)
+	name, err := nameById.Get(7)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(name, "name-7")
+
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName2")
+	testutils.CheckErr(err)
+	blob, err := blobByName.Get("name-7")
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(string(blob), "blob-7")
+
+	bt, err := db.OpenBTree("btree2")
+	testutils.CheckErr(err)
+	blob, err = bt.Get(7)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(string(blob), "btree-7")
+
+	// a rolled back drop leaves the dict in place
+	tx, err := s.Begin()
+	testutils.CheckErr(err)
+	db, err = tx.DB("mydb")
+	testutils.CheckErr(err)
+	testutils.CheckErr(db.DropDict("idByName"))
+	testutils.CheckErr(tx.Rollback())
+
+	db, err = s.DB("mydb")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	id, err := idByName.Get("name-7")
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(id, int64(7))
+
+	// dropping every dict of otherdb and the db itself frees their pages
+	for _, info := range db.ListDicts() {
+		testutils.CheckErr(db.DropDict(info.Name))
+	}
+	testutils.ExpectErr(db.DropDict("nameById2"), gokvdb.ErrNotFound)
+	testutils.CheckErr(s.DropDB("otherdb"))
+	testutils.ExpectErr(s.DropDB("otherdb"), gokvdb.ErrNotFound)
+	testutils.CheckErr(s.Save())
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	testutils.ExpectDBs(s, "mydb")
+	db, err = s.DB("mydb")
+	testutils.CheckErr(err)
+	ExpectDicts(db, map[string]gokvdb.DictKind{})
+
+	_, err = db.GetMeta("nameById2")
+	testutils.ExpectErr(err, gokvdb.ErrNotFound)
+
+	// the freed pages take the same data again without the file growing
+	testutils.FillDicts(s, "mydb", KEY_COUNT, false)
+	testutils.FillDicts(s, "otherdb", KEY_COUNT, false)
+	testutils.CheckErr(s.Close())
+
+	refillSize := testutils.FileSize(dbPath)
+	fmt.Println("REFILL SIZE", refillSize)
+	if refillSize > fullSize + fullSize / 20 {
+		fmt.Println("DROPPED PAGES NOT REUSED!", fullSize, refillSize)
+		os.Exit(1)
+	}
+
+	// a read-only storage drops nothing
+	s, err = gokvdb.OpenStorageReadOnly(dbPath)
+	testutils.CheckErr(err)
+	db, err = s.DB("mydb")
+	testutils.CheckErr(err)
+	testutils.ExpectErr(db.DropDict("nameById"), gokvdb.ErrReadOnly)
+	testutils.ExpectErr(s.DropDB("mydb"), gokvdb.ErrReadOnly)
+	testutils.CheckErr(s.Close())
+
+	TestDropCached(fmt.Sprintf("./testdata/catalog_cached_%v.kv", time.Now().UTC().UnixNano()))
+
+	fmt.Println("OK")
+}
+
+// TestDropCached drops dicts with unsaved writes in a small cache while
+// another dict keeps writing, the cache must not write the dropped
+// contexts back over the freed pages.
+func TestDropCached(dbPath string) {
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	testutils.FillDicts(s, "mydb", KEY_COUNT, false)
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorageWithOptions(dbPath, gokvdb.Options{CacheSize: 1 << 20})
+	testutils.CheckErr(err)
+
+	db, err := s.DB("mydb")
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	idSetById, err := gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+	bt, err := db.OpenBTree("btree")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+
+	expected := make(map[string]int64)
+
+	for i:=0; i<KEY_COUNT; i++ {
+		key := int64(i)
+		name := fmt.Sprintf("name-%v", i)
+		expected[name] = key
+
+		testutils.CheckErr(nameById.Set(key, fmt.Sprintf("renamed-%v", i)))
+		testutils.CheckErr(blobByName.Set(name, testutils.RandBytes(64)))
+		testutils.CheckErr(idSetById.Add(key % 100, key + KEY_COUNT))
+		testutils.CheckErr(bt.Set(key + KEY_COUNT, []byte("more")))
+		testutils.CheckErr(idByName.Set(fmt.Sprintf("name-%v", i + KEY_COUNT), key))
+		expected[fmt.Sprintf("name-%v", i + KEY_COUNT)] = key
+	}
+
+	testutils.CheckErr(db.DropDict("nameById"))
+	testutils.CheckErr(db.DropDict("blobByName"))
+	testutils.CheckErr(db.DropDict("idSetById"))
+	testutils.CheckErr(db.DropDict("btree"))
+
+	testutils.ExpectErr(nameById.Set(1, "x"), gokvdb.ErrDropped)
+	testutils.ExpectErr(nameById.Save(false), gokvdb.ErrDropped)
+	testutils.ExpectErr(blobByName.Set("x", []byte("x")), gokvdb.ErrDropped)
+	testutils.ExpectErr(blobByName.Save(false), gokvdb.ErrDropped)
+	testutils.ExpectErr(idSetById.Add(1, 1), gokvdb.ErrDropped)
+	testutils.ExpectErr(idSetById.Save(false), gokvdb.ErrDropped)
+	testutils.ExpectErr(bt.Set(1, []byte("x")), gokvdb.ErrDropped)
+
+	// the dict left keeps trimming the cache
+	for i:=0; i<KEY_COUNT; i++ {
+		name := fmt.Sprintf("name-%v", i + 2 * KEY_COUNT)
+		expected[name] = int64(i)
+		testutils.CheckErr(idByName.Set(name, int64(i)))
+	}
+	testutils.CheckErr(idByName.Save(false))
+	testutils.CheckErr(s.Save())
+	testutils.ExpectClean(s)
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	db, err = s.DB("mydb")
+	testutils.CheckErr(err)
+	ExpectDicts(db, map[string]gokvdb.DictKind{
+		"idByName": gokvdb.DICT_KIND_STRI64,
+		"blobById": gokvdb.DICT_KIND_I64BLOB,
+		"idSetByName": gokvdb.DICT_KIND_STRI64SET,
+	})
+	idByName, err = gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	testutils.VerifyStrI64(idByName, expected)
+	testutils.ExpectClean(s)
+	testutils.CheckErr(s.Close())
+}
+
+func ExpectDicts(db *gokvdb.DBContext, kindByName map[string]gokvdb.DictKind) {
+	infos := db.ListDicts()
+	for _, info := range infos {
+		fmt.Println(info.ToString())
+		kind, ok := kindByName[info.Name]
+		if !ok || kind != info.Kind {
+			fmt.Println("LIST DICTS ERROR!", info.ToString(), kind)
+			os.Exit(1)
+		}
+	}
+	if len(infos) != len(kindByName) {
+		fmt.Println("LIST DICTS ERROR!", len(infos), len(kindByName))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"time"
+	"strings"
+	"hash/crc32"
+	"encoding/binary"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 2000
+)
+
+func main() {
+
+	stamp := time.Now().UTC().UnixNano()
+	dbPath := fmt.Sprintf("./testdata/check_%v.kv", stamp)
+	cyclePath := fmt.Sprintf("./testdata/check_%v_cycle.kv", stamp)
+	corruptPath := fmt.Sprintf("./testdata/check_%v_corrupt.kv", stamp)
+
+	Build(dbPath)
+	CopyFile(dbPath, cyclePath)
+	CopyFile(dbPath, corruptPath)
+
+	// a clean file accounts for every page
+	s, err := gokvdb.OpenStorageReadOnly(dbPath)
+	testutils.CheckErr(err)
+	info, err := s.Info()
+	testutils.CheckErr(err)
+	report, err := s.Check(false)
+	testutils.CheckErr(err)
+	fmt.Println("CLEAN", report.ToString())
+	ExpectOK(report)
+	testutils.ExpectEqual(report.ReachablePages + report.FreePages, int(info.LastPageId))
+	freeCount := report.FreePages
+
+	_, err = s.Check(true)
+	testutils.ExpectErr(err, gokvdb.ErrReadOnly)
+
+	roleByPageId, err := s.PageRoles()
+	testutils.CheckErr(err)
+	contextPageId := FindPage(s, roleByPageId, gokvdb.PAGE_ROLE_INTERNAL_CONTEXT, false)
+	chainPageId := FindPage(s, roleByPageId, "", true)
+	testutils.CheckErr(s.Close())
+
+	// a freelist with a page past the end and a page still in use leaves
+	// the two free pages it lost as orphans
+	RewritePage(dbPath, info.FreelistPageId, info.PageSize, func(page []byte) {
+		rows := page[gokvdb.PAYLOAD_PAGE_HEADER_SIZE + gokvdb.PAYLOAD_HEADER_SIZE:]
+		binary.LittleEndian.PutUint32(rows[4:], info.LastPageId + 3)
+		binary.LittleEndian.PutUint32(rows[8:], contextPageId)
+	})
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	report, err = s.Check(false)
+	testutils.CheckErr(err)
+	PrintReport("FREELIST", report)
+	testutils.ExpectEqual(report.OK(), false)
+	testutils.ExpectEqual(report.Count(gokvdb.CHECK_FREE_BEYOND_LAST), 1)
+	testutils.ExpectEqual(report.Count(gokvdb.CHECK_REFERENCED_FREE), 1)
+	testutils.ExpectEqual(report.Count(gokvdb.CHECK_ORPHAN), 2)
+	testutils.ExpectEqual(len(report.Problems), 4)
+
+	report, err = s.Check(true)
+	testutils.CheckErr(err)
+	PrintReport("REPAIR", report)
+	ExpectOK(report)
+	testutils.ExpectEqual(len(report.Problems), 4)
+	testutils.ExpectEqual(report.FreePages, freeCount)
+	testutils.CheckErr(s.Close())
+
+	// the repair is saved and the freed pages are used again
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	report, err = s.Check(false)
+	testutils.CheckErr(err)
+	ExpectOK(report)
+	testutils.ExpectEqual(len(report.Problems), 0)
+	Verify(s, 0, KEY_COUNT)
+	Fill(s, "mydb", "more", KEY_COUNT)
+	report, err = s.Check(false)
+	testutils.CheckErr(err)
+	fmt.Println("REUSED", report.ToString())
+	ExpectOK(report)
+	if report.FreePages >= freeCount {
+		fmt.Println("REUSE ERROR!", report.ToString())
+		os.Exit(1)
+	}
+	Verify(s, 0, KEY_COUNT)
+	testutils.CheckErr(s.Close())
+
+	// a chain linking back to itself loses the pages after the link, the
+	// repair leaves them as the damaged chain may still need them
+	RewritePage(cyclePath, chainPageId, info.PageSize, func(page []byte) {
+		binary.LittleEndian.PutUint32(page[8:], chainPageId)
+	})
+
+	s, err = gokvdb.OpenStorage(cyclePath)
+	testutils.CheckErr(err)
+	report, err = s.Check(true)
+	testutils.CheckErr(err)
+	PrintReport("CYCLE", report)
+	testutils.ExpectEqual(report.OK(), false)
+	testutils.ExpectEqual(report.Count(gokvdb.CHECK_CYCLE), 1)
+	if report.Count(gokvdb.CHECK_ORPHAN) == 0 {
+		fmt.Println("CYCLE ORPHAN ERROR!", report.ToString())
+		os.Exit(1)
+	}
+	for _, problem := range report.Problems {
+		if problem.Repaired {
+			fmt.Println("CYCLE REPAIRED ERROR!", problem.ToString())
+			os.Exit(1)
+		}
+		if problem.Kind == gokvdb.CHECK_CYCLE && !strings.HasPrefix(problem.Owner, "mydb/") {
+			fmt.Println("CYCLE OWNER ERROR!", problem.ToString())
+			os.Exit(1)
+		}
+	}
+	testutils.ExpectEqual(report.FreePages, freeCount)
+	testutils.CheckErr(s.Close())
+
+	// a page that fails its checksum is reported and the walk goes on
+	DamagePage(corruptPath, contextPageId, info.PageSize)
+
+	s, err = gokvdb.OpenStorageReadOnly(corruptPath)
+	testutils.CheckErr(err)
+	report, err = s.Check(false)
+	testutils.CheckErr(err)
+	PrintReport("CORRUPT", report)
+	testutils.ExpectEqual(report.Count(gokvdb.CHECK_CORRUPT), 1)
+	testutils.ExpectEqual(report.Count(gokvdb.CHECK_CYCLE), 0)
+	if report.ReachablePages < int(info.LastPageId) / 2 {
+		fmt.Println("CORRUPT WALK ERROR!", report.ToString())
+		os.Exit(1)
+	}
+	testutils.CheckErr(s.Close())
+
+	// a storage never saved checks clean
+	mem, err := gokvdb.OpenMemoryStorage()
+	testutils.CheckErr(err)
+	report, err = mem.Check(true)
+	testutils.CheckErr(err)
+	ExpectOK(report)
+	testutils.ExpectEqual(len(report.Problems), 0)
+	testutils.CheckErr(mem.Close())
+
+	TestRepairOpenDicts(fmt.Sprintf("./testdata/check_%v_open.kv", stamp))
+
+	fmt.Println("OK")
+}
+
+// TestRepairOpenDicts repairs under dicts with unsaved writes in a small
+// cache. The pages they took and the contexts written back look orphaned
+// until they save, the repair leaves them.
+func TestRepairOpenDicts(path string) {
+
+	Build(path)
+
+	s, err := gokvdb.OpenStorageWithOptions(path, gokvdb.Options{CacheSize: gokvdb.PAGE_CACHE_MIN_SIZE})
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	fresh, err := gokvdb.NewI64StrDict(s, "mydb", "fresh")
+	testutils.CheckErr(err)
+	for i:=KEY_COUNT; i<KEY_COUNT * 3; i++ {
+		testutils.CheckErr(nameById.Set(int64(i), fmt.Sprintf("name-%v", i)))
+		testutils.CheckErr(fresh.Set(int64(i), fmt.Sprintf("name-%v", i)))
+	}
+
+	report, err := s.Check(true)
+	testutils.CheckErr(err)
+	PrintReport("OPEN REPAIR", report)
+	fmt.Println("OPEN REPAIR", s.CacheStats().ToString())
+	if report.Count(gokvdb.CHECK_ORPHAN) == 0 || s.CacheStats().WriteBacks == 0 {
+		fmt.Println("OPEN REPAIR ERROR!", report.ToString())
+		os.Exit(1)
+	}
+	for _, problem := range report.Problems {
+		if problem.Repaired {
+			fmt.Println("OPEN REPAIRED ERROR!", problem.ToString())
+			os.Exit(1)
+		}
+	}
+
+	testutils.CheckErr(nameById.Save(false))
+	testutils.CheckErr(fresh.Save(false))
+	testutils.CheckErr(s.Save())
+
+	report, err = s.Check(true)
+	testutils.CheckErr(err)
+	ExpectOK(report)
+	testutils.ExpectEqual(len(report.Problems), 0)
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(path)
+	testutils.CheckErr(err)
+	Verify(s, 0, KEY_COUNT * 3)
+	testutils.ExpectClean(s)
+	testutils.CheckErr(s.Close())
+}
+
+// Build writes two dicts and drops a third one for the free pages. The
+// large blobs give the branch of blobByName more than a page.
+func Build(path string) {
+
+	s, err := gokvdb.OpenStorage(path)
+	testutils.CheckErr(err)
+
+	Fill(s, "mydb", "nameById", 0)
+
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	for i:=0; i<KEY_COUNT; i++ {
+		testutils.CheckErr(blobByName.Set(fmt.Sprintf("name-%v", i), make([]byte, i % 3000)))
+	}
+	testutils.CheckErr(blobByName.Save(false))
+	testutils.CheckErr(s.Save())
+
+	Fill(s, "mydb", "tmp", 0)
+
+	db, err := s.DB("mydb")
+	testutils.CheckErr(err)
+	testutils.CheckErr(db.DropDict("tmp"))
+	testutils.CheckErr(s.Save())
+	testutils.CheckErr(s.Close())
+}
+
+func Fill(s *gokvdb.Storage, dbName string, dictName string, start int) {
+
+	dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+	testutils.CheckErr(err)
+
+	testutils.SetNames(dict, start, start + KEY_COUNT)
+	testutils.CheckErr(dict.Save(false))
+	testutils.CheckErr(s.Save())
+}
+
+func Verify(s *gokvdb.Storage, start int, end int) {
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	testutils.VerifyNames(dict, start, end)
+}
+
+// FindPage returns a page of role, or of any dict when role is empty, one
+// that links to a next page when hasNext is set.
+func FindPage(s *gokvdb.Storage, roleByPageId map[uint32]gokvdb.PageRole, role string, hasNext bool) uint32 {
+
+	for pid, pageRole := range roleByPageId {
+		if role != "" && pageRole.Role != role || !strings.HasPrefix(pageRole.Owner, "mydb/") {
+			continue
+		}
+		page, err := s.ReadPageInfo(pid)
+		testutils.CheckErr(err)
+		if page.HasNext == hasNext {
+			return pid
+		}
+	}
+
+	fmt.Println("FIND PAGE ERROR!", role, hasNext)
+	os.Exit(1)
+	return 0
+}
+
+// RewritePage changes pid in the file and stamps its checksum again.
+func RewritePage(path string, pid uint32, pageSize uint32, change func(page []byte)) {
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	testutils.CheckErr(err)
+	defer f.Close()
+
+	offset := int64(pid) * int64(pageSize)
+	page := make([]byte, pageSize)
+	_, err = f.ReadAt(page, offset)
+	if err != nil && err != io.EOF {
+		testutils.CheckErr(err)
+	}
+
+	change(page)
+
+	binary.LittleEndian.PutUint32(page[12:], 0)
+	sum := crc32.Checksum(page, crc32.MakeTable(crc32.Castagnoli))
+	binary.LittleEndian.PutUint32(page[12:], sum)
+
+	_, err = f.WriteAt(page, offset)
+	testutils.CheckErr(err)
+}
+
+// DamagePage flips a byte of pid in the file, leaving its checksum wrong.
+func DamagePage(path string, pid uint32, pageSize uint32) {
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	testutils.CheckErr(err)
+	defer f.Close()
+
+	offset := int64(pid) * int64(pageSize) + int64(pageSize) - 1
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, offset)
+	testutils.CheckErr(err)
+	b[0] ^= 0xff
+	_, err = f.WriteAt(b, offset)
+	testutils.CheckErr(err)
+}
+
+func CopyFile(src string, dst string) {
+	data, err := os.ReadFile(src)
+	testutils.CheckErr(err)
+	testutils.CheckErr(os.WriteFile(dst, data, 0644))
+}
+
+func PrintReport(name string, report *gokvdb.CheckReport) {
+	fmt.Println(name, report.ToString())
+	for _, problem := range report.Problems {
+		fmt.Println("\t", problem.ToString())
+	}
+}
+
+func ExpectOK(report *gokvdb.CheckReport) {
+	if !report.OK() {
+		PrintReport("CHECK ERROR!", report)
+		os.Exit(1)
+	}
+}
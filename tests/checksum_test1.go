@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"strings"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 2000
+	OWNER = "mydb/nameById"
+)
+
+func main() {
+
+	dbPath := fmt.Sprintf("./testdata/checksum_%v.kv", time.Now().UTC().UnixNano())
+	Fill(dbPath)
+	TestCorruptPage(dbPath)
+	TestCorruptHeader(dbPath)
+
+	legacyPath := fmt.Sprintf("./testdata/checksum_legacy_%v.kv", time.Now().UTC().UnixNano())
+	Fill(legacyPath)
+	TestLegacyFile(legacyPath)
+
+	fmt.Println("OK")
+}
+
+func Fill(dbPath string) {
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+		dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+		testutils.CheckErr(err)
+		testutils.SetNames(dict, 0, KEY_COUNT)
+		testutils.CheckErr(dict.Save(true))
+	})
+}
+
+// TestCorruptPage flips a byte of an internal context page of the dict on
+// disk and expects the read to name the page and the dict.
+func TestCorruptPage(dbPath string) {
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	info, err := s.Info()
+	testutils.CheckErr(err)
+	roles, err := s.PageRoles()
+	testutils.CheckErr(err)
+	testutils.CheckErr(s.Close())
+
+	pid := uint32(0)
+	for _pid, role := range roles {
+		if role.Owner == OWNER && role.Role == gokvdb.PAGE_ROLE_INTERNAL_CONTEXT && (pid == 0 || _pid < pid) {
+			pid = _pid
+		}
+	}
+	testutils.ExpectEqual(pid != 0, true)
+
+	offset := int64(pid) * int64(info.PageSize) + int64(info.PageSize) / 2
+	FlipByte(dbPath, offset)
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	for i:=0; err == nil && i<KEY_COUNT; i++ {
+		_, err = dict.Get(int64(i))
+	}
+	fmt.Println("CORRUPT PAGE", err)
+	ExpectPageError(err, pid, OWNER)
+
+	testutils.CheckErr(s.Close())
+
+	FlipByte(dbPath, offset)
+}
+
+// TestCorruptHeader flips a byte of the storage header and expects the
+// open to fail on page 0.
+func TestCorruptHeader(dbPath string) {
+
+	FlipByte(dbPath, 20)
+
+	_, err := gokvdb.OpenStorage(dbPath)
+	fmt.Println("CORRUPT HEADER", err)
+	ExpectPageError(err, 0, "")
+
+	FlipByte(dbPath, 20)
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	Verify(s)
+	testutils.CheckErr(s.Close())
+}
+
+// TestLegacyFile clears the checksums flag and every checksum of the file,
+// the way it looked before checksums were added, and expects it to open
+// and to stay readable after a save.
+func TestLegacyFile(dbPath string) {
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	info, err := s.Info()
+	testutils.CheckErr(err)
+	testutils.CheckErr(s.Close())
+
+	f, err := os.OpenFile(dbPath, os.O_RDWR, 0666)
+	testutils.CheckErr(err)
+	_, err = f.WriteAt(make([]byte, 8), int64(gokvdb.STORAGE_FLAGS_OFFSET))
+	testutils.CheckErr(err)
+	for pid:=uint32(1); pid<=info.LastPageId; pid++ {
+		offset := int64(pid) * int64(info.PageSize) + int64(gokvdb.PAGE_CHECKSUM_OFFSET)
+		_, err = f.WriteAt(make([]byte, gokvdb.PAGE_CHECKSUM_SIZE), offset)
+		testutils.CheckErr(err)
+	}
+	testutils.CheckErr(f.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	Verify(s)
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	testutils.CheckErr(dict.Set(KEY_COUNT, "added"))
+	testutils.CheckErr(dict.Save(true))
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	Verify(s)
+	testutils.CheckErr(s.Close())
+
+	fmt.Println("LEGACY OK")
+}
+
+func Verify(s *gokvdb.Storage) {
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	testutils.VerifyNames(dict, 0, KEY_COUNT)
+}
+
+func FlipByte(dbPath string, offset int64) {
+	f, err := os.OpenFile(dbPath, os.O_RDWR, 0666)
+	testutils.CheckErr(err)
+	defer f.Close()
+
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, offset)
+	testutils.CheckErr(err)
+	b[0] ^= 0x5a
+	_, err = f.WriteAt(b, offset)
+	testutils.CheckErr(err)
+}
+
+// ExpectPageError expects a corrupt page error naming pid and owner, in
+// the fields of the PageError and in its message.
+func ExpectPageError(err error, pid uint32, owner string) {
+	testutils.ExpectErr(err, gokvdb.ErrCorruptPage)
+
+	var pageErr *gokvdb.PageError
+	if !errors.As(err, &pageErr) {
+		fmt.Println("PAGE ERROR!", err)
+		os.Exit(1)
+	}
+	testutils.ExpectEqual(pageErr.PageId, pid)
+	testutils.ExpectEqual(pageErr.Owner, owner)
+
+	if !strings.Contains(err.Error(), fmt.Sprintf("pid=%v", pid)) || !strings.Contains(err.Error(), owner) {
+		fmt.Println("MESSAGE ERROR!", err)
+		os.Exit(1)
+	}
+}
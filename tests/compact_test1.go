@@ -0,0 +1,330 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 5000
+)
+
+func main() {
+
+	stamp := time.Now().UTC().UnixNano()
+	dbPath := fmt.Sprintf("./testdata/compact_%v.kv", stamp)
+	dstPath := fmt.Sprintf("./testdata/compact_%v_dst.kv", stamp)
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	testutils.FillDicts(s, "mydb", KEY_COUNT, true)
+	testutils.FillDicts(s, "otherdb", KEY_COUNT, true)
+
+	// the pages of otherdb become free slots in the middle of the file
+	testutils.CheckErr(s.DropDB("otherdb"))
+	testutils.CheckErr(s.Save())
+	testutils.CheckErr(s.Checkpoint())
+
+	fullSize := testutils.FileSize(dbPath)
+	fmt.Println("FULL SIZE", fullSize)
+
+	// the storage holds its own file locked
+	_, err = s.Compact(dbPath)
+	testutils.ExpectErr(err, gokvdb.ErrLocked)
+
+	reclaimed, err := s.Compact(dstPath)
+	testutils.CheckErr(err)
+	dstSize := testutils.FileSize(dstPath)
+	fmt.Println("COMPACT", "reclaimed", reclaimed, "dstSize", dstSize)
+	if reclaimed <= 0 || dstSize > fullSize * 2 / 3 {
+		fmt.Println("COMPACT ERROR!", fullSize, dstSize, reclaimed)
+		os.Exit(1)
+	}
+
+	dst, err := gokvdb.OpenStorage(dstPath)
+	testutils.CheckErr(err)
+	testutils.ExpectDBs(dst, "mydb")
+	VerifyDicts(dst, "mydb")
+
+	// the compacted file takes new writes
+	testutils.FillDicts(dst, "otherdb", KEY_COUNT, true)
+	testutils.CheckErr(dst.Close())
+
+	dst, err = gokvdb.OpenStorage(dstPath)
+	testutils.CheckErr(err)
+	VerifyDicts(dst, "mydb")
+	VerifyDicts(dst, "otherdb")
+	testutils.CheckErr(dst.Close())
+
+	// a view open across Vacuum still reads its commit
+	err = s.View(func(snap *gokvdb.Snapshot) error {
+
+		reclaimed, err = s.Vacuum()
+		if err != nil {
+			return err
+		}
+
+		nameById, err := snap.I64StrDict("mydb", "nameById")
+		if err != nil {
+			return err
+		}
+		for i:=0; i<KEY_COUNT; i+=7 {
+			name, err := nameById.Get(int64(i))
+			if err != nil {
+				return err
+			}
+			testutils.ExpectEqual(name, fmt.Sprintf("name-%v", i))
+		}
+		return nil
+	})
+	testutils.CheckErr(err)
+
+	vacuumSize := testutils.FileSize(dbPath)
+	fmt.Println("VACUUM", "reclaimed", reclaimed, "size", vacuumSize)
+	if reclaimed <= 0 || vacuumSize != fullSize - reclaimed {
+		fmt.Println("VACUUM ERROR!", fullSize, vacuumSize, reclaimed)
+		os.Exit(1)
+	}
+
+	VerifyDicts(s, "mydb")
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	VerifyDicts(s, "mydb")
+
+	// a dense file has nothing to give back
+	reclaimed, err = s.Vacuum()
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(reclaimed, int64(0))
+
+	testutils.FillDicts(s, "otherdb", KEY_COUNT, true)
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorageReadOnly(dbPath)
+	testutils.CheckErr(err)
+	VerifyDicts(s, "mydb")
+	VerifyDicts(s, "otherdb")
+	_, err = s.Vacuum()
+	testutils.ExpectErr(err, gokvdb.ErrReadOnly)
+	testutils.CheckErr(s.Close())
+
+	// an mmap storage cuts its mapping with the file
+	s, err = gokvdb.OpenStorageWithOptions(dbPath, gokvdb.Options{Mmap: true})
+	testutils.CheckErr(err)
+	testutils.CheckErr(s.DropDB("mydb"))
+	testutils.CheckErr(s.Save())
+	reclaimed, err = s.Vacuum()
+	testutils.CheckErr(err)
+	fmt.Println("MMAP VACUUM", "reclaimed", reclaimed)
+	if reclaimed <= 0 {
+		fmt.Println("MMAP VACUUM ERROR!", reclaimed)
+		os.Exit(1)
+	}
+	VerifyDicts(s, "otherdb")
+	testutils.CheckErr(s.Close())
+
+	// a memory storage vacuums and compacts to a file
+	mem, err := gokvdb.LoadMemoryStorage(dstPath)
+	testutils.CheckErr(err)
+	testutils.CheckErr(mem.DropDB("mydb"))
+	testutils.CheckErr(mem.Save())
+	reclaimed, err = mem.Vacuum()
+	testutils.CheckErr(err)
+	fmt.Println("MEMORY VACUUM", "reclaimed", reclaimed)
+	VerifyDicts(mem, "otherdb")
+
+	_, err = mem.Compact(dstPath)
+	testutils.CheckErr(err)
+	testutils.CheckErr(mem.Close())
+
+	dst, err = gokvdb.OpenStorage(dstPath)
+	testutils.CheckErr(err)
+	testutils.ExpectDBs(dst, "otherdb")
+	VerifyDicts(dst, "otherdb")
+	testutils.CheckErr(dst.Close())
+
+	TestCompactDeleted(stamp)
+	TestVacuumOpenDicts(stamp)
+
+	fmt.Println("OK")
+}
+
+// TestVacuumOpenDicts vacuums under dicts with unsaved writes in a small
+// cache. The pages they hold move, so they fail until opened again and the
+// file keeps what was saved.
+func TestVacuumOpenDicts(stamp int64) {
+
+	dbPath := fmt.Sprintf("./testdata/compact_open_%v.kv", stamp)
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	testutils.FillDicts(s, "mydb", KEY_COUNT, true)
+	testutils.FillDicts(s, "otherdb", KEY_COUNT, true)
+	testutils.CheckErr(s.DropDB("otherdb"))
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorageWithOptions(dbPath, gokvdb.Options{CacheSize: 1 << 20})
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	for i:=0; i<KEY_COUNT; i++ {
+		testutils.CheckErr(nameById.Set(int64(i), fmt.Sprintf("unsaved-%v", i)))
+		testutils.CheckErr(blobByName.Set(fmt.Sprintf("name-%v", i), testutils.RandBytes(64)))
+	}
+
+	reclaimed, err := s.Vacuum()
+	testutils.CheckErr(err)
+	fmt.Println("OPEN VACUUM", "reclaimed", reclaimed, s.CacheStats().ToString())
+
+	testutils.ExpectErr(nameById.Set(1, "x"), gokvdb.ErrStaleDict)
+	testutils.ExpectErr(nameById.Save(false), gokvdb.ErrStaleDict)
+	testutils.ExpectErr(blobByName.Set("x", []byte("x")), gokvdb.ErrStaleDict)
+	testutils.ExpectErr(blobByName.Save(false), gokvdb.ErrStaleDict)
+
+	VerifyDicts(s, "mydb")
+
+	// dicts opened again write as before
+	nameById, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	testutils.CheckErr(nameById.Set(int64(KEY_COUNT), "added"))
+	testutils.CheckErr(nameById.Save(true))
+	testutils.ExpectClean(s)
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	VerifyDicts(s, "mydb")
+	nameById, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	name, err := nameById.Get(int64(KEY_COUNT))
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(name, "added")
+	testutils.ExpectClean(s)
+	testutils.CheckErr(s.Close())
+}
+
+// TestCompactDeleted deletes most keys of a dict and expects Compact to
+// give back the space they took inside its pages.
+func TestCompactDeleted(stamp int64) {
+
+	dbPath := fmt.Sprintf("./testdata/compact_deleted_%v.kv", stamp)
+	dstPath := fmt.Sprintf("./testdata/compact_deleted_%v_dst.kv", stamp)
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	for i:=0; i<1000; i++ {
+		testutils.CheckErr(blobByName.Set(fmt.Sprintf("name-%v", i), []byte(fmt.Sprintf("blob-%v", i))))
+	}
+	testutils.CheckErr(blobByName.Save(true))
+
+	for i:=100; i<1000; i++ {
+		testutils.CheckErr(blobByName.Delete(fmt.Sprintf("name-%v", i)))
+	}
+	testutils.CheckErr(blobByName.Save(true))
+	testutils.CheckErr(s.Checkpoint())
+
+	fullSize := testutils.FileSize(dbPath)
+
+	reclaimed, err := s.Compact(dstPath)
+	testutils.CheckErr(err)
+	dstSize := testutils.FileSize(dstPath)
+	fmt.Println("COMPACT DELETED", "reclaimed", reclaimed, "size", fullSize, "dstSize", dstSize)
+	if reclaimed <= 0 || dstSize > fullSize / 2 {
+		fmt.Println("COMPACT DELETED ERROR!", fullSize, dstSize, reclaimed)
+		os.Exit(1)
+	}
+	testutils.CheckErr(s.Close())
+
+	dst, err := gokvdb.OpenStorage(dstPath)
+	testutils.CheckErr(err)
+	blobByName, err = gokvdb.NewStrBlobDict(dst, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	for i:=0; i<1000; i++ {
+		blob, err := blobByName.Get(fmt.Sprintf("name-%v", i))
+		if i < 100 {
+			testutils.CheckErr(err)
+			testutils.ExpectEqual(string(blob), fmt.Sprintf("blob-%v", i))
+		} else {
+			testutils.ExpectErr(err, gokvdb.ErrNotFound)
+		}
+	}
+	testutils.ExpectClean(dst)
+	testutils.CheckErr(dst.Close())
+}
+
+// VerifyDicts reads back what testutils.FillDicts wrote into dbName.
+func VerifyDicts(s *gokvdb.Storage, dbName string) {
+
+	nameById, err := gokvdb.NewI64StrDict(s, dbName, "nameById")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewOrderedStrI64Dict(s, dbName, "idByName")
+	testutils.CheckErr(err)
+	blobById, err := gokvdb.NewI64BlobDict(s, dbName, "blobById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, dbName, "blobByName")
+	testutils.CheckErr(err)
+	idSetById, err := gokvdb.NewLazyI64I64SetDict(s, dbName, "idSetById")
+	testutils.CheckErr(err)
+	idSetByName, err := gokvdb.NewStrI64SetDict(s, dbName, "idSetByName")
+	testutils.CheckErr(err)
+
+	db, err := s.DB(dbName)
+	testutils.CheckErr(err)
+	bt, err := db.OpenBTree("btree")
+	testutils.CheckErr(err)
+
+	for i:=0; i<KEY_COUNT; i+=3 {
+		key := int64(i)
+		name := fmt.Sprintf("name-%v", i)
+
+		val, err := nameById.Get(key)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(val, name)
+
+		id, err := idByName.Get(name)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(id, key)
+
+		blob, err := blobById.Get(key)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(string(blob), fmt.Sprintf("blob-%v", i))
+
+		blob, err = blobByName.Get(name)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(string(blob), fmt.Sprintf("blob-%v", i))
+
+		blob, err = bt.Get(key)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(string(blob), fmt.Sprintf("btree-%v", i))
+	}
+
+	for i:=0; i<100; i++ {
+		set, err := idSetById.Get(int64(i))
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(testutils.CountValues(set.Values()), KEY_COUNT / 100)
+
+		set2, err := idSetByName.Get(fmt.Sprintf("set-%v", i))
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(testutils.CountValues(set2.Values()), KEY_COUNT / 100)
+	}
+
+	count := 0
+	cur := idByName.PrefixScan("name-")
+	for cur.Next() {
+		count += 1
+	}
+	testutils.CheckErr(cur.Err())
+	cur.Close()
+	testutils.ExpectEqual(count, KEY_COUNT)
+}
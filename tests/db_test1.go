@@ -69,10 +69,10 @@ func TestBTree(dbPath string, dbName string, btName string, testCount int) {
 
 				fmt.Println("SET", key, "bytes", len(data1))
 
-				bt.Set(key, data1)
+				CheckErr(bt.Set(key, data1))
 
 		}
-		s.Save()
+		CheckErr(s.Save())
 
 	})
 
@@ -92,9 +92,9 @@ func TestBTree(dbPath string, dbName string, btName string, testCount int) {
 
 		for key, data1 := range testData {
 
-			data2, ok := bt.Get(key)
-			if !ok {
-				fmt.Println("NO KEY", key)
+			data2, err := bt.Get(key)
+			if err != nil {
+				fmt.Println("NO KEY", key, err)
 				os.Exit(1)
 
 			}
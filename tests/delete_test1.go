@@ -0,0 +1,241 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	// the sets of the first keys are deleted whole
+	DELETED_SETS = 4
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/delete_%v.kv", time.Now().UTC().UnixNano())
+	dbName := "mydb"
+
+	for i:=0; i<8; i++ {
+		TestDelete(dbPath, dbName, 2000)
+	}
+
+	TestReuse(fmt.Sprintf("./testdata/delete_reuse_%v.kv", time.Now().UTC().UnixNano()))
+}
+
+// TestReuse deletes every blob and expects the pages freed by the save to
+// take the same amount of blobs again without the file growing. The blobs
+// live in the InternalPager of their dict, its freelist takes the pages.
+func TestReuse(dbPath string) {
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	i64BlobDict, err := gokvdb.NewI64BlobDict(s, "mydb", "i64blob")
+	testutils.CheckErr(err)
+	strBlobDict, err := gokvdb.NewStrBlobDict(s, "mydb", "strblob")
+	testutils.CheckErr(err)
+
+	var first gokvdb.StorageInfo
+
+	for round:=0; round<3; round++ {
+
+		for i:=0; i<500; i++ {
+			key := int64(round * 1000 + i)
+			testutils.CheckErr(i64BlobDict.Set(key, testutils.RandBytes(8192)))
+			testutils.CheckErr(strBlobDict.Set(fmt.Sprintf("key-%v", key), testutils.RandBytes(8192)))
+		}
+		testutils.CheckErr(i64BlobDict.Save(false))
+		testutils.CheckErr(strBlobDict.Save(true))
+
+		full, err := s.Info()
+		testutils.CheckErr(err)
+
+		for i:=0; i<500; i++ {
+			key := int64(round * 1000 + i)
+			testutils.CheckErr(i64BlobDict.Delete(key))
+			testutils.CheckErr(strBlobDict.Delete(fmt.Sprintf("key-%v", key)))
+		}
+		testutils.CheckErr(i64BlobDict.Save(false))
+		testutils.CheckErr(strBlobDict.Save(true))
+
+		fmt.Println("REUSE", round, "lastPageId", full.LastPageId)
+
+		// the later rounds fit in the pages of the first
+		if round > 0 && full.LastPageId > first.LastPageId + first.LastPageId / 20 {
+			fmt.Println("REUSE ERROR!", first.LastPageId, full.LastPageId)
+			os.Exit(1)
+		}
+		if round == 0 {
+			first = full
+		}
+	}
+
+	testutils.CheckErr(s.Close())
+}
+
+func TestDelete(dbPath string, dbName string, testCount int) {
+
+	var keys []int64
+	keySet := make(map[int64]byte)
+
+	// the file is shared by the rounds, the set keys of each are its own
+	setPrefix := fmt.Sprintf("set-%v", rand.Int63())
+
+	for len(keys) < testCount {
+		key := rand.Int63n(68719476736)
+		if _, ok := keySet[key]; ok {
+			continue
+		}
+		keySet[key] = 1
+		keys = append(keys, key)
+	}
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		i64StrDict, err := gokvdb.NewI64StrDict(s, dbName, "i64str")
+		testutils.CheckErr(err)
+		i64BlobDict, err := gokvdb.NewI64BlobDict(s, dbName, "i64blob")
+		testutils.CheckErr(err)
+		strI64Dict, err := gokvdb.NewStrI64Dict(s, dbName, "stri64")
+		testutils.CheckErr(err)
+		setDict, err := gokvdb.NewLazyI64I64SetDict(s, dbName, "i64i64set")
+		testutils.CheckErr(err)
+		strBlobDict, err := gokvdb.NewStrBlobDict(s, dbName, "strblob")
+		testutils.CheckErr(err)
+		strSetDict, err := gokvdb.NewStrI64SetDict(s, dbName, "stri64set")
+		testutils.CheckErr(err)
+
+		for _, key := range keys {
+			testutils.CheckErr(i64StrDict.Set(key, fmt.Sprintf("val-%v", key)))
+			testutils.CheckErr(i64BlobDict.Set(key, testutils.RandBytes(rand.Intn(8192) + 1)))
+			testutils.CheckErr(strI64Dict.Set(fmt.Sprintf("key-%v", key), key))
+			testutils.CheckErr(setDict.Add(key % 64, key))
+			testutils.CheckErr(strBlobDict.Set(fmt.Sprintf("key-%v", key), testutils.RandBytes(rand.Intn(8192) + 1)))
+			testutils.CheckErr(strSetDict.Add(SetKey(setPrefix, key), key))
+		}
+
+		testutils.CheckErr(i64StrDict.Save(false))
+		testutils.CheckErr(i64BlobDict.Save(false))
+		testutils.CheckErr(strI64Dict.Save(false))
+		testutils.CheckErr(setDict.Save(false))
+		testutils.CheckErr(strBlobDict.Save(false))
+		testutils.CheckErr(strSetDict.Save(true))
+	})
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		i64StrDict, err := gokvdb.NewI64StrDict(s, dbName, "i64str")
+		testutils.CheckErr(err)
+		i64BlobDict, err := gokvdb.NewI64BlobDict(s, dbName, "i64blob")
+		testutils.CheckErr(err)
+		strI64Dict, err := gokvdb.NewStrI64Dict(s, dbName, "stri64")
+		testutils.CheckErr(err)
+		setDict, err := gokvdb.NewLazyI64I64SetDict(s, dbName, "i64i64set")
+		testutils.CheckErr(err)
+		strBlobDict, err := gokvdb.NewStrBlobDict(s, dbName, "strblob")
+		testutils.CheckErr(err)
+		strSetDict, err := gokvdb.NewStrI64SetDict(s, dbName, "stri64set")
+		testutils.CheckErr(err)
+
+		for i, key := range keys {
+			if i % 2 == 0 {
+				err1 := i64StrDict.Delete(key)
+				err2 := i64BlobDict.Delete(key)
+				err3 := strI64Dict.Delete(fmt.Sprintf("key-%v", key))
+				err4 := setDict.Remove(key % 64, key)
+				err5 := strBlobDict.Delete(fmt.Sprintf("key-%v", key))
+				err6 := strSetDict.Remove(SetKey(setPrefix, key), key)
+
+				fmt.Printf("DELETE key=%v err=%v %v %v %v %v %v\n", key, err1, err2, err3, err4, err5, err6)
+				if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil || err6 != nil {
+					fmt.Println("DELETE ERROR!")
+					os.Exit(1)
+				}
+			}
+		}
+
+		// whole sets go too
+		for i:=int64(0); i<DELETED_SETS; i++ {
+			testutils.CheckErr(strSetDict.Delete(SetKey(setPrefix, i)))
+		}
+
+		testutils.CheckErr(i64StrDict.Save(false))
+		testutils.CheckErr(i64BlobDict.Save(false))
+		testutils.CheckErr(strI64Dict.Save(false))
+		testutils.CheckErr(setDict.Save(false))
+		testutils.CheckErr(strBlobDict.Save(false))
+		testutils.CheckErr(strSetDict.Save(true))
+	})
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		i64StrDict, err := gokvdb.NewI64StrDict(s, dbName, "i64str")
+		testutils.CheckErr(err)
+		i64BlobDict, err := gokvdb.NewI64BlobDict(s, dbName, "i64blob")
+		testutils.CheckErr(err)
+		strI64Dict, err := gokvdb.NewStrI64Dict(s, dbName, "stri64")
+		testutils.CheckErr(err)
+		strBlobDict, err := gokvdb.NewStrBlobDict(s, dbName, "strblob")
+		testutils.CheckErr(err)
+		strSetDict, err := gokvdb.NewStrI64SetDict(s, dbName, "stri64set")
+		testutils.CheckErr(err)
+
+		expectedSets := make(map[string]map[int64]bool)
+
+		for i, key := range keys {
+			_, err1 := i64StrDict.Get(key)
+			_, err2 := i64BlobDict.Get(key)
+			_, err3 := strI64Dict.Get(fmt.Sprintf("key-%v", key))
+			_, err4 := strBlobDict.Get(fmt.Sprintf("key-%v", key))
+
+			ok1 := err1 == nil
+			ok2 := err2 == nil
+			ok3 := err3 == nil
+			ok4 := err4 == nil
+			if !ok1 && !(errors.Is(err1, gokvdb.ErrNotFound) && errors.Is(err2, gokvdb.ErrNotFound) && errors.Is(err3, gokvdb.ErrNotFound) && errors.Is(err4, gokvdb.ErrNotFound)) {
+				fmt.Println("GET ERROR!", err1, err2, err3, err4)
+				os.Exit(1)
+			}
+
+			isValid := ok1 == (i % 2 == 1) && ok2 == ok1 && ok3 == ok1 && ok4 == ok1
+			fmt.Printf("VALID key=%v ok=%v %v %v %v isValid=%v\n", key, ok1, ok2, ok3, ok4, isValid)
+			if !isValid {
+				fmt.Println("VALID ERROR!")
+				os.Exit(1)
+			}
+
+			setKey := SetKey(setPrefix, key)
+			if key % 64 >= DELETED_SETS {
+				if _, ok := expectedSets[setKey]; !ok {
+					expectedSets[setKey] = make(map[int64]bool)
+				}
+				if i % 2 == 1 {
+					expectedSets[setKey][key] = true
+				}
+			}
+		}
+
+		for i:=int64(0); i<64; i++ {
+			setKey := SetKey(setPrefix, i)
+			item, err := strSetDict.Get(setKey)
+			expected, ok := expectedSets[setKey]
+			if !ok {
+				testutils.ExpectErr(err, gokvdb.ErrNotFound)
+				continue
+			}
+			testutils.CheckErr(err)
+			testutils.VerifyMembers(item.Values(), expected)
+		}
+	})
+}
+
+func SetKey(prefix string, key int64) string {
+	return fmt.Sprintf("%v-%v", prefix, key % 64)
+}
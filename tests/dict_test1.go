@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"sort"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+type User struct {
+	Name string
+	Age int
+	Tags []string
+}
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/dict_%v.kv", time.Now().UTC().UnixNano())
+	dbName := "mydb"
+
+	for i:=0; i<4; i++ {
+		TestDict(dbPath, dbName)
+		TestSet(dbPath, dbName)
+	}
+}
+
+func TestDict(dbPath string, dbName string) {
+
+	users := make(map[float64]User)
+	var keys []float64
+
+	for i:=0; i<1000; i++ {
+		key := rand.NormFloat64() * 1000
+		users[key] = User{Name: fmt.Sprintf("user-%v", i), Age: rand.Intn(100), Tags: []string{"a", "b"}}
+		keys = append(keys, key)
+	}
+	sort.Float64s(keys)
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		dict, err := gokvdb.NewDict(s, dbName, "userByScore", gokvdb.Float64Codec{}, gokvdb.JSONCodec[User]{})
+		testutils.CheckErr(err)
+
+		for key, user := range users {
+			testutils.CheckErr(dict.Set(key, user))
+		}
+
+		testutils.CheckErr(dict.Save(true))
+	})
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		dict, err := gokvdb.NewDict(s, dbName, "userByScore", gokvdb.Float64Codec{}, gokvdb.JSONCodec[User]{})
+		testutils.CheckErr(err)
+
+		for key, user := range users {
+			user2, err := dict.Get(key)
+			testutils.CheckErr(err)
+
+			isValid := user.Name == user2.Name && user.Age == user2.Age && len(user2.Tags) == 2
+			if !isValid {
+				fmt.Println("VALID ERROR!", key, user, user2)
+				os.Exit(1)
+			}
+		}
+
+		// the float codec keeps negative keys ahead of positive ones
+		from := keys[100]
+		to := keys[900]
+		count := 0
+
+		cur := dict.Range(from, to)
+		for cur.Next() {
+			if cur.Key() != keys[100 + count] {
+				fmt.Println("RANGE ERROR!", count, cur.Key(), keys[100 + count])
+				os.Exit(1)
+			}
+			count += 1
+		}
+		testutils.CheckErr(cur.Close())
+
+		fmt.Println("RANGE", from, to, "count", count)
+		if count != 800 {
+			fmt.Println("RANGE ERROR!", count)
+			os.Exit(1)
+		}
+
+		for _, key := range keys {
+			testutils.CheckErr(dict.Delete(key))
+		}
+		testutils.CheckErr(dict.Save(true))
+	})
+}
+
+func TestSet(dbPath string, dbName string) {
+
+	testData := make(map[uint64][]string)
+
+	for i:=0; i<100; i++ {
+		key := rand.Uint64()
+		for j:=0; j<rand.Intn(50)+1; j++ {
+			testData[key] = append(testData[key], fmt.Sprintf("friend-%05d", rand.Intn(100000)))
+		}
+	}
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		set, err := gokvdb.NewSet(s, dbName, "friendsById", gokvdb.Uint64Codec{}, gokvdb.StringCodec{})
+		testutils.CheckErr(err)
+
+		for key, vals := range testData {
+			for _, val := range vals {
+				testutils.CheckErr(set.Add(key, val))
+			}
+		}
+
+		testutils.CheckErr(set.Save(true))
+	})
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		set, err := gokvdb.NewSet(s, dbName, "friendsById", gokvdb.Uint64Codec{}, gokvdb.StringCodec{})
+		testutils.CheckErr(err)
+
+		for key, vals := range testData {
+
+			valSet := make(map[string]bool)
+			for _, val := range vals {
+				valSet[val] = true
+			}
+
+			var expected []string
+			for val, _ := range valSet {
+				expected = append(expected, val)
+			}
+			sort.Strings(expected)
+
+			var setVals []string
+			cur := set.Values(key)
+			for cur.Next() {
+				setVals = append(setVals, cur.Key())
+			}
+			testutils.CheckErr(cur.Close())
+
+			isValid := fmt.Sprint(expected) == fmt.Sprint(setVals)
+			fmt.Printf("VALUES key=%v rows=%v isValid=%v\n", key, len(setVals), isValid)
+			if !isValid {
+				fmt.Println("VALID ERROR!", expected, setVals)
+				os.Exit(1)
+			}
+
+			ok, err := set.Has(key, expected[0])
+			testutils.CheckErr(err)
+			testutils.CheckErr(set.Remove(key, expected[0]))
+			ok2, err := set.Has(key, expected[0])
+			testutils.CheckErr(err)
+			if !ok || ok2 {
+				fmt.Println("REMOVE ERROR!", key, ok, ok2)
+				os.Exit(1)
+			}
+
+			if len(expected) > 1 {
+				testutils.CheckErr(set.Delete(key))
+			}
+		}
+
+		testutils.CheckErr(set.Save(true))
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	dbPath := fmt.Sprintf("./testdata/dictkind_%v.kv", time.Now().UTC().UnixNano())
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewOrderedStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	blobById, err := gokvdb.NewI64BlobDict(s, "mydb", "blobById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	idSetById, err := gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+	idSetByName, err := gokvdb.NewStrI64SetDict(s, "mydb", "idSetByName")
+	testutils.CheckErr(err)
+
+	testutils.CheckErr(nameById.Set(1, "name1"))
+	testutils.CheckErr(idByName.Set("name1", 1))
+	testutils.CheckErr(blobById.Set(1, []byte("blob1")))
+	testutils.CheckErr(blobByName.Set("name1", []byte("blob1")))
+	testutils.CheckErr(idSetById.Add(1, 2))
+	testutils.CheckErr(idSetByName.Add("name1", 2))
+
+	for _, dict := range []gokvdb.IDict{nameById, idByName, blobById, blobByName, idSetById, idSetByName} {
+		testutils.CheckErr(dict.Save(false))
+	}
+
+	db, err := s.DB("mydb")
+	testutils.CheckErr(err)
+	bt, err := db.OpenBTree("btree")
+	testutils.CheckErr(err)
+	testutils.CheckErr(bt.Set(1, []byte("btree1")))
+
+	testutils.CheckErr(s.Save())
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	db, err = s.DB("mydb")
+	testutils.CheckErr(err)
+	for _, info := range db.ListDicts() {
+		fmt.Println(info.ToString())
+		if info.Kind != gokvdb.DICT_KIND_BTREE && info.Version != gokvdb.DICT_FORMAT_VERSION {
+			fmt.Println("VERSION ERROR!", info.ToString())
+			os.Exit(1)
+		}
+	}
+
+	// OpenDict hands back the concrete type each dict was saved as
+	for _, name := range []string{"nameById", "idByName", "blobById", "blobByName", "idSetById", "idSetByName"} {
+
+		dict, err := s.OpenDict("mydb", name)
+		testutils.CheckErr(err)
+		fmt.Println("OPEN", name, dict.ToString())
+
+		switch d := dict.(type) {
+		case *gokvdb.LazyI64StrDict:
+			val, err := d.Get(1)
+			testutils.CheckErr(err)
+			testutils.ExpectEqual(name, "nameById")
+			testutils.ExpectEqual(val, "name1")
+		case *gokvdb.LazyStrI64Dict:
+			val, err := d.Get("name1")
+			testutils.CheckErr(err)
+			testutils.ExpectEqual(name, "idByName")
+			testutils.ExpectEqual(val, int64(1))
+			if !d.IsOrdered() {
+				fmt.Println("ORDER ERROR!", name)
+				os.Exit(1)
+			}
+		case *gokvdb.LazyI64BlobDict:
+			val, err := d.Get(1)
+			testutils.CheckErr(err)
+			testutils.ExpectEqual(name, "blobById")
+			testutils.ExpectEqual(string(val), "blob1")
+		case *gokvdb.LazyStrBlobDict:
+			val, err := d.Get("name1")
+			testutils.CheckErr(err)
+			testutils.ExpectEqual(name, "blobByName")
+			testutils.ExpectEqual(string(val), "blob1")
+		case *gokvdb.LazyI64I64SetDict:
+			set, err := d.Get(1)
+			testutils.CheckErr(err)
+			testutils.ExpectEqual(name, "idSetById")
+			testutils.ExpectEqual(FirstValue(set.Values()), int64(2))
+		case *gokvdb.LazyStrI64SetDict:
+			set, err := d.Get("name1")
+			testutils.CheckErr(err)
+			testutils.ExpectEqual(name, "idSetByName")
+			testutils.ExpectEqual(FirstValue(set.Values()), int64(2))
+		default:
+			fmt.Println("TYPE ERROR!", name, dict.ToString())
+			os.Exit(1)
+		}
+	}
+
+	_, err = s.OpenDict("mydb", "btree")
+	testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+	_, err = s.OpenDict("mydb", "blobByName_idByKey")
+	testutils.ExpectErr(err, gokvdb.ErrNotFound)
+	_, err = s.OpenDict("mydb", "missing")
+	testutils.ExpectErr(err, gokvdb.ErrNotFound)
+
+	// a constructor of another kind does not misread the meta
+	_, err = gokvdb.NewStrI64Dict(s, "mydb", "nameById")
+	testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+	_, err = gokvdb.NewI64StrDict(s, "mydb", "blobById")
+	testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+	_, err = gokvdb.NewStrBlobDict(s, "mydb", "idByName")
+	testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+	_, err = gokvdb.NewStrI64SetDict(s, "mydb", "idSetById")
+	testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+	_, err = gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetByName")
+	testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+	_, err = gokvdb.NewI64BlobDict(s, "mydb", "blobByName")
+	testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+	fmt.Println(err)
+
+	testutils.CheckErr(s.Close())
+
+	TestLegacyKind(fmt.Sprintf("./testdata/dictkind_legacy_%v.kv", time.Now().UTC().UnixNano()))
+
+	fmt.Println("OK")
+}
+
+// TestLegacyKind opens dicts saved before kinds were recorded. A
+// constructor opens one and its save records the kind, SetDictKind records
+// it without a save.
+func TestLegacyKind(dbPath string) {
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	testutils.CheckErr(nameById.Set(1, "name1"))
+	testutils.CheckErr(nameById.Save(false))
+
+	// a meta set with no kind is what a file of an older version holds,
+	// the legacy dicts share the pages of nameById
+	db, err := s.DB("mydb")
+	testutils.CheckErr(err)
+	meta, err := db.GetMeta("nameById")
+	testutils.CheckErr(err)
+	testutils.CheckErr(db.SetMeta("legacy", meta))
+	testutils.CheckErr(db.SetMeta("legacy2", meta))
+	testutils.CheckErr(s.Save())
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	db, err = s.DB("mydb")
+	testutils.CheckErr(err)
+
+	ExpectKind(db, "legacy", gokvdb.DICT_KIND_UNKNOWN)
+	_, err = s.OpenDict("mydb", "legacy")
+	testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+
+	legacy, err := gokvdb.NewI64StrDict(s, "mydb", "legacy")
+	testutils.CheckErr(err)
+	val, err := legacy.Get(1)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(val, "name1")
+	testutils.CheckErr(legacy.Save(true))
+	ExpectKind(db, "legacy", gokvdb.DICT_KIND_I64STR)
+
+	testutils.ExpectErr(db.SetDictKind("missing", gokvdb.DICT_KIND_I64STR), gokvdb.ErrNotFound)
+	testutils.ExpectErr(db.SetDictKind("nameById", gokvdb.DICT_KIND_STRI64), gokvdb.ErrWrongKind)
+	testutils.ExpectErr(db.SetDictKind("legacy2", gokvdb.DICT_KIND_BTREE), gokvdb.ErrWrongKind)
+	testutils.CheckErr(db.SetDictKind("legacy2", gokvdb.DICT_KIND_I64STR))
+	testutils.CheckErr(s.Save())
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	for _, name := range []string{"legacy", "legacy2"} {
+		_, err = gokvdb.NewStrI64Dict(s, "mydb", name)
+		testutils.ExpectErr(err, gokvdb.ErrWrongKind)
+
+		dict, err := s.OpenDict("mydb", name)
+		testutils.CheckErr(err)
+		legacy, ok := dict.(*gokvdb.LazyI64StrDict)
+		if !ok {
+			fmt.Println("TYPE ERROR!", name, dict.ToString())
+			os.Exit(1)
+		}
+		val, err := legacy.Get(1)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(val, "name1")
+	}
+
+	testutils.CheckErr(s.Close())
+
+	fmt.Println("LEGACY KIND OK")
+}
+
+func ExpectKind(db *gokvdb.DBContext, name string, kind gokvdb.DictKind) {
+	for _, info := range db.ListDicts() {
+		if info.Name == name {
+			testutils.ExpectEqual(info.Kind, kind)
+			return
+		}
+	}
+	fmt.Println("LIST DICTS ERROR!", name)
+	os.Exit(1)
+}
+
+func FirstValue(cur *gokvdb.Cursor[int64, struct{}]) int64 {
+	defer cur.Close()
+	if !cur.Next() {
+		testutils.CheckErr(cur.Err())
+		return 0
+	}
+	return cur.Key()
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"bytes"
+	"strings"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 1000
+)
+
+func main() {
+
+	stamp := time.Now().UTC().UnixNano()
+	srcPath := fmt.Sprintf("./testdata/export_%v.kv", stamp)
+
+	src, err := gokvdb.OpenStorage(srcPath)
+	testutils.CheckErr(err)
+	Fill(src)
+
+	for _, format := range []string{gokvdb.EXPORT_JSONL, gokvdb.EXPORT_CSV} {
+
+		dstPath := fmt.Sprintf("./testdata/export_%v_%v.kv", stamp, format)
+		dst, err := gokvdb.OpenStorage(dstPath)
+		testutils.CheckErr(err)
+
+		for _, dictName := range []string{"nameById", "idByName", "blobById", "blobByName", "idSetById", "idSetByName"} {
+
+			srcDict, err := src.OpenDict("mydb", dictName)
+			testutils.CheckErr(err)
+
+			var buf bytes.Buffer
+			count, err := gokvdb.ExportDict(&buf, srcDict, format)
+			testutils.CheckErr(err)
+			testutils.ExpectEqual(count, KEY_COUNT)
+
+			dstDict := NewDict(dst, dictName)
+
+			// the import saves the storage once
+			before, err := dst.Info()
+			testutils.CheckErr(err)
+			report, err := gokvdb.ImportDict(&buf, dstDict, format)
+			testutils.CheckErr(err)
+			after, err := dst.Info()
+			testutils.CheckErr(err)
+			fmt.Println("IMPORT", format, dictName, report.ToString())
+			testutils.ExpectEqual(len(report.Malformed), 0)
+			testutils.ExpectEqual(after.Epoch, before.Epoch + 1)
+		}
+
+		testutils.CheckErr(dst.Close())
+
+		dst, err = gokvdb.OpenStorageReadOnly(dstPath)
+		testutils.CheckErr(err)
+		Verify(dst)
+		testutils.CheckErr(dst.Close())
+	}
+
+	testutils.CheckErr(src.Close())
+
+	// malformed lines are skipped and reported, the others are imported
+	dst, err := gokvdb.OpenStorage(fmt.Sprintf("./testdata/export_%v_bad.kv", stamp))
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(dst, "mydb", "nameById")
+	testutils.CheckErr(err)
+	jsonl := strings.Join([]string{
+		`{"key":1,"value":"one"}`,
+		`{"key":"2","value":"two"}`,
+		`not json`,
+		``,
+		`{"key":3}`,
+		`{"key":4,"value":"four"}`,
+	}, "\n")
+	report, err := gokvdb.ImportDict(strings.NewReader(jsonl), nameById, gokvdb.EXPORT_JSONL)
+	testutils.CheckErr(err)
+	fmt.Println("BAD JSONL", report.ToString(), report.Malformed)
+	testutils.ExpectEqual(report.Lines, 6)
+	testutils.ExpectEqual(report.Imported, 2)
+	testutils.ExpectEqual(fmt.Sprint(report.Malformed), "[2 3 5]")
+	val, err := nameById.Get(4)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(val, "four")
+
+	blobById, err := gokvdb.NewI64BlobDict(dst, "mydb", "blobById")
+	testutils.CheckErr(err)
+	csvText := "key,value\n1,b25l\nx,dHdv\n3,not base64!\n4,\"Zm91\nuncl\n5,Zml2ZQ==\n"
+	report, err = gokvdb.ImportDict(strings.NewReader(csvText), blobById, gokvdb.EXPORT_CSV)
+	testutils.CheckErr(err)
+	fmt.Println("BAD CSV", report.ToString(), report.Malformed)
+	testutils.ExpectEqual(report.Imported, 1)
+	if len(report.Malformed) < 3 || report.Malformed[0] != 3 || report.Malformed[1] != 4 {
+		fmt.Println("MALFORMED ERROR!", report.Malformed)
+		os.Exit(1)
+	}
+	blob, err := blobById.Get(1)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(string(blob), "one")
+
+	_, err = gokvdb.ImportDict(strings.NewReader(""), blobById, "xml")
+	testutils.ExpectErr(err, gokvdb.ErrNotImplemented)
+
+	testutils.CheckErr(dst.Close())
+
+	fmt.Println("OK")
+}
+
+func Fill(s *gokvdb.Storage) {
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	blobById, err := gokvdb.NewI64BlobDict(s, "mydb", "blobById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	idSetById, err := gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+	idSetByName, err := gokvdb.NewStrI64SetDict(s, "mydb", "idSetByName")
+	testutils.CheckErr(err)
+
+	for i:=0; i<KEY_COUNT; i++ {
+		name := Name(i)
+		testutils.CheckErr(nameById.Set(int64(i), name))
+		testutils.CheckErr(idByName.Set(name, int64(i)))
+		testutils.CheckErr(blobById.Set(int64(i), testutils.Blob(int64(i), 300)))
+		testutils.CheckErr(blobByName.Set(name, testutils.Blob(int64(i), 300)))
+		for j:=0; j<=i % 5; j++ {
+			testutils.CheckErr(idSetById.Add(int64(i), int64(i * 10 + j)))
+			testutils.CheckErr(idSetByName.Add(name, int64(i * 10 + j)))
+		}
+	}
+
+	testutils.CheckErr(nameById.Save(false))
+	testutils.CheckErr(idByName.Save(false))
+	testutils.CheckErr(blobById.Save(false))
+	testutils.CheckErr(blobByName.Save(false))
+	testutils.CheckErr(idSetById.Save(false))
+	testutils.CheckErr(idSetByName.Save(false))
+	testutils.CheckErr(s.Save())
+}
+
+func NewDict(s *gokvdb.Storage, dictName string) gokvdb.IDict {
+
+	var dict gokvdb.IDict
+	var err error
+
+	switch dictName {
+	case "nameById":
+		dict, err = gokvdb.NewI64StrDict(s, "mydb", dictName)
+	case "idByName":
+		dict, err = gokvdb.NewStrI64Dict(s, "mydb", dictName)
+	case "blobById":
+		dict, err = gokvdb.NewI64BlobDict(s, "mydb", dictName)
+	case "blobByName":
+		dict, err = gokvdb.NewStrBlobDict(s, "mydb", dictName)
+	case "idSetById":
+		dict, err = gokvdb.NewLazyI64I64SetDict(s, "mydb", dictName)
+	case "idSetByName":
+		dict, err = gokvdb.NewStrI64SetDict(s, "mydb", dictName)
+	}
+	testutils.CheckErr(err)
+
+	return dict
+}
+
+func Verify(s *gokvdb.Storage) {
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	blobById, err := gokvdb.NewI64BlobDict(s, "mydb", "blobById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	idSetById, err := gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+	idSetByName, err := gokvdb.NewStrI64SetDict(s, "mydb", "idSetByName")
+	testutils.CheckErr(err)
+
+	for i:=0; i<KEY_COUNT; i++ {
+		name := Name(i)
+
+		val, err := nameById.Get(int64(i))
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(val, name)
+
+		id, err := idByName.Get(name)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(id, int64(i))
+
+		blob, err := blobById.Get(int64(i))
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(string(blob), string(testutils.Blob(int64(i), 300)))
+
+		blob, err = blobByName.Get(name)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(string(blob), string(testutils.Blob(int64(i), 300)))
+
+		expected := ""
+		for j:=0; j<=i % 5; j++ {
+			expected += fmt.Sprintf("%v,", i * 10 + j)
+		}
+		item, err := idSetById.Get(int64(i))
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(Values(item.Values()), expected)
+		nameItem, err := idSetByName.Get(name)
+		testutils.CheckErr(err)
+		testutils.ExpectEqual(Values(nameItem.Values()), expected)
+	}
+}
+
+// Name gives the keys commas, quotes and line breaks for the CSV.
+func Name(i int) string {
+	switch i % 4 {
+	case 1:
+		return fmt.Sprintf("name, %v", i)
+	case 2:
+		return fmt.Sprintf("\"name\" %v", i)
+	case 3:
+		return fmt.Sprintf("name\n%v", i)
+	}
+	return fmt.Sprintf("name-%v", i)
+}
+
+func Values(cur *gokvdb.Cursor[int64, struct{}]) string {
+	defer cur.Close()
+	text := ""
+	for cur.Next() {
+		text += fmt.Sprintf("%v,", cur.Key())
+	}
+	testutils.CheckErr(cur.Err())
+	return text
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 5000
+	LOOKUP_COUNT = 3000
+)
+
+type Dicts struct {
+	nameById *gokvdb.LazyI64StrDict
+	idByName *gokvdb.LazyStrI64Dict
+	orderedIdByName *gokvdb.LazyStrI64Dict
+	blobById *gokvdb.LazyI64BlobDict
+	blobByName *gokvdb.LazyStrBlobDict
+	idSetById *gokvdb.LazyI64I64SetDict
+	idSetByName *gokvdb.LazyStrI64SetDict
+	scoreById *gokvdb.Dict[int64, float64]
+}
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/getmany_%v.kv", time.Now().UTC().UnixNano())
+
+	// a small SplitKeys and fan-out give the indexes and trees several levels
+	opts := gokvdb.Options{SplitKeys: 16, BranchFanOut: 16}
+	s, err := gokvdb.OpenStorageWithOptions(dbPath, opts)
+	testutils.CheckErr(err)
+
+	d := OpenDicts(s)
+
+	var ids []int64
+	for i:=0; i<KEY_COUNT; i++ {
+		id := rand.Int63n(1 << 32) - (1 << 31)
+		ids = append(ids, id)
+		name := fmt.Sprintf("name-%v", id)
+
+		testutils.CheckErr(d.nameById.Set(id, fmt.Sprintf("value-%v", id)))
+		testutils.CheckErr(d.idByName.Set(name, id))
+		testutils.CheckErr(d.orderedIdByName.Set(name, id))
+		testutils.CheckErr(d.blobById.Set(id, testutils.Blob(id, 100)))
+		testutils.CheckErr(d.blobByName.Set(name, testutils.Blob(id, 100)))
+		testutils.CheckErr(d.scoreById.Set(id, float64(id) / 2))
+		if i % 10 == 0 {
+			testutils.CheckErr(d.idSetById.AddMany(id, []int64{id, id + 1, id + 5000}))
+			testutils.CheckErr(d.idSetByName.AddMany(name, []int64{id, id + 1}))
+		}
+	}
+
+	// the unsaved contexts answer too
+	VerifyGetMany(d, Lookups(ids))
+
+	testutils.CheckErr(d.nameById.Save(false))
+	testutils.CheckErr(d.idByName.Save(false))
+	testutils.CheckErr(d.orderedIdByName.Save(false))
+	testutils.CheckErr(d.blobById.Save(false))
+	testutils.CheckErr(d.blobByName.Save(false))
+	testutils.CheckErr(d.idSetById.Save(false))
+	testutils.CheckErr(d.idSetByName.Save(false))
+	testutils.CheckErr(d.scoreById.Save(true))
+	testutils.CheckErr(s.Close())
+
+	// and the pages read back from the file
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	d = OpenDicts(s)
+	VerifyGetMany(d, Lookups(ids))
+	VerifyGetMany(d, nil)
+
+	// a missing key in every position
+	VerifyGetMany(d, []int64{1, ids[0], 1, ids[0]})
+
+	testutils.CheckErr(s.Close())
+
+	fmt.Println("OK")
+}
+
+func OpenDicts(s *gokvdb.Storage) *Dicts {
+	var err error
+	d := new(Dicts)
+	d.nameById, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	d.idByName, err = gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+	d.orderedIdByName, err = gokvdb.NewOrderedStrI64Dict(s, "mydb", "orderedIdByName")
+	testutils.CheckErr(err)
+	d.blobById, err = gokvdb.NewI64BlobDict(s, "mydb", "blobById")
+	testutils.CheckErr(err)
+	d.blobByName, err = gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+	d.idSetById, err = gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+	d.idSetByName, err = gokvdb.NewStrI64SetDict(s, "mydb", "idSetByName")
+	testutils.CheckErr(err)
+	d.scoreById, err = gokvdb.NewDict(s, "mydb", "scoreById", gokvdb.Int64Codec{}, gokvdb.Float64Codec{})
+	testutils.CheckErr(err)
+	return d
+}
+
+// Lookups mixes the keys with missing ones and repeats, out of order.
+func Lookups(ids []int64) []int64 {
+	var keys []int64
+	for i:=0; i<LOOKUP_COUNT; i++ {
+		switch i % 3 {
+		case 0:
+			keys = append(keys, rand.Int63n(1 << 32) - (1 << 31))
+		default:
+			keys = append(keys, ids[rand.Intn(len(ids))])
+		}
+	}
+	return keys
+}
+
+func VerifyGetMany(d *Dicts, ids []int64) {
+
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = fmt.Sprintf("name-%v", id)
+	}
+
+	strs, found, err := d.nameById.GetMany(ids)
+	testutils.CheckErr(err)
+	testutils.ExpectEqual(len(strs), len(ids))
+	count := 0
+	for i, id := range ids {
+		val, err := d.nameById.Get(id)
+		ExpectFound(found.Has(i), err)
+		if found.Has(i) {
+			testutils.ExpectEqual(strs[i], val)
+			count += 1
+		}
+	}
+	testutils.ExpectEqual(found.Count(), count)
+
+	for _, dict := range []*gokvdb.LazyStrI64Dict{d.idByName, d.orderedIdByName} {
+		values, found, err := dict.GetMany(names)
+		testutils.CheckErr(err)
+		for i, name := range names {
+			val, err := dict.Get(name)
+			ExpectFound(found.Has(i), err)
+			if found.Has(i) {
+				testutils.ExpectEqual(values[i], val)
+			}
+		}
+	}
+
+	blobs, found, err := d.blobById.GetMany(ids)
+	testutils.CheckErr(err)
+	for i, id := range ids {
+		val, err := d.blobById.Get(id)
+		ExpectFound(found.Has(i), err)
+		if found.Has(i) {
+			testutils.ExpectEqual(string(blobs[i]), string(val))
+		}
+	}
+
+	blobs, found, err = d.blobByName.GetMany(names)
+	testutils.CheckErr(err)
+	for i, name := range names {
+		val, err := d.blobByName.Get(name)
+		ExpectFound(found.Has(i), err)
+		if found.Has(i) {
+			testutils.ExpectEqual(string(blobs[i]), string(val))
+		}
+	}
+
+	items, found, err := d.idSetById.GetMany(ids)
+	testutils.CheckErr(err)
+	for i, id := range ids {
+		_, err := d.idSetById.Get(id)
+		ExpectFound(found.Has(i), err)
+		if found.Has(i) {
+			testutils.ExpectEqual(items[i].Key(), id)
+			VerifyMembers(items[i].Values(), []int64{id, id + 1, id + 5000})
+		}
+	}
+
+	strItems, found, err := d.idSetByName.GetMany(names)
+	testutils.CheckErr(err)
+	for i, name := range names {
+		_, err := d.idSetByName.Get(name)
+		ExpectFound(found.Has(i), err)
+		if found.Has(i) {
+			testutils.ExpectEqual(strItems[i].Key(), name)
+			VerifyMembers(strItems[i].Values(), []int64{ids[i], ids[i] + 1})
+		}
+	}
+
+	scores, found, err := d.scoreById.GetMany(ids)
+	testutils.CheckErr(err)
+	for i, id := range ids {
+		val, err := d.scoreById.Get(id)
+		ExpectFound(found.Has(i), err)
+		if found.Has(i) {
+			testutils.ExpectEqual(scores[i], val)
+		}
+	}
+
+	fmt.Println("GetMany", len(ids), "keys", count, "found")
+}
+
+func VerifyMembers(cur *gokvdb.Cursor[int64, struct{}], expected []int64) {
+	defer cur.Close()
+
+	count := 0
+	for cur.Next() {
+		testutils.ExpectEqual(cur.Key(), expected[count])
+		count += 1
+	}
+	testutils.CheckErr(cur.Err())
+	testutils.ExpectEqual(count, len(expected))
+}
+
+// ExpectFound checks the bitmap against the error of a single Get.
+func ExpectFound(found bool, err error) {
+	if found {
+		testutils.CheckErr(err)
+	} else {
+		testutils.ExpectErr(err, gokvdb.ErrNotFound)
+	}
+}
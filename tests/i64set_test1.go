@@ -79,11 +79,12 @@ func TestSet(dbPath string, pageSize int) {
 				}
 				
 				fmt.Printf("%04d i64SetinsertCounter=%v add=%v\n", testCounter, insertCounter, v)
-				set.Add(v)
+				testutils.CheckErr(set.Add(v))
 			}
 
-			meta := set.Save()
-			pager.WritePayloadData(pid, meta)		
+			meta, err := set.Save()
+			testutils.CheckErr(err)
+			testutils.CheckErr(pager.WritePayloadData(pid, meta))
 
 			fmt.Println("Save", set.ToString())
 		})
@@ -110,7 +111,9 @@ func TestSet(dbPath string, pageSize int) {
 
 		count := 0
 
-		for v2 := range set.Values() {
+		cur := set.Values()
+		for cur.Next() {
+			v2 := cur.Key()
 			v := vals[count]
 			isValid := v == v2
 			fmt.Printf("%04d VALUES i=%07d v=%v v2=%v valid=%v\n", testCounter, count, v, v2, isValid)
@@ -120,6 +123,7 @@ func TestSet(dbPath string, pageSize int) {
 				os.Exit(1)
 			}
 		}
+		testutils.CheckErr(cur.Close())
 
 		fmt.Println("VALID SUCCESS!", count)
 
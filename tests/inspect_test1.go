@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"strings"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	dbPath := fmt.Sprintf("./testdata/inspect_%v.kv", time.Now().UTC().UnixNano())
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	info, err := s.Info()
+	testutils.CheckErr(err)
+	fmt.Println("NEW", info.ToString())
+	testutils.ExpectEqual(info.Epoch, uint64(0))
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, "mydb", "blobByName")
+	testutils.CheckErr(err)
+
+	for i:=0; i<2000; i++ {
+		testutils.CheckErr(nameById.Set(int64(i), fmt.Sprintf("name-%v", i)))
+		testutils.CheckErr(blobByName.Set(fmt.Sprintf("name-%v", i), make([]byte, i % 300)))
+	}
+	testutils.CheckErr(nameById.Save(false))
+	testutils.CheckErr(blobByName.Save(false))
+	testutils.CheckErr(s.Save())
+
+	// a dropped dict leaves free pages
+	tmp, err := gokvdb.NewI64StrDict(s, "mydb", "tmp")
+	testutils.CheckErr(err)
+	for i:=0; i<2000; i++ {
+		testutils.CheckErr(tmp.Set(int64(i), fmt.Sprintf("name-%v", i)))
+	}
+	testutils.CheckErr(tmp.Save(false))
+	testutils.CheckErr(s.Save())
+
+	db, err := s.DB("mydb")
+	testutils.CheckErr(err)
+	testutils.CheckErr(db.DropDict("tmp"))
+	testutils.CheckErr(s.Save())
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorageReadOnly(dbPath)
+	testutils.CheckErr(err)
+
+	info, err = s.Info()
+	testutils.CheckErr(err)
+	fmt.Println("INFO", info.ToString())
+	testutils.ExpectEqual(info.Epoch, uint64(3))
+	testutils.ExpectEqual(info.FileSize, int64(info.LastPageId + 1) * int64(info.PageSize))
+
+	roleByPageId, err := s.PageRoles()
+	testutils.CheckErr(err)
+
+	countByRole := make(map[string]int)
+	for pid:=uint32(1); pid<=info.LastPageId; pid++ {
+
+		page, err := s.ReadPageInfo(pid)
+		testutils.CheckErr(err)
+
+		role, ok := roleByPageId[pid]
+		if !ok {
+			// a page neither reachable nor free is lost
+			if !page.Free {
+				fmt.Println("LOST PAGE ERROR!", page.ToString())
+				os.Exit(1)
+			}
+			countByRole["free"] += 1
+			continue
+		}
+		countByRole[role.Role] += 1
+
+		if page.Free {
+			fmt.Println("FREE PAGE ERROR!", page.ToString(), role.ToString())
+			os.Exit(1)
+		}
+
+		switch role.Role {
+		case gokvdb.PAGE_ROLE_FREELIST, gokvdb.PAGE_ROLE_INTERNAL_FREELIST:
+			testutils.ExpectEqual(page.Type, gokvdb.PGTYPE_FREELIST)
+		case gokvdb.PAGE_ROLE_STORAGE_ROOT:
+			testutils.ExpectEqual(page.Type, gokvdb.PGTYPE_PAYLOAD)
+			testutils.ExpectEqual(pid, info.RootPageId)
+			testutils.ExpectEqual(role.Owner, "")
+		default:
+			testutils.ExpectEqual(page.Type, gokvdb.PGTYPE_PAYLOAD)
+			if role.Role != gokvdb.PAGE_ROLE_DB_META && !strings.HasPrefix(role.Owner, "mydb/") {
+				fmt.Println("OWNER ERROR!", pid, role.ToString())
+				os.Exit(1)
+			}
+		}
+
+		// a chain ends with its last page
+		if page.HasNext != (page.NextPageId != 0) {
+			fmt.Println("NEXT ERROR!", page.ToString())
+			os.Exit(1)
+		}
+	}
+	fmt.Println("ROLES", countByRole)
+	testutils.ExpectEqual(countByRole["free"], info.FreePageCount)
+	if countByRole["free"] == 0 || countByRole[gokvdb.PAGE_ROLE_INTERNAL_CONTEXT] == 0 {
+		fmt.Println("ROLES ERROR!", countByRole)
+		os.Exit(1)
+	}
+
+	_, err = s.ReadPageInfo(0)
+	testutils.ExpectErr(err, gokvdb.ErrInvalidPageId)
+	_, err = s.ReadPageInfo(info.LastPageId + 1)
+	testutils.ExpectErr(err, gokvdb.ErrNotFound)
+
+	testutils.CheckErr(s.Close())
+
+	fmt.Println("OK")
+}
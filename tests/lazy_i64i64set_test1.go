@@ -72,20 +72,21 @@ func testInsert(dbPath string, dbName string, dictName string) {
 		
 			testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-				i64i64set := gokvdb.NewLazyI64I64SetDict(s, dbName, dictName)				
+				i64i64set, err := gokvdb.NewLazyI64I64SetDict(s, dbName, dictName)				
+				testutils.CheckErr(err)
 
 				vals := testutils.RandI64Array(100)
 				
 				for _, v := range vals {
 					//for i:=0; i<16384; i++{
 					counter += 1
-					i64i64set.Add(key, v)
+					testutils.CheckErr(i64i64set.Add(key, v))
 					fmt.Printf("%04d Add count=%06d key=%v val=%v\n", testCounter, counter, key, v)
 
 					checkData[v] = 0
 				}
 
-				i64i64set.Save(true)
+				testutils.CheckErr(i64i64set.Save(true))
 
 			})
 		}
@@ -94,11 +95,12 @@ func testInsert(dbPath string, dbName string, dictName string) {
 
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
-		i64i64set := gokvdb.NewLazyI64I64SetDict(s, dbName, dictName)
+		i64i64set, err := gokvdb.NewLazyI64I64SetDict(s, dbName, dictName)
+		testutils.CheckErr(err)
 		for key, checkData := range checkMap {
-				item, ok := i64i64set.Get(key)
-				if !ok {
-					fmt.Println("No key", key)
+				item, err := i64i64set.Get(key)
+				if err != nil {
+					fmt.Println("No key", key, err)
 
 					os.Exit(1)
 				}
@@ -113,7 +115,9 @@ func testInsert(dbPath string, dbName string, dictName string) {
 				sort.Sort(vals)
 				i:= 0
 
-				for setVal := range item.Values() {
+				cur := item.Values()
+				for cur.Next() {
+					setVal := cur.Key()
 					val := vals[i]
 					isValid := val == setVal
 
@@ -125,6 +129,7 @@ func testInsert(dbPath string, dbName string, dictName string) {
 					i += 1
 
 				}
+				testutils.CheckErr(cur.Close())
 				
 		}
 	})
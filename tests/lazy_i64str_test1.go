@@ -46,7 +46,8 @@ func validI64StrDictWithChan(dbPath string, dbName string, dictName string, item
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-		dict := gokvdb.NewI64StrDict(s, dbName, dictName)
+		dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 		counter := 0
 
@@ -57,11 +58,11 @@ func validI64StrDictWithChan(dbPath string, dbName string, dictName string, item
 			key := item[0].(int64)
 			val := item[1].(string)
 
-			valResult, ok := dict.Get(key)
+			valResult, err := dict.Get(key)
 
 			isValid := val ==valResult
 
-			fmt.Printf("[%08d] GET key=%v ok=%v result=%v isValid=%v\n", counter, key, ok, valResult, isValid)
+			fmt.Printf("[%08d] GET key=%v err=%v result=%v isValid=%v\n", counter, key, err, valResult, isValid)
 			if !isValid {
 				fmt.Println("VALID ERROR!!")
 				os.Exit(1)
@@ -74,14 +75,17 @@ func validI64StrDictWithChan(dbPath string, dbName string, dictName string, item
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-		dict := gokvdb.NewI64StrDict(s, dbName, dictName)
+		dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 		counter := 0
 
-		for item := range dict.Items() {
+		cur := dict.Items()
+		for cur.Next() {
 			counter += 1
-			fmt.Println("Items", fmt.Sprintf("%08d", counter), item.Key(), item.Value())
+			fmt.Println("Items", fmt.Sprintf("%08d", counter), cur.Key(), cur.Value())
 		}
+		testutils.CheckErr(cur.Close())
 
 	})
 
@@ -95,16 +99,17 @@ func insertI64StrDictWithChan(dbPath string, dbName string, dictName string, ite
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-		dict := gokvdb.NewI64StrDict(s, dbName, dictName)
+		dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 		for item := range items {
 			insertCount += 1
 			key := item[0].(int64)
 			val := item[1].(string)
 			fmt.Printf("%08d SET key=%v val=%v\n", insertCount, key, val)
-			dict.Set(key, val)
+			testutils.CheckErr(dict.Set(key, val))
 		}
-		dict.Save(true)
+		testutils.CheckErr(dict.Save(true))
 	})
 
 
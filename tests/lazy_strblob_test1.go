@@ -39,7 +39,8 @@ func testStrBloc(dbPath string, dbName string, dictName string, testCount int, c
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-		dict := gokvdb.NewStrBlobDict(s, dbName, dictName)
+		dict, err := gokvdb.NewStrBlobDict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 		for i:=0; i<testCount; i++ {
 			key := fmt.Sprintf("key-%v", uuid.NewV4())
@@ -57,7 +58,8 @@ func testStrBloc(dbPath string, dbName string, dictName string, testCount int, c
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-			dict := gokvdb.NewStrBlobDict(s, dbName, dictName)
+			dict, err := gokvdb.NewStrBlobDict(s, dbName, dictName)
+			testutils.CheckErr(err)
 
 			for k, v := range testData {
 
@@ -80,15 +82,18 @@ func testStrBloc(dbPath string, dbName string, dictName string, testCount int, c
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-		dict := gokvdb.NewStrBlobDict(s, dbName, dictName)
+		dict, err := gokvdb.NewStrBlobDict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 		counter := 0
 
-		for item := range dict.Items() {
+		cur := dict.Items()
+		for cur.Next() {
 			counter += 1
-			val := item.Value()
-			fmt.Println("Items", fmt.Sprintf("%07d", counter), item.Key(), "bytes", len(val) )
+			val := cur.Value()
+			fmt.Println("Items", fmt.Sprintf("%07d", counter), cur.Key(), "bytes", len(val) )
 		}
+		testutils.CheckErr(cur.Close())
 	})
 
 }
@@ -58,7 +58,8 @@ func validWithChan(dbPath string, dbName string, dictName string, items chan []i
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-			dict := gokvdb.NewStrI64Dict(s, dbName, dictName)
+			dict, err := gokvdb.NewStrI64Dict(s, dbName, dictName)
+			testutils.CheckErr(err)
 
 			counter := 0
 
@@ -67,11 +68,11 @@ func validWithChan(dbPath string, dbName string, dictName string, items chan []i
 				k := item[0].(string)
 				v := item[1].(int64)
 
-				valResult, ok := dict.Get(k)
+				valResult, err := dict.Get(k)
 
 				isValid := v == valResult
 
-				fmt.Printf("[%08d] GET key=%v value=%v ok=%v result=%v isValid=%v\n", counter, k, v, ok, valResult, isValid)
+				fmt.Printf("[%08d] GET key=%v value=%v err=%v result=%v isValid=%v\n", counter, k, v, err, valResult, isValid)
 				if !isValid {
 					fmt.Println("VALID ERROR!!")
 					os.Exit(1)
@@ -86,7 +87,8 @@ func insertWithChan(dbPath string, dbName string, dictName string, items chan []
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-		dict := gokvdb.NewStrI64Dict(s, dbName, dictName)
+		dict, err := gokvdb.NewStrI64Dict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 		saveCount := 0
 
@@ -96,18 +98,18 @@ func insertWithChan(dbPath string, dbName string, dictName string, items chan []
 			key := item[0].(string)
 			val := item[1].(int64)
 
-			dict.Set(key, val)
+			testutils.CheckErr(dict.Set(key, val))
 			fmt.Println(fmt.Sprintf("%09d", insertCount), "SET", key, val)
 
 			saveCount += 1
 
 			if saveCount >= 16384 {
 				saveCount = 0
-				dict.Save(true)
+				testutils.CheckErr(dict.Save(true))
 			}
 		}
 
-		dict.Save(true)
+		testutils.CheckErr(dict.Save(true))
 
 	})
 
@@ -119,14 +121,17 @@ func testItems(dbPath string, dbName string, dictName string ) {
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-		dict := gokvdb.NewStrI64Dict(s, dbName, dictName)
+		dict, err := gokvdb.NewStrI64Dict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 		counter := 0
 
-		for item := range dict.Items() {
+		cur := dict.Items()
+		for cur.Next() {
 			counter += 1
-			fmt.Println("Items", fmt.Sprintf("%08d", counter), item.Key(), item.Value())
+			fmt.Println("Items", fmt.Sprintf("%08d", counter), cur.Key(), cur.Value())
 		}
+		testutils.CheckErr(cur.Close())
 	})
 
 }
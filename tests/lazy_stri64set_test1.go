@@ -37,7 +37,8 @@ func Test(dbPath string, dbName string, dictName string) {
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-			set := gokvdb.NewStrI64SetDict(s, dbName, dictName)
+			set, err := gokvdb.NewStrI64SetDict(s, dbName, dictName)
+			testutils.CheckErr(err)
 
 			for i:= 0; i<32; i++ {
 				key := fmt.Sprintf("key-%v", uuid.NewV4())
@@ -59,7 +60,8 @@ func Test(dbPath string, dbName string, dictName string) {
 	})
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
-		set := gokvdb.NewStrI64SetDict(s, dbName, dictName)
+		set, err := gokvdb.NewStrI64SetDict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 	
 		for _, key := range keys {
@@ -71,9 +73,11 @@ func Test(dbPath string, dbName string, dictName string) {
 			}
 
 			var vals []int64
-			for val := range item.Values() {
-				vals = append(vals, val)
+			cur := item.Values()
+			for cur.Next() {
+				vals = append(vals, cur.Key())
 			}
+			testutils.CheckErr(cur.Close())
 
 
 			fmt.Printf("%07d Get Item key=%v rows=%v\n",  testCounter, item.Key(), len(vals))
@@ -55,7 +55,8 @@ func testI64BlobDictWithDict(dbPath string, dbName string, dictName string, test
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-		dict := gokvdb.NewI64BlobDict(s, dbName, dictName)
+		dict, err := gokvdb.NewI64BlobDict(s, dbName, dictName)
+		testutils.CheckErr(err)
 
 		for key, val := range testData {
 			fmt.Println("SET", key, "bytes", len(val))
@@ -66,7 +67,8 @@ func testI64BlobDictWithDict(dbPath string, dbName string, dictName string, test
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-			dict := gokvdb.NewI64BlobDict(s, dbName, dictName)
+			dict, err := gokvdb.NewI64BlobDict(s, dbName, dictName)
+			testutils.CheckErr(err)
 
 			for k, v := range testData {
 
@@ -89,14 +91,17 @@ func testI64BlobDictWithDict(dbPath string, dbName string, dictName string, test
 
 	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
 
-			dict := gokvdb.NewI64BlobDict(s, dbName, dictName)
+			dict, err := gokvdb.NewI64BlobDict(s, dbName, dictName)
+			testutils.CheckErr(err)
 
 			counter := 0
 
-			for item := range dict.Items() {
+			cur := dict.Items()
+			for cur.Next() {
 				counter += 1
-				fmt.Println("Items", fmt.Sprintf("%07d", counter), item.Key(), "bytes", len(item.Value()))
+				fmt.Println("Items", fmt.Sprintf("%07d", counter), cur.Key(), "bytes", len(cur.Value()))
 			}
+			testutils.CheckErr(cur.Close())
 
 	})
 
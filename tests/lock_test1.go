@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	dbPath := fmt.Sprintf("./testdata/lock_%v.kv", time.Now().UTC().UnixNano())
+	dbName := "mydb"
+	dictName := "nameById"
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+		dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+		testutils.CheckErr(err)
+		for i:=0; i<1000; i++ {
+			testutils.CheckErr(dict.Set(int64(i), fmt.Sprintf("name-%v", i)))
+		}
+		testutils.CheckErr(dict.Save(true))
+	})
+
+	TestWriterLock(dbPath)
+	TestReaders(dbPath, dbName, dictName)
+	TestLockTimeout(dbPath)
+}
+
+// TestWriterLock expects a second writer or a reader to be refused while the
+// file is open for writing.
+func TestWriterLock(dbPath string) {
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	_, err = gokvdb.OpenStorage(dbPath)
+	testutils.ExpectErr(err, gokvdb.ErrLocked)
+
+	_, err = gokvdb.OpenStorageReadOnly(dbPath)
+	testutils.ExpectErr(err, gokvdb.ErrLocked)
+
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+	testutils.CheckErr(s.Close())
+}
+
+// TestReaders opens two readers at once, expects a writer to be refused and
+// every write path of a reader to fail.
+func TestReaders(dbPath string, dbName string, dictName string) {
+
+	r1, err := gokvdb.OpenStorageReadOnly(dbPath)
+	testutils.CheckErr(err)
+	r2, err := gokvdb.OpenStorageReadOnly(dbPath)
+	testutils.CheckErr(err)
+
+	_, err = gokvdb.OpenStorage(dbPath)
+	testutils.ExpectErr(err, gokvdb.ErrLocked)
+
+	for _, r := range []*gokvdb.Storage{r1, r2} {
+
+		dict, err := gokvdb.NewI64StrDict(r, dbName, dictName)
+		testutils.CheckErr(err)
+
+		for i:=0; i<1000; i++ {
+			val, err := dict.Get(int64(i))
+			testutils.CheckErr(err)
+			if val != fmt.Sprintf("name-%v", i) {
+				fmt.Println("VALID ERROR!", i, val)
+				os.Exit(1)
+			}
+		}
+
+		testutils.CheckErr(dict.Set(1000, "name-1000"))
+		testutils.ExpectErr(dict.Save(true), gokvdb.ErrReadOnly)
+		testutils.ExpectErr(r.Save(), gokvdb.ErrReadOnly)
+		testutils.ExpectErr(r.Checkpoint(), gokvdb.ErrReadOnly)
+	}
+
+	testutils.CheckErr(r1.Close())
+	testutils.CheckErr(r2.Close())
+
+	_, err = gokvdb.OpenStorageReadOnly(dbPath + "-missing")
+	if err == nil || !os.IsNotExist(err) {
+		fmt.Println("EXPECT ERROR! missing file", err)
+		os.Exit(1)
+	}
+}
+
+// TestLockTimeout closes the writer while a second open is waiting for it.
+func TestLockTimeout(dbPath string) {
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	startTime := time.Now()
+	_, err = gokvdb.OpenStorageWithOptions(dbPath, gokvdb.Options{LockTimeout: 100 * time.Millisecond})
+	testutils.ExpectErr(err, gokvdb.ErrLocked)
+	fmt.Println("waited", time.Since(startTime))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.Close()
+	}()
+
+	s2, err := gokvdb.OpenStorageWithOptions(dbPath, gokvdb.Options{LockTimeout: 5 * time.Second})
+	testutils.CheckErr(err)
+	fmt.Println("OPEN after", time.Since(startTime), s2.ToString())
+	testutils.CheckErr(s2.Close())
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"errors"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	snapshotPath := fmt.Sprintf("./testdata/memory_%v.kv", time.Now().UTC().UnixNano())
+
+	testData := make(map[int64]string)
+
+	startTime := time.Now()
+
+	s, err := gokvdb.OpenMemoryStorageWithOptions(gokvdb.Options{PageSize: 1024})
+	testutils.CheckErr(err)
+
+	fmt.Println("OPEN", s.ToString())
+
+	SetRandom(s, testData, 20000)
+	CheckDict(s, testData)
+
+	testutils.CheckErr(s.WriteSnapshot(snapshotPath))
+	testutils.CheckErr(s.Close())
+
+	fmt.Println("memory", time.Since(startTime))
+
+	// a snapshot is a database file
+	s, err = gokvdb.OpenStorage(snapshotPath)
+	testutils.CheckErr(err)
+	CheckDict(s, testData)
+	SetRandom(s, testData, 1000)
+
+	_, err = gokvdb.LoadMemoryStorage(snapshotPath)
+	fmt.Println("LoadMemoryStorage", err)
+	if !errors.Is(err, gokvdb.ErrLocked) {
+		fmt.Println("EXPECT ERROR! locked")
+		os.Exit(1)
+	}
+
+	err = s.WriteSnapshot(snapshotPath + "-copy")
+	fmt.Println("WriteSnapshot", err)
+	if !errors.Is(err, gokvdb.ErrNotImplemented) {
+		fmt.Println("EXPECT ERROR! file storage")
+		os.Exit(1)
+	}
+
+	testutils.CheckErr(s.Close())
+
+	// changes to a loaded image stay in memory
+	s, err = gokvdb.LoadMemoryStorage(snapshotPath)
+	testutils.CheckErr(err)
+	fmt.Println("LOAD", s.ToString(), s.Options().ToString())
+	CheckDict(s, testData)
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	testutils.CheckErr(dict.Set(-1, "memory only"))
+	testutils.CheckErr(dict.Save(true))
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(snapshotPath)
+	testutils.CheckErr(err)
+	dict, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	_, err = dict.Get(-1)
+	if !errors.Is(err, gokvdb.ErrNotFound) {
+		fmt.Println("EXPECT ERROR! not found", err)
+		os.Exit(1)
+	}
+	CheckDict(s, testData)
+	testutils.CheckErr(s.Close())
+}
+
+func SetRandom(s *gokvdb.Storage, testData map[int64]string, count int) {
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+
+	for i:=0; i<count; i++ {
+		key := rand.Int63n(1 << 40)
+		val := fmt.Sprintf("name-%v", key)
+		testData[key] = val
+		testutils.CheckErr(dict.Set(key, val))
+	}
+
+	testutils.CheckErr(dict.Save(true))
+}
+
+func CheckDict(s *gokvdb.Storage, testData map[int64]string) {
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+
+	for key, val := range testData {
+		val2, err := dict.Get(key)
+		testutils.CheckErr(err)
+		if val != val2 {
+			fmt.Println("VALID ERROR!", key, val, val2)
+			os.Exit(1)
+		}
+	}
+}
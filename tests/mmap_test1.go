@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/mmap_%v.kv", time.Now().UTC().UnixNano())
+	opts := gokvdb.Options{Mmap: true}
+
+	testData := make(map[string]int64)
+
+	s, err := gokvdb.OpenStorageWithOptions(dbPath, opts)
+	testutils.CheckErr(err)
+	fmt.Println("OPEN", s.ToString())
+
+	// the file outgrows the mapping between the rounds
+	for round:=0; round<3; round++ {
+		SetRandom(s, testData, 50000)
+		CheckParallel(s, testData, 8)
+	}
+
+	testutils.CheckErr(s.Close())
+
+	for _, opts := range []gokvdb.Options{gokvdb.Options{}, gokvdb.Options{Mmap: true}, gokvdb.Options{Mmap: true, ReadOnly: true}} {
+
+		s, err = gokvdb.OpenStorageWithOptions(dbPath, opts)
+		testutils.CheckErr(err)
+
+		startTime := time.Now()
+		CheckParallel(s, testData, 8)
+		fmt.Println("mmap", opts.Mmap, "readOnly", opts.ReadOnly, time.Since(startTime))
+
+		testutils.CheckErr(s.Close())
+	}
+}
+
+func SetRandom(s *gokvdb.Storage, testData map[string]int64, count int) {
+
+	dict, err := gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+
+	for i:=0; i<count; i++ {
+		val := rand.Int63()
+		key := fmt.Sprintf("name-%v", val)
+		testData[key] = val
+		testutils.CheckErr(dict.Set(key, val))
+	}
+
+	testutils.CheckErr(dict.Save(true))
+}
+
+// CheckParallel looks every key up from workers goroutines sharing one dict.
+func CheckParallel(s *gokvdb.Storage, testData map[string]int64, workers int) {
+
+	dict, err := gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+
+	var keys []string
+	for key, _ := range testData {
+		keys = append(keys, key)
+	}
+
+	var wg sync.WaitGroup
+
+	for w:=0; w<workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i:=w; i<len(keys); i+=workers {
+				val, err := dict.Get(keys[i])
+				testutils.CheckErr(err)
+				if val != testData[keys[i]] {
+					fmt.Println("VALID ERROR!", keys[i], val, testData[keys[i]])
+					os.Exit(1)
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+}
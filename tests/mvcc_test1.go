@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"strings"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+const (
+	KEY_COUNT = 2000
+	ROUNDS = 12
+	READERS = 6
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/mvcc_%v.kv", time.Now().UTC().UnixNano())
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	testutils.CheckErr(SetRound(s, 0))
+
+	var wg sync.WaitGroup
+	done := make(chan bool)
+	firstCommit := make(chan bool)
+
+	// a view held open across later commits keeps its round
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		err := s.View(func(snap *gokvdb.Snapshot) error {
+			close(firstCommit)
+			<-done
+			return CheckRound(snap, 0, KEY_COUNT)
+		})
+		testutils.CheckErr(err)
+	}()
+
+	for r:=0; r<READERS; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			lastRound := 0
+			views := 0
+			for {
+				select {
+				case <-done:
+					fmt.Println("READER", r, "views", views, "lastRound", lastRound)
+					return
+				default:
+				}
+				err := s.View(func(snap *gokvdb.Snapshot) error {
+					round, err := ReadRound(snap)
+					if err != nil {
+						return err
+					}
+					if round < lastRound {
+						return fmt.Errorf("round %v after %v", round, lastRound)
+					}
+					lastRound = round
+					return CheckRound(snap, round, 200)
+				})
+				testutils.CheckErr(err)
+				views += 1
+			}
+		}(r)
+	}
+
+	<-firstCommit
+
+	for round:=1; round<=ROUNDS; round++ {
+		testutils.CheckErr(SetRound(s, round))
+	}
+
+	// a failed update leaves nothing behind
+	errFailed := errors.New("failed")
+	err = s.Update(func(tx *gokvdb.Tx) error {
+		dict, err := tx.I64StrDict("mydb", "nameById")
+		testutils.CheckErr(err)
+		testutils.CheckErr(dict.Set(0, "failed"))
+		return errFailed
+	})
+	if err != errFailed {
+		fmt.Println("EXPECT ERROR!", err)
+		os.Exit(1)
+	}
+
+	close(done)
+	wg.Wait()
+
+	testutils.CheckErr(s.View(func(snap *gokvdb.Snapshot) error {
+		return CheckRound(snap, ROUNDS, KEY_COUNT)
+	}))
+
+	testutils.CheckErr(s.Close())
+}
+
+// SetRound writes round into every value and the round dict in one commit.
+func SetRound(s *gokvdb.Storage, round int) error {
+	return s.Update(func(tx *gokvdb.Tx) error {
+		nameById, err := tx.I64StrDict("mydb", "nameById")
+		if err != nil {
+			return err
+		}
+		roundByName, err := tx.StrI64Dict("mydb", "roundByName")
+		if err != nil {
+			return err
+		}
+
+		for key:=0; key<KEY_COUNT; key++ {
+			err = nameById.Set(int64(key), fmt.Sprintf("round-%v-%v", round, strings.Repeat("x", rand.Intn(64))))
+			if err != nil {
+				return err
+			}
+		}
+
+		return roundByName.Set("round", int64(round))
+	})
+}
+
+func ReadRound(snap *gokvdb.Snapshot) (int, error) {
+	roundByName, err := snap.StrI64Dict("mydb", "roundByName")
+	if err != nil {
+		return 0, err
+	}
+	round, err := roundByName.Get("round")
+	return int(round), err
+}
+
+// CheckRound expects count random keys to hold round.
+func CheckRound(snap *gokvdb.Snapshot, round int, count int) error {
+
+	nameById, err := snap.I64StrDict("mydb", "nameById")
+	if err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("round-%v-", round)
+
+	for i:=0; i<count; i++ {
+		key := int64(i)
+		if count < KEY_COUNT {
+			key = rand.Int63n(KEY_COUNT)
+		}
+		val, err := nameById.Get(key)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(val, prefix) {
+			return fmt.Errorf("VALID ERROR! key=%v val=%v round=%v", key, val, round)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"sort"
+	"errors"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	for _, pageSize := range []int{1024, 8192, 65536} {
+
+		opts := gokvdb.Options{
+			PageSize: pageSize,
+			InternalPageSize: 256,
+			SplitKeys: 1024,
+			SplitsPerSave: 2,
+			BranchFanOut: 64,
+			SyncMode: gokvdb.SYNC_NORMAL,
+		}
+
+		TestOptions(opts)
+	}
+
+	TestDefaults()
+	TestInvalid()
+}
+
+// TestOptions creates a file with opts and expects a reopen with the zero
+// options to use the saved ones.
+func TestOptions(opts gokvdb.Options) {
+
+	dbPath := fmt.Sprintf("./testdata/options_%v.kv", time.Now().UTC().UnixNano())
+
+	strById := make(map[int64]string)
+	idByStr := make(map[string]int64)
+
+	s, err := gokvdb.OpenStorageWithOptions(dbPath, opts)
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	idByName, err := gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+
+	for round:=0; round<3; round++ {
+		for i:=0; i<5000; i++ {
+			key := rand.Int63n(1 << 40)
+			val := fmt.Sprintf("name-%v", key)
+			strById[key] = val
+			idByStr[val] = key
+			testutils.CheckErr(nameById.Set(key, val))
+			testutils.CheckErr(idByName.Set(val, key))
+		}
+		testutils.CheckErr(nameById.Save(false))
+		testutils.CheckErr(idByName.Save(false))
+		testutils.CheckErr(s.Save())
+	}
+
+	testutils.CheckErr(s.Close())
+
+	s, err = gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	saved := s.Options()
+	fmt.Println("REOPEN", saved.ToString())
+	if saved.PageSize != opts.PageSize || saved.InternalPageSize != opts.InternalPageSize || saved.SplitKeys != opts.SplitKeys || saved.SplitsPerSave != opts.SplitsPerSave || saved.BranchFanOut != opts.BranchFanOut || saved.SyncMode != opts.SyncMode {
+		fmt.Println("OPTIONS ERROR!", opts.ToString(), saved.ToString())
+		os.Exit(1)
+	}
+
+	nameById, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	idByName, err = gokvdb.NewStrI64Dict(s, "mydb", "idByName")
+	testutils.CheckErr(err)
+
+	for key, val := range strById {
+		val2, err := nameById.Get(key)
+		testutils.CheckErr(err)
+		key2, err := idByName.Get(val)
+		testutils.CheckErr(err)
+		if val != val2 || key != key2 {
+			fmt.Println("VALID ERROR!", key, val, val2, key2)
+			os.Exit(1)
+		}
+	}
+
+	// the branch keys follow the fan-out, a range still comes in key order
+	var keys []int64
+	for key, _ := range strById {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	from := keys[len(keys) / 4]
+	to := keys[len(keys) * 3 / 4]
+	i := len(keys) / 4
+
+	cursor := nameById.Range(from, to)
+	for cursor.Next() {
+		if cursor.Key() != keys[i] {
+			fmt.Println("RANGE ERROR!", i, cursor.Key(), keys[i])
+			os.Exit(1)
+		}
+		i += 1
+	}
+	testutils.CheckErr(cursor.Err())
+
+	if i != len(keys) * 3 / 4 {
+		fmt.Println("RANGE ERROR! count", i)
+		os.Exit(1)
+	}
+
+	testutils.CheckErr(s.Close())
+}
+
+// TestDefaults expects a file created without options to report the
+// defaults and to keep them whatever a reopen passes.
+func TestDefaults() {
+
+	dbPath := fmt.Sprintf("./testdata/options_%v.kv", time.Now().UTC().UnixNano())
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+		dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+		testutils.CheckErr(err)
+		testutils.CheckErr(dict.Set(1, "name-1"))
+		testutils.CheckErr(dict.Save(true))
+	})
+
+	s, err := gokvdb.OpenStorageWithOptions(dbPath, gokvdb.Options{PageSize: 8192, BranchFanOut: 64})
+	testutils.CheckErr(err)
+
+	saved := s.Options()
+	fmt.Println("DEFAULTS", saved.ToString())
+	if saved.PageSize != gokvdb.PAGE_SIZE || saved.BranchFanOut != gokvdb.BRANCH_FAN_OUT || saved.SplitKeys != gokvdb.STR_SPLIT_KEYS || saved.SyncMode != gokvdb.SYNC_FULL {
+		fmt.Println("OPTIONS ERROR!", saved.ToString())
+		os.Exit(1)
+	}
+
+	dict, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	val, err := dict.Get(1)
+	testutils.CheckErr(err)
+	if val != "name-1" {
+		fmt.Println("VALID ERROR!", val)
+		os.Exit(1)
+	}
+
+	testutils.CheckErr(s.Close())
+}
+
+func TestInvalid() {
+
+	for _, opts := range []gokvdb.Options{
+		gokvdb.Options{PageSize: 512},
+		gokvdb.Options{PageSize: 3000},
+		gokvdb.Options{PageSize: 1 << 17},
+		gokvdb.Options{InternalPageSize: 8},
+		gokvdb.Options{BranchFanOut: 2},
+		gokvdb.Options{SyncMode: 9},
+	} {
+		dbPath := fmt.Sprintf("./testdata/options_%v.kv", time.Now().UTC().UnixNano())
+		_, err := gokvdb.OpenStorageWithOptions(dbPath, opts)
+		fmt.Println("INVALID", err)
+		if !errors.Is(err, gokvdb.ErrInvalidOptions) {
+			fmt.Println("EXPECT ERROR!", opts.ToString())
+			os.Exit(1)
+		}
+		_, err = os.Stat(dbPath)
+		if !os.IsNotExist(err) {
+			fmt.Println("EXPECT NO FILE!", dbPath)
+			os.Exit(1)
+		}
+	}
+}
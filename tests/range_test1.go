@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"math"
+	"time"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	dbPath := fmt.Sprintf("./testdata/range_%v.kv", time.Now().UTC().UnixNano())
+
+	s, err := gokvdb.OpenStorage(dbPath)
+	testutils.CheckErr(err)
+
+	nameById, err := gokvdb.NewI64StrDict(s, "mydb", "nameById")
+	testutils.CheckErr(err)
+	blobById, err := gokvdb.NewI64BlobDict(s, "mydb", "blobById")
+	testutils.CheckErr(err)
+	idSetById, err := gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+	testutils.CheckErr(err)
+
+	// the keys at both ends of int64 and around zero
+	keys := []int64{math.MinInt64, math.MinInt64 + 1, -1, 0, 1, math.MaxInt64 - 1, math.MaxInt64}
+	for _, key := range keys {
+		testutils.CheckErr(nameById.Set(key, fmt.Sprintf("name-%v", key)))
+		testutils.CheckErr(blobById.Set(key, []byte(fmt.Sprintf("blob-%v", key))))
+		testutils.CheckErr(idSetById.Add(key, key))
+	}
+
+	testutils.CheckErr(nameById.Save(false))
+	testutils.CheckErr(blobById.Save(false))
+	testutils.CheckErr(idSetById.Save(true))
+
+	for round:=0; round<2; round++ {
+
+		// Range leaves out to, RangeClosed takes it and so reaches the last key
+		ExpectKeys(nameById.Range(math.MinInt64, math.MaxInt64), keys[:6])
+		ExpectKeys(nameById.RangeClosed(math.MinInt64, math.MaxInt64), keys)
+		ExpectKeys(nameById.RangeClosed(math.MaxInt64, math.MaxInt64), keys[6:])
+		ExpectKeys(nameById.RangeClosed(-1, 1), keys[2:5])
+		ExpectKeys(nameById.Range(0, 0), nil)
+		ExpectKeys(nameById.RangeClosed(0, 0), keys[3:4])
+		ExpectKeys(nameById.RangeClosed(1, -1), nil)
+
+		ExpectKeys(blobById.Range(math.MinInt64, math.MaxInt64), keys[:6])
+		ExpectKeys(blobById.RangeClosed(math.MinInt64, math.MaxInt64), keys)
+		ExpectKeys(blobById.RangeClosed(math.MaxInt64 - 1, math.MaxInt64), keys[5:])
+		ExpectKeys(blobById.RangeClosed(1, -1), nil)
+
+		ExpectKeys(idSetById.Range(math.MinInt64, math.MaxInt64), keys[:6])
+		ExpectKeys(idSetById.RangeClosed(math.MinInt64, math.MaxInt64), keys)
+		ExpectKeys(idSetById.RangeClosed(math.MaxInt64, math.MaxInt64), keys[6:])
+		ExpectKeys(idSetById.RangeClosed(1, -1), nil)
+
+		cur := nameById.RangeClosed(math.MaxInt64, math.MaxInt64)
+		for cur.Next() {
+			testutils.ExpectEqual(cur.Value(), fmt.Sprintf("name-%v", int64(math.MaxInt64)))
+		}
+		testutils.CheckErr(cur.Err())
+
+		// the same from the file
+		testutils.CheckErr(s.Close())
+		s, err = gokvdb.OpenStorage(dbPath)
+		testutils.CheckErr(err)
+		nameById, err = gokvdb.NewI64StrDict(s, "mydb", "nameById")
+		testutils.CheckErr(err)
+		blobById, err = gokvdb.NewI64BlobDict(s, "mydb", "blobById")
+		testutils.CheckErr(err)
+		idSetById, err = gokvdb.NewLazyI64I64SetDict(s, "mydb", "idSetById")
+		testutils.CheckErr(err)
+	}
+
+	testutils.CheckErr(s.Close())
+
+	fmt.Println("OK")
+}
+
+// ExpectKeys expects cur to give exactly the keys expected, in order.
+func ExpectKeys[V any](cur *gokvdb.Cursor[int64, V], expected []int64) {
+	defer cur.Close()
+
+	var keys []int64
+	for cur.Next() {
+		keys = append(keys, cur.Key())
+	}
+	testutils.CheckErr(cur.Err())
+
+	if fmt.Sprint(keys) != fmt.Sprint(expected) {
+		fmt.Println("RANGE ERROR!", keys, expected)
+		os.Exit(1)
+	}
+}
@@ -26,13 +26,13 @@ func main() {
 		seek2 := int64(rand.Intn(536870912))
 
 		fmt.Println("Write", stream, "seek2", seek2, "bytes", len(data))
-		stream.Seek(seek2)
+		stream.SeekTo(seek2)
 		stream.Write(data)
 		stream.Close()
 
 		stream, _ = gokvdb.OpenFileStream(fp)
 
-		stream.Seek(seek2)
+		stream.SeekTo(seek2)
 		data2, err := stream.Read(len(data))
 
 		compareRtn := bytes.Compare(data, data2)
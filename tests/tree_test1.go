@@ -39,7 +39,8 @@ func TestTree(dbPath string, pageSize int, testCount int) {
 	testutils.OpenStreamPager(dbPath, pageSize, metaOffset, "w", func(pager gokvdb.IPager) {
 
 		pid = pager.CreatePageId()
-		tree := gokvdb.NewI64I64BTreePage(nil)
+		tree, err := gokvdb.NewI64I64BTreePage(nil)
+		testutils.CheckErr(err)
 
 		for i:=0; i<4096; i++ {
 			k := rand.Int63n(68719476736)
@@ -50,16 +51,18 @@ func TestTree(dbPath string, pageSize int, testCount int) {
 			testData[k] = v
 		}
 
-		pager.WritePayloadData(pid, tree.ToBytes())
+		testutils.CheckErr(pager.WritePayloadData(pid, tree.ToBytes()))
 
 	})
 
 
 	testutils.OpenStreamPager(dbPath, pageSize, metaOffset, "r", func(pager gokvdb.IPager) {
 
-		data, _ := pager.ReadPayloadData(pid)
+		data, err := pager.ReadPayloadData(pid)
+		testutils.CheckErr(err)
 
-		tree := gokvdb.NewI64I64BTreePage(data)
+		tree, err := gokvdb.NewI64I64BTreePage(data)
+		testutils.CheckErr(err)
 
 		for k, v := range testData {
 			v2, ok := tree.Get(k)
@@ -78,18 +81,22 @@ func TestTree(dbPath string, pageSize int, testCount int) {
 
 	testutils.OpenStreamPager(dbPath, pageSize, metaOffset, "r", func(pager gokvdb.IPager) {
 
-		data, _ := pager.ReadPayloadData(pid)
+		data, err := pager.ReadPayloadData(pid)
+		testutils.CheckErr(err)
 
-		tree := gokvdb.NewI64I64BTreePage(data)
+		tree, err := gokvdb.NewI64I64BTreePage(data)
+		testutils.CheckErr(err)
 
 		fmt.Println("Load", tree.Count())
 
 		count := 0
 
-		for range tree.Items() {
+		cur := tree.Items()
+		for cur.Next() {
 			count += 1
-			//fmt.Printf("Items %07d k=%v v=%v\n", count, item.Key(), item.Value())
+			//fmt.Printf("Items %07d k=%v v=%v\n", count, cur.Key(), cur.Value())
 		}
+		cur.Close()
 		fmt.Printf("Items %07d\n", count)
 
 
@@ -45,7 +45,7 @@ func TestTree(dbPath string, pageSize int, testCount int) {
 
 			data1 = testutils.RandBytes(rand.Intn(16384) + 512)
 
-			tree.Set(key, data1)
+			testutils.CheckErr(tree.Set(key, data1))
 			testData[key] = data1
 
 			fmt.Printf("SET key=%v bytes=%v \n", key , len(data1))
@@ -58,9 +58,9 @@ func TestTree(dbPath string, pageSize int, testCount int) {
 
 		for key, data1 := range testData {
 
-			data2 , ok := tree.Get(key)
-			if !ok {
-				fmt.Printf("VALID key=%v Failed!\n", key)
+			data2 , err := tree.Get(key)
+			if err != nil {
+				fmt.Printf("VALID key=%v Failed! %v\n", key, err)
 			}
 			compareRtn := bytes.Compare(data1, data2)
 			fmt.Printf("VALID key=%v bytes=%v compareRtn=%v\n", key , len(data2), compareRtn)
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"sync"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	dbPath := fmt.Sprintf("./testdata/tx_%v.kv", time.Now().UTC().UnixNano())
+	dbName := "mydb"
+
+	for i:=0; i<16; i++ {
+		TestTx(dbPath, dbName, i % 2 == 0)
+	}
+
+	TestConcurrentBegin(dbPath)
+	TestSaveOutsideTx(dbPath, dbName)
+}
+
+// TestSaveOutsideTx saves a dict not opened through an open transaction,
+// the save fails and a Rollback leaves the file clean.
+func TestSaveOutsideTx(dbPath string, dbName string) {
+
+	testData := make(map[int64]string)
+	for i:=0; i<1000; i++ {
+		key := rand.Int63n(68719476736)
+		testData[key] = fmt.Sprintf("plain-%v", key)
+	}
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		plain, err := gokvdb.NewI64StrDict(s, dbName, "plainById")
+		testutils.CheckErr(err)
+		testutils.CheckErr(plain.Save(true))
+
+		tx, err := s.Begin()
+		testutils.CheckErr(err)
+
+		nameById, err := tx.I64StrDict(dbName, "nameById")
+		testutils.CheckErr(err)
+		testutils.CheckErr(nameById.Set(1, "tx"))
+		// a dict of the transaction leaves the storage save to Commit
+		testutils.CheckErr(nameById.Save(true))
+
+		for key, val := range testData {
+			testutils.CheckErr(plain.Set(key, val))
+		}
+		if plain.Save(true) == nil || plain.Save(false) == nil || s.Save() == nil {
+			fmt.Println("SAVE ERROR! saved during a transaction")
+			os.Exit(1)
+		}
+
+		testutils.CheckErr(tx.Rollback())
+
+		testutils.CheckErr(plain.Save(true))
+		testutils.ExpectClean(s)
+	})
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		plain, err := gokvdb.NewI64StrDict(s, dbName, "plainById")
+		testutils.CheckErr(err)
+		testutils.VerifyI64Str(plain, testData)
+		testutils.ExpectClean(s)
+
+		fmt.Println("SAVE OUTSIDE TX OK")
+	})
+}
+
+func TestConcurrentBegin(dbPath string) {
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		var wg sync.WaitGroup
+		txs := make(chan *gokvdb.Tx, 8)
+		for i:=0; i<8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tx, err := s.Begin()
+				if err == nil {
+					txs <- tx
+				}
+			}()
+		}
+		wg.Wait()
+		close(txs)
+
+		count := 0
+		for tx := range txs {
+			testutils.CheckErr(tx.Rollback())
+			count += 1
+		}
+		if count != 1 {
+			fmt.Println("BEGIN ERROR! transactions", count)
+			os.Exit(1)
+		}
+
+		began := make(chan *gokvdb.Tx, 1)
+		err := s.Update(func(tx *gokvdb.Tx) error {
+			go func() {
+				tx, err := s.Begin()
+				testutils.CheckErr(err)
+				began <- tx
+			}()
+			time.Sleep(50 * time.Millisecond)
+			if len(began) > 0 {
+				fmt.Println("BEGIN ERROR! began during Update")
+				os.Exit(1)
+			}
+			return nil
+		})
+		testutils.CheckErr(err)
+		testutils.CheckErr((<-began).Rollback())
+
+		fmt.Println("CONCURRENT BEGIN OK")
+	})
+}
+
+func TestTx(dbPath string, dbName string, commit bool) {
+
+	testData := make(map[int64]string)
+
+	for i:=0; i<1000; i++ {
+		key := rand.Int63n(68719476736)
+		testData[key] = fmt.Sprintf("val-%v", key)
+	}
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		tx, err := s.Begin()
+		testutils.CheckErr(err)
+
+		nameById, err := tx.I64StrDict(dbName, "nameById")
+		testutils.CheckErr(err)
+		idByName, err := tx.StrI64Dict(dbName, "idByName")
+		testutils.CheckErr(err)
+
+		for key, val := range testData {
+			testutils.CheckErr(nameById.Set(key, val))
+			testutils.CheckErr(idByName.Set(val, key))
+		}
+
+		if commit {
+			testutils.CheckErr(tx.Commit())
+		} else {
+			testutils.CheckErr(tx.Rollback())
+		}
+
+		fmt.Println("TX DONE", tx.ToString(), "commit", commit)
+	})
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+
+		nameById, err := gokvdb.NewI64StrDict(s, dbName, "nameById")
+		testutils.CheckErr(err)
+		idByName, err := gokvdb.NewStrI64Dict(s, dbName, "idByName")
+		testutils.CheckErr(err)
+
+		for key, val := range testData {
+			_, err1 := nameById.Get(key)
+			_, err2 := idByName.Get(val)
+			ok1 := err1 == nil
+			ok2 := err2 == nil
+
+			isValid := ok1 == commit && ok2 == commit
+			fmt.Printf("VALID key=%v ok=%v %v commit=%v isValid=%v\n", key, ok1, ok2, commit, isValid)
+			if !isValid {
+				fmt.Println("VALID ERROR!")
+				os.Exit(1)
+			}
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"fmt"
+	"time"
+	"io/ioutil"
+	"math/rand"
+	"../../gokvdb"
+	"../testutils"
+)
+
+func main() {
+
+	rand.Seed(time.Now().UTC().UnixNano())
+
+	for i:=0; i<8; i++ {
+		dbPath := fmt.Sprintf("./testdata/wal_%v.kv", time.Now().UTC().UnixNano())
+		TestReplay(dbPath, "mydb", "nameById")
+	}
+
+	dbPath := fmt.Sprintf("./testdata/wal_%v.kv", time.Now().UTC().UnixNano())
+	TestCorruptLength(dbPath, "mydb", "nameById")
+}
+
+// TestCorruptLength appends a frame header with a huge length after a
+// commit, recovery drops it as a torn tail and keeps the commit.
+func TestCorruptLength(dbPath string, dbName string, dictName string) {
+
+	testData := make(map[int64]string)
+
+	for i:=0; i<2000; i++ {
+		key := rand.Int63n(68719476736)
+		testData[key] = fmt.Sprintf("val-%v", key)
+	}
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+		dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+		testutils.CheckErr(err)
+		for key, val := range testData {
+			testutils.CheckErr(dict.Set(key, val))
+		}
+		testutils.CheckErr(dict.Save(true))
+	})
+
+	image, err := ioutil.ReadFile(dbPath)
+	testutils.CheckErr(err)
+
+	pageSize := 4096
+
+	stream, err := gokvdb.OpenFileStream(dbPath)
+	testutils.CheckErr(err)
+	wal, err := gokvdb.OpenWriteAheadLog(dbPath + "-wal", stream, uint32(pageSize))
+	testutils.CheckErr(err)
+	pageData := map[uint32][]byte{1: image[pageSize:2*pageSize]}
+	testutils.CheckErr(wal.Append([]uint32{1}, pageData, image[:512]))
+	size := wal.Size()
+	wal.Close()
+	stream.Close()
+
+	// type, page id, length and checksum of a frame header
+	frameHeader := []byte{1, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0}
+	f, err := os.OpenFile(dbPath + "-wal", os.O_WRONLY | os.O_APPEND, 0666)
+	testutils.CheckErr(err)
+	_, err = f.Write(frameHeader)
+	testutils.CheckErr(err)
+	testutils.CheckErr(f.Close())
+
+	wal, err = gokvdb.OpenWriteAheadLogReadOnly(dbPath + "-wal", uint32(pageSize))
+	testutils.CheckErr(err)
+	fmt.Println("RECOVERED", wal.ToString())
+	testutils.ExpectEqual(wal.Size(), size)
+	wal.Close()
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+		dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+		testutils.CheckErr(err)
+		testutils.VerifyI64Str(dict, testData)
+		testutils.ExpectClean(s)
+	})
+
+	fmt.Println("CORRUPT LENGTH OK")
+}
+
+// TestReplay logs a full image of the storage by hand, wipes the main file
+// and expects OpenStorage to rebuild it from the write-ahead log.
+func TestReplay(dbPath string, dbName string, dictName string) {
+
+	testData := make(map[int64]string)
+
+	for i:=0; i<2000; i++ {
+		key := rand.Int63n(68719476736)
+		testData[key] = fmt.Sprintf("val-%v", key)
+	}
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+		dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+		testutils.CheckErr(err)
+		for key, val := range testData {
+			testutils.CheckErr(dict.Set(key, val))
+		}
+		testutils.CheckErr(dict.Save(true))
+	})
+
+	image, err := ioutil.ReadFile(dbPath)
+	testutils.CheckErr(err)
+
+	pageSize := 4096
+	dataByPageId := make(map[uint32][]byte)
+	var pageIds []uint32
+
+	for pid:=1; pid*pageSize < len(image); pid++ {
+		end := (pid+1) * pageSize
+		if end > len(image) {
+			end = len(image)
+		}
+		dataByPageId[uint32(pid)] = image[pid*pageSize:end]
+		pageIds = append(pageIds, uint32(pid))
+	}
+
+	stream, err := gokvdb.OpenFileStream(dbPath)
+	testutils.CheckErr(err)
+
+	wal, err := gokvdb.OpenWriteAheadLog(dbPath + "-wal", stream, uint32(pageSize))
+	testutils.CheckErr(err)
+	testutils.CheckErr(wal.Append(pageIds, dataByPageId, image[:512]))
+	fmt.Println("APPEND", wal.ToString())
+
+	// a checkpoint into a stream that fails to write keeps the log
+	closed, err := gokvdb.OpenFileStream(dbPath)
+	testutils.CheckErr(err)
+	closed.Close()
+	size := wal.Size()
+	err = wal.Checkpoint(closed)
+	if err == nil || wal.Size() != size {
+		fmt.Println("CHECKPOINT ERROR!", err, wal.Size(), size)
+		os.Exit(1)
+	}
+	fmt.Println("FAILED CHECKPOINT", err)
+
+	stream.Close()
+
+	testutils.CheckErr(ioutil.WriteFile(dbPath, make([]byte, len(image)), 0666))
+
+	testutils.OpenStorage(dbPath, func(s *gokvdb.Storage) {
+		dict, err := gokvdb.NewI64StrDict(s, dbName, dictName)
+		testutils.CheckErr(err)
+		for key, val := range testData {
+			val2, err := dict.Get(key)
+			isValid := err == nil && val == val2
+			fmt.Printf("VALID key=%v err=%v isValid=%v\n", key, err, isValid)
+			if !isValid {
+				fmt.Println("VALID ERROR!")
+				os.Exit(1)
+			}
+		}
+	})
+}
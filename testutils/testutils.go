@@ -3,8 +3,10 @@ package testutils
 import (
 	"os"
 	"fmt"
+	"errors"
 	//"time"
 	//"bytes"
+	"sort"
 	"bufio"
 	"strconv"
 	"strings"
@@ -21,6 +23,21 @@ func CheckErr(err error) {
 	}
 }
 
+// ExpectErr exits unless err wraps target.
+func ExpectErr(err error, target error) {
+	if !errors.Is(err, target) {
+		fmt.Println("EXPECT ERROR!", target, err)
+		os.Exit(1)
+	}
+}
+
+func ExpectEqual(val interface{}, expected interface{}) {
+	if val != expected {
+		fmt.Println("VALID ERROR!", val, expected)
+		os.Exit(1)
+	}
+}
+
 
 
 func CreateTempFilePath() string {
@@ -166,18 +183,13 @@ func TakeStrI64Items(logPath string) chan []interface{} {
 func OpenStorage(path string, callback func(s *gokvdb.Storage)) {
 
 	s, err := gokvdb.OpenStorage(path)
-
-	if err != nil {
-		s.Close()
-	}
-
 	CheckErr(err)
 
 	fmt.Println("OpenStorage", s.ToString())
 
 	callback(s)
 
-	s.Close()
+	CheckErr(s.Close())
 
 }
 
@@ -197,14 +209,16 @@ func OpenInternalPager(path string, pageSize int, metaOffset int, mode string, c
 
 	if err == nil {
 
-		stream.Seek(int64(metaOffset))
+		stream.SeekTo(int64(metaOffset))
 		metaData, _ := stream.Read(128)
-		stream.Seek(int64(metaOffset + 128))
+		stream.SeekTo(int64(metaOffset + 128))
 		metaData2, _ := stream.Read(128)
 
 		meta := gokvdb.ReadOrNewStreamPagerMeta(uint32(pageSize), metaData)
-		pager := gokvdb.NewStreamPager(stream, meta)
-		internalPager := gokvdb.NewInternalPager(pager, 128, metaData2)
+		pager, err := gokvdb.NewStreamPager(stream, meta)
+		CheckErr(err)
+		internalPager, err := gokvdb.NewInternalPager(pager, 128, metaData2, "")
+		CheckErr(err)
 
 		fmt.Println("ReadOrNewStreamPagerMeta", meta.ToString())
 
@@ -213,13 +227,15 @@ func OpenInternalPager(path string, pageSize int, metaOffset int, mode string, c
 
 		if mode == "w" {
 
-			metaData2 = internalPager.Save()
+			metaData2, err = internalPager.Save()
+			CheckErr(err)
 			
-			metaData = pager.Save()
+			metaData, err = pager.Save()
+			CheckErr(err)
 			
-			stream.Seek(int64(metaOffset))
+			stream.SeekTo(int64(metaOffset))
 			stream.Write(metaData)
-			stream.Seek(int64(metaOffset + 128))
+			stream.SeekTo(int64(metaOffset + 128))
 			stream.Write(metaData2)
 		}
 	}
@@ -236,11 +252,12 @@ func OpenStreamPager(path string, pageSize int, metaOffset int, mode string, cal
 
 	if err == nil {
 
-		stream.Seek(int64(metaOffset))
+		stream.SeekTo(int64(metaOffset))
 		metaData, _ := stream.Read(128)
 
 		meta := gokvdb.ReadOrNewStreamPagerMeta(uint32(pageSize), metaData)
-		pager := gokvdb.NewStreamPager(stream, meta)
+		pager, err := gokvdb.NewStreamPager(stream, meta)
+		CheckErr(err)
 
 		fmt.Println("ReadOrNewStreamPagerMeta", meta.ToString())
 
@@ -249,8 +266,9 @@ func OpenStreamPager(path string, pageSize int, metaOffset int, mode string, cal
 
 		if mode == "w" {
 		
-			metaData = pager.Save()
-			stream.Seek(int64(metaOffset))
+			metaData, err = pager.Save()
+			CheckErr(err)
+			stream.SeekTo(int64(metaOffset))
 			stream.Write(metaData)		
 		}
 	}
@@ -267,19 +285,22 @@ func OpenBTeeBlobMap(path string, pageSize int, mode string, callback func(*gokv
 
 	if err == nil {
 
-		stream.Seek(int64(metaOffset))
+		stream.SeekTo(int64(metaOffset))
 		metaData, _ := stream.Read(128)
 
-		stream.Seek(int64(metaOffset + 128))
+		stream.SeekTo(int64(metaOffset + 128))
 		metaData2, _ := stream.Read(128)
 
-		stream.Seek(int64(metaOffset + 256))
+		stream.SeekTo(int64(metaOffset + 256))
 		metaData3, _ := stream.Read(128)
 
 		meta := gokvdb.ReadOrNewStreamPagerMeta(uint32(pageSize), metaData)
-		pager := gokvdb.NewStreamPager(stream, meta)
-		internalPager := gokvdb.NewInternalPager(pager, 128, metaData2)
-		btreeMap := gokvdb.NewBTreeBlobMap(internalPager, metaData3)
+		pager, err := gokvdb.NewStreamPager(stream, meta)
+		CheckErr(err)
+		internalPager, err := gokvdb.NewInternalPager(pager, 128, metaData2, "")
+		CheckErr(err)
+		btreeMap, err := gokvdb.NewBTreeBlobMap(internalPager, metaData3)
+		CheckErr(err)
 
 		callback(btreeMap)
 
@@ -287,17 +308,20 @@ func OpenBTeeBlobMap(path string, pageSize int, mode string, callback func(*gokv
 
 		if mode == "w" {
 		
-			metaData3 = btreeMap.Save()
+			metaData3, err = btreeMap.Save()
+			CheckErr(err)
 
-			metaData2 = internalPager.Save()
+			metaData2, err = internalPager.Save()
+			CheckErr(err)
 			
-			metaData = pager.Save()
+			metaData, err = pager.Save()
+			CheckErr(err)
 			
-			stream.Seek(int64(metaOffset))
+			stream.SeekTo(int64(metaOffset))
 			stream.Write(metaData)
-			stream.Seek(int64(metaOffset + 128))
+			stream.SeekTo(int64(metaOffset + 128))
 			stream.Write(metaData2)
-			stream.Seek(int64(metaOffset + 256))
+			stream.SeekTo(int64(metaOffset + 256))
 			stream.Write(metaData3)
 		}
 
@@ -331,3 +355,267 @@ func RandI64Array(count int) []int64 {
 
 	return vals
 }
+
+// AddMember adds member to the set of key in setByKey.
+func AddMember[K comparable](setByKey map[K]map[int64]bool, key K, member int64) {
+	set, ok := setByKey[key]
+	if !ok {
+		set = make(map[int64]bool)
+		setByKey[key] = set
+	}
+	set[member] = true
+}
+
+// Keys returns the keys of m in order.
+func Keys(m map[int64]string) []int64 {
+	var keys []int64
+	for key, _ := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// VerifyI64Str expects dict to hold exactly expected, through Get and the
+// cursor.
+func VerifyI64Str(dict *gokvdb.LazyI64StrDict, expected map[int64]string) {
+
+	for key, value := range expected {
+		val, err := dict.Get(key)
+		CheckErr(err)
+		ExpectEqual(val, value)
+	}
+
+	// the items come in key order
+	count := 0
+	last := int64(0)
+	cur := dict.Items()
+	for cur.Next() {
+		if count > 0 && cur.Key() <= last {
+			fmt.Println("ORDER ERROR!", last, cur.Key())
+			os.Exit(1)
+		}
+		ExpectEqual(cur.Value(), expected[cur.Key()])
+		last = cur.Key()
+		count += 1
+	}
+	CheckErr(cur.Err())
+	ExpectEqual(count, len(expected))
+}
+
+func VerifyStrI64(dict *gokvdb.LazyStrI64Dict, expected map[string]int64) {
+
+	for key, value := range expected {
+		val, err := dict.Get(key)
+		CheckErr(err)
+		ExpectEqual(val, value)
+	}
+	_, err := dict.Get("missing")
+	ExpectErr(err, gokvdb.ErrNotFound)
+
+	count := 0
+	last := ""
+	cur := dict.Items()
+	for cur.Next() {
+		if dict.IsOrdered() && count > 0 && cur.Key() <= last {
+			fmt.Println("ORDER ERROR!", last, cur.Key())
+			os.Exit(1)
+		}
+		ExpectEqual(cur.Value(), expected[cur.Key()])
+		last = cur.Key()
+		count += 1
+	}
+	CheckErr(cur.Err())
+	ExpectEqual(count, len(expected))
+}
+
+func VerifyI64Blob(dict *gokvdb.LazyI64BlobDict, expected map[int64][]byte) {
+
+	for key, value := range expected {
+		val, err := dict.Get(key)
+		CheckErr(err)
+		ExpectEqual(string(val), string(value))
+	}
+
+	count := 0
+	cur := dict.Items()
+	for cur.Next() {
+		ExpectEqual(string(cur.Value()), string(expected[cur.Key()]))
+		count += 1
+	}
+	CheckErr(cur.Err())
+	ExpectEqual(count, len(expected))
+}
+
+func VerifyStrBlob(dict *gokvdb.LazyStrBlobDict, expected map[string][]byte) {
+
+	for key, value := range expected {
+		val, err := dict.Get(key)
+		CheckErr(err)
+		ExpectEqual(string(val), string(value))
+	}
+
+	count := 0
+	cur := dict.Items()
+	for cur.Next() {
+		ExpectEqual(string(cur.Value()), string(expected[cur.Key()]))
+		count += 1
+	}
+	CheckErr(cur.Err())
+	ExpectEqual(count, len(expected))
+}
+
+func VerifyI64Set(dict *gokvdb.LazyI64I64SetDict, expected map[int64]map[int64]bool) {
+
+	count := 0
+	cur := dict.Items()
+	for cur.Next() {
+		VerifyMembers(cur.Value().Values(), expected[cur.Key()])
+		count += 1
+	}
+	CheckErr(cur.Err())
+	ExpectEqual(count, len(expected))
+
+	for key, members := range expected {
+		item, err := dict.Get(key)
+		CheckErr(err)
+		VerifyMembers(item.Values(), members)
+	}
+}
+
+func VerifyStrSet(dict *gokvdb.LazyStrI64SetDict, expected map[string]map[int64]bool) {
+
+	count := 0
+	cur := dict.Items()
+	for cur.Next() {
+		VerifyMembers(cur.Value().Values(), expected[cur.Key()])
+		count += 1
+	}
+	CheckErr(cur.Err())
+	ExpectEqual(count, len(expected))
+}
+
+func VerifyMembers(cur *gokvdb.Cursor[int64, struct{}], expected map[int64]bool) {
+	defer cur.Close()
+
+	count := 0
+	for cur.Next() {
+		if !expected[cur.Key()] {
+			fmt.Println("MEMBER ERROR!", cur.Key())
+			os.Exit(1)
+		}
+		count += 1
+	}
+	CheckErr(cur.Err())
+	ExpectEqual(count, len(expected))
+}
+
+// ExpectClean exits unless Check finds no problem in s.
+func ExpectClean(s *gokvdb.Storage) {
+	report, err := s.Check(false)
+	CheckErr(err)
+	if !report.OK() {
+		fmt.Println("CHECK ERROR!", report.ToString())
+		for _, problem := range report.Problems {
+			fmt.Println("\t", problem.ToString())
+		}
+		os.Exit(1)
+	}
+}
+
+// SetNames sets the keys start to end of dict to "name-<key>".
+func SetNames(dict *gokvdb.LazyI64StrDict, start int, end int) {
+	for i:=start; i<end; i++ {
+		CheckErr(dict.Set(int64(i), fmt.Sprintf("name-%v", i)))
+	}
+}
+
+// VerifyNames expects the keys start to end of dict set by SetNames.
+func VerifyNames(dict *gokvdb.LazyI64StrDict, start int, end int) {
+	for i:=start; i<end; i++ {
+		val, err := dict.Get(int64(i))
+		CheckErr(err)
+		ExpectEqual(val, fmt.Sprintf("name-%v", i))
+	}
+}
+
+// ExpectDBs exits unless s lists exactly the DBs names, in order.
+func ExpectDBs(s *gokvdb.Storage, names ...string) {
+	dbNames := s.ListDBs()
+	if fmt.Sprint(dbNames) != fmt.Sprint(names) {
+		fmt.Println("LIST DBS ERROR!", dbNames, names)
+		os.Exit(1)
+	}
+}
+
+func FileSize(path string) int64 {
+	info, err := os.Stat(path)
+	CheckErr(err)
+	return info.Size()
+}
+
+// CountValues counts the members of cur and closes it.
+func CountValues(cur *gokvdb.Cursor[int64, struct{}]) int {
+	defer cur.Close()
+	count := 0
+	for cur.Next() {
+		count += 1
+	}
+	CheckErr(cur.Err())
+	return count
+}
+
+// Blob returns the test value of seed, shorter than maxSize.
+func Blob(seed int64, maxSize int) []byte {
+	blob := make([]byte, int(uint64(seed) % uint64(maxSize)))
+	for j := range blob {
+		blob[j] = byte(seed) + byte(j)
+	}
+	return blob
+}
+
+// FillDicts writes count keys into a dict of every kind and a BTree index
+// of dbName, idByName is ordered when isOrdered is set.
+func FillDicts(s *gokvdb.Storage, dbName string, count int, isOrdered bool) {
+
+	nameById, err := gokvdb.NewI64StrDict(s, dbName, "nameById")
+	CheckErr(err)
+	var idByName *gokvdb.LazyStrI64Dict
+	if isOrdered {
+		idByName, err = gokvdb.NewOrderedStrI64Dict(s, dbName, "idByName")
+	} else {
+		idByName, err = gokvdb.NewStrI64Dict(s, dbName, "idByName")
+	}
+	CheckErr(err)
+	blobById, err := gokvdb.NewI64BlobDict(s, dbName, "blobById")
+	CheckErr(err)
+	blobByName, err := gokvdb.NewStrBlobDict(s, dbName, "blobByName")
+	CheckErr(err)
+	idSetById, err := gokvdb.NewLazyI64I64SetDict(s, dbName, "idSetById")
+	CheckErr(err)
+	idSetByName, err := gokvdb.NewStrI64SetDict(s, dbName, "idSetByName")
+	CheckErr(err)
+
+	db, err := s.DB(dbName)
+	CheckErr(err)
+	bt, err := db.OpenBTree("btree")
+	CheckErr(err)
+
+	for i:=0; i<count; i++ {
+		key := int64(i)
+		name := fmt.Sprintf("name-%v", i)
+
+		CheckErr(nameById.Set(key, name))
+		CheckErr(idByName.Set(name, key))
+		CheckErr(blobById.Set(key, []byte(fmt.Sprintf("blob-%v", i))))
+		CheckErr(blobByName.Set(name, []byte(fmt.Sprintf("blob-%v", i))))
+		CheckErr(idSetById.Add(key % 100, key))
+		CheckErr(idSetByName.Add(fmt.Sprintf("set-%v", i % 100), key))
+		CheckErr(bt.Set(key, []byte(fmt.Sprintf("btree-%v", i))))
+	}
+
+	for _, dict := range []gokvdb.IDict{nameById, idByName, blobById, blobByName, idSetById, idSetByName} {
+		CheckErr(dict.Save(false))
+	}
+	CheckErr(s.Save())
+}
@@ -1,8 +1,9 @@
 package gokvdb
 
 import (
-	"os"
 	"fmt"
+	"math"
+	"sort"
 	"sync"
 )
 
@@ -91,32 +92,29 @@ func (self *I64I64BTreePage) Count() int {
 	return len(self.nodeById)
 }
 
-type I64I64BTreeItem struct {
-	key int64
-	value int64
-}
-
-func (self *I64I64BTreeItem) Key() int64 {
-	return self.key
+func (self *I64I64BTreePage) Items() *Cursor[int64, int64] {
+	return _NewCursor(func(yield func(int64, int64) bool) error {
+		self._Each(yield)
+		return nil
+	})
 }
 
-func (self *I64I64BTreeItem) Value() int64 {
-	return self.value
+// _Each calls yield for every item in key order and reports whether it ran
+// to the end.
+func (self *I64I64BTreePage) _Each(yield func(key int64, value int64) bool) bool {
+	return EachII64I64BTreeNodes(self, func(node II64I64BTreeNode) bool {
+		_node := node.(*I64I64BTreePageNode)
+		return yield(_node.key, _node.value)
+	})
 }
 
-func (self *I64I64BTreePage) Items() chan I64I64BTreeItem {
-	q := make(chan I64I64BTreeItem)
-	go func(ch chan I64I64BTreeItem) {
-
-		for node := range TakeII64I64BTreeNodes(self) {
-			_node := node.(*I64I64BTreePageNode)
-			item := I64I64BTreeItem{key:_node.key, value: _node.value}
-			ch <- item
-		}
-
-		close(ch)
-	} (q)
-	return q
+// _EachRange is _Each over the keys in [from, to], walking down from to
+// when reverse is set.
+func (self *I64I64BTreePage) _EachRange(from int64, to int64, reverse bool, yield func(key int64, value int64) bool) bool {
+	return EachII64I64BTreeNodesInRange(self, from, to, reverse, func(node II64I64BTreeNode) bool {
+		_node := node.(*I64I64BTreePageNode)
+		return yield(_node.key, _node.value)
+	})
 }
 
 func (self *I64I64BTreePage) Get(key int64) (int64, bool) {
@@ -135,7 +133,7 @@ func (self *I64I64BTreePage) Get(key int64) (int64, bool) {
 		nodeKey := node.GetKey()
 
 		if loopCount > 24 {
-			fmt.Println("too many loop", loopCount, self.ToString())
+			//fmt.Println("too many loop", loopCount, self.ToString())
 		}
 
 		if nodeKey == key {
@@ -211,6 +209,77 @@ func (self *I64I64BTreePage) Insert(key int64, value int64) {
 
 }
 
+func (self *I64I64BTreePage) Delete(key int64) bool {
+
+	var nodeStack []II64I64BTreeNode
+	var dirStack []byte
+
+	node := self.GetRootNode()
+
+	for {
+		if node == nil {
+			return false
+		}
+
+		nodeKey := node.GetKey()
+		if nodeKey == key {
+			break
+		}
+
+		nodeStack = append(nodeStack, node)
+
+		if key < nodeKey {
+			node = node.GetLeftNode()
+			dirStack = append(dirStack, BTREE_NODE_DIR_LEFT)
+		} else {
+			node = node.GetRightNode()
+			dirStack = append(dirStack, BTREE_NODE_DIR_RIGHT)
+		}
+	}
+
+	target := node.(*I64I64BTreePageNode)
+
+	if target.leftNodeId > 0 && target.rightNodeId > 0 {
+		// replace with the in-order successor and unlink the successor instead
+		nodeStack = append(nodeStack, target)
+		dirStack = append(dirStack, BTREE_NODE_DIR_RIGHT)
+
+		succ := target.GetRightNode()
+		for succ.GetLeftNode() != nil {
+			nodeStack = append(nodeStack, succ)
+			dirStack = append(dirStack, BTREE_NODE_DIR_LEFT)
+			succ = succ.GetLeftNode()
+		}
+
+		_succ := succ.(*I64I64BTreePageNode)
+		target.key = _succ.key
+		target.value = _succ.value
+		target = _succ
+	}
+
+	child := target.GetLeftNode()
+	if child == nil {
+		child = target.GetRightNode()
+	}
+
+	if len(nodeStack) > 0 {
+		parent := nodeStack[len(nodeStack)-1]
+		if dirStack[len(dirStack)-1] == BTREE_NODE_DIR_LEFT {
+			parent.SetLeftNode(child)
+		} else {
+			parent.SetRightNode(child)
+		}
+	} else {
+		self.SetRootNode(child)
+	}
+
+	delete(self.nodeById, target.id)
+
+	II64I64BTree_DoBalance(self, nodeStack, dirStack)
+
+	return true
+}
+
 func (self *I64I64BTreePage) ToString() string {
 	return fmt.Sprintf("<I64I64BTreePage rootNodeId=%v>", self.rootNodeId)
 }
@@ -234,7 +303,7 @@ func (self *I64I64BTreePage) ToBytes() []byte {
 	return w.ToBytes()
 }
 
-func NewI64I64BTreePage(data []byte) *I64I64BTreePage {
+func NewI64I64BTreePage(data []byte) (*I64I64BTreePage, error) {
 
 	tree := new(I64I64BTreePage)
 	tree.nodeById = make(map[uint32]*I64I64BTreePageNode)
@@ -244,11 +313,19 @@ func NewI64I64BTreePage(data []byte) *I64I64BTreePage {
 
 	if data != nil {
 
+		if len(data) < 12 {
+			return nil, fmt.Errorf("%w: tree page bytes=%v", ErrCorruptPage, len(data))
+		}
+
 		rd := NewDataStreamFromBuffer(data)
 		lastNodeId = rd.ReadUInt32()
 		rootNodeId = rd.ReadUInt32()
 		nodeCount := rd.ReadUInt32()
 
+		if uint64(len(data)) < 12 + uint64(nodeCount) * 28 {
+			return nil, fmt.Errorf("%w: tree page nodes=%v bytes=%v", ErrCorruptPage, nodeCount, len(data))
+		}
+
 		var i uint32
 
 		var nodeId uint32
@@ -274,11 +351,35 @@ func NewI64I64BTreePage(data []byte) *I64I64BTreePage {
 		}
 	}
 
-	fmt.Println("NewI64I64BTreePage nodes", len(tree.nodeById))
+	//fmt.Println("NewI64I64BTreePage nodes", len(tree.nodeById))
 
 	tree.lastNodeId = lastNodeId
 	tree.rootNodeId = rootNodeId
 
+	return tree, nil
+}
+
+// _NewSortedI64I64BTreePage builds a balanced tree from keys in ascending
+// order and their values, without the rotations of Insert.
+func _NewSortedI64I64BTreePage(keys []int64, values []int64) *I64I64BTreePage {
+
+	tree := new(I64I64BTreePage)
+	tree.nodeById = make(map[uint32]*I64I64BTreePageNode)
+
+	var build func(lo int, hi int) uint32
+	build = func(lo int, hi int) uint32 {
+		if lo >= hi {
+			return 0
+		}
+		mid := (lo + hi) / 2
+		node := tree.CreateNode(keys[mid], values[mid])
+		node.leftNodeId = build(lo, mid)
+		node.rightNodeId = build(mid+1, hi)
+		return node.id
+	}
+
+	tree.rootNodeId = build(0, len(keys))
+
 	return tree
 }
 
@@ -344,47 +445,97 @@ func (self *I64I64BTreePageNode) ToString() string {
 
 
 
-func TakeII64I64BTreeNodes(tree II64I64BTree) chan II64I64BTreeNode {
+// EachII64I64BTreeNodes walks tree in key order until yield returns false
+// and reports whether it ran to the end.
+func EachII64I64BTreeNodes(tree II64I64BTree, yield func(node II64I64BTreeNode) bool) bool {
 
-	q := make(chan II64I64BTreeNode)
-
-	go func(chOutNodes chan II64I64BTreeNode) {
-		rootNode := tree.GetRootNode()
-		node := rootNode
-		goLeft := true
-		stack := NewLazyList()
+	rootNode := tree.GetRootNode()
+	node := rootNode
+	goLeft := true
+	stack := NewLazyList()
 
-		for {
-			if node == nil {
-				break
+	for {
+		if node == nil {
+			break
+		}
+		leftNode := node.GetLeftNode()
+		rightNode := node.GetRightNode()
+		if goLeft && leftNode != nil {
+			stack.Append(node)
+			
+			node = leftNode
+		} else {
+			if !yield(node) {
+				return false
 			}
-			leftNode := node.GetLeftNode()
-			rightNode := node.GetRightNode()
-			if goLeft && leftNode != nil {
-				stack.Append(node)
-				
-				node = leftNode
+			if rightNode != nil {
+				node = rightNode
+				goLeft = true
+
 			} else {
-				chOutNodes <- node
-				if rightNode != nil {
-					node = rightNode
-					goLeft = true
+				if stack.Len() == 0 {
+					break
+				}
 
-				} else {
-					if stack.Len() == 0 {
-						break
-					}
+				node = stack.Pop().(II64I64BTreeNode)
+				goLeft = false
+			}
 
-					node = stack.Pop().(II64I64BTreeNode)
-					goLeft = false
-				}
+		}
+	}
+
+	return true
+}
+
+// EachII64I64BTreeNodesInRange walks the nodes of tree with keys in
+// [from, to], skipping the subtrees outside it.
+func EachII64I64BTreeNodesInRange(tree II64I64BTree, from int64, to int64, reverse bool, yield func(node II64I64BTreeNode) bool) bool {
+
+	node := tree.GetRootNode()
+	var stack []II64I64BTreeNode
 
+	for {
+
+		for node != nil {
+			key := node.GetKey()
+			if !reverse && key < from {
+				node = node.GetRightNode()
+			} else if reverse && key > to {
+				node = node.GetLeftNode()
+			} else {
+				stack = append(stack, node)
+				if reverse {
+					node = node.GetRightNode()
+				} else {
+					node = node.GetLeftNode()
+				}
 			}
 		}
 
-		close(chOutNodes)
-	}(q)
-	return q
+		if len(stack) == 0 {
+			break
+		}
+
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		key := node.GetKey()
+		if (!reverse && key > to) || (reverse && key < from) {
+			return true
+		}
+
+		if !yield(node) {
+			return false
+		}
+
+		if reverse {
+			node = node.GetLeftNode()
+		} else {
+			node = node.GetRightNode()
+		}
+	}
+
+	return true
 }
 
 
@@ -493,6 +644,7 @@ type BTreeBlobMap struct {
 	nodes map[uint32]*BTreeBlobMapNode
 	nodeDataContexts map[uint32]*BTreeBlobMapNodeContext
 	//pageContexts map[uint32]*BTreeInternalPageContext
+	cache *PageCache
 
 	isChanged bool
 	rwlock sync.Mutex
@@ -513,6 +665,7 @@ type BTreeBlobMapNodeContext struct {
 	pageIdByKey map[int64]uint32
 	isChanged bool 
 	bt *BTreeBlobMap
+	cacheEntry *PageCacheEntry
 }
 
 
@@ -524,7 +677,7 @@ func (n *BTreeBlobMapNode) ToString() string {
 	return fmt.Sprintf("<BTreeBlobMapNode id=%v key=%v dataPageId=%v leftNodeId=%v rightNodeId=%v>", n.id, n.key, n.dataPageId, n.leftNodeId, n.rightNodeId)
 }
 
-func NewBTreeBlobMap(pager IPager, meta []byte) *BTreeBlobMap {
+func NewBTreeBlobMap(pager IPager, meta []byte) (*BTreeBlobMap, error) {
 
 	bt := new(BTreeBlobMap)
 	bt.pager = pager
@@ -533,6 +686,7 @@ func NewBTreeBlobMap(pager IPager, meta []byte) *BTreeBlobMap {
 	bt.nodes = make(map[uint32]*BTreeBlobMapNode)
 	bt.nodeDataContexts = make(map[uint32]*BTreeBlobMapNodeContext)
 //	bt.pageContexts = make(map[uint32]*BTreeInternalPageContext)
+	bt.cache = _PageCacheOf(pager)
 
 	var nodeContextPageId uint32
 
@@ -555,31 +709,51 @@ func NewBTreeBlobMap(pager IPager, meta []byte) *BTreeBlobMap {
 		nodeContextPageId = pager.CreatePageId()
 	} else {
 		nodesData, err := pager.ReadPayloadData(nodeContextPageId)
-		_CheckErr("LOAD BTREE NODES Context", err)
+		if err != nil {
+			return nil, err
+		}
 		
-		nodesR := NewDataStreamFromBuffer(nodesData)
-		nodesCount := nodesR.ReadUInt32()
-		var i uint32
-		var nodeId uint32
-		var nodeKey int64
-		var nodeDataPageId uint32
-		var nodeLeftId uint32
-		var nodeRightId uint32
-
-		for i=0; i<nodesCount; i++ {
-			nodeId = nodesR.ReadUInt32()
-			nodeKey = int64(nodesR.ReadUInt64())
-			nodeDataPageId = nodesR.ReadUInt32()
-			nodeLeftId = nodesR.ReadUInt32()
-			nodeRightId = nodesR.ReadUInt32()
-			
-			node := bt._NewNode(nodeId, nodeKey)
-			node.dataPageId = nodeDataPageId
-			node.leftNodeId = nodeLeftId
-			node.rightNodeId = nodeRightId
-			bt.nodes[node.id] = node
+		err = _DecodePage("BTreeBlobMap nodes", nodeContextPageId, nodesData, func(nodesR *DataStream) {
+			nodesCount := nodesR.ReadUInt32()
+			var i uint32
+			var nodeId uint32
+			var nodeKey int64
+			var nodeDataPageId uint32
+			var nodeLeftId uint32
+			var nodeRightId uint32
+
+			for i=0; i<nodesCount; i++ {
+				nodeId = nodesR.ReadUInt32()
+				nodeKey = int64(nodesR.ReadUInt64())
+				nodeDataPageId = nodesR.ReadUInt32()
+				nodeLeftId = nodesR.ReadUInt32()
+				nodeRightId = nodesR.ReadUInt32()
+				
+				node := bt._NewNode(nodeId, nodeKey)
+				node.dataPageId = nodeDataPageId
+				node.leftNodeId = nodeLeftId
+				node.rightNodeId = nodeRightId
+				bt.nodes[node.id] = node
+
+				//fmt.Println("LOAD", node.ToString())
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
 
-			//fmt.Println("LOAD", node.ToString())
+		// every link must resolve so the tree walk never meets a missing node
+		_, ok := bt.nodes[bt.rootNodeId]
+		if bt.rootNodeId > 0 && !ok {
+			return nil, _CorruptPageError("BTreeBlobMap nodes", nodeContextPageId, "no root node id=%v", bt.rootNodeId)
+		}
+
+		for _, node := range bt.nodes {
+			_, leftOk := bt.nodes[node.leftNodeId]
+			_, rightOk := bt.nodes[node.rightNodeId]
+			if (node.leftNodeId > 0 && !leftOk) || (node.rightNodeId > 0 && !rightOk) {
+				return nil, _CorruptPageError("BTreeBlobMap nodes", nodeContextPageId, "broken link %v", node.ToString())
+			}
 		}
 
 		//fmt.Println("LOAD NODES", len(bt.nodes))
@@ -588,37 +762,111 @@ func NewBTreeBlobMap(pager IPager, meta []byte) *BTreeBlobMap {
 	bt.nodeContextPageId = nodeContextPageId
 
 
-	return bt
+	return bt, nil
 }
 
-func (bt *BTreeBlobMap) Get(key int64) ([]byte, bool) {
+// Get returns ErrNotFound when key is not in the map.
+func (bt *BTreeBlobMap) Get(key int64) (_ []byte, err error) {
+	bt.cache._Enter()
+	defer bt.cache._Leave(&err)
+
+	err = bt.cache._Trim()
+	if err != nil {
+		return nil, err
+	}
 
 	node := bt._FindNode(key)
 
 	if node != nil {
-		ctx := node.GetOrCreateDataContext()
+		ctx, err := node.GetDataContext()
+		if err != nil {
+			return nil, err
+		}
 
-		pageId2, ok := ctx.pageIdByKey[key]
+		if ctx != nil {
+			pageId2, ok := ctx.pageIdByKey[key]
 
-		if ok {
+			if ok {
+				return bt.pager.ReadPayloadData(pageId2)
+			}
+		}
+	}
 
-			outputData, err := bt.pager.ReadPayloadData(pageId2)
+	return nil, ErrNotFound
+}
+
+// _GetMany looks up every key. The node contexts and then the values are
+// read in ascending page id order. found[i] tells whether keys[i] is in
+// the map.
+func (bt *BTreeBlobMap) _GetMany(keys []int64) (_ [][]byte, _ []bool, err error) {
+	bt.cache._Enter()
+	defer bt.cache._Leave(&err)
+
+	values := make([][]byte, len(keys))
+	found := make([]bool, len(keys))
 
-			if err == nil {
-				return outputData, true
+	nodeByPageId := make(map[uint32]*BTreeBlobMapNode)
+	indexesByPageId := make(map[uint32][]int)
+	for i, key := range keys {
+		node := bt._FindNode(key)
+		if node != nil && node.dataPageId > 0 {
+			nodeByPageId[node.dataPageId] = node
+			indexesByPageId[node.dataPageId] = append(indexesByPageId[node.dataPageId], i)
+		}
+	}
+
+	indexesByValuePageId := make(map[uint32][]int)
+
+	for _, pid := range _SortPageIds(indexesByPageId) {
+
+		err := bt.cache._Trim()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ctx, err := nodeByPageId[pid].GetDataContext()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, i := range indexesByPageId[pid] {
+			valuePageId, ok := ctx.pageIdByKey[keys[i]]
+			if ok {
+				indexesByValuePageId[valuePageId] = append(indexesByValuePageId[valuePageId], i)
 			}
 		}
 	}
 
-	return nil, false
+	for _, pid := range _SortPageIds(indexesByValuePageId) {
+		value, err := bt.pager.ReadPayloadData(pid)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, i := range indexesByValuePageId[pid] {
+			values[i] = value
+			found[i] = true
+		}
+	}
+
+	return values, found, nil
 }
 
-func (m *BTreeBlobMap) Set(key int64, value []byte) {
+func (m *BTreeBlobMap) Set(key int64, value []byte) (err error) {
+	m.cache._Enter()
+	defer m.cache._Leave(&err)
+
+	err = m.cache._Trim()
+	if err != nil {
+		return err
+	}
 
 	node := m._InsertNode(key)
 	//fmt.Println("BTreeBlobMap Set", "key=", key, node.ToString(), "value bytes", len(value))
 
-	ctx := node.GetOrCreateDataContext()
+	ctx, err := node.GetOrCreateDataContext()
+	if err != nil {
+		return err
+	}
 
 	//fmt.Println("pageIdByKey", ctx.pageIdByKey)
 	pageId2, ok := ctx.pageIdByKey[key]
@@ -628,16 +876,67 @@ func (m *BTreeBlobMap) Set(key int64, value []byte) {
 		pageId2 = m.pager.CreatePageId()
 		ctx.pageIdByKey[key] = pageId2
 		ctx.isChanged = true
+		m.cache._Resize(ctx.cacheEntry, BTREE_BLOB_ROW_SIZE)
 	}
 
-	m.pager.WritePayloadData(pageId2, value)
-
 	m.isChanged = true
 
+	return m.pager.WritePayloadData(pageId2, value)
 }
 
 
-func (bt *BTreeBlobMap) Save() []byte {
+// Delete returns ErrNotFound when key is not in the map.
+func (bt *BTreeBlobMap) Delete(key int64) (err error) {
+	bt.cache._Enter()
+	defer bt.cache._Leave(&err)
+
+	err = bt.cache._Trim()
+	if err != nil {
+		return err
+	}
+
+	node := bt._FindNode(key)
+	if node == nil {
+		return ErrNotFound
+	}
+
+	ctx, err := node.GetDataContext()
+	if err != nil {
+		return err
+	}
+	if ctx == nil {
+		return ErrNotFound
+	}
+
+	pageId2, ok := ctx.pageIdByKey[key]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(ctx.pageIdByKey, key)
+	ctx.isChanged = true
+	bt.isChanged = true
+	bt.cache._Resize(ctx.cacheEntry, -BTREE_BLOB_ROW_SIZE)
+
+	err = bt.pager.FreePayloadData(pageId2)
+	if err != nil {
+		return err
+	}
+
+	if len(ctx.pageIdByKey) == 0 {
+		delete(bt.nodeDataContexts, ctx.pid)
+		bt.cache._Remove(ctx.cacheEntry)
+		node.dataPageId = 0
+
+		bt._RemoveNode(node.key)
+
+		return bt.pager.FreePayloadData(ctx.pid)
+	}
+
+	return nil
+}
+
+func (bt *BTreeBlobMap) Save() ([]byte, error) {
 
 
 	nodesW := NewDataStream()
@@ -653,23 +952,19 @@ func (bt *BTreeBlobMap) Save() []byte {
 		//fmt.Println("SAVE NODE", node.ToString())
 	}
 
-	bt.pager.WritePayloadData(bt.nodeContextPageId, nodesW.ToBytes())
+	err := bt.pager.WritePayloadData(bt.nodeContextPageId, nodesW.ToBytes())
+	if err != nil {
+		return nil, err
+	}
 
-	for dataPid, dataContext := range bt.nodeDataContexts {
+	for _, dataContext := range bt.nodeDataContexts {
 		//if true {
 		if dataContext.isChanged {
-			//fmt.Println(bt.ToString(), "[SAVE DATA Context]", "dataPid=", dataPid, "rows=", len(dataContext.pageIdByKey))
-
-			w := NewDataStream()
-			w.WriteUInt32(uint32(len(dataContext.pageIdByKey)))
-			for key, pid := range dataContext.pageIdByKey {
-				w.WriteUInt64(uint64(key))
-				w.WriteUInt32(pid)
+			//fmt.Println(bt.ToString(), "[SAVE DATA Context]", "dataPid=", dataContext.pid, "rows=", len(dataContext.pageIdByKey))
+			_, err = dataContext._WriteBack()
+			if err != nil {
+				return nil, err
 			}
-
-			bt.pager.WritePayloadData(dataPid, w.ToBytes())
-
-			dataContext.isChanged = false
 		}
 	}
 
@@ -679,109 +974,152 @@ func (bt *BTreeBlobMap) Save() []byte {
 	meta.WriteUInt32(bt.rootNodeId)
 	meta.WriteUInt32(bt.nodeContextPageId)
 
-	return meta.ToBytes()
+	return meta.ToBytes(), nil
 }
 
-type BTreeBlobMapItem struct {
-	key int64
-	pid uint32
-	bt *BTreeBlobMap
+// Items walks the map in key order. Values are read as the cursor
+// reaches them.
+func (m *BTreeBlobMap) Items() *Cursor[int64, []byte] {
+	return m.SeekKey(math.MinInt64, false)
 }
 
-func (i *BTreeBlobMapItem) Key() int64 {
-	return i.key
+// Range walks the keys in [from, to) in order.
+func (m *BTreeBlobMap) Range(from int64, to int64) *Cursor[int64, []byte] {
+	first, last, ok := _RangeBounds(from, to)
+	if !ok {
+		return _EmptyCursor[int64, []byte]()
+	}
+	return _NewCursor(func(yield func(int64, []byte) bool) error {
+		return m._EachRange(first, last, false, yield)
+	})
 }
 
-func (i *BTreeBlobMapItem) Value() []byte {
-	i.bt.rwlock.Lock()
-	defer i.bt.rwlock.Unlock()
-	data, err := i.bt.pager.ReadPayloadData(i.pid)
-	_CheckErr("DBBTree Items", err)
-	return data
+// RangeClosed walks the keys in [from, to] in order, unlike Range it can
+// end at math.MaxInt64.
+func (m *BTreeBlobMap) RangeClosed(from int64, to int64) *Cursor[int64, []byte] {
+	if to < from {
+		return _EmptyCursor[int64, []byte]()
+	}
+	return _NewCursor(func(yield func(int64, []byte) bool) error {
+		return m._EachRange(from, to, false, yield)
+	})
 }
 
+// SeekKey walks up from the first key >= key, or down from the last key
+// <= key when reverse is set.
+func (m *BTreeBlobMap) SeekKey(key int64, reverse bool) *Cursor[int64, []byte] {
+	first, last := _SeekBounds(key, reverse)
+	return _NewCursor(func(yield func(int64, []byte) bool) error {
+		return m._EachRange(first, last, reverse, yield)
+	})
+}
 
-func (m *BTreeBlobMap) Items() chan BTreeBlobMapItem {
-	q := make(chan BTreeBlobMapItem)
+func (m *BTreeBlobMap) _EachRange(from int64, to int64, reverse bool, yield func(key int64, value []byte) bool) error {
 
-	go func(ch chan BTreeBlobMapItem) {
-		nodesCount := 0
+	var nodes []*BTreeBlobMapNode
+	m._EachNodeRange(m._GetBranchKey(from), m._GetBranchKey(to), reverse, func(node *BTreeBlobMapNode) bool {
+		nodes = append(nodes, node)
+		return true
+	})
 
-		for node := range m._Nodes() {
-			nodesCount += 1
+	for _, node := range nodes {
 
-			ctx := node.GetDataContext()
+		err := m.cache._Trim()
+		if err != nil {
+			return err
+		}
 
-			if ctx == nil {
-				fmt.Println("Items ctx is nil", node.ToString())
-			}
+		ctx, err := node.GetDataContext()
+		if err != nil {
+			return err
+		}
 
-			if ctx != nil {
-				//fmt.Println("Items ctx", node.ToString(), len(ctx.pageIdByKey))
-				for key, pgId := range ctx.pageIdByKey {			
-					item := BTreeBlobMapItem{key: key, bt: m, pid: pgId}
-					ch <- item
-				}
+		if ctx == nil {
+			continue
+		}
+
+		var keys I64Array
+		for key, _ := range ctx.pageIdByKey {
+			if key >= from && key <= to {
+				keys = append(keys, key)
 			}
 		}
 
-		fmt.Println("Items Done nodesCount", nodesCount)
+		if reverse {
+			sort.Sort(sort.Reverse(keys))
+		} else {
+			sort.Sort(keys)
+		}
 
-		close(ch)
-	} (q)
+		for _, key := range keys {
+			pgId, ok := ctx.pageIdByKey[key]
+			if !ok {
+				// deleted while the cursor was open
+				continue
+			}
 
-	return q
+			m.rwlock.Lock()
+			value, err := m.pager.ReadPayloadData(pgId)
+			m.rwlock.Unlock()
+			if err != nil {
+				return err
+			}
+
+			if !yield(key, value) {
+				return nil
+			}
+		}
+	}
+
+	return nil
 }
 
 
-func (bt *BTreeBlobMap) _Nodes() chan *BTreeBlobMapNode {
+// _EachNodeRange walks the nodes with branch keys in [from, to] until
+// yield returns false, skipping the subtrees outside the range.
+func (bt *BTreeBlobMap) _EachNodeRange(from int64, to int64, reverse bool, yield func(node *BTreeBlobMapNode) bool) {
 
-	q := make(chan *BTreeBlobMapNode)
+	node := bt._GetRootNode()
+	stack := make([]*BTreeBlobMapNode, 0)
 
-	go func(ch chan *BTreeBlobMapNode) {
-		
-		rootNode := bt._GetRootNode()
-		node := rootNode
-		goRight := true
-		stack := make([]*BTreeBlobMapNode, 0)
-
-		for {
-			if node == nil {
-				break
-			}
-			leftNode := node.GetLeftNode()
-			rightNode := node.GetRightNode()
+	for {
 
-			if goRight && rightNode != nil {
-				stack = append(stack, node)
-				node = rightNode
+		for node != nil {
+			if !reverse && node.key < from {
+				node = node.GetRightNode()
+			} else if reverse && node.key > to {
+				node = node.GetLeftNode()
 			} else {
-
-				ch <- node
-				if leftNode != nil {
-					node = leftNode
-					goRight = true
+				stack = append(stack, node)
+				if reverse {
+					node = node.GetRightNode()
 				} else {
-					if len(stack) == 0{
-						break
-					}
-
-					//fmt.Println("POP STACK", len(stack))
+					node = node.GetLeftNode()
+				}
+			}
+		}
 
-					node = stack[len(stack)-1]
-					stack = stack[:len(stack)-1]
-					goRight = false
+		if len(stack) == 0 {
+			return
+		}
 
-					//fmt.Println("POP STACK DONE", len(stack))
+		node = stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
 
-				}
-			}
+		if (!reverse && node.key > to) || (reverse && node.key < from) {
+			return
 		}
 
-		close(ch)
-	} (q)
+		if !yield(node) {
+			return
+		}
 
-	return q
+		if reverse {
+			node = node.GetLeftNode()
+		} else {
+			node = node.GetRightNode()
+		}
+	}
 }
 
 func (bt *BTreeBlobMap) _FindNode(key int64) *BTreeBlobMapNode {
@@ -833,6 +1171,8 @@ const (
 	BTREE_NODE_DIR_LEFT = 0
 	BTREE_NODE_DIR_RIGHT = 1
 
+	// cache cost of a key and its value page id
+	BTREE_BLOB_ROW_SIZE = 12 + PAGE_CACHE_ROW_OVERHEAD
 )
 
 func (bt *BTreeBlobMap) _InsertNode(key int64) *BTreeBlobMapNode {
@@ -889,6 +1229,78 @@ func (bt *BTreeBlobMap) _InsertNode(key int64) *BTreeBlobMapNode {
 }
 
 
+func (bt *BTreeBlobMap) _RemoveNode(findKey int64) bool {
+
+	var node *BTreeBlobMapNode
+	var nodeStack []*BTreeBlobMapNode
+	var dirStack []byte
+
+	node = bt._GetRootNode()
+
+	for {
+		if node == nil {
+			return false
+		}
+
+		if node.key == findKey {
+			break
+		}
+
+		nodeStack = append(nodeStack, node)
+
+		if findKey < node.key {
+			node = node.GetLeftNode()
+			dirStack = append(dirStack, BTREE_NODE_DIR_LEFT)
+		} else {
+			node = node.GetRightNode()
+			dirStack = append(dirStack, BTREE_NODE_DIR_RIGHT)
+		}
+	}
+
+	target := node
+
+	if node.leftNodeId > 0 && node.rightNodeId > 0 {
+		// replace with the in-order successor and unlink the successor instead
+		nodeStack = append(nodeStack, node)
+		dirStack = append(dirStack, BTREE_NODE_DIR_RIGHT)
+
+		succ := node.GetRightNode()
+		for succ.GetLeftNode() != nil {
+			nodeStack = append(nodeStack, succ)
+			dirStack = append(dirStack, BTREE_NODE_DIR_LEFT)
+			succ = succ.GetLeftNode()
+		}
+
+		node.key = succ.key
+		node.dataPageId = succ.dataPageId
+		target = succ
+	}
+
+	child := target.GetLeftNode()
+	if child == nil {
+		child = target.GetRightNode()
+	}
+
+	if len(nodeStack) > 0 {
+		parent := nodeStack[len(nodeStack)-1]
+		if dirStack[len(dirStack)-1] == BTREE_NODE_DIR_LEFT {
+			parent.SetLeftNode(child)
+		} else {
+			parent.SetRightNode(child)
+		}
+	} else {
+		bt._SetRootNode(child)
+	}
+
+	delete(bt.nodes, target.id)
+
+	bt._DoBalance(nodeStack, dirStack)
+	bt.isChanged = true
+
+	return true
+}
+
+
 func (bt* BTreeBlobMap) _DoBalance(nodeStack []*BTreeBlobMapNode, dirStack []byte) {
 
 	balanceCount := 0
@@ -986,6 +1398,7 @@ func (bt *BTreeBlobMap) _CreateNodeDataContext() *BTreeBlobMapNodeContext {
 	pid := bt.pager.CreatePageId()
 	dp := bt._NewNodeDataContext(pid)
 	bt.nodeDataContexts[pid] = dp
+	dp.cacheEntry = bt.cache._Add(dp, 0, false)
 
 	dp.isChanged = true
 	bt.isChanged = true
@@ -1012,6 +1425,27 @@ func (bt *BTreeBlobMap) _NewNode(id uint32, key int64) *BTreeBlobMapNode {
 	return node
 }
 
+// _BuildSorted fills an empty map with a balanced tree of nodes, keys are
+// the branch keys in ascending order and dataPageIds their written node
+// contexts.
+func (bt *BTreeBlobMap) _BuildSorted(keys []int64, dataPageIds []uint32) {
+
+	var build func(lo int, hi int) uint32
+	build = func(lo int, hi int) uint32 {
+		if lo >= hi {
+			return 0
+		}
+		mid := (lo + hi) / 2
+		node := bt._CreateNode(keys[mid])
+		node.dataPageId = dataPageIds[mid]
+		node.leftNodeId = build(lo, mid)
+		node.rightNodeId = build(mid+1, hi)
+		return node.id
+	}
+
+	bt.rootNodeId = build(0, len(keys))
+}
+
 func (bt *BTreeBlobMap) _CreateNode(key int64) *BTreeBlobMapNode {
 
 	id := bt._CreateNodeId()
@@ -1023,32 +1457,29 @@ func (bt *BTreeBlobMap) _CreateNode(key int64) *BTreeBlobMapNode {
 }
 
 
+// _GetNode only looks up ids that NewBTreeBlobMap has validated.
 func (bt *BTreeBlobMap) _GetNode(nodeId uint32) *BTreeBlobMapNode {
 
-	node, ok := bt.nodes[nodeId]
-	if !ok {
-
-		fmt.Printf("Node is None id=%v\n",  nodeId)
-		os.Exit(1)
-
-	}
-
-	return node
+	return bt.nodes[nodeId]
 }
 
-func (bt* BTreeBlobMap) _GetNodeDataContext(pid uint32) *BTreeBlobMapNodeContext {
+func (bt* BTreeBlobMap) _GetNodeDataContext(pid uint32) (*BTreeBlobMapNodeContext, error) {
 	ctx, ok := bt.nodeDataContexts[pid]
 
-	if !ok {
+	if ok {
+		bt.cache._Touch(ctx.cacheEntry)
+	} else {
 		bt.rwlock.Lock()
 		defer bt.rwlock.Unlock()
 		ctx = bt._NewNodeDataContext(pid)
 
 		pageIdByKey := make(map[int64]uint32)
 		data, err := bt.pager.ReadPayloadData(pid)
-		if err == nil {
+		if err != nil {
+			return nil, err
+		}
 
-			rd := NewDataStreamFromBuffer(data)
+		err = _DecodePage("BTreeBlobMap context", pid, data, func(rd *DataStream) {
 			rowCount := int(rd.ReadUInt32())
 			for i:=0; i<rowCount; i++ {
 				rowKey := int64(rd.ReadUInt64())
@@ -1056,26 +1487,65 @@ func (bt* BTreeBlobMap) _GetNodeDataContext(pid uint32) *BTreeBlobMapNodeContext
 
 				pageIdByKey[rowKey] = rowPid
 			}
-		
-			
-		} else  {
-
-			fmt.Println("_GetDataContext ERRPR !!!!!!", "pid=", pid, err)
-			os.Exit(1)
+		})
+		if err != nil {
+			return nil, err
 		}
 
 		ctx.pageIdByKey = pageIdByKey
+		ctx.isChanged = false
 		//fmt.Println("LOAD DATA Context", "pid=", pid, "rows", len(ctx.pageIdByKey))
 
 		bt.nodeDataContexts[pid] = ctx
+		ctx.cacheEntry = bt.cache._Add(ctx, len(pageIdByKey) * BTREE_BLOB_ROW_SIZE, true)
 	}
 
-	return ctx
+	return ctx, nil
+}
+
+func (ctx *BTreeBlobMapNodeContext) _Owner() string {
+	return _PagerOwner(ctx.bt.pager)
+}
+
+func (ctx *BTreeBlobMapNodeContext) _IsChanged() bool {
+	return ctx.isChanged
+}
+
+func (ctx *BTreeBlobMapNodeContext) _WriteBack() (bool, error) {
+
+	w := NewDataStream()
+	w.WriteUInt32(uint32(len(ctx.pageIdByKey)))
+	for key, pid := range ctx.pageIdByKey {
+		w.WriteUInt64(uint64(key))
+		w.WriteUInt32(pid)
+	}
+
+	err := ctx.bt.pager.WritePayloadData(ctx.pid, w.ToBytes())
+	if err != nil {
+		return false, err
+	}
+
+	ctx.isChanged = false
+
+	return true, nil
+}
+
+func (ctx *BTreeBlobMapNodeContext) _Release() {
+	ctx.bt.rwlock.Lock()
+	defer ctx.bt.rwlock.Unlock()
+	delete(ctx.bt.nodeDataContexts, ctx.pid)
+}
+
+func (ctx *BTreeBlobMapNodeContext) ToString() string {
+	return fmt.Sprintf("<BTreeBlobMapNodeContext pid=%v rows=%v isChanged=%v>", ctx.pid, len(ctx.pageIdByKey), ctx.isChanged)
 }
 
 func (bt* BTreeBlobMap) _SetRootNode(node *BTreeBlobMapNode) {
 
-	bt.rootNodeId = node.id
+	bt.rootNodeId = 0
+	if node != nil {
+		bt.rootNodeId = node.id
+	}
 	bt.isChanged = true
 }
 
@@ -1121,25 +1591,28 @@ func (n *BTreeBlobMapNode) SetRightNode(node *BTreeBlobMapNode) {
 }
 
 
-func (n *BTreeBlobMapNode) GetDataContext() *BTreeBlobMapNodeContext {
+func (n *BTreeBlobMapNode) GetDataContext() (*BTreeBlobMapNodeContext, error) {
 	if n.dataPageId > 0 {
 		//fmt.Println(n.ToString(), "GetDataContext", n.dataPageId )
 
 		return n.bt._GetNodeDataContext(n.dataPageId)
 	}
 
-	return nil
+	return nil, nil
 }
 
-func (n *BTreeBlobMapNode) GetOrCreateDataContext() *BTreeBlobMapNodeContext {
+func (n *BTreeBlobMapNode) GetOrCreateDataContext() (*BTreeBlobMapNodeContext, error) {
 
-	dp := n.GetDataContext()
+	dp, err := n.GetDataContext()
+	if err != nil {
+		return nil, err
+	}
 	if dp == nil {
 		dataContext := n.bt._CreateNodeDataContext()
 		n.dataPageId = dataContext.pid
 		dp = dataContext
 	}
 
-	return dp
+	return dp, nil
 }
 
@@ -3,6 +3,7 @@ package gokvdb
 import (
 	//"os"
 	"fmt"
+	"math"
 	//"sort"
 	//"sync"
 )
@@ -12,6 +13,7 @@ type BranchI64BTreeFactory struct {
 	rootPageId uint32
 	treePageByPageId map[uint32]*BranchI64BTreePage
 	depth int
+	fanOut int64
 }
 
 type BranchI64BTreePage struct {
@@ -21,7 +23,7 @@ type BranchI64BTreePage struct {
 }
 
 func (self *BranchI64BTreeFactory) ToString() string {
-	return fmt.Sprintf("<BranchI64BTreeFactory rootPageId=%v>", self.rootPageId)
+	return fmt.Sprintf("<BranchI64BTreeFactory rootPageId=%v fanOut=%v>", self.rootPageId, self.fanOut)
 }
 
 
@@ -36,7 +38,7 @@ func (self *BranchI64BTreeFactory) CalcBranchKeys(key int64) []int64 {
 	curKey := key
 
 	for i:=0; i<self.depth; i++ {
-		curKey = curKey / 4096
+		curKey = curKey / self.fanOut
 		keys = append(keys, curKey)
 	}
 
@@ -52,13 +54,18 @@ func (self *BranchI64BTreeFactory) CalcBranchKeys(key int64) []int64 {
 	return reverseKeys
 }
 
-func (self *BranchI64BTreeFactory) NewTreePage(pid uint32, data []byte) *BranchI64BTreePage {
+func (self *BranchI64BTreeFactory) NewTreePage(pid uint32, data []byte) (*BranchI64BTreePage, error) {
+	tree, err := NewI64I64BTreePage(data)
+	if err != nil {
+		return nil, _PageError("BranchI64BTreePage", pid, err)
+	}
+
 	treePage := new(BranchI64BTreePage)
 	treePage.pid = pid
-	treePage.tree = NewI64I64BTreePage(data)
+	treePage.tree = tree
 	treePage.isChanged = false
 
-	return treePage
+	return treePage, nil
 }
 
 func NewBranchI64BTreeFactory(pager IPager, meta []byte, depth int) *BranchI64BTreeFactory {
@@ -66,6 +73,7 @@ func NewBranchI64BTreeFactory(pager IPager, meta []byte, depth int) *BranchI64BT
 	self.pager = pager
 	self.treePageByPageId = make(map[uint32]*BranchI64BTreePage)
 	self.depth = depth
+	self.fanOut = _OptionsOf(pager)._BranchFanOut()
 
 	rootPageId := uint32(0)	
 
@@ -81,81 +89,152 @@ func NewBranchI64BTreeFactory(pager IPager, meta []byte, depth int) *BranchI64BT
 
 
 
-func (self *BranchI64BTreeFactory) Items() chan I64I64BTreeItem {
+func (self *BranchI64BTreeFactory) Items() *Cursor[int64, int64] {
+	return _NewCursor(self._EachItems)
+}
 
-	q := make(chan I64I64BTreeItem)
+// Range walks the keys in [from, to) in order.
+func (self *BranchI64BTreeFactory) Range(from int64, to int64) *Cursor[int64, int64] {
+	first, last, ok := _RangeBounds(from, to)
+	if !ok {
+		return _EmptyCursor[int64, int64]()
+	}
+	return _NewCursor(func(yield func(int64, int64) bool) error {
+		return self._EachRange(first, last, false, yield)
+	})
+}
 
-	go func(ch chan I64I64BTreeItem) {
+// RangeClosed walks the keys in [from, to] in order, unlike Range it can
+// end at math.MaxInt64.
+func (self *BranchI64BTreeFactory) RangeClosed(from int64, to int64) *Cursor[int64, int64] {
+	if to < from {
+		return _EmptyCursor[int64, int64]()
+	}
+	return _NewCursor(func(yield func(int64, int64) bool) error {
+		return self._EachRange(from, to, false, yield)
+	})
+}
 
-		root := self.GetRootPage()
-		//fmt.Println("Contexts", self.ToString(), root.ToString())
+// SeekKey walks up from the first key >= key, or down from the last key
+// <= key when reverse is set.
+func (self *BranchI64BTreeFactory) SeekKey(key int64, reverse bool) *Cursor[int64, int64] {
+	first, last := _SeekBounds(key, reverse)
+	return _NewCursor(func(yield func(int64, int64) bool) error {
+		return self._EachRange(first, last, reverse, yield)
+	})
+}
 
-		self._EachItems(root, ch, 0)
+func (self *BranchI64BTreeFactory) LoadTreePage(pid uint32) (*BranchI64BTreePage, error) {
 
-		close(ch)
-	} (q)
+	pageData, err := self.pager.ReadPayloadData(pid)
+	if err != nil {
+		return nil, err
+	}
+	return self.NewTreePage(pid, pageData)
+}
 
-	return q
+// _EachItems calls yield for every leaf item in key order until it
+// returns false.
+func (self *BranchI64BTreeFactory) _EachItems(yield func(key int64, value int64) bool) error {
+	return self._EachRange(math.MinInt64, math.MaxInt64, false, yield)
 }
 
-func (self *BranchI64BTreeFactory) LoadTreePage(pid uint32) *BranchI64BTreePage {
+// _EachRange calls yield for the leaf items with keys in [from, to]. Only
+// the branch pages whose keys overlap the range are loaded.
+func (self *BranchI64BTreeFactory) _EachRange(from int64, to int64, reverse bool, yield func(key int64, value int64) bool) error {
 
-	pageData, _ := self.pager.ReadPayloadData(pid)
-	treePage := self.NewTreePage(pid, pageData)
-	return treePage
-}
+	root, err := self.GetRootPage()
+	if err != nil || root == nil {
+		return err
+	}
 
+	fromKeys := self.CalcBranchKeys(from)
+	toKeys := self.CalcBranchKeys(to)
 
-func (self *BranchI64BTreeFactory) _EachItems(page *BranchI64BTreePage, outCh chan I64I64BTreeItem, depth int) {
+	// branch keys are key/fanOut per level, which keeps the key order, so a
+	// child overlaps [from, to] when its key is within the same bounds
+	fromKeys = append(fromKeys, from)
+	toKeys = append(toKeys, to)
+
+	_, err = self._EachPageRange(root, fromKeys, toKeys, reverse, yield, 0)
+	return err
+}
+
+func (self *BranchI64BTreeFactory) _EachPageRange(page *BranchI64BTreePage, fromKeys []int64, toKeys []int64, reverse bool, yield func(key int64, value int64) bool, depth int) (bool, error) {
 	//fmt.Println("BEGIN _EachContexts depth", depth, page.ToString())
 
+	var keys []int64
+	var values []int64
+	page.tree._EachRange(fromKeys[depth], toKeys[depth], reverse, func(key int64, value int64) bool {
+		keys = append(keys, key)
+		values = append(values, value)
+		return true
+	})
+
 	if depth >= self.depth {
-		for item := range page.tree.Items() {
-			//fmt.Println("_EachItems Last depth", depth, page.ToString(), item.Key(), item.Value())
+		// the page was copied first, yield may change it
+		for i, key := range keys {
+			//fmt.Println("_EachItems Last depth", depth, page.ToString(), key, values[i])
 
-			outCh <- item
+			if !yield(key, values[i]) {
+				return false, nil
+			}
 		}
 
-		return
+		return true, nil
 	}
 
-	for item := range page.tree.Items() {
-		pageId := uint32(item.Value())
+	for _, value := range values {
+
+		pageId := uint32(value)
 
 		//fmt.Println("_FillValues depth", depth, "pageId", pageId)
 
 		treePage, ok := self.treePageByPageId[pageId]
 		if !ok {
-			treePage = self.LoadTreePage(pageId)
+			var err error
+			treePage, err = self.LoadTreePage(pageId)
+			if err != nil {
+				return false, err
+			}
 		}
 
 		//fmt.Println("_EachContexts depth", depth, "treePage", treePage.ToString())
 
-		self._EachItems(treePage, outCh, depth+1)
+		more, err := self._EachPageRange(treePage, fromKeys, toKeys, reverse, yield, depth+1)
+		if err != nil || !more {
+			return false, err
+		}
 	}
 
+	return true, nil
 }
 
-func (self *BranchI64BTreeFactory) GetRootPage() *BranchI64BTreePage {
+func (self *BranchI64BTreeFactory) GetRootPage() (*BranchI64BTreePage, error) {
 	if self.rootPageId > 0 {
 		page, ok := self.treePageByPageId[self.rootPageId]
 		if !ok {
-			data, _ := self.pager.ReadPayloadData(self.rootPageId)
-			page = self.NewTreePage(self.rootPageId, data)
+			page, err := self.LoadTreePage(self.rootPageId)
+			if err != nil {
+				return nil, err
+			}
 			page.isChanged = true
 			self.treePageByPageId[self.rootPageId] = page
-			return page
+			return page, nil
 		}
-		return page
+		return page, nil
 	}
 
-	return nil
+	return nil, nil
 }
 
 
-func (self *BranchI64BTreeFactory) GetPage(key int64) *BranchI64BTreePage {
+func (self *BranchI64BTreeFactory) GetPage(key int64) (*BranchI64BTreePage, error) {
 
-	root := self.GetRootPage()
+	root, err := self.GetRootPage()
+	if err != nil {
+		return nil, err
+	}
 	if root != nil {
 		keys := self.CalcBranchKeys(key)
 
@@ -171,7 +250,7 @@ func (self *BranchI64BTreeFactory) GetPage(key int64) *BranchI64BTreePage {
 			_pageId, ok := page.tree.Get(k)
 
 			if !ok {
-				return nil
+				return nil, nil
 			}
 			
 			pageId = uint32(_pageId)
@@ -180,25 +259,89 @@ func (self *BranchI64BTreeFactory) GetPage(key int64) *BranchI64BTreePage {
 				nextPage = _nextPage
 			}		
 			if nextPage == nil {
-				nextPageData, _ := self.pager.ReadPayloadData(pageId)
-				nextPage = self.NewTreePage(pageId, nextPageData)
+				nextPage, err = self.LoadTreePage(pageId)
+				if err != nil {
+					return nil, err
+				}
 				self.treePageByPageId[pageId] = nextPage
 			}
 			page = nextPage
 		}
 
-		return page
+		return page, nil
 		
 	}
 
-	return nil
+	return nil, nil
 }
 
-func (self *BranchI64BTreeFactory) GetOrCreatePage(key int64) *BranchI64BTreePage {
+// _GetMany looks up every key a level at a time, the pages of a level are
+// read in ascending page id order. found[i] tells whether keys[i] is in
+// the tree.
+func (self *BranchI64BTreeFactory) _GetMany(keys []int64) ([]int64, []bool, error) {
+
+	values := make([]int64, len(keys))
+	found := make([]bool, len(keys))
+
+	root, err := self.GetRootPage()
+	if err != nil {
+		return nil, nil, err
+	}
+	if root == nil || len(keys) == 0 {
+		return values, found, nil
+	}
+
+	branchKeys := make([][]int64, len(keys))
+	indexes := make([]int, len(keys))
+	for i, key := range keys {
+		branchKeys[i] = self.CalcBranchKeys(key)
+		indexes[i] = i
+	}
+
+	indexesByPageId := map[uint32][]int{root.pid: indexes}
+
+	for depth:=0; depth<=self.depth; depth++ {
+
+		nextIndexesByPageId := make(map[uint32][]int)
+
+		for _, pid := range _SortPageIds(indexesByPageId) {
+
+			page, ok := self.treePageByPageId[pid]
+			if !ok {
+				page, err = self.LoadTreePage(pid)
+				if err != nil {
+					return nil, nil, err
+				}
+				self.treePageByPageId[pid] = page
+			}
+
+			for _, i := range indexesByPageId[pid] {
+				if depth == self.depth {
+					values[i], found[i] = page.Get(keys[i])
+					continue
+				}
+
+				pageId, ok := page.Get(branchKeys[i][depth])
+				if ok {
+					nextIndexesByPageId[uint32(pageId)] = append(nextIndexesByPageId[uint32(pageId)], i)
+				}
+			}
+		}
+
+		indexesByPageId = nextIndexesByPageId
+	}
+
+	return values, found, nil
+}
+
+func (self *BranchI64BTreeFactory) GetOrCreatePage(key int64) (*BranchI64BTreePage, error) {
 
 	keys := self.CalcBranchKeys(key)
 
-	root := self.GetOrCreateRootPage()
+	root, err := self.GetOrCreateRootPage()
+	if err != nil {
+		return nil, err
+	}
 	page := root
 
 	var pageId uint32
@@ -216,7 +359,7 @@ func (self *BranchI64BTreeFactory) GetOrCreatePage(key int64) *BranchI64BTreePag
 		if !ok {
 
 			pageId = self.pager.CreatePageId()
-			nextPage = self.NewTreePage(pageId, nil)
+			nextPage, _ = self.NewTreePage(pageId, nil)
 			nextPage.isChanged = true
 			self.treePageByPageId[pageId] = nextPage
 
@@ -234,32 +377,139 @@ func (self *BranchI64BTreeFactory) GetOrCreatePage(key int64) *BranchI64BTreePag
 		}
 
 		if nextPage == nil {
-			nextPageData, _ := self.pager.ReadPayloadData(pageId)
-			nextPage = self.NewTreePage(pageId, nextPageData)
+			nextPage, err = self.LoadTreePage(pageId)
+			if err != nil {
+				return nil, err
+			}
 			self.treePageByPageId[pageId] = nextPage
 		}
 
 		page = nextPage
 	}
 
-	return page
+	return page, nil
 }
 
 
-func (self *BranchI64BTreeFactory) Save() []byte {
+// Delete removes key from its leaf page and frees the branch pages
+// left empty on the way back up to the root.
+func (self *BranchI64BTreeFactory) Delete(key int64) (bool, error) {
+
+	root, err := self.GetRootPage()
+	if err != nil || root == nil {
+		return false, err
+	}
+
+	keys := self.CalcBranchKeys(key)
+
+	pages := []*BranchI64BTreePage{root}
+	page := root
+
+	for i:=0; i<len(keys); i++ {
+		_pageId, ok := page.tree.Get(keys[i])
+		if !ok {
+			return false, nil
+		}
+
+		pageId := uint32(_pageId)
+		nextPage, ok := self.treePageByPageId[pageId]
+		if !ok {
+			nextPage, err = self.LoadTreePage(pageId)
+			if err != nil {
+				return false, err
+			}
+			self.treePageByPageId[pageId] = nextPage
+		}
+
+		page = nextPage
+		pages = append(pages, page)
+	}
+
+	if !page.tree.Delete(key) {
+		return false, nil
+	}
+	page.isChanged = true
+
+	for i:=len(pages)-1; i>0; i-- {
+		if pages[i].tree.Count() > 0 {
+			break
+		}
+
+		delete(self.treePageByPageId, pages[i].pid)
+		err = self.pager.FreePayloadData(pages[i].pid)
+		if err != nil {
+			return false, err
+		}
+
+		pages[i-1].tree.Delete(keys[i-1])
+		pages[i-1].isChanged = true
+	}
+
+	return true, nil
+}
+
+// Free releases every tree page of the factory back to the pager.
+func (self *BranchI64BTreeFactory) Free() error {
+
+	root, err := self.GetRootPage()
+	if err != nil {
+		return err
+	}
+	if root != nil {
+		err = self._FreePage(root, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	self.rootPageId = 0
+	self.treePageByPageId = make(map[uint32]*BranchI64BTreePage)
+
+	return nil
+}
+
+func (self *BranchI64BTreeFactory) _FreePage(page *BranchI64BTreePage, depth int) error {
+
+	if depth < self.depth {
+		pageIds := page._ChildPageIds()
+
+		for _, pageId := range pageIds {
+			treePage, ok := self.treePageByPageId[pageId]
+			if !ok {
+				var err error
+				treePage, err = self.LoadTreePage(pageId)
+				if err != nil {
+					return err
+				}
+			}
+			err := self._FreePage(treePage, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return self.pager.FreePayloadData(page.pid)
+}
+
+func (self *BranchI64BTreeFactory) Save() ([]byte, error) {
 
 	//fmt.Println("SAVE...", self.ToString())
 
 	for pid, treePage := range self.treePageByPageId {
 		//fmt.Println("SAVE...", treePage.ToString())
 		if treePage.isChanged {
-			treePage.isChanged = false
 	
 			treeData := treePage.tree.ToBytes()
 
 			//fmt.Println("SAVE TREE pid", pid, "bytes", len(treeData))
 
-			self.pager.WritePayloadData(pid, treeData)
+			err := self.pager.WritePayloadData(pid, treeData)
+			if err != nil {
+				return nil, err
+			}
+
+			treePage.isChanged = false
 		}
 
 	}
@@ -267,25 +517,28 @@ func (self *BranchI64BTreeFactory) Save() []byte {
 	metaW := NewDataStreamFromBuffer(make([]byte, 32))
 	metaW.WriteUInt32(self.rootPageId)
 
-	return metaW.ToBytes()
+	return metaW.ToBytes(), nil
 }
 
 
-func (self *BranchI64BTreeFactory) GetOrCreateRootPage() *BranchI64BTreePage {
+func (self *BranchI64BTreeFactory) GetOrCreateRootPage() (*BranchI64BTreePage, error) {
 
-	page := self.GetRootPage()
+	page, err := self.GetRootPage()
+	if err != nil {
+		return nil, err
+	}
 
 	if page == nil {
 
 		pageId := self.pager.CreatePageId()
 
 		self.rootPageId = pageId
-		page = self.NewTreePage(pageId, nil)
+		page, _ = self.NewTreePage(pageId, nil)
 		page.isChanged = true
 		self.treePageByPageId[self.rootPageId] = page
 	}
 
-	return page
+	return page, nil
 }
 
 
@@ -301,3 +554,115 @@ func (self *BranchI64BTreePage) Set(key int64, value int64) {
 	self.isChanged = true
 
 }
+
+// _ChildPageIds returns the page ids held by a branch page in key order.
+func (self *BranchI64BTreePage) _ChildPageIds() []uint32 {
+
+	var pageIds []uint32
+	self.tree._Each(func(key int64, value int64) bool {
+		pageIds = append(pageIds, uint32(value))
+		return true
+	})
+
+	return pageIds
+}
+
+// _BranchBuilder fills an empty factory from keys added in ascending
+// order. Each page is written once, when the keys move past it, so only
+// the open page of every level is held.
+type _BranchBuilder struct {
+	factory *BranchI64BTreeFactory
+	keysByDepth [][]int64
+	valuesByDepth [][]int64
+	openKeys []int64
+	count int
+}
+
+func _NewBranchBuilder(factory *BranchI64BTreeFactory) *_BranchBuilder {
+	b := new(_BranchBuilder)
+	b.factory = factory
+	b.keysByDepth = make([][]int64, factory.depth + 1)
+	b.valuesByDepth = make([][]int64, factory.depth + 1)
+	return b
+}
+
+func (b *_BranchBuilder) ToString() string {
+	return fmt.Sprintf("<_BranchBuilder depth=%v count=%v>", b.factory.depth, b.count)
+}
+
+// Add puts key in the leaf pages, it must be above the keys added before.
+func (b *_BranchBuilder) Add(key int64, value int64) error {
+
+	branchKeys := b.factory.CalcBranchKeys(key)
+
+	if b.count > 0 {
+		// the page of a depth holds one branch key of the depth above it,
+		// every page below the first one that changed is closed
+		for d:=0; d<len(branchKeys); d++ {
+			if branchKeys[d] != b.openKeys[d] {
+				err := b._CloseFrom(d + 1)
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+
+	depth := b.factory.depth
+	b.keysByDepth[depth] = append(b.keysByDepth[depth], key)
+	b.valuesByDepth[depth] = append(b.valuesByDepth[depth], value)
+	b.openKeys = branchKeys
+	b.count += 1
+
+	return nil
+}
+
+// _CloseFrom writes the open pages from the leaf depth up to depth and
+// adds each to the page above it.
+func (b *_BranchBuilder) _CloseFrom(depth int) error {
+
+	for d:=b.factory.depth; d>=depth; d-- {
+		pid, err := b._WritePage(d)
+		if err != nil {
+			return err
+		}
+		b.keysByDepth[d-1] = append(b.keysByDepth[d-1], b.openKeys[d-1])
+		b.valuesByDepth[d-1] = append(b.valuesByDepth[d-1], int64(pid))
+	}
+
+	return nil
+}
+
+func (b *_BranchBuilder) _WritePage(depth int) (uint32, error) {
+
+	tree := _NewSortedI64I64BTreePage(b.keysByDepth[depth], b.valuesByDepth[depth])
+	b.keysByDepth[depth] = nil
+	b.valuesByDepth[depth] = nil
+
+	pid := b.factory.pager.CreatePageId()
+	err := b.factory.pager.WritePayloadData(pid, tree.ToBytes())
+	if err != nil {
+		return 0, err
+	}
+
+	return pid, nil
+}
+
+// Finish writes the pages still open and returns the factory meta. A
+// builder without keys leaves the factory empty.
+func (b *_BranchBuilder) Finish() ([]byte, error) {
+
+	if b.count > 0 {
+		err := b._CloseFrom(1)
+		if err != nil {
+			return nil, err
+		}
+		b.factory.rootPageId, err = b._WritePage(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.factory.Save()
+}
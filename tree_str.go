@@ -0,0 +1,702 @@
+package gokvdb
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	STRBTREE_LEAF = 1
+	STRBTREE_BRANCH = 2
+
+	// a node is split in two once it holds more keys
+	STRBTREE_MAX_KEYS = 128
+)
+
+// IStrI64Index maps the keys of the string keyed dicts to int64 values.
+// SimpleStrI64Factory hashes the keys, StrI64BTree keeps them in order.
+type IStrI64Index interface {
+	Get(key string) (int64, error)
+	_GetMany(keys []string) ([]int64, []bool, error)
+	Set(key string, value int64) error
+	_SetMany(pairs []StrI64Pair) error
+	Delete(key string) error
+	Items() *Cursor[string, int64]
+	Save() ([]byte, error)
+	ReleaseCache()
+	ToString() string
+}
+
+// StrI64BTree is an ordered B+tree from string to int64. Keys are compared
+// as raw bytes and every node is one payload of the pager.
+type StrI64BTree struct {
+	pager IPager
+	rootPageId uint32
+	nodeByPageId map[uint32]*StrI64BTreeNode
+}
+
+// StrI64BTreeNode is a leaf holding keys and values or a branch where
+// childPageIds[i] holds the keys in [keys[i-1], keys[i]).
+type StrI64BTreeNode struct {
+	pid uint32
+	nodeType byte
+	keys []string
+	values []int64
+	childPageIds []uint32
+	isChanged bool
+}
+
+func NewStrI64BTree(pager IPager, meta []byte) (*StrI64BTree, error) {
+
+	self := new(StrI64BTree)
+	self.pager = pager
+	self.nodeByPageId = make(map[uint32]*StrI64BTreeNode)
+
+	if meta != nil {
+		err := _DecodePage("StrI64BTree meta", 0, meta, func(rd *DataStream) {
+			self.rootPageId = rd.ReadUInt32()
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return self, nil
+}
+
+func (self *StrI64BTree) ToString() string {
+	return fmt.Sprintf("<StrI64BTree rootPageId=%v nodes=%v>", self.rootPageId, len(self.nodeByPageId))
+}
+
+func (n *StrI64BTreeNode) ToString() string {
+	return fmt.Sprintf("<StrI64BTreeNode pid=%v nodeType=%v keys=%v isChanged=%v>", n.pid, n.nodeType, len(n.keys), n.isChanged)
+}
+
+func (self *StrI64BTree) _CreateNode(nodeType byte) *StrI64BTreeNode {
+	node := new(StrI64BTreeNode)
+	node.pid = self.pager.CreatePageId()
+	node.nodeType = nodeType
+	node.isChanged = true
+	self.nodeByPageId[node.pid] = node
+	return node
+}
+
+func (self *StrI64BTree) _LoadNode(pid uint32) (*StrI64BTreeNode, error) {
+
+	data, err := self.pager.ReadPayloadData(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	node := new(StrI64BTreeNode)
+	node.pid = pid
+
+	err = _DecodePage("StrI64BTreeNode", pid, data, func(rd *DataStream) {
+		node.nodeType = rd.ReadUInt8()
+		rowsCount := int(rd.ReadUInt24())
+
+		switch node.nodeType {
+		case STRBTREE_LEAF:
+			for i:=0; i<rowsCount; i++ {
+				node.keys = append(node.keys, rd.ReadHStr())
+				node.values = append(node.values, int64(rd.ReadUInt64()))
+			}
+
+		case STRBTREE_BRANCH:
+			node.childPageIds = append(node.childPageIds, rd.ReadUInt32())
+			for i:=0; i<rowsCount; i++ {
+				node.keys = append(node.keys, rd.ReadHStr())
+				node.childPageIds = append(node.childPageIds, rd.ReadUInt32())
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if node.nodeType != STRBTREE_LEAF && node.nodeType != STRBTREE_BRANCH {
+		return nil, _CorruptPageError("StrI64BTreeNode", pid, "nodeType=%v", node.nodeType)
+	}
+
+	return node, nil
+}
+
+func (n *StrI64BTreeNode) ToBytes() []byte {
+
+	w := NewDataStream()
+	w.WriteUInt8(n.nodeType)
+	w.WriteUInt24(uint32(len(n.keys)))
+
+	switch n.nodeType {
+	case STRBTREE_LEAF:
+		for i, key := range n.keys {
+			w.WriteHStr(key)
+			w.WriteUInt64(uint64(n.values[i]))
+		}
+
+	case STRBTREE_BRANCH:
+		w.WriteUInt32(n.childPageIds[0])
+		for i, key := range n.keys {
+			w.WriteHStr(key)
+			w.WriteUInt32(n.childPageIds[i+1])
+		}
+	}
+
+	return w.ToBytes()
+}
+
+func (self *StrI64BTree) _GetNode(pid uint32) (*StrI64BTreeNode, error) {
+	node, ok := self.nodeByPageId[pid]
+	if !ok {
+		var err error
+		node, err = self._LoadNode(pid)
+		if err != nil {
+			return nil, err
+		}
+		self.nodeByPageId[pid] = node
+	}
+	return node, nil
+}
+
+// _ChildIndex returns the child of a branch that holds key.
+func (n *StrI64BTreeNode) _ChildIndex(key string) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return n.keys[i] > key
+	})
+}
+
+// _FindLeaf returns the leaf that holds key and the branches above it with
+// the child taken at each of them.
+func (self *StrI64BTree) _FindLeaf(key string) (*StrI64BTreeNode, []*StrI64BTreeNode, []int, error) {
+
+	var path []*StrI64BTreeNode
+	var indexes []int
+
+	node, err := self._GetNode(self.rootPageId)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for node.nodeType == STRBTREE_BRANCH {
+		i := node._ChildIndex(key)
+		path = append(path, node)
+		indexes = append(indexes, i)
+
+		node, err = self._GetNode(node.childPageIds[i])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return node, path, indexes, nil
+}
+
+// Get returns ErrNotFound when key is not in the tree.
+func (self *StrI64BTree) Get(key string) (int64, error) {
+
+	if self.rootPageId == 0 {
+		return 0, ErrNotFound
+	}
+
+	leaf, _, _, err := self._FindLeaf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	i := sort.SearchStrings(leaf.keys, key)
+	if i < len(leaf.keys) && leaf.keys[i] == key {
+		return leaf.values[i], nil
+	}
+
+	return 0, ErrNotFound
+}
+
+// _GetMany looks up every key a level at a time, the nodes of a level are
+// read in ascending page id order. found[i] tells whether keys[i] is in
+// the tree.
+func (self *StrI64BTree) _GetMany(keys []string) ([]int64, []bool, error) {
+
+	values := make([]int64, len(keys))
+	found := make([]bool, len(keys))
+
+	if self.rootPageId == 0 || len(keys) == 0 {
+		return values, found, nil
+	}
+
+	indexes := make([]int, len(keys))
+	for i := range keys {
+		indexes[i] = i
+	}
+	indexesByPageId := map[uint32][]int{self.rootPageId: indexes}
+
+	for len(indexesByPageId) > 0 {
+
+		nextIndexesByPageId := make(map[uint32][]int)
+
+		for _, pid := range _SortPageIds(indexesByPageId) {
+
+			node, err := self._GetNode(pid)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for _, i := range indexesByPageId[pid] {
+				key := keys[i]
+				if node.nodeType == STRBTREE_BRANCH {
+					childPageId := node.childPageIds[node._ChildIndex(key)]
+					nextIndexesByPageId[childPageId] = append(nextIndexesByPageId[childPageId], i)
+					continue
+				}
+
+				k := sort.SearchStrings(node.keys, key)
+				if k < len(node.keys) && node.keys[k] == key {
+					values[i] = node.values[k]
+					found[i] = true
+				}
+			}
+		}
+
+		indexesByPageId = nextIndexesByPageId
+	}
+
+	return values, found, nil
+}
+
+func (self *StrI64BTree) Set(key string, value int64) error {
+
+	if self.rootPageId == 0 {
+		root := self._CreateNode(STRBTREE_LEAF)
+		self.rootPageId = root.pid
+	}
+
+	leaf, path, indexes, err := self._FindLeaf(key)
+	if err != nil {
+		return err
+	}
+
+	i := sort.SearchStrings(leaf.keys, key)
+	if i < len(leaf.keys) && leaf.keys[i] == key {
+		leaf.values[i] = value
+		leaf.isChanged = true
+		return nil
+	}
+
+	leaf.keys = append(leaf.keys, "")
+	copy(leaf.keys[i+1:], leaf.keys[i:])
+	leaf.keys[i] = key
+
+	leaf.values = append(leaf.values, 0)
+	copy(leaf.values[i+1:], leaf.values[i:])
+	leaf.values[i] = value
+
+	leaf.isChanged = true
+
+	node := leaf
+	for d:=len(path)-1; len(node.keys) > STRBTREE_MAX_KEYS; d-- {
+		var parent *StrI64BTreeNode
+		var childIndex int
+		if d >= 0 {
+			parent = path[d]
+			childIndex = indexes[d]
+		}
+		self._Split(node, parent, childIndex)
+		if parent == nil {
+			break
+		}
+		node = parent
+	}
+
+	return nil
+}
+
+// _SetMany sets pairs sorted by key, which walks the leaves in order.
+func (self *StrI64BTree) _SetMany(pairs []StrI64Pair) error {
+	for _, pair := range pairs {
+		err := self.Set(pair.Key, pair.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// _Split moves the upper half of node to a new node and links it into
+// parent next to node. A nil parent grows the tree by a new root.
+func (self *StrI64BTree) _Split(node *StrI64BTreeNode, parent *StrI64BTreeNode, childIndex int) {
+
+	right := self._CreateNode(node.nodeType)
+	mid := len(node.keys) / 2
+
+	var sepKey string
+
+	if node.nodeType == STRBTREE_LEAF {
+		sepKey = node.keys[mid]
+		right.keys = append(right.keys, node.keys[mid:]...)
+		right.values = append(right.values, node.values[mid:]...)
+		node.keys = node.keys[:mid:mid]
+		node.values = node.values[:mid:mid]
+	} else {
+		sepKey = node.keys[mid]
+		right.keys = append(right.keys, node.keys[mid+1:]...)
+		right.childPageIds = append(right.childPageIds, node.childPageIds[mid+1:]...)
+		node.keys = node.keys[:mid:mid]
+		node.childPageIds = node.childPageIds[:mid+1:mid+1]
+	}
+	node.isChanged = true
+
+	if parent == nil {
+		root := self._CreateNode(STRBTREE_BRANCH)
+		root.keys = []string{sepKey}
+		root.childPageIds = []uint32{node.pid, right.pid}
+		self.rootPageId = root.pid
+		return
+	}
+
+	parent.keys = append(parent.keys, "")
+	copy(parent.keys[childIndex+1:], parent.keys[childIndex:])
+	parent.keys[childIndex] = sepKey
+
+	parent.childPageIds = append(parent.childPageIds, 0)
+	copy(parent.childPageIds[childIndex+2:], parent.childPageIds[childIndex+1:])
+	parent.childPageIds[childIndex+1] = right.pid
+
+	parent.isChanged = true
+}
+
+// Delete returns ErrNotFound when key is not in the tree. Nodes left empty
+// are freed, the others are not merged.
+func (self *StrI64BTree) Delete(key string) error {
+
+	if self.rootPageId == 0 {
+		return ErrNotFound
+	}
+
+	leaf, path, indexes, err := self._FindLeaf(key)
+	if err != nil {
+		return err
+	}
+
+	i := sort.SearchStrings(leaf.keys, key)
+	if i >= len(leaf.keys) || leaf.keys[i] != key {
+		return ErrNotFound
+	}
+
+	leaf.keys = append(leaf.keys[:i], leaf.keys[i+1:]...)
+	leaf.values = append(leaf.values[:i], leaf.values[i+1:]...)
+	leaf.isChanged = true
+
+	node := leaf
+	for d:=len(path)-1; d >= 0; d-- {
+		isEmpty := len(node.keys) == 0
+		if node.nodeType == STRBTREE_BRANCH {
+			isEmpty = len(node.childPageIds) == 0
+		}
+		if !isEmpty {
+			break
+		}
+
+		err = self._FreeNode(node)
+		if err != nil {
+			return err
+		}
+
+		parent := path[d]
+		childIndex := indexes[d]
+
+		parent.childPageIds = append(parent.childPageIds[:childIndex], parent.childPageIds[childIndex+1:]...)
+		if len(parent.keys) > 0 {
+			keyIndex := childIndex - 1
+			if keyIndex < 0 {
+				keyIndex = 0
+			}
+			parent.keys = append(parent.keys[:keyIndex], parent.keys[keyIndex+1:]...)
+		}
+		parent.isChanged = true
+
+		node = parent
+	}
+
+	// a root branch left with one child hands the root down
+	for self.rootPageId != 0 {
+		root, err := self._GetNode(self.rootPageId)
+		if err != nil {
+			return err
+		}
+		if root.nodeType != STRBTREE_BRANCH || len(root.childPageIds) > 1 {
+			break
+		}
+		self.rootPageId = 0
+		if len(root.childPageIds) == 1 {
+			self.rootPageId = root.childPageIds[0]
+		}
+		err = self._FreeNode(root)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *StrI64BTree) _FreeNode(node *StrI64BTreeNode) error {
+	delete(self.nodeByPageId, node.pid)
+	return self.pager.FreePayloadData(node.pid)
+}
+
+func (self *StrI64BTree) Items() *Cursor[string, int64] {
+	return self.Range("", "")
+}
+
+// Range walks the keys in [startKey, endKey) in order. An empty endKey
+// runs to the last key.
+func (self *StrI64BTree) Range(startKey string, endKey string) *Cursor[string, int64] {
+	return _NewCursor(func(yield func(string, int64) bool) error {
+		return self._EachRange(startKey, endKey, endKey != "", yield)
+	})
+}
+
+// PrefixScan walks the keys starting with prefix in order.
+func (self *StrI64BTree) PrefixScan(prefix string) *Cursor[string, int64] {
+	endKey, hasEnd := _PrefixEnd(prefix)
+	return _NewCursor(func(yield func(string, int64) bool) error {
+		return self._EachRange(prefix, endKey, hasEnd, yield)
+	})
+}
+
+// _PrefixEnd returns the first key after all the keys starting with prefix.
+// hasEnd is false when there is none, for a prefix of 0xff bytes.
+func _PrefixEnd(prefix string) (string, bool) {
+	end := []byte(prefix)
+	for i:=len(end)-1; i>=0; i-- {
+		if end[i] < 0xff {
+			end[i] += 1
+			return string(end[:i+1]), true
+		}
+	}
+	return "", false
+}
+
+func (self *StrI64BTree) _EachRange(startKey string, endKey string, hasEnd bool, yield func(key string, value int64) bool) error {
+
+	if self.rootPageId == 0 {
+		return nil
+	}
+
+	root, err := self._GetNode(self.rootPageId)
+	if err != nil {
+		return err
+	}
+
+	_, err = self._EachNodeRange(root, startKey, endKey, hasEnd, yield)
+	return err
+}
+
+func (self *StrI64BTree) _EachNodeRange(node *StrI64BTreeNode, startKey string, endKey string, hasEnd bool, yield func(key string, value int64) bool) (bool, error) {
+
+	if node.nodeType == STRBTREE_LEAF {
+		// copy the node first, yield may change it
+		var keys []string
+		var values []int64
+		for i:=sort.SearchStrings(node.keys, startKey); i<len(node.keys); i++ {
+			if hasEnd && node.keys[i] >= endKey {
+				break
+			}
+			keys = append(keys, node.keys[i])
+			values = append(values, node.values[i])
+		}
+
+		for i, key := range keys {
+			if !yield(key, values[i]) {
+				return false, nil
+			}
+		}
+
+		return true, nil
+	}
+
+	var childPageIds []uint32
+	for i:=node._ChildIndex(startKey); i<len(node.childPageIds); i++ {
+		if hasEnd && i > 0 && node.keys[i-1] >= endKey {
+			break
+		}
+		childPageIds = append(childPageIds, node.childPageIds[i])
+	}
+
+	for _, pid := range childPageIds {
+		child, err := self._GetNode(pid)
+		if err != nil {
+			return false, err
+		}
+
+		more, err := self._EachNodeRange(child, startKey, endKey, hasEnd, yield)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (self *StrI64BTree) Save() ([]byte, error) {
+
+	for pid, node := range self.nodeByPageId {
+		if node.isChanged {
+			err := self.pager.WritePayloadData(pid, node.ToBytes())
+			if err != nil {
+				return nil, err
+			}
+			node.isChanged = false
+		}
+	}
+
+	meta := NewDataStream()
+	meta.WriteUInt32(self.rootPageId)
+
+	return meta.ToBytes(), nil
+}
+
+// ReleaseCache drops the nodes without unsaved changes.
+func (self *StrI64BTree) ReleaseCache() {
+	for pid, node := range self.nodeByPageId {
+		if !node.isChanged {
+			delete(self.nodeByPageId, pid)
+		}
+	}
+}
+
+// _StrI64BTreeBuilder fills an empty tree from keys added in ascending
+// order. The nodes are packed to STRBTREE_MAX_KEYS and each is written
+// once, when it is full, so only the open node of every level is held.
+type _StrI64BTreeBuilder struct {
+	tree *StrI64BTree
+	leaf *StrI64BTreeNode
+	branches []*StrI64BTreeNode
+	firstKeys []string
+	count int
+}
+
+func _NewStrI64BTreeBuilder(tree *StrI64BTree) *_StrI64BTreeBuilder {
+	b := new(_StrI64BTreeBuilder)
+	b.tree = tree
+	return b
+}
+
+func (b *_StrI64BTreeBuilder) ToString() string {
+	return fmt.Sprintf("<_StrI64BTreeBuilder levels=%v count=%v>", len(b.branches) + 1, b.count)
+}
+
+// Add puts key in the tree, it must be above the keys added before.
+func (b *_StrI64BTreeBuilder) Add(key string, value int64) error {
+
+	if b.leaf != nil && len(b.leaf.keys) >= STRBTREE_MAX_KEYS {
+		err := b._CloseLeaf()
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.leaf == nil {
+		b.leaf = &StrI64BTreeNode{nodeType: STRBTREE_LEAF}
+	}
+
+	b.leaf.keys = append(b.leaf.keys, key)
+	b.leaf.values = append(b.leaf.values, value)
+	b.count += 1
+
+	return nil
+}
+
+func (b *_StrI64BTreeBuilder) _Write(node *StrI64BTreeNode) (uint32, error) {
+	node.pid = b.tree.pager.CreatePageId()
+	return node.pid, b.tree.pager.WritePayloadData(node.pid, node.ToBytes())
+}
+
+func (b *_StrI64BTreeBuilder) _CloseLeaf() error {
+	pid, err := b._Write(b.leaf)
+	if err != nil {
+		return err
+	}
+	firstKey := b.leaf.keys[0]
+	b.leaf = nil
+	return b._Push(0, firstKey, pid)
+}
+
+// _Push adds the node pid starting with firstKey to the open branch of
+// level, a full branch is written first and pushed a level up.
+func (b *_StrI64BTreeBuilder) _Push(level int, firstKey string, pid uint32) error {
+
+	if level == len(b.branches) {
+		b.branches = append(b.branches, nil)
+		b.firstKeys = append(b.firstKeys, "")
+	}
+
+	node := b.branches[level]
+	if node != nil && len(node.childPageIds) > STRBTREE_MAX_KEYS {
+		nodePid, err := b._Write(node)
+		if err != nil {
+			return err
+		}
+		b.branches[level] = nil
+		err = b._Push(level + 1, b.firstKeys[level], nodePid)
+		if err != nil {
+			return err
+		}
+		node = nil
+	}
+
+	if node == nil {
+		b.branches[level] = &StrI64BTreeNode{nodeType: STRBTREE_BRANCH, childPageIds: []uint32{pid}}
+		b.firstKeys[level] = firstKey
+		return nil
+	}
+
+	node.keys = append(node.keys, firstKey)
+	node.childPageIds = append(node.childPageIds, pid)
+
+	return nil
+}
+
+// Finish writes the nodes still open and returns the tree meta.
+func (b *_StrI64BTreeBuilder) Finish() ([]byte, error) {
+
+	if b.leaf != nil {
+		if len(b.branches) == 0 {
+			pid, err := b._Write(b.leaf)
+			if err != nil {
+				return nil, err
+			}
+			b.tree.rootPageId = pid
+		} else {
+			err := b._CloseLeaf()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for level:=0; level<len(b.branches); level++ {
+		node := b.branches[level]
+		isTop := level == len(b.branches) - 1
+
+		// a top branch with one child hands the root down
+		if isTop && len(node.childPageIds) == 1 {
+			b.tree.rootPageId = node.childPageIds[0]
+			break
+		}
+
+		pid, err := b._Write(node)
+		if err != nil {
+			return nil, err
+		}
+		if isTop {
+			b.tree.rootPageId = pid
+			break
+		}
+		err = b._Push(level + 1, b.firstKeys[level], pid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b.tree.Save()
+}
@@ -0,0 +1,302 @@
+package gokvdb
+
+import (
+	"fmt"
+)
+
+type ITxDict interface {
+	Save(commit bool) error
+	ToString() string
+}
+
+// Tx groups the writes of several dicts so they reach the file together.
+// Pages written while the transaction is open stay in the pager write
+// buffer, or in the write-ahead log past the cache budget, until Commit.
+// Rollback drops them and restores the page allocator.
+type Tx struct {
+	storage *Storage
+	pagerMeta StreamPagerMeta
+	pagerIsChanged bool
+	dirtyPages map[uint32][]byte
+	walPending WALPending
+	freePageIdSet map[uint32]byte
+	dbItems map[string]*DBItem
+	dicts []ITxDict
+	isDone bool
+}
+
+func (tx *Tx) ToString() string {
+	return fmt.Sprintf("<Tx dicts=%v lastPageId=%v isDone=%v>", len(tx.dicts), tx.pagerMeta.lastPageId, tx.isDone)
+}
+
+// Begin opens a transaction. It holds writeLock for the check of s.tx, so
+// two Begins never both succeed and a Begin waits for a running Update.
+func (s *Storage) Begin() (*Tx, error) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	return s._Begin()
+}
+
+// _Begin runs with writeLock held.
+func (s *Storage) _Begin() (*Tx, error) {
+
+	if s.tx != nil {
+		return nil, DBError{message: "transaction already in progress"}
+	}
+
+	pager := s.pager.(*StreamPager)
+
+	tx := new(Tx)
+	tx.storage = s
+	tx.pagerMeta = *pager.basePager.meta
+	tx.pagerIsChanged = pager.basePager.isChanged
+	tx.dirtyPages = make(map[uint32][]byte)
+	tx.freePageIdSet = make(map[uint32]byte)
+	tx.dbItems = s._CloneDBItems()
+
+	// buffered pages are never modified in place so sharing them is safe
+	for pid, pageData := range pager.basePager.dirtyPages {
+		tx.dirtyPages[pid] = pageData
+	}
+	if pager.basePager.wal != nil {
+		tx.walPending = pager.basePager.wal.PendingMark()
+	}
+
+	for pid, _ := range pager.freelist.pageIdSet {
+		tx.freePageIdSet[pid] = 1
+	}
+
+	s.tx = tx
+
+	return tx, nil
+}
+
+func (tx *Tx) _Check() error {
+	if tx.isDone {
+		return DBError{message: "transaction has already been committed or rolled back"}
+	}
+	return nil
+}
+
+func (tx *Tx) _Track(dict ITxDict) {
+	tx.dicts = append(tx.dicts, dict)
+}
+
+func (tx *Tx) _Tracks(dict ITxDict) bool {
+	for _, _dict := range tx.dicts {
+		if _dict == dict {
+			return true
+		}
+	}
+	return false
+}
+
+// _CheckDictSave fails the save of a dict not opened through the open
+// transaction. Its pages would be staged in the transaction and a Rollback
+// drops them while the dict still points at them.
+func (s *Storage) _CheckDictSave(dict ITxDict) error {
+	if s.tx != nil && !s.tx._Tracks(dict) {
+		return DBError{message: "transaction in progress"}
+	}
+	return nil
+}
+
+// _SaveAfterDict saves the storage after a dict save, a dict of the open
+// transaction leaves it to Tx.Commit.
+func (s *Storage) _SaveAfterDict() error {
+	if s.tx != nil {
+		return nil
+	}
+	return s.Save()
+}
+
+func (tx *Tx) DB(name string) (*DBContext, error) {
+	err := tx._Check()
+	if err != nil {
+		return nil, err
+	}
+	return tx.storage.DB(name)
+}
+
+func (tx *Tx) I64StrDict(dbName string, dictName string) (*LazyI64StrDict, error) {
+	err := tx._Check()
+	if err != nil {
+		return nil, err
+	}
+	dict, err := NewI64StrDict(tx.storage, dbName, dictName)
+	if err != nil {
+		return nil, err
+	}
+	tx._Track(dict)
+	return dict, nil
+}
+
+func (tx *Tx) StrI64Dict(dbName string, dictName string) (*LazyStrI64Dict, error) {
+	err := tx._Check()
+	if err != nil {
+		return nil, err
+	}
+	dict, err := NewStrI64Dict(tx.storage, dbName, dictName)
+	if err != nil {
+		return nil, err
+	}
+	tx._Track(dict)
+	return dict, nil
+}
+
+func (tx *Tx) I64BlobDict(dbName string, dictName string) (*LazyI64BlobDict, error) {
+	err := tx._Check()
+	if err != nil {
+		return nil, err
+	}
+	dict, err := NewI64BlobDict(tx.storage, dbName, dictName)
+	if err != nil {
+		return nil, err
+	}
+	tx._Track(dict)
+	return dict, nil
+}
+
+func (tx *Tx) StrBlobDict(dbName string, dictName string) (*LazyStrBlobDict, error) {
+	err := tx._Check()
+	if err != nil {
+		return nil, err
+	}
+	dict, err := NewStrBlobDict(tx.storage, dbName, dictName)
+	if err != nil {
+		return nil, err
+	}
+	tx._Track(dict)
+	return dict, nil
+}
+
+func (tx *Tx) I64I64SetDict(dbName string, dictName string) (*LazyI64I64SetDict, error) {
+	err := tx._Check()
+	if err != nil {
+		return nil, err
+	}
+	dict, err := NewLazyI64I64SetDict(tx.storage, dbName, dictName)
+	if err != nil {
+		return nil, err
+	}
+	tx._Track(dict)
+	return dict, nil
+}
+
+func (tx *Tx) StrI64SetDict(dbName string, dictName string) (*LazyStrI64SetDict, error) {
+	err := tx._Check()
+	if err != nil {
+		return nil, err
+	}
+	dict, err := NewStrI64SetDict(tx.storage, dbName, dictName)
+	if err != nil {
+		return nil, err
+	}
+	tx._Track(dict)
+	return dict, nil
+}
+
+// Commit saves every dict opened through the transaction and then writes
+// all staged pages and the storage header in one flush. When a dict fails
+// to save the transaction stays open so it can still be rolled back.
+func (tx *Tx) Commit() error {
+	err := tx._Check()
+	if err != nil {
+		return err
+	}
+
+	for _, dict := range tx.dicts {
+		err = dict.Save(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx.isDone = true
+	tx.storage.tx = nil
+
+	return tx.storage._Save()
+}
+
+// Rollback discards every page staged since Begin, including the pages
+// allocated with CreatePageId. Dicts opened through the transaction must
+// not be used afterwards.
+func (tx *Tx) Rollback() error {
+	err := tx._Check()
+	if err != nil {
+		return err
+	}
+
+	s := tx.storage
+	pager := s.pager.(*StreamPager)
+
+	pager.basePager.dirtyPages = tx.dirtyPages
+	if pager.basePager.wal != nil {
+		err = pager.basePager.wal.RestorePending(tx.walPending)
+		if err != nil {
+			return err
+		}
+	}
+	// the page size is read by open snapshots, only the allocator moves
+	pager.basePager.meta.lastPageId = tx.pagerMeta.lastPageId
+	pager.basePager.meta.freelistPageId = tx.pagerMeta.freelistPageId
+	pager.basePager.isChanged = tx.pagerIsChanged
+	pager.freelist.pageIdSet = tx.freePageIdSet
+	pager.cache._Reset()
+
+	s.dbItems = tx.dbItems
+
+	tx.isDone = true
+	tx.dicts = nil
+	s.tx = nil
+
+	return nil
+}
+
+func (s *Storage) _CloneDBItems() map[string]*DBItem {
+
+	items := make(map[string]*DBItem)
+
+	for name, item := range s.dbItems {
+		_item := s._NewDBItem(item.name, item.metaPageId)
+
+		if item.ctx != nil {
+			ctx := new(DBContext)
+			ctx.name = item.ctx.name
+			ctx.pager = item.ctx.pager
+			ctx.readOnly = item.ctx.readOnly
+			ctx.pageIdByMetaName = make(map[string]uint32)
+			ctx.kindByMetaName = make(map[string]DictKind)
+			ctx.versionByMetaName = make(map[string]uint8)
+			ctx.dbSets = make(map[string]*DBSet)
+
+			for metaName, pid := range item.ctx.pageIdByMetaName {
+				ctx.pageIdByMetaName[metaName] = pid
+			}
+
+			for metaName, kind := range item.ctx.kindByMetaName {
+				ctx.kindByMetaName[metaName] = kind
+			}
+
+			for metaName, version := range item.ctx.versionByMetaName {
+				ctx.versionByMetaName[metaName] = version
+			}
+
+			for dsetName, dset := range item.ctx.dbSets {
+				_dset := new(DBSet)
+				_dset.name = dset.name
+				_dset.dbType = dset.dbType
+				_dset.metaPageId = dset.metaPageId
+				// opened indexes are reloaded from their saved meta
+				ctx.dbSets[dsetName] = _dset
+			}
+
+			_item.ctx = ctx
+		}
+
+		items[name] = _item
+	}
+
+	return items
+}
@@ -2,7 +2,6 @@ package gokvdb
 
 import (
 	"os"
-	"fmt"
 	//"sync"
 	"path/filepath"
 )
@@ -41,13 +40,6 @@ func (self *LazyList) Len() int {
 	return self.offset
 }
 
-func _CheckErr(message string, err error) {
-	if err != nil {
-		fmt.Println(message, "ERROR", err)
-		os.Exit(1)
-	}
-}
-
 func _CheckCreateDirpath(fullpath string) {
 
 	dirpath := filepath.Dir(fullpath)
@@ -0,0 +1,654 @@
+package gokvdb
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"sort"
+	"sync"
+	"hash/crc32"
+)
+
+const (
+	WAL_MAGIC uint32 = 0x4c41574b
+	WAL_VERSION uint16 = 1
+	WAL_HEADER_SIZE int = 16
+	WAL_FRAME_HEADER_SIZE int = 13
+
+	WAL_FRAME_PAGE byte = 1
+	WAL_FRAME_COMMIT byte = 2
+
+	// frames with this page id carry the storage header written at offset 0
+	WAL_HEADER_PAGE_ID uint32 = 0
+)
+
+// WriteAheadLog is the sidecar log of StreamPager. Every commit appends the
+// page images and the storage header followed by a commit record, and the
+// log is fsynced before the main file is touched. Checkpoint folds the
+// committed pages back into the main file and truncates the log.
+//
+// Pages of a commit not made yet may be spilled to the log ahead of it,
+// they are read back by the writer only and count once Append logs the
+// commit record after them.
+type WriteAheadLog struct {
+	path string
+	file *os.File
+	pageSize uint32
+	size int64
+	frameByPageId map[uint32]WALFrame
+	pending WALPending
+	readOnly bool
+	syncMode SyncMode
+	rwlock sync.RWMutex
+}
+
+type WALFrame struct {
+	offset int64
+	dataLen uint32
+}
+
+// WALPending is the tail of page frames spilled past the last commit: its
+// bytes, the frames in it and the latest frame of each page. A page spilled
+// again is written over its frame, unless the frame is below sealed where
+// a PendingMark may still go back to it.
+type WALPending struct {
+	size int64
+	count uint32
+	sealed int64
+	frameByPageId map[uint32]WALFrame
+}
+
+func (wal *WriteAheadLog) ToString() string {
+	return fmt.Sprintf("<WriteAheadLog path=%v size=%v pages=%v pending=%v>", wal.path, wal.size, len(wal.frameByPageId), len(wal.pending.frameByPageId))
+}
+
+// OpenWriteAheadLog opens or creates the log at path. Committed batches
+// left by a previous process are replayed into stream and a torn tail is
+// discarded.
+func OpenWriteAheadLog(path string, stream IStream, pageSize uint32) (*WriteAheadLog, error) {
+
+	f, err := os.OpenFile(path, os.O_RDWR | os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	wal, err := _NewWriteAheadLog(path, f, pageSize, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(wal.frameByPageId) > 0 {
+		err = wal.Checkpoint(stream)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return wal, nil
+}
+
+// OpenWriteAheadLogReadOnly reads the committed pages of the log at path
+// without replaying, truncating or removing it. It returns nil when there
+// is no log.
+func OpenWriteAheadLogReadOnly(path string, pageSize uint32) (*WriteAheadLog, error) {
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return _NewWriteAheadLog(path, f, pageSize, true)
+}
+
+// _ReadWriteAheadLogPageSize returns the page size recorded by the log at
+// path, zero when there is no readable log.
+func _ReadWriteAheadLogPageSize(path string) uint32 {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	headerData := make([]byte, WAL_HEADER_SIZE)
+	_, err = f.ReadAt(headerData, 0)
+	if err != nil {
+		return 0
+	}
+
+	rd := NewDataStreamFromBuffer(headerData)
+	if rd.ReadUInt32() != WAL_MAGIC {
+		return 0
+	}
+	rd.Seek(8)
+
+	return rd.ReadUInt32()
+}
+
+func _NewWriteAheadLog(path string, f *os.File, pageSize uint32, readOnly bool) (*WriteAheadLog, error) {
+
+	wal := new(WriteAheadLog)
+	wal.path = path
+	wal.file = f
+	wal.pageSize = pageSize
+	wal.readOnly = readOnly
+	wal.frameByPageId = make(map[uint32]WALFrame)
+
+	err := wal._Recover()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return wal, nil
+}
+
+func (wal *WriteAheadLog) _WriteHeader() error {
+	w := NewDataStreamFromBuffer(make([]byte, WAL_HEADER_SIZE))
+	w.WriteUInt32(WAL_MAGIC)
+	w.WriteUInt16(WAL_VERSION)
+	w.Seek(8)
+	w.WriteUInt32(wal.pageSize)
+
+	err := wal.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+
+	_, err = wal.file.WriteAt(w.ToBytes(), 0)
+	if err != nil {
+		return err
+	}
+
+	wal.size = int64(WAL_HEADER_SIZE)
+
+	if wal.syncMode == SYNC_OFF {
+		return nil
+	}
+	return wal.file.Sync()
+}
+
+func (wal *WriteAheadLog) _Recover() error {
+
+	headerData := make([]byte, WAL_HEADER_SIZE)
+	_, err := wal.file.ReadAt(headerData, 0)
+	if err != nil {
+		// empty or truncated log
+		if wal.readOnly {
+			return nil
+		}
+		return wal._WriteHeader()
+	}
+
+	rd := NewDataStreamFromBuffer(headerData)
+	magic := rd.ReadUInt32()
+	rd.ReadUInt16()
+	rd.Seek(8)
+	pageSize := rd.ReadUInt32()
+
+	if magic != WAL_MAGIC {
+		return DBError{message: fmt.Sprintf("wal %v bad magic %x", wal.path, magic)}
+	}
+
+	if pageSize != wal.pageSize {
+		return DBError{message: fmt.Sprintf("wal %v pageSize=%v storage pageSize=%v", wal.path, pageSize, wal.pageSize)}
+	}
+
+	fileInfo, err := wal.file.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := fileInfo.Size()
+
+	offset := int64(WAL_HEADER_SIZE)
+	goodOffset := offset
+
+	batch := make(map[uint32]WALFrame)
+	batchCount := uint32(0)
+	batchHash := crc32.NewIEEE()
+
+	frameHeader := make([]byte, WAL_FRAME_HEADER_SIZE)
+
+	for {
+		_, err = wal.file.ReadAt(frameHeader, offset)
+		if err != nil {
+			break
+		}
+
+		hdrR := NewDataStreamFromBuffer(frameHeader)
+		frameType := hdrR.ReadUInt8()
+		pid := hdrR.ReadUInt32()
+		dataLen := hdrR.ReadUInt32()
+		checksum := hdrR.ReadUInt32()
+
+		if frameType == WAL_FRAME_PAGE {
+
+			// a torn header may carry any length, it ends the replay
+			// like a bad checksum
+			if dataLen > wal.pageSize || offset + int64(WAL_FRAME_HEADER_SIZE) + int64(dataLen) > fileSize {
+				break
+			}
+
+			data := make([]byte, dataLen)
+			_, err = wal.file.ReadAt(data, offset + int64(WAL_FRAME_HEADER_SIZE))
+			if err != nil || crc32.ChecksumIEEE(data) != checksum {
+				break
+			}
+
+			batchHash.Write(frameHeader)
+			batchHash.Write(data)
+
+			batch[pid] = WALFrame{offset: offset + int64(WAL_FRAME_HEADER_SIZE), dataLen: dataLen}
+			batchCount += 1
+
+			offset += int64(WAL_FRAME_HEADER_SIZE) + int64(dataLen)
+			continue
+		}
+
+		if frameType == WAL_FRAME_COMMIT && pid == batchCount && checksum == batchHash.Sum32() {
+
+			for _pid, frame := range batch {
+				wal.frameByPageId[_pid] = frame
+			}
+
+			offset += int64(WAL_FRAME_HEADER_SIZE)
+			goodOffset = offset
+
+			batch = make(map[uint32]WALFrame)
+			batchCount = 0
+			batchHash.Reset()
+			continue
+		}
+
+		break
+	}
+
+	wal.size = goodOffset
+
+	if wal.readOnly {
+		return nil
+	}
+
+	// drop the uncommitted tail
+	return wal.file.Truncate(goodOffset)
+}
+
+// Append logs one commit, after the pages spilled for it, and fsyncs the
+// log unless the sync mode defers it to the checkpoint.
+func (wal *WriteAheadLog) Append(pageIds []uint32, dataByPageId map[uint32][]byte, header []byte) error {
+
+	wal.rwlock.Lock()
+	defer wal.rwlock.Unlock()
+
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+
+	batch := wal._Pending()
+	for _, pid := range pageIds {
+		batch._AddFrame(wal.size, pid, dataByPageId[pid])
+	}
+	batch._AddFrame(wal.size, WAL_HEADER_PAGE_ID, header)
+
+	err := wal._WriteRewrites(batch)
+	if err != nil {
+		return err
+	}
+
+	// the spilled frames may have been written over, they are hashed as
+	// they are in the log now
+	checksum, err := wal._PendingChecksum()
+	if err != nil {
+		return err
+	}
+	checksum = crc32.Update(checksum, crc32.IEEETable, batch.data)
+
+	commitW := NewDataStreamFromBuffer(make([]byte, WAL_FRAME_HEADER_SIZE))
+	commitW.WriteUInt8(WAL_FRAME_COMMIT)
+	commitW.WriteUInt32(batch.count)
+	commitW.WriteUInt32(0)
+	commitW.WriteUInt32(checksum)
+
+	data := append(batch.data, commitW.ToBytes()...)
+
+	_, err = wal.file.WriteAt(data, wal.size + wal.pending.size)
+	if err != nil {
+		return err
+	}
+
+	if wal.syncMode == SYNC_FULL {
+		err = wal.file.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	wal.size += batch.size + int64(WAL_FRAME_HEADER_SIZE)
+
+	for pid, frame := range batch.frameByPageId {
+		wal.frameByPageId[pid] = frame
+	}
+	wal.pending = WALPending{}
+
+	return nil
+}
+
+// Spill logs pages of the next commit ahead of it, they are read back with
+// ReadPendingPage until Append logs the commit.
+func (wal *WriteAheadLog) Spill(pageIds []uint32, dataByPageId map[uint32][]byte) error {
+
+	wal.rwlock.Lock()
+	defer wal.rwlock.Unlock()
+
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+
+	batch := wal._Pending()
+	for _, pid := range pageIds {
+		batch._AddFrame(wal.size, pid, dataByPageId[pid])
+	}
+
+	err := wal._WriteRewrites(batch)
+	if err != nil {
+		return err
+	}
+
+	_, err = wal.file.WriteAt(batch.data, wal.size + wal.pending.size)
+	if err != nil {
+		return err
+	}
+
+	wal.pending = batch.WALPending
+
+	return nil
+}
+
+// _WALBatch is the pending tail with the frames of a write added to it,
+// data holds the bytes of the added frames only and rewrites the frames
+// written over.
+type _WALBatch struct {
+	WALPending
+	data []byte
+	rewrites []_WALRewrite
+}
+
+type _WALRewrite struct {
+	offset int64
+	data []byte
+}
+
+func (wal *WriteAheadLog) _WriteRewrites(batch *_WALBatch) error {
+	for _, rewrite := range batch.rewrites {
+		_, err := wal.file.WriteAt(rewrite.data, rewrite.offset)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// _PendingChecksum reads the spilled frames back for the CRC32 of the
+// commit record.
+func (wal *WriteAheadLog) _PendingChecksum() (uint32, error) {
+
+	checksum := uint32(0)
+	buf := make([]byte, 1 << 20)
+
+	for offset := int64(0); offset < wal.pending.size; {
+		n := int64(len(buf))
+		if wal.pending.size - offset < n {
+			n = wal.pending.size - offset
+		}
+
+		_, err := wal.file.ReadAt(buf[:n], wal.size + offset)
+		if err != nil {
+			return 0, err
+		}
+
+		checksum = crc32.Update(checksum, crc32.IEEETable, buf[:n])
+		offset += n
+	}
+
+	return checksum, nil
+}
+
+// _Pending copies the pending tail for a write to add frames to, the log
+// takes it back once the write succeeded.
+func (wal *WriteAheadLog) _Pending() *_WALBatch {
+	batch := new(_WALBatch)
+	batch.WALPending = wal.pending._Clone()
+	return batch
+}
+
+func (b *_WALBatch) _AddFrame(base int64, pid uint32, data []byte) {
+	hdrW := NewDataStreamFromBuffer(make([]byte, WAL_FRAME_HEADER_SIZE))
+	hdrW.WriteUInt8(WAL_FRAME_PAGE)
+	hdrW.WriteUInt32(pid)
+	hdrW.WriteUInt32(uint32(len(data)))
+	hdrW.WriteUInt32(crc32.ChecksumIEEE(data))
+	frameHeader := hdrW.ToBytes()
+
+	frame, ok := b.frameByPageId[pid]
+	if ok && frame.dataLen == uint32(len(data)) && frame.offset - base - int64(WAL_FRAME_HEADER_SIZE) >= b.sealed {
+		rewrite := _WALRewrite{offset: frame.offset - int64(WAL_FRAME_HEADER_SIZE)}
+		rewrite.data = append(frameHeader, data...)
+		b.rewrites = append(b.rewrites, rewrite)
+		return
+	}
+
+	b.data = append(b.data, frameHeader...)
+	b.data = append(b.data, data...)
+
+	b.frameByPageId[pid] = WALFrame{offset: base + b.size + int64(WAL_FRAME_HEADER_SIZE), dataLen: uint32(len(data))}
+	b.size += int64(WAL_FRAME_HEADER_SIZE) + int64(len(data))
+	b.count += 1
+}
+
+func (p WALPending) _Clone() WALPending {
+	clone := p
+	clone.frameByPageId = make(map[uint32]WALFrame)
+	for pid, frame := range p.frameByPageId {
+		clone.frameByPageId[pid] = frame
+	}
+	return clone
+}
+
+// ReadPendingPage returns the latest image of pid spilled for the next
+// commit.
+func (wal *WriteAheadLog) ReadPendingPage(pid uint32, count int) ([]byte, bool) {
+
+	wal.rwlock.RLock()
+	defer wal.rwlock.RUnlock()
+
+	frame, ok := wal.pending.frameByPageId[pid]
+	if !ok {
+		return nil, false
+	}
+
+	return wal._ReadFrame(frame, count)
+}
+
+// PendingPageIds returns the pages spilled for the next commit.
+func (wal *WriteAheadLog) PendingPageIds() []uint32 {
+
+	wal.rwlock.RLock()
+	defer wal.rwlock.RUnlock()
+
+	var pageIds []uint32
+	for pid, _ := range wal.pending.frameByPageId {
+		pageIds = append(pageIds, pid)
+	}
+
+	return pageIds
+}
+
+// PendingMark returns the pending tail as it is, RestorePending goes back
+// to it. The frames in it are not written over from now on.
+func (wal *WriteAheadLog) PendingMark() WALPending {
+	wal.rwlock.Lock()
+	defer wal.rwlock.Unlock()
+	wal.pending.sealed = wal.pending.size
+	return wal.pending._Clone()
+}
+
+// RestorePending drops the pages spilled after mark, a zero mark drops
+// them all.
+func (wal *WriteAheadLog) RestorePending(mark WALPending) error {
+
+	wal.rwlock.Lock()
+	defer wal.rwlock.Unlock()
+
+	if wal.pending.size == mark.size {
+		return nil
+	}
+
+	wal.pending = mark._Clone()
+
+	return wal.file.Truncate(wal.size + wal.pending.size)
+}
+
+// ReadPage returns the latest committed image of pid still held by the log.
+func (wal *WriteAheadLog) ReadPage(pid uint32, count int) ([]byte, bool) {
+
+	wal.rwlock.RLock()
+	defer wal.rwlock.RUnlock()
+
+	frame, ok := wal.frameByPageId[pid]
+	if !ok {
+		return nil, false
+	}
+
+	return wal._ReadFrame(frame, count)
+}
+
+func (wal *WriteAheadLog) _ReadFrame(frame WALFrame, count int) ([]byte, bool) {
+
+	data := make([]byte, frame.dataLen)
+	_, err := wal.file.ReadAt(data, frame.offset)
+	if err != nil && err != io.EOF {
+		return nil, false
+	}
+
+	if count > 0 && count < len(data) {
+		data = data[:count]
+	}
+
+	return data, true
+}
+
+func (wal *WriteAheadLog) Size() int64 {
+	wal.rwlock.RLock()
+	defer wal.rwlock.RUnlock()
+	return wal.size
+}
+
+// Checkpoint writes the committed pages and header into stream, syncs it
+// and resets the log. The log is only truncated once the pages are written
+// and the fsync of stream succeeded, after a failure it still holds them.
+func (wal *WriteAheadLog) Checkpoint(stream IStream) error {
+
+	wal.rwlock.Lock()
+	defer wal.rwlock.Unlock()
+
+	if wal.readOnly {
+		return ErrReadOnly
+	}
+
+	// the log has to be durable before the main file is overwritten
+	if wal.syncMode == SYNC_NORMAL {
+		err := wal.file.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := wal._WritePages(stream)
+	if err != nil {
+		return err
+	}
+
+	if wal.syncMode != SYNC_OFF {
+		err = stream.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	// the pages spilled for the next commit sit behind the committed ones,
+	// the log is reset at a checkpoint after that commit
+	if wal.pending.size > 0 {
+		return nil
+	}
+
+	wal.frameByPageId = make(map[uint32]WALFrame)
+
+	return wal._WriteHeader()
+}
+
+// WritePages copies the committed pages and header into stream without
+// resetting the log.
+func (wal *WriteAheadLog) WritePages(stream IStream) error {
+	wal.rwlock.RLock()
+	defer wal.rwlock.RUnlock()
+	return wal._WritePages(stream)
+}
+
+func (wal *WriteAheadLog) _WritePages(stream IStream) error {
+
+	var pageIds []uint32
+	for pid, _ := range wal.frameByPageId {
+		pageIds = append(pageIds, pid)
+	}
+	sort.Sort(U32Array(pageIds))
+
+	// the header goes last so the main file never points at missing pages
+	if len(pageIds) > 0 && pageIds[0] == WAL_HEADER_PAGE_ID {
+		pageIds = append(pageIds[1:], WAL_HEADER_PAGE_ID)
+	}
+
+	for _, pid := range pageIds {
+		frame := wal.frameByPageId[pid]
+		data := make([]byte, frame.dataLen)
+		_, err := wal.file.ReadAt(data, frame.offset)
+		if err != nil {
+			return err
+		}
+
+		err = stream.SeekTo(int64(pid) * int64(wal.pageSize))
+		if err != nil {
+			return _PageError("Checkpoint", pid, err)
+		}
+		err = stream.Write(data)
+		if err != nil {
+			return _PageError("Checkpoint", pid, err)
+		}
+	}
+
+	return nil
+}
+
+// Close closes the log and removes the sidecar file when it holds no
+// pending commit. A read-only log is left in place.
+func (wal *WriteAheadLog) Close() {
+
+	wal.rwlock.Lock()
+	defer wal.rwlock.Unlock()
+
+	if wal.file == nil {
+		return
+	}
+
+	isEmpty := len(wal.frameByPageId) == 0 && !wal.readOnly
+
+	wal.file.Close()
+	wal.file = nil
+
+	if isEmpty {
+		os.Remove(wal.path)
+	}
+}